@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/util"
+)
+
+// runAdmin 管理员账户子命令：从标准输入读取密码，输出可填入 adminpassword 的bcrypt哈希
+func runAdmin(args []string) error {
+	if len(args) != 1 || args[0] != "hash-password" {
+		return fmt.Errorf("用法: server admin hash-password（从标准输入读取密码）")
+	}
+
+	fmt.Fprint(os.Stderr, "请输入管理员密码: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return fmt.Errorf("读取密码失败: %v", err)
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return fmt.Errorf("密码不能为空")
+	}
+
+	hash, err := util.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	fmt.Println(hash)
+	return nil
+}
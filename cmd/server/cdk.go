@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/config"
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
+	"github.com/zhongruan0522/DuiDuiMao/internal/service"
+	"github.com/zhongruan0522/DuiDuiMao/internal/util"
+)
+
+// runCDK 执行CDK维护子命令：cdk rotate-key | cdk reindex
+func runCDK(cfg *config.Config, args []string) error {
+	if len(args) != 1 || (args[0] != "rotate-key" && args[0] != "reindex") {
+		return fmt.Errorf("用法: server cdk rotate-key|reindex")
+	}
+
+	codeCipher, err := util.NewCodeCipher(cfg.CDK.KeyID, cfg.CDK.Keys, cfg.CDK.FingerprintKey)
+	if err != nil {
+		return fmt.Errorf("CDK加密配置错误: %v", err)
+	}
+	store, err := repository.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	cdkService := service.NewCDKService(store, codeCipher, time.Now)
+
+	if args[0] == "reindex" {
+		result, err := cdkService.RebuildFingerprints(true)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("共 %d 个CDK，更新指纹 %d 个，失败 %d 个\n", result.Total, result.Updated, result.Failed)
+		if result.Failed > 0 {
+			return fmt.Errorf("有 %d 个CDK无法计算指纹，请检查日志", result.Failed)
+		}
+		return nil
+	}
+
+	// 与服务端注册同一组持有加密数据的服务；轮换只用到存储和密钥，其余依赖留空
+	keyRotation := service.NewKeyRotationService(codeCipher,
+		cdkService,
+		service.NewAdminAuthService(cfg.Admin, nil, nil, store, codeCipher, time.Now),
+		service.NewUserSyncService(store, nil, nil, codeCipher, time.Now),
+	)
+	result, err := keyRotation.RotateKeys()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("当前密钥 %s：共 %d 条加密数据（CDK、两步验证密钥和LinuxDo令牌），重新加密 %d 条，无法解密 %d 条\n", result.KeyID, result.Total, result.Rotated, result.Failed)
+	if result.Failed > 0 {
+		return fmt.Errorf("有 %d 条数据无法解密，请检查是否缺少旧密钥", result.Failed)
+	}
+	return nil
+}
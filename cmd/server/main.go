@@ -1,41 +1,172 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/zhongruan0522/DuiDuiMao/internal/config"
 	"github.com/zhongruan0522/DuiDuiMao/internal/handler"
 	"github.com/zhongruan0522/DuiDuiMao/internal/middleware"
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
 	"github.com/zhongruan0522/DuiDuiMao/internal/service"
 	"github.com/zhongruan0522/DuiDuiMao/internal/util"
 )
 
+const (
+	orderSweepInterval = 30 * time.Second // 超时订单扫描间隔
+	shutdownTimeout    = 10 * time.Second // 优雅关闭等待时间
+	adminLockoutBase   = 2 * time.Second  // 管理员登录第一次锁定的时长，此后每次失败翻倍
+	configWatchPeriod  = 5 * time.Second  // 检查配置文件是否修改的间隔
+)
+
 func main() {
+	// 生成管理员密码哈希子命令：server admin hash-password（无需配置文件）
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		if err := runAdmin(os.Args[2:]); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+
 	// 加载配置
 	cfg, err := config.Load()
 	if err != nil {
 		log.Fatalf("加载配置失败: %v", err)
 	}
 
+	// 数据库迁移子命令：server migrate up|down|status
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(cfg, os.Args[2:]); err != nil {
+			log.Fatalf("数据库迁移失败: %v", err)
+		}
+		return
+	}
+
+	// CDK维护子命令：server cdk rotate-key|reindex
+	if len(os.Args) > 1 && os.Args[1] == "cdk" {
+		if err := runCDK(cfg, os.Args[2:]); err != nil {
+			log.Fatalf("CDK维护失败: %v", err)
+		}
+		return
+	}
+
+	// CDK加密存储（AES-GCM），未配置密钥时退化为Base64编码
+	codeCipher, err := util.NewCodeCipher(cfg.CDK.KeyID, cfg.CDK.Keys, cfg.CDK.FingerprintKey)
+	if err != nil {
+		log.Fatalf("CDK加密配置错误: %v", err)
+	}
+	if !codeCipher.Enabled() {
+		log.Printf("⚠️ 未配置CDK加密密钥（cdk_key_id/cdk_keys），CDK仅以Base64编码存储，生产环境请务必配置")
+	}
+	if !codeCipher.FingerprintEnabled() {
+		log.Printf("⚠️ 未配置CDK指纹密钥（cdk_fingerprint_key），正在使用内置的开发密钥，生产环境请务必配置")
+	}
+
+	// server模式禁止使用默认的管理员密码（重新加载配置时同样校验），并提醒使用密码哈希
+	if err := checkAdminPassword(cfg); err != nil {
+		log.Fatalf("%v", err)
+	}
+	config.AddValidator(checkAdminPassword)
+	if cfg.Server.Mode == config.ModeServer && !util.IsPasswordHash(cfg.Admin.Password) {
+		log.Printf("⚠️ 管理员密码（adminpassword）以明文配置，建议使用 server admin hash-password 生成的哈希")
+	}
+
 	// 初始化JWT
 	util.InitJWT(cfg.JWT.Secret)
 
 	// 创建Gin引擎
-	r := gin.Default()
+	r, err := newEngine(cfg.Server.TrustedProxies)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	// 打开存储（dev模式使用CSV，sqlite/server模式使用数据库并自动执行迁移）
+	store, err := repository.Open(cfg)
+	if err != nil {
+		log.Fatalf("初始化存储失败: %v", err)
+	}
+	defer store.Close()
 
 	// 创建服务层
-	userService := service.NewUserService(cfg.Server.Mode)
-	tierService := service.NewTierService(cfg.Server.Mode)
-	cdkService := service.NewCDKService(cfg.Server.Mode)
-	redeemLogService := service.NewRedeemLogService(cfg.Server.Mode)
+	userService := service.NewUserService(store.Users(), cfg.Admin)
+	// 收回已从配置中移除的管理员的角色
+	if _, err := userService.SyncAdminRoles(); err != nil {
+		log.Printf("同步管理员角色失败: %v", err)
+	}
+	tierService := service.NewTierService(store.Tiers(), store.CDKs(), time.Now)
+	cdkService := service.NewCDKService(store, codeCipher, time.Now)
+	// 回填尚未计算指纹的CDK（升级前导入的数据），否则导入时无法检测到与它们重复
+	if result, err := cdkService.RebuildFingerprints(false); err != nil {
+		log.Printf("回填CDK指纹失败: %v", err)
+	} else if result.Updated > 0 || result.Failed > 0 {
+		log.Printf("回填CDK指纹：更新 %d 个，失败 %d 个", result.Updated, result.Failed)
+	}
+	redeemLogService := service.NewRedeemLogService(store)
+	orderService := service.NewOrderService(store, time.Now)
+	settingsService := service.NewSettingsService(store.Settings(), time.Now)
+
+	// 后台关闭超时未支付的订单并释放锁定的CDK
+	orderSweeper := service.NewOrderSweeper(orderService, orderSweepInterval)
+	orderSweeper.Start()
+	paymentService := service.NewPaymentService(store, orderService)
+	sessionService := service.NewSessionService(store, time.Duration(cfg.JWT.AccessMinutes)*time.Minute, time.Duration(cfg.JWT.ExpireHours)*time.Hour, time.Now)
+	payService := service.NewPayService(cfg.Pay, orderService, paymentService)
+	linuxDoClient := service.NewLinuxDoClient(cfg.OAuth, nil)
+	loginLimiter := service.NewLoginLimiter(cfg.Admin.FreeAttempts, adminLockoutBase, time.Duration(cfg.Admin.LockoutMinutes)*time.Minute, time.Now)
+	adminAuthService := service.NewAdminAuthService(cfg.Admin, userService, loginLimiter, store, codeCipher, time.Now)
+	oauthStateRepo := store.OAuthStates()
+	if cfg.OAuth.StateStore == config.StateStoreMemory {
+		oauthStateRepo = repository.NewMemoryOAuthStateRepository()
+	}
+	oauthStateService := service.NewOAuthStateService(oauthStateRepo, time.Duration(cfg.OAuth.StateMinutes)*time.Minute, cfg.OAuth.PKCE, time.Now)
+	userSyncService := service.NewUserSyncService(store, linuxDoClient, sessionService, codeCipher, time.Now)
+	// 所有用CDK密钥加密数据的服务都要注册到密钥轮换服务
+	keyRotationService := service.NewKeyRotationService(codeCipher, cdkService, adminAuthService, userSyncService)
+
+	// 后台定期同步LinuxDo用户的信任等级和账号状态，降级或被禁言的用户及时失去相应权限
+	var userSyncer *service.UserSyncer
+	if cfg.OAuth.SyncHours > 0 {
+		userSyncer = service.NewUserSyncer(userSyncService, time.Duration(cfg.OAuth.SyncHours)*time.Hour)
+		userSyncer.Start()
+	}
+
+	// 重新加载配置后把新配置应用到各个服务（处理器每次请求都通过服务或config.Get()读取当前配置）
+	config.Subscribe(func(cfg *config.Config) {
+		userService.SetAdmins(cfg.Admin)
+		if _, err := userService.SyncAdminRoles(); err != nil {
+			log.Printf("同步管理员角色失败: %v", err)
+		}
+		adminAuthService.SetConfig(cfg.Admin)
+		loginLimiter.SetLimits(cfg.Admin.FreeAttempts, time.Duration(cfg.Admin.LockoutMinutes)*time.Minute)
+		payService.SetConfig(cfg.Pay)
+		linuxDoClient.SetConfig(cfg.OAuth)
+		sessionService.SetTTL(time.Duration(cfg.JWT.AccessMinutes)*time.Minute, time.Duration(cfg.JWT.ExpireHours)*time.Hour)
+		oauthStateService.SetOptions(time.Duration(cfg.OAuth.StateMinutes)*time.Minute, cfg.OAuth.PKCE)
+	})
+
+	// 配置文件修改后自动重新加载，也可以发送SIGHUP立即重新加载
+	stopWatch := make(chan struct{})
+	go config.Watch(configWatchPeriod, stopWatch)
+	go reloadOnSIGHUP(stopWatch)
 
 	// 创建处理器
-	authHandler := handler.NewAuthHandler(cfg, userService)
-	userHandler := handler.NewUserHandler()
-	redeemHandler := handler.NewRedeemHandler(tierService, cdkService, redeemLogService)
-	adminHandler := handler.NewAdminHandler(tierService, cdkService, redeemLogService)
+	authHandler := handler.NewAuthHandler(userService, sessionService, userSyncService, adminAuthService, oauthStateService, linuxDoClient)
+	siteHandler := handler.NewSiteHandler(settingsService)
+	userHandler := handler.NewUserHandler(userService, tierService, redeemLogService)
+	tierHandler := handler.NewTierHandler(tierService, userService)
+	redeemHandler := handler.NewRedeemHandler(tierService, cdkService, redeemLogService, userService)
+	orderHandler := handler.NewOrderHandler(orderService, tierService, userService, cdkService, payService, paymentService)
+	payHandler := handler.NewPayHandler(payService, orderService, tierService)
+	adminHandler := handler.NewAdminHandler(tierService, cdkService, redeemLogService, orderService, paymentService, userService, userSyncService, adminAuthService, settingsService, keyRotationService)
 
 	// ========== 用户端接口 ==========
 	api := r.Group("/api")
@@ -46,45 +177,101 @@ func main() {
 			auth.POST("/admin/login", authHandler.AdminLogin) // 管理员账密登录
 			auth.GET("/login", authHandler.Login)             // LinuxDo OAuth登录跳转
 			auth.GET("/callback", authHandler.Callback)       // OAuth回调
-			auth.POST("/logout", authHandler.Logout)          // 登出
+			auth.POST("/refresh", authHandler.Refresh)        // 刷新Token
+		}
+
+		// 登出接口（需要登录）
+		session := api.Group("/auth", middleware.AuthMiddleware(sessionService))
+		{
+			session.POST("/logout", authHandler.Logout)        // 登出当前会话
+			session.POST("/logout-all", authHandler.LogoutAll) // 登出所有设备
 		}
 
 		// 用户接口（需要登录）
-		user := api.Group("/user", middleware.AuthMiddleware())
+		user := api.Group("/user", middleware.AuthMiddleware(sessionService))
 		{
 			user.GET("/me", userHandler.GetMe)
 		}
 
-		// 档位接口（无需登录）
-		api.GET("/tiers", adminHandler.GetTiers) // 暂时用管理端的接口，后续可以创建用户端专用接口
+		// 站点信息（无需登录）：首页公告和是否开放兑换
+		api.GET("/site", siteHandler.GetSite)
+
+		// 全局开关关闭时暂停兑换、下单和发起支付（查询、取消订单和支付回调不受影响）
+		purchaseSwitch := middleware.PurchaseSwitchMiddleware(settingsService)
+
+		// 档位接口（无需登录，登录后额外返回是否满足信任等级要求）
+		api.GET("/tiers", middleware.OptionalAuthMiddleware(sessionService), tierHandler.GetTiers)
 
 		// 兑换接口（需要登录）
-		redeem := api.Group("/redeem", middleware.AuthMiddleware())
+		redeem := api.Group("/redeem", middleware.AuthMiddleware(sessionService))
 		{
-			redeem.POST("/:tier_id", redeemHandler.Redeem)
+			redeem.POST("/:tier_id", purchaseSwitch, redeemHandler.Redeem)
 			redeem.GET("/history", redeemHandler.GetHistory)
 		}
 
+		// 订单接口（需要登录）
+		orders := api.Group("/orders", middleware.AuthMiddleware(sessionService))
+		{
+			orders.POST("", purchaseSwitch, orderHandler.CreateOrder)
+			orders.GET("", orderHandler.GetOrders)
+			orders.GET("/:order_no", orderHandler.GetOrder)
+			orders.POST("/:order_no/cancel", orderHandler.CancelOrder)
+			orders.GET("/:order_no/cdks", orderHandler.GetOrderCDKs)
+		}
+
+		// 支付接口（回跳和异步通知由支付网关调用，通过签名校验，无需登录）
+		pay := api.Group("/pay")
+		{
+			pay.POST("/:order_no", middleware.AuthMiddleware(sessionService), purchaseSwitch, payHandler.Pay)
+			pay.GET("/callback", payHandler.Callback)
+			pay.GET("/notify", payHandler.Notify)
+			pay.POST("/notify", payHandler.Notify)
+		}
+
 		// ========== 管理端接口 ==========
-		admin := api.Group("/admin", middleware.AuthMiddleware(), middleware.AdminMiddleware())
+		// 任一管理员角色可进入管理端，具体接口再按角色限制；超级管理员拥有全部权限
+		superAdmin := middleware.RoleMiddleware()
+		cdkOperator := middleware.RoleMiddleware(model.RoleCDKOperator)
+		auditor := middleware.RoleMiddleware(model.RoleAuditor)
+		admin := api.Group("/admin", middleware.AuthMiddleware(sessionService), middleware.RoleMiddleware(model.RoleCDKOperator, model.RoleAuditor))
 		{
 			// 档位管理
 			admin.GET("/tiers", adminHandler.GetTiers)
-			admin.POST("/tiers", adminHandler.CreateTier)
-			admin.PUT("/tiers/:id", adminHandler.UpdateTier)
-			admin.DELETE("/tiers/:id", adminHandler.DeleteTier)
+			admin.POST("/tiers", superAdmin, adminHandler.CreateTier)
+			admin.PUT("/tiers/:id", superAdmin, adminHandler.UpdateTier)
+			admin.DELETE("/tiers/:id", superAdmin, adminHandler.DeleteTier)
+			admin.POST("/tiers/:id/releases", cdkOperator, adminHandler.ScheduleRelease)
+			admin.DELETE("/tiers/:id/releases", cdkOperator, adminHandler.ReleaseNow)
 
 			// CDK管理
-			admin.POST("/cdks/import", adminHandler.ImportCDKs)
+			admin.POST("/cdks/import", cdkOperator, adminHandler.ImportCDKs)
 			admin.GET("/cdks", adminHandler.GetCDKs)
-			admin.PUT("/cdks/:id/revoke", adminHandler.RevokeCDK)
+			admin.PUT("/cdks/:id/revoke", cdkOperator, adminHandler.RevokeCDK)
+			admin.POST("/cdks/rotate-key", superAdmin, adminHandler.RotateCDKKeys)
+
+			// 用户管理
+			admin.POST("/users/sync", superAdmin, adminHandler.SyncUsers)
+			admin.POST("/users/:id/sync", superAdmin, adminHandler.SyncUser)
 
 			// 订单管理
-			admin.GET("/orders", adminHandler.GetOrders)
+			admin.GET("/orders", auditor, adminHandler.GetOrders)
+			admin.GET("/orders/:order_no", auditor, adminHandler.GetOrder)
+
+			// 对账
+			admin.GET("/reconciliation", auditor, adminHandler.Reconcile)
+
+			// 管理员登录审计
+			admin.GET("/login-audits", auditor, adminHandler.GetLoginAudits)
+
+			// 账密登录管理员的两步验证
+			admin.GET("/2fa", superAdmin, adminHandler.GetTOTPStatus)
+			admin.POST("/2fa/setup", superAdmin, adminHandler.SetupTOTP)
+			admin.POST("/2fa/enable", superAdmin, adminHandler.EnableTOTP)
+			admin.POST("/2fa/disable", superAdmin, adminHandler.DisableTOTP)
 
 			// 系统设置
-			admin.GET("/settings", adminHandler.GetSettings)
-			admin.PUT("/settings", adminHandler.UpdateSettings)
+			admin.GET("/settings", auditor, adminHandler.GetSettings)
+			admin.PUT("/settings", superAdmin, adminHandler.UpdateSettings)
 		}
 	}
 
@@ -97,12 +284,68 @@ func main() {
 
 	// 启动服务器
 	addr := fmt.Sprintf(":%d", cfg.Server.Port)
+	srv := &http.Server{Addr: addr, Handler: r}
 	log.Printf("🚀 DuiDuiMao 服务启动成功！")
 	log.Printf("📍 监听地址: http://localhost%s", addr)
 	log.Printf("🎯 运行模式: %s", cfg.Server.Mode)
 
-	err = r.Run(addr)
-	if err != nil {
-		log.Fatalf("服务启动失败: %v", err)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("服务启动失败: %v", err)
+		}
+	}()
+
+	// 收到退出信号后优雅关闭：停止接收新请求、等待处理中的请求，再停止后台任务
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	log.Printf("正在关闭服务...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("关闭HTTP服务失败: %v", err)
+	}
+	close(stopWatch)
+	orderSweeper.Stop()
+	if userSyncer != nil {
+		userSyncer.Stop()
+	}
+}
+
+// newEngine 创建Gin引擎，只采信来自可信代理的X-Forwarded-For，防止伪造客户端IP绕过登录限流
+func newEngine(trustedProxies []string) (*gin.Engine, error) {
+	r := gin.Default()
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		return nil, fmt.Errorf("设置可信代理失败: %v", err)
+	}
+	return r, nil
+}
+
+// checkAdminPassword server模式下不能使用默认的管理员密码
+func checkAdminPassword(cfg *config.Config) error {
+	if cfg.Server.Mode == config.ModeServer && util.CheckPassword(cfg.Admin.Password, config.DefaultAdminPassword) {
+		return fmt.Errorf("server模式下不能使用默认的管理员密码，请修改 adminpassword（可用 server admin hash-password 生成哈希）")
+	}
+	return nil
+}
+
+// reloadOnSIGHUP 收到SIGHUP时重新加载配置文件，直到stop被关闭
+func reloadOnSIGHUP(stop <-chan struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-hup:
+			if err := config.Reload(); err != nil {
+				log.Printf("收到SIGHUP，但重新加载配置失败，继续使用原配置: %v", err)
+				continue
+			}
+			log.Printf("收到SIGHUP，已重新加载配置")
+		}
 	}
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zhongruan0522/DuiDuiMao/internal/config"
+	"github.com/zhongruan0522/DuiDuiMao/internal/handler"
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
+	"github.com/zhongruan0522/DuiDuiMao/internal/service"
+	"github.com/zhongruan0522/DuiDuiMao/internal/util"
+)
+
+// newLoginEngine 创建只挂载管理员登录接口的引擎，同一IP连续失败超过free次后锁定
+func newLoginEngine(t *testing.T, trustedProxies []string, free int) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	store, err := repository.OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("打开存储失败: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	adminCfg := config.AdminConfig{Username: "root", Password: "rootpassword"}
+	userService := service.NewUserService(store.Users(), adminCfg)
+	limiter := service.NewLoginLimiter(free, time.Minute, time.Hour, time.Now)
+	adminAuth := service.NewAdminAuthService(adminCfg, userService, limiter, store, nil, time.Now)
+	authHandler := handler.NewAuthHandler(userService, nil, nil, adminAuth, nil, nil)
+
+	r, err := newEngine(trustedProxies)
+	if err != nil {
+		t.Fatalf("newEngine() error = %v", err)
+	}
+	r.POST("/api/auth/admin/login", authHandler.AdminLogin)
+	return r
+}
+
+// adminLogin 从remoteAddr发起一次密码错误的登录，每次使用不同的用户名，只有IP维度会累计失败次数
+func adminLogin(r *gin.Engine, remoteAddr, forwardedFor string, attempt int) int {
+	body := fmt.Sprintf(`{"username":%q,"password":%q}`, util.DoubleEncode(fmt.Sprintf("user%d", attempt)), util.DoubleEncode("wrong"))
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/admin/login", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = remoteAddr
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestTrustedProxies(t *testing.T) {
+	t.Run("未配置可信代理时伪造X-Forwarded-For不能绕过限流", func(t *testing.T) {
+		r := newLoginEngine(t, nil, 2)
+		for i := 0; i < 3; i++ {
+			if code := adminLogin(r, "203.0.113.7:1234", fmt.Sprintf("198.51.100.%d", i+1), i); code != http.StatusUnauthorized {
+				t.Fatalf("第%d次登录 状态码 = %d, want 401", i+1, code)
+			}
+		}
+		if code := adminLogin(r, "203.0.113.7:1234", "198.51.100.99", 3); code != http.StatusTooManyRequests {
+			t.Errorf("更换X-Forwarded-For后 状态码 = %d, want 429", code)
+		}
+	})
+
+	t.Run("来自可信代理的请求按X-Forwarded-For限流", func(t *testing.T) {
+		r := newLoginEngine(t, []string{"10.0.0.0/8"}, 2)
+		for i := 0; i < 3; i++ {
+			if code := adminLogin(r, "10.0.0.1:1234", "198.51.100.1", i); code != http.StatusUnauthorized {
+				t.Fatalf("第%d次登录 状态码 = %d, want 401", i+1, code)
+			}
+		}
+		if code := adminLogin(r, "10.0.0.1:1234", "198.51.100.1", 3); code != http.StatusTooManyRequests {
+			t.Errorf("同一客户端 状态码 = %d, want 429", code)
+		}
+		// 代理后的其他客户端不受影响
+		if code := adminLogin(r, "10.0.0.1:1234", "198.51.100.2", 4); code != http.StatusUnauthorized {
+			t.Errorf("其他客户端 状态码 = %d, want 401", code)
+		}
+	})
+
+	t.Run("无效的代理地址", func(t *testing.T) {
+		if _, err := newEngine([]string{"nginx"}); err == nil {
+			t.Error("newEngine() error = nil, want 错误")
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/config"
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
+)
+
+// runMigrate 执行数据库迁移子命令：migrate up|down|status
+func runMigrate(cfg *config.Config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("用法: server migrate up|down|status")
+	}
+
+	var db *sql.DB
+	var err error
+	var newMigrator func(db *sql.DB) (*repository.Migrator, error)
+	switch cfg.Server.Mode {
+	case config.ModeServer:
+		if cfg.Database.URL == "" {
+			return fmt.Errorf("server模式必须配置dburl")
+		}
+		db, err = repository.OpenPostgresDB(cfg.Database.URL)
+		newMigrator = repository.NewPostgresMigrator
+	case config.ModeSQLite:
+		db, err = repository.OpenSQLiteDB(cfg.Database.SQLitePath)
+		newMigrator = repository.NewSQLiteMigrator
+	default:
+		return fmt.Errorf("当前模式不支持数据库迁移: %s", cfg.Server.Mode)
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := newMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up()
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("数据库已是最新版本")
+			return nil
+		}
+		for _, version := range applied {
+			fmt.Printf("已应用迁移 %04d\n", version)
+		}
+	case "down":
+		version, err := migrator.Down()
+		if err != nil {
+			return err
+		}
+		if version == 0 {
+			fmt.Println("没有可回滚的迁移")
+			return nil
+		}
+		fmt.Printf("已回滚迁移 %04d\n", version)
+	case "status":
+		statuses, err := migrator.Status()
+		for _, s := range statuses {
+			state := "未应用"
+			if s.Applied {
+				state = "已应用 " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-24s %s\n", s.Version, s.Name, state)
+		}
+		return err
+	default:
+		return fmt.Errorf("未知的迁移命令: %s（可选 up|down|status）", args[0])
+	}
+	return nil
+}
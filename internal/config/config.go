@@ -3,15 +3,30 @@ package config
 import (
 	"fmt"
 	"os"
-	"strconv"
+	"slices"
 	"strings"
 	"sync"
+	"time"
+	_ "time/tzdata" // 内置时区数据，精简镜像中没有系统时区库也能加载
 )
 
 // 运行模式常量
 const (
 	ModeDev    = "dev"    // 开发模式
 	ModeServer = "server" // 服务器模式
+	ModeSQLite = "sqlite" // SQLite单文件模式
+)
+
+// OAuth state存储方式
+const (
+	StateStoreDB     = "db"     // 保存在数据库（dev模式为CSV），重启和多实例部署不影响进行中的登录
+	StateStoreMemory = "memory" // 保存在内存，仅适合单实例部署
+)
+
+// 默认的管理员账号密码（仅适合开发环境，server模式下拒绝启动）
+const (
+	DefaultAdminUsername = "root"
+	DefaultAdminPassword = "rootpassword"
 )
 
 // Config 应用配置结构
@@ -22,13 +37,18 @@ type Config struct {
 	Admin    AdminConfig
 	Database DatabaseConfig
 	JWT      JWTConfig
+	CDK      CDKConfig
 	Settings SettingsConfig
 }
 
 // ServerConfig 服务器配置
 type ServerConfig struct {
-	Port int
-	Mode string // dev 或 server
+	Port     int
+	Mode     string         // dev、sqlite 或 server
+	Timezone string         // 业务时区（每日限购按该时区的自然日计算）
+	Location *time.Location // Timezone 解析后的时区
+	// TrustedProxies 可信反向代理的IP或CIDR，只有来自这些地址的请求才采信X-Forwarded-For；为空时不信任任何代理，客户端IP取连接的远端地址
+	TrustedProxies []string
 }
 
 // OAuthConfig OAuth认证配置
@@ -36,46 +56,101 @@ type OAuthConfig struct {
 	AppClientID     string
 	AppClientSecret string
 	RedirectURI     string
+	AuthorizeURL    string // 授权地址（浏览器跳转）
+	TokenURL        string // 令牌接口地址
+	UserURL         string // 用户信息接口地址
+	TimeoutSeconds  int    // 请求令牌和用户信息接口的超时时间（秒）
+	SyncHours       int    // 定期同步LinuxDo用户信任等级和账号状态的间隔（小时），0表示不同步
+	StateStore      string // OAuth state存储方式：db 或 memory
+	StateMinutes    int    // OAuth state有效期（分钟），超时未完成授权需要重新登录
+	PKCE            bool   // 是否使用PKCE（code_challenge_method=S256）
 }
 
 // PayConfig 支付配置
 type PayConfig struct {
+	APIURL       string // LinuxDo Credit 支付网关地址
 	ClientID     string
 	ClientSecret string
-	CallbackURL  string
-	NotifyURL    string
+	CallbackURL  string // 支付完成后浏览器跳转地址
+	NotifyURL    string // 支付网关异步通知地址
+}
+
+// Enabled 是否已配置支付（未配置时只能购买免费档位）
+func (p PayConfig) Enabled() bool {
+	return p.ClientID != "" && p.ClientSecret != ""
 }
 
 // AdminConfig 管理员配置
 type AdminConfig struct {
-	Username string
-	Password string
+	Username string // 账密登录的管理员（超级管理员）
+	Password string // bcrypt或argon2id哈希（可用 server admin hash-password 生成），开发环境也可以是明文
+	// 账密登录限流：连续失败超过FreeAttempts次后按指数增长锁定，单次最长锁定LockoutMinutes分钟
+	FreeAttempts   int
+	LockoutMinutes int
+	// 按LinuxDo用户ID或用户名指定的管理员，每次登录时按配置授予或收回角色
+	SuperAdmins  AdminList
+	CDKOperators AdminList
+	Auditors     AdminList
+}
+
+// AdminList 按LinuxDo用户ID或用户名指定的用户
+type AdminList struct {
+	UserIDs   []int
+	Usernames []string
+}
+
+// Contains 列表中是否包含该用户（用户名不区分大小写）
+func (l AdminList) Contains(linuxDoID int, username string) bool {
+	for _, id := range l.UserIDs {
+		if id == linuxDoID {
+			return true
+		}
+	}
+	for _, name := range l.Usernames {
+		if strings.EqualFold(name, username) {
+			return true
+		}
+	}
+	return false
 }
 
 // DatabaseConfig 数据库配置
 type DatabaseConfig struct {
-	URL string
+	URL        string // PostgreSQL连接串（server模式）
+	SQLitePath string // SQLite数据库文件路径（sqlite模式）
 }
 
 // JWTConfig JWT配置
 type JWTConfig struct {
-	Secret      string
-	ExpireHours int
+	Secret        string
+	ExpireHours   int // 登录会话（refresh token）有效期，刷新时顺延
+	AccessMinutes int // access token有效期
 }
 
-// SettingsConfig 系统设置配置
+// CDKConfig CDK加密配置
+type CDKConfig struct {
+	KeyID string            // 当前用于加密的密钥ID
+	Keys  map[string]string // 密钥ID -> Base64编码的32字节AES密钥（保留旧密钥以便解密和轮换）
+	// FingerprintKey Base64编码的HMAC密钥，用于计算CDK指纹检测重复（更换后需执行 cdk reindex）
+	FingerprintKey string
+}
+
+// SettingsConfig 系统设置的初始值（管理后台首次保存后以数据库中的设置为准）
 type SettingsConfig struct {
 	GlobalEnabled      bool   // 全局开关（是否暂停购买功能）
 	Announcement       string // 公告内容
 	OrderExpireMinutes int    // 订单超时时间（分钟）
 }
 
+// configFile 配置文件路径，可通过环境变量 CONFIG_FILE 指定
+var configFile = "config.yaml"
+
 var (
 	globalConfig *Config
 	configMutex  sync.RWMutex
 )
 
-// Load 从config.yaml加载配置（使用简单的键值对格式）
+// Load 加载配置：读取config.yaml（YAML格式，兼容旧版的键值对格式），再用环境变量覆盖，最后校验
 func Load() (*Config, error) {
 	cfg, err := loadFromFile()
 	if err != nil {
@@ -90,153 +165,120 @@ func Load() (*Config, error) {
 	return cfg, nil
 }
 
-// loadFromFile 从文件加载配置
-func loadFromFile() (*Config, error) {
-	data, err := os.ReadFile("config.yaml")
-	if err != nil {
-		return nil, fmt.Errorf("读取配置文件失败: %v", err)
-	}
-
-	cfg := &Config{}
-	lines := strings.Split(string(data), ",")
-
-	configMap := make(map[string]string)
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) != 2 {
-			continue
-		}
-
-		key := strings.Trim(parts[0], "\"")
-		value := strings.Trim(parts[1], "\"")
-		configMap[key] = value
+// configPath 当前使用的配置文件路径
+func configPath() string {
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		return path
 	}
-
-	// 解析配置
-	cfg.Server.Port, _ = strconv.Atoi(getConfigValue(configMap, "port", "3001"))
-	cfg.Server.Mode = getConfigValue(configMap, "mode", ModeDev)
-
-	cfg.OAuth.AppClientID = getConfigValue(configMap, "app_client_id", "")
-	cfg.OAuth.AppClientSecret = getConfigValue(configMap, "app_client_secret", "")
-	cfg.OAuth.RedirectURI = getConfigValue(configMap, "app_redirect_uri", "http://localhost:3001/api/auth/callback")
-
-	cfg.Pay.ClientID = getConfigValue(configMap, "pay_client_id", "")
-	cfg.Pay.ClientSecret = getConfigValue(configMap, "pay_client_secret", "")
-	cfg.Pay.CallbackURL = getConfigValue(configMap, "pay_callback_url", "http://localhost:3001/api/pay/callback")
-	cfg.Pay.NotifyURL = getConfigValue(configMap, "pay_notify_url", "http://localhost:3001/api/pay/notify")
-
-	cfg.Admin.Username = getConfigValue(configMap, "adminname", "root")
-	cfg.Admin.Password = getConfigValue(configMap, "adminpassword", "rootpassword")
-
-	cfg.Database.URL = getConfigValue(configMap, "dburl", "")
-
-	cfg.JWT.Secret = getConfigValue(configMap, "jwt_secret", "default_jwt_secret")
-	cfg.JWT.ExpireHours, _ = strconv.Atoi(getConfigValue(configMap, "jwt_expire_hours", "168"))
-
-	// 解析系统设置
-	cfg.Settings.GlobalEnabled = getConfigValue(configMap, "global_enabled", "true") == "true"
-	cfg.Settings.Announcement = getConfigValue(configMap, "announcement", "欢迎使用兑兑猫 CDK 兑换平台！")
-	cfg.Settings.OrderExpireMinutes, _ = strconv.Atoi(getConfigValue(configMap, "order_expire_minutes", "15"))
-
-	return cfg, nil
+	return configFile
 }
 
-func getConfigValue(m map[string]string, key, defaultValue string) string {
-	if v, ok := m[key]; ok && v != "" {
-		return v
+// loadFromFile 从文件加载配置，文件不存在时只使用环境变量和默认值
+func loadFromFile() (*Config, error) {
+	data, err := os.ReadFile(configPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("读取配置文件失败: %v", err)
 	}
-	return defaultValue
-}
 
-// Get 获取当前全局配置（线程安全）
-func Get() *Config {
-	configMutex.RLock()
-	defer configMutex.RUnlock()
-	return globalConfig
-}
-
-// Reload 重新加载配置文件（热重载）
-func Reload() error {
-	cfg, err := loadFromFile()
+	values, err := parseValues(data)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("配置文件 %s 格式错误: %v", configPath(), err)
 	}
-
-	configMutex.Lock()
-	globalConfig = cfg
-	configMutex.Unlock()
-
-	return nil
-}
-
-// UpdateSettingsRequest 更新系统设置请求
-type UpdateSettingsRequest struct {
-	GlobalEnabled      *bool   `json:"global_enabled"`
-	Announcement       *string `json:"announcement"`
-	OrderExpireMinutes *int    `json:"order_expire_minutes"`
+	overlayEnv(values)
+	return build(values)
 }
 
-// UpdateSettings 更新系统设置并写入配置文件（热更新）
-func UpdateSettings(req UpdateSettingsRequest) error {
-	configMutex.Lock()
-	defer configMutex.Unlock()
+// build 按类型解析并校验配置，返回所有校验错误
+func build(values map[string]string) (*Config, error) {
+	p := &valueParser{values: values}
+	cfg := &Config{}
 
-	// 读取当前配置文件内容
-	data, err := os.ReadFile("config.yaml")
+	cfg.Server.Port = p.int("port", 3001, 1, 65535)
+	cfg.Server.Mode = p.oneOf("mode", ModeDev, ModeDev, ModeServer, ModeSQLite)
+	cfg.Server.Timezone = p.str("timezone", "Asia/Shanghai")
+	loc, err := time.LoadLocation(cfg.Server.Timezone)
 	if err != nil {
-		return fmt.Errorf("读取配置文件失败: %v", err)
+		p.fail("无效的时区 %s: %v", cfg.Server.Timezone, err)
+		loc = time.Local
 	}
+	cfg.Server.Location = loc
+	cfg.Server.TrustedProxies = p.proxyList("trusted_proxies")
+
+	cfg.OAuth.AppClientID = p.str("app_client_id", "")
+	cfg.OAuth.AppClientSecret = p.str("app_client_secret", "")
+	cfg.OAuth.RedirectURI = p.url("app_redirect_uri", "http://localhost:3001/api/auth/callback")
+	// LinuxDo Connect接口地址，可替换为其他基于Discourse的Connect服务
+	cfg.OAuth.AuthorizeURL = p.url("linuxdo_authorize_url", "https://connect.linux.do/oauth2/authorize")
+	cfg.OAuth.TokenURL = p.url("linuxdo_token_url", "https://connect.linux.do/oauth2/token")
+	cfg.OAuth.UserURL = p.url("linuxdo_user_url", "https://connect.linux.do/api/user")
+	cfg.OAuth.TimeoutSeconds = p.int("linuxdo_timeout_seconds", 10, 1, 300)
+	cfg.OAuth.SyncHours = p.int("linuxdo_sync_hours", 6, 0, 24*30)
+	cfg.OAuth.StateStore = p.oneOf("oauth_state_store", StateStoreDB, StateStoreDB, StateStoreMemory)
+	cfg.OAuth.StateMinutes = p.int("oauth_state_minutes", 10, 1, 24*60)
+	cfg.OAuth.PKCE = p.bool("linuxdo_pkce", false)
+
+	cfg.Pay.APIURL = p.url("pay_api_url", "https://credit.linux.do/epay")
+	cfg.Pay.ClientID = p.str("pay_client_id", "")
+	cfg.Pay.ClientSecret = p.str("pay_client_secret", "")
+	cfg.Pay.CallbackURL = p.url("pay_callback_url", "http://localhost:3001/api/pay/callback")
+	cfg.Pay.NotifyURL = p.url("pay_notify_url", "http://localhost:3001/api/pay/notify")
+
+	cfg.Admin.Username = p.str("adminname", DefaultAdminUsername)
+	cfg.Admin.Password = p.str("adminpassword", DefaultAdminPassword)
+	cfg.Admin.FreeAttempts = p.int("admin_login_free_attempts", 5, 0, 100)
+	cfg.Admin.LockoutMinutes = p.int("admin_lockout_max_minutes", 15, 1, 24*60)
+	cfg.Admin.SuperAdmins = p.adminList("admin")
+	cfg.Admin.CDKOperators = p.adminList("cdk_operator")
+	cfg.Admin.Auditors = p.adminList("auditor")
+
+	cfg.Database.URL = p.str("dburl", "")
+	cfg.Database.SQLitePath = p.str("sqlite_path", "./data/duiuimao.db")
+
+	cfg.JWT.Secret = p.str("jwt_secret", "default_jwt_secret")
+	cfg.JWT.ExpireHours = p.int("jwt_expire_hours", 168, 1, 24*365)
+	cfg.JWT.AccessMinutes = p.int("jwt_access_minutes", 15, 1, 24*60)
+
+	// CDK加密密钥，格式: 密钥ID:Base64密钥;密钥ID:Base64密钥
+	cfg.CDK.KeyID = p.str("cdk_key_id", "")
+	cfg.CDK.Keys = p.cdkKeys("cdk_keys")
+	cfg.CDK.FingerprintKey = p.str("cdk_fingerprint_key", "")
 
-	configMap := make(map[string]string)
-	lines := strings.Split(string(data), ",")
-
-	// 解析现有配置
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
+	// 解析系统设置
+	cfg.Settings.GlobalEnabled = p.bool("global_enabled", true)
+	cfg.Settings.Announcement = p.str("announcement", "欢迎使用兑兑猫 CDK 兑换平台！")
+	cfg.Settings.OrderExpireMinutes = p.int("order_expire_minutes", 15, 1, 24*60)
+
+	// server模式必须配置数据库和各项密钥
+	if cfg.Server.Mode == ModeServer {
+		if cfg.Database.URL == "" {
+			p.fail("server模式必须配置dburl（或 database.postgres）")
 		}
-
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) != 2 {
-			continue
+		if cfg.OAuth.AppClientID == "" || cfg.OAuth.AppClientSecret == "" {
+			p.fail("server模式必须配置app_client_id和app_client_secret")
+		}
+		if len(cfg.JWT.Secret) < minJWTSecretLength || slices.Contains(insecureJWTSecrets, cfg.JWT.Secret) {
+			p.fail("server模式必须配置至少%d个字符的jwt_secret，且不能使用示例值", minJWTSecretLength)
+		}
+		// CDK、两步验证密钥和LinuxDo令牌都用这套密钥加密，不能退回开发用的双重Base64和固定指纹密钥
+		if cfg.CDK.KeyID == "" || len(cfg.CDK.Keys) == 0 {
+			p.fail("server模式必须配置cdk_key_id和cdk_keys")
+		} else if _, ok := cfg.CDK.Keys[cfg.CDK.KeyID]; !ok {
+			p.fail("cdk_keys 中没有 cdk_key_id 指定的密钥 %s", cfg.CDK.KeyID)
+		}
+		if cfg.CDK.FingerprintKey == "" {
+			p.fail("server模式必须配置cdk_fingerprint_key")
 		}
-
-		key := strings.Trim(parts[0], "\"")
-		value := strings.Trim(parts[1], "\"")
-		configMap[key] = value
-	}
-
-	// 更新设置
-	if req.GlobalEnabled != nil {
-		configMap["global_enabled"] = strconv.FormatBool(*req.GlobalEnabled)
-		globalConfig.Settings.GlobalEnabled = *req.GlobalEnabled
-	}
-	if req.Announcement != nil {
-		configMap["announcement"] = *req.Announcement
-		globalConfig.Settings.Announcement = *req.Announcement
-	}
-	if req.OrderExpireMinutes != nil {
-		configMap["order_expire_minutes"] = strconv.Itoa(*req.OrderExpireMinutes)
-		globalConfig.Settings.OrderExpireMinutes = *req.OrderExpireMinutes
-	}
-
-	// 写回文件
-	var newLines []string
-	for key, value := range configMap {
-		newLines = append(newLines, fmt.Sprintf("\"%s\"=\"%s\"", key, value))
 	}
-	newContent := strings.Join(newLines, ",\n")
 
-	err = os.WriteFile("config.yaml", []byte(newContent), 0644)
-	if err != nil {
-		return fmt.Errorf("写入配置文件失败: %v", err)
+	if err := validationError(p.errs); err != nil {
+		return nil, err
 	}
+	return cfg, nil
+}
 
-	return nil
+// Get 获取当前全局配置（线程安全）
+func Get() *Config {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	return globalConfig
 }
@@ -0,0 +1,277 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loadTestConfig 把content写入临时配置文件并加载
+func loadTestConfig(t *testing.T, content string) (*Config, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("写入配置文件失败: %v", err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	return loadFromFile()
+}
+
+func TestLoadExample(t *testing.T) {
+	data, err := os.ReadFile("../../config.example.yaml")
+	if err != nil {
+		t.Fatalf("读取示例配置失败: %v", err)
+	}
+	cfg, err := loadTestConfig(t, string(data))
+	if err != nil {
+		t.Fatalf("加载示例配置失败: %v", err)
+	}
+	if cfg.Server.Port != 3001 || cfg.Server.Mode != ModeDev || cfg.OAuth.AppClientID != "本地测试ID" || cfg.Admin.FreeAttempts != 5 {
+		t.Errorf("示例配置 = %+v", cfg)
+	}
+}
+
+func TestLoadYAML(t *testing.T) {
+	cfg, err := loadTestConfig(t, `
+server:
+  port: 8080
+  mode: server
+  jwt_secret: "0123456789abcdef0123"
+  trusted_proxies: [127.0.0.1, 10.0.0.0/8]
+database:
+  driver: postgres
+  postgres:
+    host: db
+    user: duiduimao
+    password: "p@ss:word"
+    dbname: duiduimao
+oauth:
+  client_id: id
+  client_secret: secret
+  pkce: true
+admin:
+  user_ids: [124, 456]
+  cdk_operators:
+    usernames: [Alice, bob]
+cdk:
+  key_id: k2
+  keys:
+    k1: a2V5MQ==
+    k2: a2V5Mg==
+  fingerprint_key: ZmluZ2VycHJpbnQta2V5
+order:
+  expire_minutes: 30
+settings:
+  announcement: "欢迎，今天上新, 明天补货"
+`)
+	if err != nil {
+		t.Fatalf("加载配置失败: %v", err)
+	}
+	if cfg.Server.Port != 8080 || cfg.Server.Mode != ModeServer || cfg.JWT.Secret != "0123456789abcdef0123" {
+		t.Errorf("server = %+v, jwt = %+v", cfg.Server, cfg.JWT)
+	}
+	if strings.Join(cfg.Server.TrustedProxies, ";") != "127.0.0.1;10.0.0.0/8" {
+		t.Errorf("TrustedProxies = %v", cfg.Server.TrustedProxies)
+	}
+	if want := "postgres://duiduimao:p%40ss%3Aword@db:5432/duiduimao?sslmode=disable"; cfg.Database.URL != want {
+		t.Errorf("Database.URL = %s, want %s", cfg.Database.URL, want)
+	}
+	if !cfg.OAuth.PKCE || cfg.OAuth.TimeoutSeconds != 10 {
+		t.Errorf("OAuth = %+v", cfg.OAuth)
+	}
+	if len(cfg.Admin.SuperAdmins.UserIDs) != 2 || !cfg.Admin.CDKOperators.Contains(0, "alice") {
+		t.Errorf("Admin = %+v", cfg.Admin)
+	}
+	if cfg.CDK.Keys["k1"] != "a2V5MQ==" || cfg.CDK.Keys["k2"] != "a2V5Mg==" {
+		t.Errorf("CDK.Keys = %v", cfg.CDK.Keys)
+	}
+	if cfg.Settings.OrderExpireMinutes != 30 || cfg.Settings.Announcement != "欢迎，今天上新, 明天补货" {
+		t.Errorf("Settings = %+v", cfg.Settings)
+	}
+}
+
+func TestLoadLegacy(t *testing.T) {
+	cfg, err := loadTestConfig(t, `"port"="3002",
+"mode"="sqlite",
+"admin_user_ids"="1;2",
+"announcement"="你好, 世界",
+"linuxdo_pkce"="true"`)
+	if err != nil {
+		t.Fatalf("加载旧版配置失败: %v", err)
+	}
+	if cfg.Server.Port != 3002 || cfg.Server.Mode != ModeSQLite || !cfg.OAuth.PKCE {
+		t.Errorf("旧版配置 = %+v", cfg)
+	}
+	if len(cfg.Admin.SuperAdmins.UserIDs) != 2 {
+		t.Errorf("admin_user_ids = %v", cfg.Admin.SuperAdmins.UserIDs)
+	}
+	// 引号内的逗号不再截断公告
+	if cfg.Settings.Announcement != "你好, 世界" {
+		t.Errorf("announcement = %q, want %q", cfg.Settings.Announcement, "你好, 世界")
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	t.Setenv("adminname", "boss")
+	t.Setenv("DBURL", "postgres://env/db")
+	t.Setenv("PORT", "4000")
+	cfg, err := loadTestConfig(t, "server:\n  port: 3001\n")
+	if err != nil {
+		t.Fatalf("加载配置失败: %v", err)
+	}
+	if cfg.Admin.Username != "boss" || cfg.Database.URL != "postgres://env/db" || cfg.Server.Port != 4000 {
+		t.Errorf("环境变量未覆盖配置文件: admin=%s dburl=%s port=%d", cfg.Admin.Username, cfg.Database.URL, cfg.Server.Port)
+	}
+
+	// 没有配置文件时只使用环境变量和默认值
+	t.Setenv("CONFIG_FILE", filepath.Join(t.TempDir(), "missing.yaml"))
+	if cfg, err := loadFromFile(); err != nil || cfg.Admin.Username != "boss" {
+		t.Errorf("无配置文件时 loadFromFile() = %+v, %v", cfg, err)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"端口超出范围", "server:\n  port: 70000\n", "port"},
+		{"端口不是数字", "server:\n  port: abc\n", "port"},
+		{"无效的模式", "server:\n  mode: prod\n", "mode"},
+		{"无效的时区", "server:\n  timezone: Mars/Base\n", "时区"},
+		{"布尔值错误", "oauth:\n  pkce: yes-please\n", "linuxdo_pkce"},
+		{"无效的地址", "oauth:\n  token_url: connect.linux.do\n", "linuxdo_token_url"},
+		{"未知的配置项", "server:\n  prot: 3001\n", "未知的配置项 server.prot"},
+		{"无效的可信代理", "server:\n  trusted_proxies: [nginx]\n", "trusted_proxies"},
+		{"无效的管理员ID", "admin:\n  user_ids: [abc]\n", "admin_user_ids"},
+		{"YAML语法错误", "server:\n  port: [3001\n", "YAML"},
+		{"driver与mode不一致", "server:\n  mode: dev\ndatabase:\n  driver: postgres\n", "不一致"},
+		{"server模式缺少数据库", "server:\n  mode: server\njwt:\n  secret: 0123456789abcdef\noauth:\n  client_id: a\n  client_secret: b\n", "dburl"},
+		{"server模式使用示例JWT密钥", "server:\n  mode: server\ndatabase:\n  url: postgres://x\njwt:\n  secret: your_jwt_secret_key_change_me_in_production\noauth:\n  client_id: a\n  client_secret: b\n", "jwt_secret"},
+		{"server模式缺少CDK密钥", "server:\n  mode: server\ndatabase:\n  url: postgres://x\njwt:\n  secret: 0123456789abcdef\noauth:\n  client_id: a\n  client_secret: b\n", "cdk_key_id和cdk_keys"},
+		{"server模式缺少当前CDK密钥", "server:\n  mode: server\ndatabase:\n  url: postgres://x\njwt:\n  secret: 0123456789abcdef\noauth:\n  client_id: a\n  client_secret: b\ncdk:\n  key_id: k2\n  keys:\n    k1: a2V5MQ==\n  fingerprint_key: ZmluZ2VycHJpbnQ=\n", "cdk_keys 中没有"},
+		{"server模式缺少指纹密钥", "server:\n  mode: server\ndatabase:\n  url: postgres://x\njwt:\n  secret: 0123456789abcdef\noauth:\n  client_id: a\n  client_secret: b\ncdk:\n  key_id: k1\n  keys:\n    k1: a2V5MQ==\n", "cdk_fingerprint_key"},
+		{"server模式缺少OAuth密钥", "server:\n  mode: server\ndatabase:\n  url: postgres://x\njwt:\n  secret: 0123456789abcdef\n", "app_client_secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, tt.content)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadFromFile() error = %v, want 包含 %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// 所有错误一起报告
+	_, err := loadTestConfig(t, "server:\n  port: 0\n  mode: prod\n")
+	if err == nil || !strings.Contains(err.Error(), "port") || !strings.Contains(err.Error(), "mode") {
+		t.Errorf("多个错误 error = %v", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	t.Setenv("CONFIG_FILE", path)
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("写入配置文件失败: %v", err)
+		}
+	}
+
+	write("admin:\n  username: alice\n")
+	if _, err := Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	var notified []string
+	unsubscribe := Subscribe(func(cfg *Config) { notified = append(notified, cfg.Admin.Username) })
+	defer unsubscribe()
+
+	write("admin:\n  username: bob\n")
+	if err := Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if Get().Admin.Username != "bob" || len(notified) != 1 || notified[0] != "bob" {
+		t.Errorf("Reload() 后 username = %q, 通知 = %v", Get().Admin.Username, notified)
+	}
+
+	tests := []struct {
+		name      string
+		content   string
+		validator func(*Config) error
+	}{
+		{"配置无效", "server:\n  port: abc\n", nil},
+		{"额外校验失败", "admin:\n  username: carol\n", func(cfg *Config) error {
+			if cfg.Admin.Username == "carol" {
+				return errors.New("不允许的用户名")
+			}
+			return nil
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.validator != nil {
+				AddValidator(tt.validator)
+				defer func() { validators = nil }()
+			}
+			write(tt.content)
+			if err := Reload(); err == nil {
+				t.Fatal("Reload() 应返回错误")
+			}
+			// 加载失败时继续使用原配置，也不通知订阅者
+			if Get().Admin.Username != "bob" || len(notified) != 1 {
+				t.Errorf("Reload() 失败后 username = %q, 通知 = %v", Get().Admin.Username, notified)
+			}
+		})
+	}
+
+	unsubscribe()
+	write("admin:\n  username: dave\n")
+	if err := Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if len(notified) != 1 {
+		t.Errorf("取消订阅后仍收到通知: %v", notified)
+	}
+}
+
+func TestRestartFields(t *testing.T) {
+	old := &Config{Server: ServerConfig{Port: 3001, Mode: ModeDev}, CDK: CDKConfig{Keys: map[string]string{"k1": "a"}}}
+	cfg := &Config{Server: ServerConfig{Port: 3001, Mode: ModeSQLite}, CDK: CDKConfig{Keys: map[string]string{"k1": "b"}}}
+	cfg.Admin.Username = "bob"
+	if got := strings.Join(restartFields(old, cfg), ","); got != "mode,cdk" {
+		t.Errorf("restartFields() = %q, want %q", got, "mode,cdk")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	if _, err := loadTestConfig(t, "admin:\n  username: alice\n"); err != nil {
+		t.Fatalf("加载配置失败: %v", err)
+	}
+	if err := Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	reloaded := make(chan string, 1)
+	defer Subscribe(func(cfg *Config) { reloaded <- cfg.Admin.Username })()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go Watch(10*time.Millisecond, stop)
+	time.Sleep(30 * time.Millisecond)
+
+	if err := os.WriteFile(configPath(), []byte("admin:\n  username: bobby\n"), 0644); err != nil {
+		t.Fatalf("写入配置文件失败: %v", err)
+	}
+	select {
+	case username := <-reloaded:
+		if username != "bobby" {
+			t.Errorf("重新加载后 username = %q, want bobby", username)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("修改配置文件后没有重新加载")
+	}
+}
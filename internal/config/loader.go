@@ -0,0 +1,456 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configKey 配置项：name 为旧版键值对格式和环境变量使用的键名，path 为YAML中的路径
+type configKey struct {
+	name string
+	path string
+}
+
+// configKeys 所有配置项，YAML结构见 Docs/需求文档.md 第七节
+var configKeys = []configKey{
+	{"port", "server.port"},
+	{"mode", "server.mode"},
+	{"timezone", "server.timezone"},
+	{"trusted_proxies", "server.trusted_proxies"},
+
+	{"dburl", "database.url"},
+	{"sqlite_path", "database.sqlite.path"},
+
+	{"app_client_id", "oauth.client_id"},
+	{"app_client_secret", "oauth.client_secret"},
+	{"app_redirect_uri", "oauth.redirect_uri"},
+	{"linuxdo_authorize_url", "oauth.authorize_url"},
+	{"linuxdo_token_url", "oauth.token_url"},
+	{"linuxdo_user_url", "oauth.user_url"},
+	{"linuxdo_timeout_seconds", "oauth.timeout_seconds"},
+	{"linuxdo_sync_hours", "oauth.sync_hours"},
+	{"oauth_state_store", "oauth.state_store"},
+	{"oauth_state_minutes", "oauth.state_minutes"},
+	{"linuxdo_pkce", "oauth.pkce"},
+
+	{"pay_api_url", "pay.api_url"},
+	{"pay_client_id", "pay.client_id"},
+	{"pay_client_secret", "pay.client_secret"},
+	{"pay_callback_url", "pay.callback_url"},
+	{"pay_notify_url", "pay.notify_url"},
+
+	{"adminname", "admin.username"},
+	{"adminpassword", "admin.password"},
+	{"admin_user_ids", "admin.user_ids"},
+	{"admin_usernames", "admin.usernames"},
+	{"cdk_operator_user_ids", "admin.cdk_operators.user_ids"},
+	{"cdk_operator_usernames", "admin.cdk_operators.usernames"},
+	{"auditor_user_ids", "admin.auditors.user_ids"},
+	{"auditor_usernames", "admin.auditors.usernames"},
+	{"admin_login_free_attempts", "admin.login.free_attempts"},
+	{"admin_lockout_max_minutes", "admin.login.lockout_max_minutes"},
+
+	{"jwt_secret", "jwt.secret"},
+	{"jwt_expire_hours", "jwt.expire_hours"},
+	{"jwt_access_minutes", "jwt.access_minutes"},
+
+	{"cdk_key_id", "cdk.key_id"},
+	{"cdk_keys", "cdk.keys"},
+	{"cdk_fingerprint_key", "cdk.fingerprint_key"},
+
+	{"global_enabled", "settings.global_enabled"},
+	{"announcement", "settings.announcement"},
+	{"order_expire_minutes", "order.expire_minutes"},
+}
+
+// yamlAliases 需求文档中使用的其他写法
+var yamlAliases = map[string]string{
+	"server.jwt_secret": "jwt_secret",
+}
+
+// insecureJWTSecrets 示例配置中的占位密钥，server模式下不允许使用
+var insecureJWTSecrets = []string{"default_jwt_secret", "your_jwt_secret", "your_jwt_secret_key_change_me_in_production"}
+
+// minJWTSecretLength server模式下JWT密钥的最短长度
+const minJWTSecretLength = 16
+
+// legacyLine 旧版配置的一行："key"="value"
+var legacyLine = regexp.MustCompile(`^\s*"?[A-Za-z_]+"?\s*=`)
+
+// isLegacyFormat 是否为旧版的 "key"="value", 格式（第一条有效内容形如 key=value）
+func isLegacyFormat(data []byte) bool {
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		return legacyLine.MatchString(line)
+	}
+	return false
+}
+
+// parseValues 把配置文件解析为 键名 -> 值，支持YAML和旧版键值对格式
+func parseValues(data []byte) (map[string]string, error) {
+	if isLegacyFormat(data) {
+		return parseLegacy(data), nil
+	}
+	return parseYAML(data)
+}
+
+// parseLegacy 解析旧版的 "key"="value", 格式（引号内的逗号不会被当作分隔符）
+func parseLegacy(data []byte) map[string]string {
+	values := make(map[string]string)
+	var entries []string
+	var current strings.Builder
+	quoted := false
+	for _, r := range string(data) {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			entries = append(entries, current.String())
+			current.Reset()
+			continue
+		}
+		current.WriteRune(r)
+	}
+	entries = append(entries, current.String())
+
+	for _, entry := range entries {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.Trim(strings.TrimSpace(parts[0]), "\"")
+		value := strings.Trim(strings.TrimSpace(parts[1]), "\"")
+		values[key] = value
+	}
+	return values
+}
+
+// postgresConfig database.postgres 下分项填写的连接参数
+type postgresConfig struct {
+	host, port, user, password, dbname, sslmode string
+}
+
+// dsn 组装PostgreSQL连接串
+func (p postgresConfig) dsn() string {
+	if p.host == "" {
+		p.host = "localhost"
+	}
+	if p.port == "" {
+		p.port = "5432"
+	}
+	if p.sslmode == "" {
+		p.sslmode = "disable"
+	}
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(p.user, p.password),
+		Host:     net.JoinHostPort(p.host, p.port),
+		Path:     "/" + p.dbname,
+		RawQuery: "sslmode=" + url.QueryEscape(p.sslmode),
+	}
+	return u.String()
+}
+
+// yamlParser 把嵌套的YAML配置展开为 键名 -> 值
+type yamlParser struct {
+	keys     map[string]string // YAML路径 -> 键名
+	values   map[string]string
+	postgres postgresConfig
+	usePG    bool
+	driver   string
+}
+
+// parseYAML 解析YAML格式的配置文件，未知的配置项视为错误
+func parseYAML(data []byte) (map[string]string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("解析YAML失败: %v", err)
+	}
+
+	p := &yamlParser{keys: make(map[string]string), values: make(map[string]string)}
+	for _, k := range configKeys {
+		p.keys[k.path] = k.name
+	}
+	for path, name := range yamlAliases {
+		p.keys[path] = name
+	}
+
+	if len(doc.Content) > 0 {
+		if err := p.walk(doc.Content[0], ""); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.usePG && p.values["dburl"] == "" {
+		p.values["dburl"] = p.postgres.dsn()
+	}
+	if p.driver != "" {
+		mode, ok := map[string]string{"postgres": ModeServer, "sqlite": ModeSQLite, "csv": ModeDev}[p.driver]
+		if !ok {
+			return nil, fmt.Errorf("database.driver 应为 postgres、sqlite 或 csv，当前为 %q", p.driver)
+		}
+		if p.values["mode"] != "" && p.values["mode"] != mode {
+			return nil, fmt.Errorf("database.driver（%s）与 server.mode（%s）不一致", p.driver, p.values["mode"])
+		}
+		p.values["mode"] = mode
+	}
+	return p.values, nil
+}
+
+// walk 递归展开映射节点
+func (p *yamlParser) walk(node *yaml.Node, prefix string) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("配置项 %s 应为映射（第%d行）", strings.TrimSuffix(prefix, "."), node.Line)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+		path := prefix + keyNode.Value
+
+		if name, ok := p.keys[path]; ok {
+			value, err := scalarValue(path, valueNode)
+			if err != nil {
+				return err
+			}
+			p.values[name] = value
+			continue
+		}
+
+		switch {
+		case path == "database.driver" && valueNode.Kind == yaml.ScalarNode:
+			p.driver = valueNode.Value
+		case strings.HasPrefix(path, "database.postgres.") && valueNode.Kind == yaml.ScalarNode:
+			if err := p.setPostgres(strings.TrimPrefix(path, "database.postgres."), valueNode); err != nil {
+				return err
+			}
+		case valueNode.Kind == yaml.MappingNode && p.hasChildren(path):
+			if err := p.walk(valueNode, path+"."); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("未知的配置项 %s（第%d行）", path, keyNode.Line)
+		}
+	}
+	return nil
+}
+
+// hasChildren 是否存在以path为前缀的配置项
+func (p *yamlParser) hasChildren(path string) bool {
+	if path == "database.postgres" {
+		return true
+	}
+	for k := range p.keys {
+		if strings.HasPrefix(k, path+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// setPostgres 记录 database.postgres 下的连接参数
+func (p *yamlParser) setPostgres(field string, node *yaml.Node) error {
+	p.usePG = true
+	switch field {
+	case "host":
+		p.postgres.host = node.Value
+	case "port":
+		p.postgres.port = node.Value
+	case "user":
+		p.postgres.user = node.Value
+	case "password":
+		p.postgres.password = node.Value
+	case "dbname":
+		p.postgres.dbname = node.Value
+	case "sslmode":
+		p.postgres.sslmode = node.Value
+	default:
+		return fmt.Errorf("未知的配置项 database.postgres.%s（第%d行）", field, node.Line)
+	}
+	return nil
+}
+
+// scalarValue 把配置值转换为字符串：列表用;连接，映射（cdk.keys）转换为 键:值;键:值
+func scalarValue(path string, node *yaml.Node) (string, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if node.Tag == "!!null" {
+			return "", nil
+		}
+		return node.Value, nil
+	case yaml.SequenceNode:
+		items := make([]string, 0, len(node.Content))
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return "", fmt.Errorf("配置项 %s 的列表元素应为单个值（第%d行）", path, item.Line)
+			}
+			items = append(items, item.Value)
+		}
+		return strings.Join(items, ";"), nil
+	case yaml.MappingNode:
+		if path != "cdk.keys" {
+			break
+		}
+		items := make([]string, 0, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			items = append(items, node.Content[i].Value+":"+node.Content[i+1].Value)
+		}
+		return strings.Join(items, ";"), nil
+	}
+	return "", fmt.Errorf("配置项 %s 的格式不正确（第%d行）", path, node.Line)
+}
+
+// overlayEnv 用环境变量覆盖配置文件中的值，环境变量名为键名（如 dburl）或其大写形式（如 DBURL）
+func overlayEnv(values map[string]string) {
+	for _, k := range configKeys {
+		for _, name := range []string{k.name, strings.ToUpper(k.name)} {
+			if v, ok := os.LookupEnv(name); ok && v != "" {
+				values[k.name] = v
+				break
+			}
+		}
+	}
+}
+
+// valueParser 按类型读取配置值，收集所有错误后一起返回
+type valueParser struct {
+	values map[string]string
+	errs   []error
+}
+
+func (p *valueParser) fail(format string, args ...any) {
+	p.errs = append(p.errs, fmt.Errorf(format, args...))
+}
+
+// str 读取字符串，未配置或为空时返回默认值
+func (p *valueParser) str(key, def string) string {
+	if v := strings.TrimSpace(p.values[key]); v != "" {
+		return v
+	}
+	return def
+}
+
+// int 读取[min, max]范围内的整数
+func (p *valueParser) int(key string, def, min, max int) int {
+	raw := p.str(key, "")
+	if raw == "" {
+		return def
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < min || v > max {
+		p.fail("%s 应为 %d 到 %d 之间的整数，当前为 %q", key, min, max, raw)
+		return def
+	}
+	return v
+}
+
+// bool 读取布尔值（true/false）
+func (p *valueParser) bool(key string, def bool) bool {
+	raw := p.str(key, "")
+	if raw == "" {
+		return def
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		p.fail("%s 应为 true 或 false，当前为 %q", key, raw)
+		return def
+	}
+	return v
+}
+
+// oneOf 读取取值必须在options中的字符串
+func (p *valueParser) oneOf(key, def string, options ...string) string {
+	v := p.str(key, def)
+	for _, option := range options {
+		if v == option {
+			return v
+		}
+	}
+	p.fail("%s 应为 %s 之一，当前为 %q", key, strings.Join(options, "、"), v)
+	return def
+}
+
+// url 读取http(s)地址
+func (p *valueParser) url(key, def string) string {
+	v := p.str(key, def)
+	if u, err := url.Parse(v); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		p.fail("%s 不是有效的http(s)地址: %q", key, v)
+	}
+	return v
+}
+
+// list 读取用;分隔的列表
+func (p *valueParser) list(key string) []string {
+	return splitList(p.str(key, ""))
+}
+
+// proxyList 读取可信代理列表，每项必须是IP或CIDR
+func (p *valueParser) proxyList(key string) []string {
+	var proxies []string
+	for _, v := range p.list(key) {
+		if net.ParseIP(v) == nil {
+			if _, _, err := net.ParseCIDR(v); err != nil {
+				p.fail("%s 中的 %q 不是有效的IP或CIDR", key, v)
+				continue
+			}
+		}
+		proxies = append(proxies, v)
+	}
+	return proxies
+}
+
+// adminList 读取 <prefix>_user_ids 和 <prefix>_usernames
+func (p *valueParser) adminList(prefix string) AdminList {
+	list := AdminList{Usernames: p.list(prefix + "_usernames")}
+	for _, v := range p.list(prefix + "_user_ids") {
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 {
+			p.fail("%s_user_ids 中的 %q 不是有效的LinuxDo用户ID", prefix, v)
+			continue
+		}
+		list.UserIDs = append(list.UserIDs, id)
+	}
+	return list
+}
+
+// cdkKeys 读取 密钥ID:Base64密钥;密钥ID:Base64密钥
+func (p *valueParser) cdkKeys(key string) map[string]string {
+	keys := make(map[string]string)
+	for _, entry := range p.list(key) {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			p.fail("%s 格式错误，应为 密钥ID:Base64密钥", key)
+			continue
+		}
+		keys[parts[0]] = parts[1]
+	}
+	return keys
+}
+
+// splitList 按;分割列表值，忽略空项
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ";") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// validationError 把收集到的错误合并为一个
+func validationError(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("配置校验失败:\n%w", errors.Join(errs...))
+}
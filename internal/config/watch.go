@@ -0,0 +1,151 @@
+package config
+
+import (
+	"log"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	reloadMutex sync.Mutex // 串行化Reload，保证订阅者按加载顺序收到通知
+	subscribers = make(map[int]func(*Config))
+	validators  []func(*Config) error
+	nextSubID   int
+)
+
+// Subscribe 订阅配置变化：每次Reload成功后以新配置调用fn，返回取消订阅的函数
+// fn在Reload的调用方goroutine中执行，应尽快返回
+func Subscribe(fn func(cfg *Config)) func() {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	id := nextSubID
+	nextSubID++
+	subscribers[id] = fn
+	return func() {
+		configMutex.Lock()
+		defer configMutex.Unlock()
+		delete(subscribers, id)
+	}
+}
+
+// AddValidator 添加重新加载时的额外校验（如依赖其他包的密码检查），校验失败时保留原配置
+func AddValidator(fn func(cfg *Config) error) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	validators = append(validators, fn)
+}
+
+// Reload 重新加载配置文件，校验通过后替换当前配置并通知订阅者；失败时继续使用原配置
+func Reload() error {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	cfg, err := loadFromFile()
+	if err != nil {
+		return err
+	}
+
+	configMutex.RLock()
+	checks := append([]func(*Config) error{}, validators...)
+	configMutex.RUnlock()
+	for _, check := range checks {
+		if err := check(cfg); err != nil {
+			return err
+		}
+	}
+
+	configMutex.Lock()
+	old := globalConfig
+	globalConfig = cfg
+	notify := make([]func(*Config), 0, len(subscribers))
+	for _, fn := range subscribers {
+		notify = append(notify, fn)
+	}
+	configMutex.Unlock()
+
+	if old != nil {
+		if fields := restartFields(old, cfg); len(fields) > 0 {
+			log.Printf("⚠️ 以下配置修改后需要重启服务才能生效: %s", strings.Join(fields, "、"))
+		}
+	}
+	for _, fn := range notify {
+		fn(cfg)
+	}
+	return nil
+}
+
+// restartFields 列出新旧配置中不同、且只在启动时读取的配置项（端口、存储、密钥等）
+func restartFields(old, cfg *Config) []string {
+	fields := []string{}
+	if old.Server.Port != cfg.Server.Port {
+		fields = append(fields, "port")
+	}
+	if old.Server.Mode != cfg.Server.Mode {
+		fields = append(fields, "mode")
+	}
+	if !slices.Equal(old.Server.TrustedProxies, cfg.Server.TrustedProxies) {
+		fields = append(fields, "trusted_proxies")
+	}
+	if old.Database != cfg.Database {
+		fields = append(fields, "database")
+	}
+	if old.JWT.Secret != cfg.JWT.Secret {
+		fields = append(fields, "jwt_secret")
+	}
+	if !reflect.DeepEqual(old.CDK, cfg.CDK) {
+		fields = append(fields, "cdk")
+	}
+	if old.OAuth.StateStore != cfg.OAuth.StateStore {
+		fields = append(fields, "oauth_state_store")
+	}
+	if old.OAuth.SyncHours != cfg.OAuth.SyncHours {
+		fields = append(fields, "linuxdo_sync_hours")
+	}
+	return fields
+}
+
+// fileStamp 配置文件的修改时间和大小，用于检测文件变化
+type fileStamp struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+func statConfig() fileStamp {
+	info, err := os.Stat(configPath())
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{exists: true, size: info.Size(), modTime: info.ModTime()}
+}
+
+// Watch 每隔interval检查一次配置文件，发生变化时调用Reload，直到stop被关闭
+// 使用轮询而不是inotify：编辑器的"写临时文件再改名"和容器中通过符号链接替换的配置文件都能检测到
+func Watch(interval time.Duration, stop <-chan struct{}) {
+	last := statConfig()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		stamp := statConfig()
+		if stamp == last {
+			continue
+		}
+		last = stamp
+		if err := Reload(); err != nil {
+			log.Printf("配置文件已修改，但重新加载失败，继续使用原配置: %v", err)
+			continue
+		}
+		log.Printf("配置文件已修改，已重新加载")
+	}
+}
@@ -1,10 +1,14 @@
 package handler
 
 import (
+	"errors"
+	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/zhongruan0522/DuiDuiMao/internal/config"
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
 	"github.com/zhongruan0522/DuiDuiMao/internal/service"
 	"github.com/zhongruan0522/DuiDuiMao/internal/util"
 )
@@ -14,14 +18,28 @@ type AdminHandler struct {
 	tierService      *service.TierService
 	cdkService       *service.CDKService
 	redeemLogService *service.RedeemLogService
+	orderService     *service.OrderService
+	paymentService   *service.PaymentService
+	userService      *service.UserService
+	userSyncService  *service.UserSyncService
+	adminAuthService *service.AdminAuthService
+	settingsService  *service.SettingsService
+	keyRotation      *service.KeyRotationService
 }
 
 // NewAdminHandler 创建管理端处理器
-func NewAdminHandler(tierService *service.TierService, cdkService *service.CDKService, redeemLogService *service.RedeemLogService) *AdminHandler {
+func NewAdminHandler(tierService *service.TierService, cdkService *service.CDKService, redeemLogService *service.RedeemLogService, orderService *service.OrderService, paymentService *service.PaymentService, userService *service.UserService, userSyncService *service.UserSyncService, adminAuthService *service.AdminAuthService, settingsService *service.SettingsService, keyRotation *service.KeyRotationService) *AdminHandler {
 	return &AdminHandler{
 		tierService:      tierService,
 		cdkService:       cdkService,
 		redeemLogService: redeemLogService,
+		orderService:     orderService,
+		paymentService:   paymentService,
+		userService:      userService,
+		userSyncService:  userSyncService,
+		adminAuthService: adminAuthService,
+		settingsService:  settingsService,
+		keyRotation:      keyRotation,
 	}
 }
 
@@ -29,12 +47,23 @@ func NewAdminHandler(tierService *service.TierService, cdkService *service.CDKSe
 
 // TierRequest 档位请求结构
 type TierRequest struct {
-	Name          string `json:"name" binding:"required"`
-	Quota         int    `json:"quota" binding:"required,min=1"`
-	RequiredLevel int    `json:"required_level" binding:"min=0,max=4"`
-	DailyLimit    int    `json:"daily_limit" binding:"min=0"`
-	SortOrder     int    `json:"sort_order"`
-	IsActive      bool   `json:"is_active"`
+	Name          string     `json:"name" binding:"required"`
+	Quota         int        `json:"quota" binding:"required,min=1"`
+	Price         int        `json:"price" binding:"min=0"` // 单价（LinuxDo积分，0表示免费）
+	RequiredLevel int        `json:"required_level" binding:"min=0,max=4"`
+	DailyLimit    int        `json:"daily_limit" binding:"min=0"`
+	SortOrder     int        `json:"sort_order"`
+	IsActive      bool       `json:"is_active"`
+	StartsAt      *time.Time `json:"starts_at"` // 开放时间（RFC3339，null表示不限）
+	EndsAt        *time.Time `json:"ends_at"`   // 结束时间（RFC3339，null表示不限）
+}
+
+// optionalTime 可选时间参数，nil返回零值
+func optionalTime(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
 }
 
 // GetTiers 获取档位列表（管理端）
@@ -46,20 +75,10 @@ func (h *AdminHandler) GetTiers(c *gin.Context) {
 	}
 
 	// 对数据进行双重Base64加密
+	now := h.tierService.Now()
 	encryptedData := []gin.H{}
-	for _, tier := range tiers {
-		encryptedData = append(encryptedData, gin.H{
-			"id":             util.DoubleEncode(strconv.Itoa(tier.ID)),
-			"name":           util.DoubleEncode(tier.Name),
-			"quota":          util.DoubleEncode(strconv.Itoa(tier.Quota)),
-			"required_level": util.DoubleEncode(strconv.Itoa(tier.RequiredLevel)),
-			"daily_limit":    util.DoubleEncode(strconv.Itoa(tier.DailyLimit)),
-			"stock":          util.DoubleEncode(strconv.Itoa(tier.Stock)),
-			"is_active":      util.DoubleEncode(strconv.FormatBool(tier.IsActive)),
-			"sort_order":     util.DoubleEncode(strconv.Itoa(tier.SortOrder)),
-			"created_at":     util.DoubleEncode(tier.CreatedAt.Format("2006-01-02 15:04:05")),
-			"updated_at":     util.DoubleEncode(tier.UpdatedAt.Format("2006-01-02 15:04:05")),
-		})
+	for i := range tiers {
+		encryptedData = append(encryptedData, encodeTier(&tiers[i], now))
 	}
 
 	util.SuccessResponse(c, encryptedData)
@@ -77,10 +96,13 @@ func (h *AdminHandler) CreateTier(c *gin.Context) {
 	tier, err := h.tierService.CreateTier(
 		req.Name,
 		req.Quota,
+		req.Price,
 		req.RequiredLevel,
 		req.DailyLimit,
 		req.SortOrder,
 		req.IsActive,
+		optionalTime(req.StartsAt),
+		optionalTime(req.EndsAt),
 	)
 	if err != nil {
 		util.ErrorResponse(c, 500, "创建档位失败: "+err.Error())
@@ -114,10 +136,13 @@ func (h *AdminHandler) UpdateTier(c *gin.Context) {
 		id,
 		req.Name,
 		req.Quota,
+		req.Price,
 		req.RequiredLevel,
 		req.DailyLimit,
 		req.SortOrder,
 		req.IsActive,
+		optionalTime(req.StartsAt),
+		optionalTime(req.EndsAt),
 	)
 	if err != nil {
 		util.ErrorResponse(c, 500, "更新档位失败: "+err.Error())
@@ -192,6 +217,16 @@ func (h *AdminHandler) ImportCDKs(c *gin.Context) {
 		return
 	}
 
+	// 重复的CDK及其冲突的档位（same_tier 表示与目标档位相同，否则已存在于其他档位）
+	duplicates := []gin.H{}
+	for _, dup := range result.Duplicates {
+		duplicates = append(duplicates, gin.H{
+			"code":      util.DoubleEncode(dup.Code),
+			"tier_id":   util.DoubleEncode(strconv.Itoa(dup.TierID)),
+			"same_tier": dup.SameTier,
+		})
+	}
+
 	// 返回加密后的结果
 	util.SuccessResponse(c, gin.H{
 		"message":       "CDK导入完成",
@@ -199,9 +234,19 @@ func (h *AdminHandler) ImportCDKs(c *gin.Context) {
 		"tier_name":     util.DoubleEncode(tier.Name),
 		"success_count": util.DoubleEncode(strconv.Itoa(result.SuccessCount)),
 		"failed_count":  util.DoubleEncode(strconv.Itoa(result.FailedCount)),
+		"duplicates":    duplicates,
 	})
 }
 
+// adminRevealCode 管理端显示的CDK：超级管理员和CDK运营看到明文，审计员只能看到打码后的CDK
+func (h *AdminHandler) adminRevealCode(c *gin.Context, cdk *model.CDK) string {
+	code := revealCode(h.cdkService, cdk)
+	if !model.RoleAllows(c.GetString("role"), model.RoleCDKOperator) {
+		return maskCode(code)
+	}
+	return code
+}
+
 // GetCDKs 获取CDK列表
 func (h *AdminHandler) GetCDKs(c *gin.Context) {
 	// 解析查询参数
@@ -271,17 +316,35 @@ func (h *AdminHandler) GetCDKs(c *gin.Context) {
 		encryptedData = append(encryptedData, gin.H{
 			"id":          util.DoubleEncode(strconv.Itoa(cdk.ID)),
 			"tier_id":     util.DoubleEncode(strconv.Itoa(cdk.TierID)),
-			"code":        cdk.Code, // 已经是加密的
+			"code":        util.DoubleEncode(h.adminRevealCode(c, &cdk)),
 			"status":      util.DoubleEncode(strconv.Itoa(cdk.Status)),
 			"redeemed_by": util.DoubleEncode(strconv.Itoa(redeemedBy)),
 			"redeemed_at": util.DoubleEncode(redeemedAtStr),
 			"created_at":  util.DoubleEncode(cdk.CreatedAt.Format("2006-01-02 15:04:05")),
+			"release_at":  util.DoubleEncode(formatTime(cdk.ReleaseAt)),
 		})
 	}
 
 	util.SuccessResponse(c, encryptedData)
 }
 
+// RotateCDKKeys 使用当前密钥重新加密所有CDK、两步验证密钥和LinuxDo令牌（新增密钥并切换cdk_key_id后执行）
+func (h *AdminHandler) RotateCDKKeys(c *gin.Context) {
+	result, err := h.keyRotation.RotateKeys()
+	if err != nil {
+		util.ErrorResponse(c, 500, "密钥轮换失败: "+err.Error())
+		return
+	}
+
+	util.SuccessResponse(c, gin.H{
+		"message": "密钥轮换完成",
+		"key_id":  util.DoubleEncode(result.KeyID),
+		"total":   util.DoubleEncode(strconv.Itoa(result.Total)),
+		"rotated": util.DoubleEncode(strconv.Itoa(result.Rotated)),
+		"failed":  util.DoubleEncode(strconv.Itoa(result.Failed)),
+	})
+}
+
 // RevokeCDK 作废CDK
 func (h *AdminHandler) RevokeCDK(c *gin.Context) {
 	idStr := c.Param("id")
@@ -302,44 +365,442 @@ func (h *AdminHandler) RevokeCDK(c *gin.Context) {
 	})
 }
 
-// ========== 订单管理（Mock，待后续实现） ==========
+// ScheduleReleaseRequest 定时放出请求
+type ScheduleReleaseRequest struct {
+	Quantity  int       `json:"quantity" binding:"required,min=1"` // 放出数量
+	ReleaseAt time.Time `json:"release_at" binding:"required"`     // 放出时间（RFC3339）
+}
 
-// GetOrders 获取订单列表（Mock）
-func (h *AdminHandler) GetOrders(c *gin.Context) {
-	util.SuccessResponse(c, []gin.H{
-		{
-			"id":       util.DoubleEncode("1"),
-			"user_id":  util.DoubleEncode("1"),
-			"username": util.DoubleEncode("test_user"),
-			"tier_id":  util.DoubleEncode("1"),
-			"quantity": util.DoubleEncode("2"),
-			"status":   util.DoubleEncode("1"),
-		},
+// ScheduleRelease 定时放出：暂扣档位中的一批可用CDK，到时间后自动放出
+func (h *AdminHandler) ScheduleRelease(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		util.ErrorResponse(c, 400, "无效的档位ID")
+		return
+	}
+
+	var req ScheduleReleaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.ErrorResponse(c, 400, "参数错误: "+err.Error())
+		return
+	}
+	if _, err := h.tierService.GetTierByID(id); err != nil {
+		util.ErrorResponse(c, 404, "档位不存在")
+		return
+	}
+
+	if err := h.cdkService.ScheduleRelease(id, req.Quantity, req.ReleaseAt); err != nil {
+		util.ErrorResponse(c, 400, "设置定时放出失败: "+err.Error())
+		return
+	}
+
+	util.SuccessResponse(c, gin.H{
+		"message":    "定时放出设置成功",
+		"id":         util.DoubleEncode(strconv.Itoa(id)),
+		"quantity":   util.DoubleEncode(strconv.Itoa(req.Quantity)),
+		"release_at": util.DoubleEncode(formatTime(req.ReleaseAt)),
 	})
 }
 
-// ========== 系统设置 ==========
+// ReleaseNow 立即放出档位中所有尚未放出的CDK
+func (h *AdminHandler) ReleaseNow(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		util.ErrorResponse(c, 400, "无效的档位ID")
+		return
+	}
 
-// GetSettings 获取系统设置
-func (h *AdminHandler) GetSettings(c *gin.Context) {
-	cfg := config.Get()
+	released, err := h.cdkService.ReleaseNow(id)
+	if err != nil {
+		util.ErrorResponse(c, 500, "放出CDK失败: "+err.Error())
+		return
+	}
 
-	// 返回加密后的数据
 	util.SuccessResponse(c, gin.H{
-		"global_enabled":       util.DoubleEncode(strconv.FormatBool(cfg.Settings.GlobalEnabled)),
-		"announcement":         util.DoubleEncode(cfg.Settings.Announcement),
-		"order_expire_minutes": util.DoubleEncode(strconv.Itoa(cfg.Settings.OrderExpireMinutes)),
+		"message":  "CDK已放出",
+		"id":       util.DoubleEncode(strconv.Itoa(id)),
+		"released": util.DoubleEncode(strconv.Itoa(released)),
 	})
 }
 
+// ========== 用户管理 ==========
+
+// SyncUser 立即从LinuxDo同步用户的信任等级和账号状态
+func (h *AdminHandler) SyncUser(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		util.ErrorResponse(c, 400, "无效的用户ID")
+		return
+	}
+
+	user, err := h.userSyncService.SyncUser(id)
+	if errors.Is(err, service.ErrAccountBlocked) {
+		util.ErrorResponse(c, 403, err.Error()+"，已登出该用户")
+		return
+	}
+	if err != nil {
+		util.ErrorResponse(c, 500, "同步用户失败: "+err.Error())
+		return
+	}
+
+	util.SuccessResponse(c, gin.H{
+		"message":     "同步成功",
+		"id":          util.DoubleEncode(strconv.Itoa(user.ID)),
+		"trust_level": util.DoubleEncode(strconv.Itoa(user.TrustLevel)),
+	})
+}
+
+// SyncUsers 立即同步所有保存了LinuxDo令牌的用户
+func (h *AdminHandler) SyncUsers(c *gin.Context) {
+	result, err := h.userSyncService.SyncAll()
+	if err != nil {
+		util.ErrorResponse(c, 500, "同步用户失败: "+err.Error())
+		return
+	}
+
+	util.SuccessResponse(c, gin.H{
+		"message": "同步完成",
+		"total":   util.DoubleEncode(strconv.Itoa(result.Total)),
+		"updated": util.DoubleEncode(strconv.Itoa(result.Updated)),
+		"blocked": util.DoubleEncode(strconv.Itoa(result.Blocked)),
+		"failed":  util.DoubleEncode(strconv.Itoa(result.Failed)),
+	})
+}
+
+// 登录审计每次最多返回的记录数
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// GetLoginAudits 获取最近的管理员登录审计记录（limit为双重Base64加密的条数）
+func (h *AdminHandler) GetLoginAudits(c *gin.Context) {
+	limit, err := decodeQueryInt(c, "limit")
+	if err != nil {
+		util.ErrorResponse(c, 400, err.Error())
+		return
+	}
+	n := defaultAuditLimit
+	if limit != nil && *limit > 0 {
+		n = min(*limit, maxAuditLimit)
+	}
+
+	audits, err := h.adminAuthService.ListAudits(n)
+	if err != nil {
+		util.ErrorResponse(c, 500, "获取登录审计失败: "+err.Error())
+		return
+	}
+
+	encryptedData := []gin.H{}
+	for _, audit := range audits {
+		encryptedData = append(encryptedData, gin.H{
+			"id":         util.DoubleEncode(strconv.Itoa(audit.ID)),
+			"username":   util.DoubleEncode(audit.Username),
+			"ip":         util.DoubleEncode(audit.IP),
+			"user_agent": util.DoubleEncode(audit.UserAgent),
+			"success":    util.DoubleEncode(strconv.FormatBool(audit.Success)),
+			"reason":     util.DoubleEncode(audit.Reason),
+			"created_at": util.DoubleEncode(audit.CreatedAt.Format(time.RFC3339)),
+		})
+	}
+	util.SuccessResponse(c, encryptedData)
+}
+
+// TOTPCodeRequest 两步验证码请求（加密后的数据）
+type TOTPCodeRequest struct {
+	Code string `json:"code" binding:"required"` // 双重Base64加密后的验证码或恢复码
+}
+
+// decodeTOTPCode 解析并解密请求中的验证码
+func decodeTOTPCode(c *gin.Context) (string, bool) {
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.ErrorResponse(c, 400, "参数错误: "+err.Error())
+		return "", false
+	}
+	code, err := util.DoubleDecode(req.Code)
+	if err != nil {
+		util.ErrorResponse(c, 400, "验证码解密失败")
+		return "", false
+	}
+	return code, true
+}
+
+// GetTOTPStatus 获取当前管理员的两步验证状态
+func (h *AdminHandler) GetTOTPStatus(c *gin.Context) {
+	status, err := h.adminAuthService.TOTPStatus(c.GetInt("user_id"))
+	if err != nil {
+		util.ErrorResponse(c, 500, "获取两步验证状态失败: "+err.Error())
+		return
+	}
+	util.SuccessResponse(c, gin.H{
+		"enabled":        util.DoubleEncode(strconv.FormatBool(status.Enabled)),
+		"recovery_codes": util.DoubleEncode(strconv.Itoa(status.RecoveryCodes)),
+	})
+}
+
+// SetupTOTP 生成新的两步验证密钥，返回密钥和otpauth链接
+func (h *AdminHandler) SetupTOTP(c *gin.Context) {
+	setup, err := h.adminAuthService.SetupTOTP(c.GetInt("user_id"))
+	if err != nil {
+		util.ErrorResponse(c, 400, err.Error())
+		return
+	}
+	util.SuccessResponse(c, gin.H{
+		"secret": util.DoubleEncode(setup.Secret),
+		"uri":    util.DoubleEncode(setup.URI),
+	})
+}
+
+// EnableTOTP 校验验证码后启用两步验证，返回一次性恢复码
+func (h *AdminHandler) EnableTOTP(c *gin.Context) {
+	code, ok := decodeTOTPCode(c)
+	if !ok {
+		return
+	}
+	codes, err := h.adminAuthService.EnableTOTP(c.GetInt("user_id"), code)
+	if err != nil {
+		util.ErrorResponse(c, 400, err.Error())
+		return
+	}
+
+	encryptedCodes := make([]string, len(codes))
+	for i, code := range codes {
+		encryptedCodes[i] = util.DoubleEncode(code)
+	}
+	util.SuccessResponse(c, gin.H{
+		"message":        "两步验证已启用，请妥善保存恢复码",
+		"recovery_codes": encryptedCodes,
+	})
+}
+
+// DisableTOTP 校验验证码或恢复码后关闭两步验证
+func (h *AdminHandler) DisableTOTP(c *gin.Context) {
+	code, ok := decodeTOTPCode(c)
+	if !ok {
+		return
+	}
+	if err := h.adminAuthService.DisableTOTP(c.GetInt("user_id"), code); err != nil {
+		util.ErrorResponse(c, 400, err.Error())
+		return
+	}
+	util.SuccessResponse(c, gin.H{"message": "两步验证已关闭"})
+}
+
+// ========== 订单管理 ==========
+
+// decodeQueryInt 解密双重Base64加密的整数查询参数，参数为空时返回nil
+func decodeQueryInt(c *gin.Context, key string) (*int, error) {
+	value := c.Query(key)
+	if value == "" {
+		return nil, nil
+	}
+	decrypted, err := util.DoubleDecode(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s 解密失败", key)
+	}
+	v, err := strconv.Atoi(decrypted)
+	if err != nil {
+		return nil, fmt.Errorf("%s 格式错误", key)
+	}
+	return &v, nil
+}
+
+// decodeQueryDate 解密双重Base64加密的日期查询参数（格式2006-01-02，按业务时区解析），参数为空时返回零值
+func decodeQueryDate(c *gin.Context, key string) (time.Time, error) {
+	value := c.Query(key)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	decrypted, err := util.DoubleDecode(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s 解密失败", key)
+	}
+	date, err := time.ParseInLocation("2006-01-02", decrypted, service.BusinessLocation())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s 格式错误", key)
+	}
+	return date, nil
+}
+
+// GetOrders 获取全部订单列表（支持按状态、用户、档位和日期范围筛选）
+func (h *AdminHandler) GetOrders(c *gin.Context) {
+	filter := repository.OrderFilter{}
+	var err error
+	if filter.Status, err = decodeQueryInt(c, "status"); err != nil {
+		util.ErrorResponse(c, 400, err.Error())
+		return
+	}
+	if filter.UserID, err = decodeQueryInt(c, "user_id"); err != nil {
+		util.ErrorResponse(c, 400, err.Error())
+		return
+	}
+	if filter.TierID, err = decodeQueryInt(c, "tier_id"); err != nil {
+		util.ErrorResponse(c, 400, err.Error())
+		return
+	}
+	if filter.From, err = decodeQueryDate(c, "start_date"); err != nil {
+		util.ErrorResponse(c, 400, err.Error())
+		return
+	}
+	endDate, err := decodeQueryDate(c, "end_date")
+	if err != nil {
+		util.ErrorResponse(c, 400, err.Error())
+		return
+	}
+	if !endDate.IsZero() {
+		filter.To = endDate.AddDate(0, 0, 1) // 包含结束日期当天
+	}
+
+	orders, err := h.orderService.GetOrders(filter)
+	if err != nil {
+		util.ErrorResponse(c, 500, "获取订单列表失败: "+err.Error())
+		return
+	}
+
+	names := tierNames(h.tierService)
+	encryptedData := []gin.H{}
+	for i := range orders {
+		data := encodeOrder(&orders[i], names[orders[i].TierID])
+		data["username"] = util.DoubleEncode(h.username(orders[i].UserID))
+		encryptedData = append(encryptedData, data)
+	}
+
+	util.SuccessResponse(c, encryptedData)
+}
+
+// GetOrder 获取订单详情（含用户信息和CDK）
+func (h *AdminHandler) GetOrder(c *gin.Context) {
+	order, err := h.orderService.GetOrderByNo(c.Param("order_no"))
+	if err != nil {
+		util.ErrorResponse(c, 404, err.Error())
+		return
+	}
+
+	cdks, err := h.orderService.GetOrderCDKs(order.ID)
+	if err != nil {
+		util.ErrorResponse(c, 500, "获取订单CDK失败: "+err.Error())
+		return
+	}
+	cdkData := []gin.H{}
+	for i := range cdks {
+		cdk := &cdks[i]
+		cdkData = append(cdkData, gin.H{
+			"id":     util.DoubleEncode(strconv.Itoa(cdk.ID)),
+			"code":   util.DoubleEncode(h.adminRevealCode(c, cdk)),
+			"status": util.DoubleEncode(strconv.Itoa(cdk.Status)),
+		})
+	}
+
+	payments, err := h.paymentService.GetOrderPayments(order.ID)
+	if err != nil {
+		util.ErrorResponse(c, 500, "获取支付记录失败: "+err.Error())
+		return
+	}
+	paymentData := []gin.H{}
+	for i := range payments {
+		paymentData = append(paymentData, encodePayment(&payments[i]))
+	}
+
+	data := encodeOrder(order, tierNames(h.tierService)[order.TierID])
+	data["username"] = util.DoubleEncode(h.username(order.UserID))
+	data["cdks"] = cdkData
+	data["payments"] = paymentData
+	util.SuccessResponse(c, data)
+}
+
+// encodePayment 支付记录数据双重Base64加密
+func encodePayment(payment *model.Payment) gin.H {
+	return gin.H{
+		"id":             util.DoubleEncode(strconv.Itoa(payment.ID)),
+		"order_id":       util.DoubleEncode(strconv.Itoa(payment.OrderID)),
+		"user_id":        util.DoubleEncode(strconv.Itoa(payment.UserID)),
+		"amount":         util.DoubleEncode(strconv.Itoa(payment.Amount)),
+		"payment_type":   util.DoubleEncode(payment.PaymentType),
+		"status":         util.DoubleEncode(strconv.Itoa(payment.Status)),
+		"transaction_id": util.DoubleEncode(payment.TransactionID),
+		"fail_reason":    util.DoubleEncode(payment.FailReason),
+		"created_at":     util.DoubleEncode(formatTime(payment.CreatedAt)),
+		"finished_at":    util.DoubleEncode(formatTime(payment.FinishedAt)),
+	}
+}
+
+// Reconcile 对账报告：已完成但没有成功支付记录的订单，以及支付成功但订单未完成的支付记录
+func (h *AdminHandler) Reconcile(c *gin.Context) {
+	report, err := h.paymentService.Reconcile()
+	if err != nil {
+		util.ErrorResponse(c, 500, "对账失败: "+err.Error())
+		return
+	}
+
+	names := tierNames(h.tierService)
+	orderData := []gin.H{}
+	for i := range report.UnpaidOrders {
+		order := &report.UnpaidOrders[i]
+		data := encodeOrder(order, names[order.TierID])
+		data["username"] = util.DoubleEncode(h.username(order.UserID))
+		orderData = append(orderData, data)
+	}
+
+	paymentData := []gin.H{}
+	for i := range report.OrphanPayments {
+		payment := &report.OrphanPayments[i]
+		data := encodePayment(payment)
+		data["username"] = util.DoubleEncode(h.username(payment.UserID))
+		if order, err := h.orderService.GetOrderByID(payment.OrderID); err == nil {
+			data["order_no"] = util.DoubleEncode(order.OrderNo)
+			data["order_status"] = util.DoubleEncode(strconv.Itoa(order.Status))
+		}
+		paymentData = append(paymentData, data)
+	}
+
+	util.SuccessResponse(c, gin.H{
+		"unpaid_orders":   orderData,
+		"orphan_payments": paymentData,
+	})
+}
+
+// username 获取用户名（用户不存在时返回空字符串）
+func (h *AdminHandler) username(userID int) string {
+	user, err := h.userService.GetUserByID(userID)
+	if err != nil {
+		return ""
+	}
+	return user.Username
+}
+
+// ========== 系统设置 ==========
+
+// settingsResponse 系统设置的响应数据（加密后返回）
+func settingsResponse(settings *model.Settings) gin.H {
+	return gin.H{
+		"global_enabled":       util.DoubleEncode(strconv.FormatBool(settings.GlobalEnabled)),
+		"announcement":         util.DoubleEncode(settings.Announcement),
+		"order_expire_minutes": util.DoubleEncode(strconv.Itoa(settings.OrderExpireMinutes)),
+		"version":              util.DoubleEncode(strconv.Itoa(settings.Version)),
+	}
+}
+
+// GetSettings 获取系统设置（包含版本号，修改时需要原样提交）
+func (h *AdminHandler) GetSettings(c *gin.Context) {
+	settings, err := h.settingsService.Get()
+	if err != nil {
+		util.ErrorResponse(c, 500, "获取设置失败: "+err.Error())
+		return
+	}
+	util.SuccessResponse(c, settingsResponse(settings))
+}
+
 // UpdateSettingsRequest 更新系统设置请求（前端需要加密传输）
 type UpdateSettingsRequest struct {
-	GlobalEnabled      string `json:"global_enabled"`       // 双重Base64加密的布尔值
-	Announcement       string `json:"announcement"`         // 双重Base64加密的公告内容
-	OrderExpireMinutes string `json:"order_expire_minutes"` // 双重Base64加密的超时时间
+	GlobalEnabled      string  `json:"global_enabled"`       // 双重Base64加密的布尔值
+	Announcement       *string `json:"announcement"`         // 双重Base64加密的公告内容，空字符串表示清空公告，不传表示不修改
+	OrderExpireMinutes string  `json:"order_expire_minutes"` // 双重Base64加密的超时时间
+	Version            string  `json:"version"`              // 双重Base64加密的版本号（获取设置时返回的version）
 }
 
-// UpdateSettings 更新系统设置
+// UpdateSettings 更新系统设置，版本号不是最新时返回409，防止覆盖其他管理员的修改
 func (h *AdminHandler) UpdateSettings(c *gin.Context) {
 	var encryptedReq UpdateSettingsRequest
 	if err := c.ShouldBindJSON(&encryptedReq); err != nil {
@@ -348,7 +809,22 @@ func (h *AdminHandler) UpdateSettings(c *gin.Context) {
 	}
 
 	// 解密请求数据
-	req := config.UpdateSettingsRequest{}
+	if encryptedReq.Version == "" {
+		util.ErrorResponse(c, 400, "缺少 version，请刷新后重试")
+		return
+	}
+	decryptedVersion, err := util.DoubleDecode(encryptedReq.Version)
+	if err != nil {
+		util.ErrorResponse(c, 400, "version 解密失败")
+		return
+	}
+	version, err := strconv.Atoi(decryptedVersion)
+	if err != nil || version < 0 {
+		util.ErrorResponse(c, 400, "version 格式错误")
+		return
+	}
+
+	update := service.SettingsUpdate{}
 
 	if encryptedReq.GlobalEnabled != "" {
 		decrypted, err := util.DoubleDecode(encryptedReq.GlobalEnabled)
@@ -357,16 +833,16 @@ func (h *AdminHandler) UpdateSettings(c *gin.Context) {
 			return
 		}
 		enabled := decrypted == "true"
-		req.GlobalEnabled = &enabled
+		update.GlobalEnabled = &enabled
 	}
 
-	if encryptedReq.Announcement != "" {
-		decrypted, err := util.DoubleDecode(encryptedReq.Announcement)
+	if encryptedReq.Announcement != nil {
+		decrypted, err := util.DoubleDecode(*encryptedReq.Announcement)
 		if err != nil {
 			util.ErrorResponse(c, 400, "announcement 解密失败")
 			return
 		}
-		req.Announcement = &decrypted
+		update.Announcement = &decrypted
 	}
 
 	if encryptedReq.OrderExpireMinutes != "" {
@@ -380,20 +856,18 @@ func (h *AdminHandler) UpdateSettings(c *gin.Context) {
 			util.ErrorResponse(c, 400, "order_expire_minutes 格式错误")
 			return
 		}
-		if minutes < 1 {
-			util.ErrorResponse(c, 400, "订单超时时间必须大于0")
-			return
-		}
-		req.OrderExpireMinutes = &minutes
+		update.OrderExpireMinutes = &minutes
 	}
 
-	// 更新配置文件并热重载
-	if err := config.UpdateSettings(req); err != nil {
-		util.ErrorResponse(c, 500, "更新设置失败: "+err.Error())
+	settings, err := h.settingsService.Update(version, update, c.GetInt("user_id"))
+	if errors.Is(err, service.ErrSettingsConflict) {
+		util.ErrorResponse(c, 409, err.Error())
+		return
+	}
+	if err != nil {
+		util.ErrorResponse(c, 400, err.Error())
 		return
 	}
 
-	util.SuccessResponse(c, gin.H{
-		"message": "设置更新成功",
-	})
+	util.SuccessResponse(c, settingsResponse(settings))
 }
@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
+	"github.com/zhongruan0522/DuiDuiMao/internal/service"
+	"github.com/zhongruan0522/DuiDuiMao/internal/util"
+)
+
+func TestAdminRevealCode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := repository.NewCSVStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("创建存储失败: %v", err)
+	}
+	cipher, err := util.NewCodeCipher("", nil, "")
+	if err != nil {
+		t.Fatalf("NewCodeCipher() error = %v", err)
+	}
+	h := &AdminHandler{cdkService: service.NewCDKService(store, cipher, time.Now)}
+	encrypted, err := cipher.Encrypt("ABCD-EFGH-IJKL")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	cdk := &model.CDK{Code: encrypted}
+
+	tests := []struct {
+		name string
+		role string
+		want string
+	}{
+		{"超级管理员看到明文", model.RoleSuperAdmin, "ABCD-EFGH-IJKL"},
+		{"CDK运营看到明文", model.RoleCDKOperator, "ABCD-EFGH-IJKL"},
+		{"审计员只看到末4位", model.RoleAuditor, "***IJKL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Set("role", tt.role)
+			if got := h.adminRevealCode(c, cdk); got != tt.want {
+				t.Errorf("adminRevealCode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaskCode(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+		want string
+	}{
+		{"保留末4位", "ABCD-EFGH-IJKL", "***IJKL"},
+		{"较短的CDK全部打码", "ABCD1234", "***"},
+		{"中文CDK按字符打码", "兑兑猫兑换码一二三四", "***一二三四"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := maskCode(tt.code); got != tt.want {
+				t.Errorf("maskCode(%q) = %q, want %q", tt.code, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeQueryDate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name    string
+		query   string
+		want    time.Time
+		wantErr bool
+	}{
+		// 未加载配置时业务时区为UTC+8，与服务器本地时区无关
+		{"按业务时区解析", "start_date=" + util.DoubleEncode("2024-01-02"), time.Date(2024, 1, 1, 16, 0, 0, 0, time.UTC), false},
+		{"参数为空", "", time.Time{}, false},
+		{"格式错误", "start_date=" + util.DoubleEncode("2024/01/02"), time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("GET", "/api/admin/orders?"+tt.query, nil)
+			got, err := decodeQueryDate(c, "start_date")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeQueryDate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("decodeQueryDate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateSettingsAnnouncement(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := repository.NewCSVStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("创建存储失败: %v", err)
+	}
+	settingsService := service.NewSettingsService(store.Settings(), time.Now)
+	h := &AdminHandler{settingsService: settingsService}
+	if _, err := settingsService.Update(0, service.SettingsUpdate{Announcement: strPtr("维护通知")}, 1); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	// update 发送一次更新请求，announcement为nil时不传该字段
+	update := func(t *testing.T, announcement *string) string {
+		t.Helper()
+		settings, err := settingsService.Get()
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		body := map[string]string{"version": util.DoubleEncode(strconv.Itoa(settings.Version))}
+		if announcement != nil {
+			body["announcement"] = util.DoubleEncode(*announcement)
+		}
+		data, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPut, "/api/admin/settings", bytes.NewReader(data))
+		c.Request.Header.Set("Content-Type", "application/json")
+		h.UpdateSettings(c)
+		if w.Code != http.StatusOK {
+			t.Fatalf("UpdateSettings() 状态码 = %d, body = %s", w.Code, w.Body.String())
+		}
+		settings, err = settingsService.Get()
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		return settings.Announcement
+	}
+
+	t.Run("不传公告时保持不变", func(t *testing.T) {
+		if got := update(t, nil); got != "维护通知" {
+			t.Errorf("Announcement = %q, want %q", got, "维护通知")
+		}
+	})
+	t.Run("传空字符串时清空公告", func(t *testing.T) {
+		if got := update(t, strPtr("")); got != "" {
+			t.Errorf("Announcement = %q, want 空", got)
+		}
+	})
+}
+
+func strPtr(s string) *string { return &s }
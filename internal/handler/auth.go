@@ -1,41 +1,93 @@
 package handler
 
 import (
-	"crypto/rand"
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"log"
 	"net/http"
-	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/zhongruan0522/DuiDuiMao/internal/config"
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
 	"github.com/zhongruan0522/DuiDuiMao/internal/service"
 	"github.com/zhongruan0522/DuiDuiMao/internal/util"
 )
 
 // AuthHandler 认证处理器
 type AuthHandler struct {
-	cfg         *config.Config
-	userService *service.UserService
-	oauthStates map[string]bool // 简易state校验（生产环境应用Redis）
+	userService      *service.UserService
+	sessionService   *service.SessionService
+	userSyncService  *service.UserSyncService
+	adminAuthService *service.AdminAuthService
+	oauthStates      *service.OAuthStateService
+	provider         service.OAuthProvider
 }
 
 // NewAuthHandler 创建认证处理器
-func NewAuthHandler(cfg *config.Config, userService *service.UserService) *AuthHandler {
+func NewAuthHandler(userService *service.UserService, sessionService *service.SessionService, userSyncService *service.UserSyncService, adminAuthService *service.AdminAuthService, oauthStates *service.OAuthStateService, provider service.OAuthProvider) *AuthHandler {
 	return &AuthHandler{
-		cfg:         cfg,
-		userService: userService,
-		oauthStates: make(map[string]bool),
+		userService:      userService,
+		sessionService:   sessionService,
+		userSyncService:  userSyncService,
+		adminAuthService: adminAuthService,
+		oauthStates:      oauthStates,
+		provider:         provider,
 	}
 }
 
+// oauthStateCookie 保存发起登录时生成的state的Cookie，回调时须与state参数一致，防止登录CSRF
+const (
+	oauthStateCookie     = "oauth_state"
+	oauthStateCookiePath = "/api/auth"
+)
+
+// setStateCookie 写入（maxAge<0时删除）state Cookie，回调地址为https时只允许通过https发送
+func (h *AuthHandler) setStateCookie(c *gin.Context, value string, maxAge int) {
+	// 每次读取当前配置，重新加载配置后立即生效
+	secure := strings.HasPrefix(config.Get().OAuth.RedirectURI, "https://")
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oauthStateCookie, value, maxAge, oauthStateCookiePath, "", secure, true)
+}
+
+// RefreshRequest 刷新令牌请求
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"` // 双重Base64加密后的refresh token
+}
+
+// encodeTokens 加密令牌响应字段
+func encodeTokens(tokens *service.TokenPair) gin.H {
+	return gin.H{
+		"token":         util.DoubleEncode(tokens.AccessToken),
+		"refresh_token": util.DoubleEncode(tokens.RefreshToken),
+		"expires_in":    util.DoubleEncode(strconv.Itoa(tokens.ExpiresIn)),
+	}
+}
+
+// loginSuccess 为用户创建登录会话并返回加密后的令牌和用户信息
+func (h *AuthHandler) loginSuccess(c *gin.Context, user *model.User) {
+	tokens, err := h.sessionService.Login(user)
+	if err != nil {
+		util.ErrorResponse(c, 500, "生成Token失败")
+		return
+	}
+
+	// 加密响应数据
+	encryptedUserData, _ := json.Marshal(user)
+	resp := encodeTokens(tokens)
+	resp["message"] = "登录成功"
+	resp["user"] = util.DoubleEncode(string(encryptedUserData))
+	util.SuccessResponse(c, resp)
+}
+
 // AdminLoginRequest 管理员登录请求（加密后的数据）
 type AdminLoginRequest struct {
 	Username string `json:"username"` // 双重Base64加密后的用户名
 	Password string `json:"password"` // 双重Base64加密后的密码
+	Code     string `json:"code"`     // 双重Base64加密后的两步验证码或恢复码（启用两步验证时必填）
 }
 
 // AdminLogin 管理员账密登录
@@ -59,60 +111,58 @@ func (h *AuthHandler) AdminLogin(c *gin.Context) {
 		return
 	}
 
-	// 校验管理员账户
-	if username != h.cfg.Admin.Username || password != h.cfg.Admin.Password {
-		util.ErrorResponse(c, 401, "账号或密码错误")
-		return
+	code := ""
+	if req.Code != "" {
+		if code, err = util.DoubleDecode(req.Code); err != nil {
+			util.ErrorResponse(c, 400, "验证码解密失败")
+			return
+		}
 	}
 
-	// 创建/更新管理员用户记录
-	user, err := h.userService.CreateOrUpdateUser(
-		0,        // 管理员没有LinuxDoID，使用0
-		username, // 使用配置的管理员用户名
-		"管理员",    // 昵称
-		4,        // 最高信任等级
-		true,     // 是管理员
-	)
-	if err != nil {
-		util.ErrorResponse(c, 500, "创建用户记录失败")
+	// 校验管理员账户（按IP和用户名限流，每次尝试都记录审计日志）
+	user, err := h.adminAuthService.Login(service.LoginAttempt{
+		Username:  username,
+		Password:  password,
+		Code:      code,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	})
+	var locked *service.LoginLockedError
+	switch {
+	case errors.As(err, &locked):
+		c.Header("Retry-After", strconv.Itoa(locked.Seconds()))
+		util.ErrorResponse(c, 429, err.Error())
 		return
-	}
-
-	// 生成JWT
-	token, err := util.GenerateJWT(user.ID, true, h.cfg.JWT.ExpireHours)
-	if err != nil {
-		util.ErrorResponse(c, 500, "生成Token失败")
+	case errors.Is(err, service.ErrTOTPRequired):
+		// 密码正确，前端提示输入两步验证码后连同账号密码重新提交
+		util.SuccessResponse(c, gin.H{
+			"totp_required": util.DoubleEncode("true"),
+			"message":       err.Error(),
+		})
+		return
+	case errors.Is(err, service.ErrInvalidCredentials), errors.Is(err, service.ErrInvalidTOTPCode):
+		util.ErrorResponse(c, 401, err.Error())
+		return
+	case err != nil:
+		util.ErrorResponse(c, 500, err.Error())
 		return
 	}
 
-	// 加密响应数据
-	encryptedToken := util.DoubleEncode(token)
-	encryptedUserData, _ := json.Marshal(user)
-	encryptedUser := util.DoubleEncode(string(encryptedUserData))
-
-	util.SuccessResponse(c, gin.H{
-		"message": "登录成功",
-		"token":   encryptedToken,
-		"user":    encryptedUser,
-	})
+	h.loginSuccess(c, user)
 }
 
 // Login LinuxDo OAuth登录跳转
 func (h *AuthHandler) Login(c *gin.Context) {
-	// 生成随机state
-	state := h.generateState()
-	h.oauthStates[state] = true
-
-	// 构造授权URL
-	authURL := fmt.Sprintf(
-		"https://connect.linux.do/oauth2/authorize?client_id=%s&response_type=code&redirect_uri=%s&state=%s",
-		h.cfg.OAuth.AppClientID,
-		url.QueryEscape(h.cfg.OAuth.RedirectURI),
-		state,
-	)
+	// 生成一次性state（启用PKCE时同时生成code_verifier），并绑定到当前浏览器
+	login, err := h.oauthStates.Begin()
+	if err != nil {
+		util.ErrorResponse(c, 500, "发起登录失败: "+err.Error())
+		return
+	}
+	h.setStateCookie(c, login.State, int(time.Until(login.ExpiresAt).Seconds()))
 
 	util.SuccessResponse(c, gin.H{
-		"url": authURL,
+		"url": h.provider.AuthURL(login.State, login.CodeChallenge),
 	})
 }
 
@@ -121,12 +171,18 @@ func (h *AuthHandler) Callback(c *gin.Context) {
 	code := c.Query("code")
 	state := c.Query("state")
 
-	// 校验state
-	if !h.oauthStates[state] {
-		util.ErrorResponse(c, 400, "非法的state参数")
+	// 校验state（一次性使用，且必须与发起登录的浏览器Cookie一致）
+	cookieState, _ := c.Cookie(oauthStateCookie)
+	h.setStateCookie(c, "", -1)
+	codeVerifier, err := h.oauthStates.Verify(state, cookieState)
+	if errors.Is(err, service.ErrOAuthStateInvalid) {
+		util.ErrorResponse(c, 400, err.Error())
+		return
+	}
+	if err != nil {
+		util.ErrorResponse(c, 500, "校验state失败: "+err.Error())
 		return
 	}
-	delete(h.oauthStates, state) // 使用后删除
 
 	if code == "" {
 		util.ErrorResponse(c, 400, "缺少授权码")
@@ -134,140 +190,90 @@ func (h *AuthHandler) Callback(c *gin.Context) {
 	}
 
 	// 1. 用code换取access_token
-	accessToken, err := h.exchangeToken(code)
+	token, err := h.provider.ExchangeCode(code, codeVerifier)
 	if err != nil {
 		util.ErrorResponse(c, 500, fmt.Sprintf("获取access_token失败: %v", err))
 		return
 	}
 
-	// 2. 用access_token获取用户信息
-	linuxDoUser, err := h.getLinuxDoUser(accessToken)
+	// 2. 用access_token获取用户信息，未激活或已被禁言的账号不允许登录
+	linuxDoUser, err := h.provider.GetUser(token.AccessToken)
 	if err != nil {
 		util.ErrorResponse(c, 500, fmt.Sprintf("获取用户信息失败: %v", err))
 		return
 	}
+	if err := linuxDoUser.CheckAccount(); err != nil {
+		util.ErrorResponse(c, 403, err.Error()+"，无法登录")
+		return
+	}
 
-	// 3. 创建/更新本地用户
+	// 3. 创建/更新本地用户，按配置授予或收回管理员角色
 	user, err := h.userService.CreateOrUpdateUser(
 		linuxDoUser.ID,
 		linuxDoUser.Username,
 		linuxDoUser.Name,
 		linuxDoUser.TrustLevel,
-		false, // 普通用户不是管理员（除非后续手动设置）
+		h.userService.RoleFor(linuxDoUser.ID, linuxDoUser.Username),
 	)
 	if err != nil {
 		util.ErrorResponse(c, 500, "创建用户记录失败")
 		return
 	}
 
-	// 4. 生成JWT
-	token, err := util.GenerateJWT(user.ID, user.IsAdmin, h.cfg.JWT.ExpireHours)
-	if err != nil {
-		util.ErrorResponse(c, 500, "生成Token失败")
-		return
+	// 4. 保存LinuxDo令牌，用于之后同步信任等级（失败不影响本次登录）
+	if err := h.userSyncService.SaveToken(user.ID, token); err != nil {
+		log.Printf("保存用户 %d 的LinuxDo令牌失败: %v", user.ID, err)
 	}
 
-	// 加密响应数据
-	encryptedToken := util.DoubleEncode(token)
-	encryptedUserData, _ := json.Marshal(user)
-	encryptedUser := util.DoubleEncode(string(encryptedUserData))
-
-	util.SuccessResponse(c, gin.H{
-		"message": "登录成功",
-		"token":   encryptedToken,
-		"user":    encryptedUser,
-	})
-}
-
-// Logout 登出
-func (h *AuthHandler) Logout(c *gin.Context) {
-	util.SuccessResponse(c, gin.H{
-		"message": "登出成功",
-	})
-}
-
-// ========== 私有辅助方法 ==========
-
-// generateState 生成随机state
-func (h *AuthHandler) generateState() string {
-	b := make([]byte, 16)
-	_, _ = rand.Read(b) // rand.Read 总是返回 len(b), nil
-	return base64.URLEncoding.EncodeToString(b)
+	// 5. 创建登录会话
+	h.loginSuccess(c, user)
 }
 
-// exchangeToken 用授权码换取access_token
-func (h *AuthHandler) exchangeToken(code string) (string, error) {
-	tokenURL := "https://connect.linux.do/oauth2/token"
-
-	data := url.Values{}
-	data.Set("grant_type", "authorization_code")
-	data.Set("code", code)
-	data.Set("redirect_uri", h.cfg.OAuth.RedirectURI)
-
-	req, err := http.NewRequest("POST", tokenURL, strings.NewReader(data.Encode()))
-	if err != nil {
-		return "", err
+// Refresh 用refresh token换取新的access token和refresh token（旧的refresh token随即失效）
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.ErrorResponse(c, 400, "请求参数错误")
+		return
 	}
-
-	// 使用Basic Auth
-	req.SetBasicAuth(h.cfg.OAuth.AppClientID, h.cfg.OAuth.AppClientSecret)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	refreshToken, err := util.DoubleDecode(req.RefreshToken)
 	if err != nil {
-		return "", err
+		util.ErrorResponse(c, 400, "refresh token解密失败")
+		return
 	}
-	defer resp.Body.Close()
 
-	body, _ := io.ReadAll(resp.Body)
-
-	var result struct {
-		AccessToken string `json:"access_token"`
-		TokenType   string `json:"token_type"`
-		ExpiresIn   int    `json:"expires_in"`
+	tokens, err := h.sessionService.Refresh(refreshToken)
+	if errors.Is(err, service.ErrSessionInvalid) {
+		util.ErrorResponse(c, 401, err.Error())
+		return
 	}
-	if err := json.Unmarshal(body, &result); err != nil {
-		return "", err
+	if err != nil {
+		util.ErrorResponse(c, 500, "刷新Token失败")
+		return
 	}
-
-	return result.AccessToken, nil
-}
-
-// LinuxDoUser LinuxDo用户信息
-type LinuxDoUser struct {
-	ID         int    `json:"id"`
-	Username   string `json:"username"`
-	Name       string `json:"name"`
-	Active     bool   `json:"active"`
-	TrustLevel int    `json:"trust_level"`
-	Silenced   bool   `json:"silenced"`
+	util.SuccessResponse(c, encodeTokens(tokens))
 }
 
-// getLinuxDoUser 获取LinuxDo用户信息
-func (h *AuthHandler) getLinuxDoUser(accessToken string) (*LinuxDoUser, error) {
-	userURL := "https://connect.linux.do/api/user"
-
-	req, err := http.NewRequest("GET", userURL, nil)
-	if err != nil {
-		return nil, err
+// Logout 登出：撤销当前会话，该会话签发的access token和refresh token立即失效
+func (h *AuthHandler) Logout(c *gin.Context) {
+	if err := h.sessionService.Logout(c.GetString("session_id")); err != nil {
+		util.ErrorResponse(c, 500, "登出失败")
+		return
 	}
+	util.SuccessResponse(c, gin.H{
+		"message": "登出成功",
+	})
+}
 
-	req.Header.Set("Authorization", "Bearer "+accessToken)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// LogoutAll 登出所有设备：撤销当前用户的全部会话
+func (h *AuthHandler) LogoutAll(c *gin.Context) {
+	revoked, err := h.sessionService.LogoutAll(c.GetInt("user_id"))
 	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-
-	var user LinuxDoUser
-	if err := json.Unmarshal(body, &user); err != nil {
-		return nil, err
+		util.ErrorResponse(c, 500, "登出失败")
+		return
 	}
-
-	return &user, nil
+	util.SuccessResponse(c, gin.H{
+		"message": "已登出所有设备",
+		"revoked": util.DoubleEncode(strconv.Itoa(revoked)),
+	})
 }
@@ -0,0 +1,273 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+	"github.com/zhongruan0522/DuiDuiMao/internal/service"
+	"github.com/zhongruan0522/DuiDuiMao/internal/util"
+)
+
+// OrderHandler 订单处理器
+type OrderHandler struct {
+	orderService   *service.OrderService
+	tierService    *service.TierService
+	userService    *service.UserService
+	cdkService     *service.CDKService
+	payService     *service.PayService
+	paymentService *service.PaymentService
+}
+
+// NewOrderHandler 创建订单处理器
+func NewOrderHandler(orderService *service.OrderService, tierService *service.TierService, userService *service.UserService, cdkService *service.CDKService, payService *service.PayService, paymentService *service.PaymentService) *OrderHandler {
+	return &OrderHandler{
+		orderService:   orderService,
+		tierService:    tierService,
+		userService:    userService,
+		cdkService:     cdkService,
+		payService:     payService,
+		paymentService: paymentService,
+	}
+}
+
+// CreateOrderRequest 创建订单请求
+type CreateOrderRequest struct {
+	TierID   int `json:"tier_id" binding:"required,min=1"`
+	Quantity int `json:"quantity" binding:"required,min=1"`
+}
+
+// formatTime 格式化时间，零值返回空字符串
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// encodeOrder 订单数据双重Base64加密
+func encodeOrder(order *model.Order, tierName string) gin.H {
+	return gin.H{
+		"id":          util.DoubleEncode(strconv.Itoa(order.ID)),
+		"order_no":    util.DoubleEncode(order.OrderNo),
+		"user_id":     util.DoubleEncode(strconv.Itoa(order.UserID)),
+		"tier_id":     util.DoubleEncode(strconv.Itoa(order.TierID)),
+		"tier_name":   util.DoubleEncode(tierName),
+		"quantity":    util.DoubleEncode(strconv.Itoa(order.Quantity)),
+		"total_quota": util.DoubleEncode(strconv.Itoa(order.TotalQuota)),
+		"total_price": util.DoubleEncode(strconv.Itoa(order.TotalPrice)),
+		"status":      util.DoubleEncode(strconv.Itoa(order.Status)),
+		"created_at":  util.DoubleEncode(formatTime(order.CreatedAt)),
+		"paid_at":     util.DoubleEncode(formatTime(order.PaidAt)),
+		"expired_at":  util.DoubleEncode(formatTime(order.ExpiredAt)),
+	}
+}
+
+// revealCode 解密CDK明文（解密失败显示占位符）
+func revealCode(cdkService *service.CDKService, cdk *model.CDK) string {
+	code, err := cdkService.RevealCode(cdk)
+	if err != nil {
+		return "***"
+	}
+	return code
+}
+
+// maskCode 打码CDK，只保留末尾几位用于核对（较短的CDK全部打码）
+func maskCode(code string) string {
+	const visible = 4
+	runes := []rune(code)
+	if len(runes) <= visible*2 {
+		return "***"
+	}
+	return "***" + string(runes[len(runes)-visible:])
+}
+
+// decodeCDKCodes 解密CDK列表用于返回给用户
+func decodeCDKCodes(cdkService *service.CDKService, cdks []model.CDK) []string {
+	codes := []string{}
+	for i := range cdks {
+		codes = append(codes, revealCode(cdkService, &cdks[i]))
+	}
+	return codes
+}
+
+// tierNames 获取档位名称映射（tier_id -> name）
+func tierNames(tierService *service.TierService) map[int]string {
+	tiers, _ := tierService.GetAllTiers()
+	names := make(map[int]string)
+	for _, tier := range tiers {
+		names[tier.ID] = tier.Name
+	}
+	return names
+}
+
+// CreateOrder 创建订单
+func (h *OrderHandler) CreateOrder(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		util.ErrorResponse(c, 401, "未登录")
+		return
+	}
+
+	var req CreateOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.ErrorResponse(c, 400, "参数错误: "+err.Error())
+		return
+	}
+	if req.Quantity > service.MaxOrderQuantity {
+		util.ErrorResponse(c, 400, fmt.Sprintf("单次最多购买%d个", service.MaxOrderQuantity))
+		return
+	}
+
+	// 验证档位是否存在且启用
+	tier, err := h.tierService.GetTierByID(req.TierID)
+	if err != nil {
+		util.ErrorResponse(c, 404, "档位不存在")
+		return
+	}
+	if !tier.IsActive {
+		util.ErrorResponse(c, 400, "该档位未启用")
+		return
+	}
+	if !checkTierEligible(c, h.userService, userID.(int), tier) {
+		return
+	}
+	if err := h.tierService.CheckOnSale(tier); err != nil {
+		util.ErrorResponse(c, 400, err.Error())
+		return
+	}
+	if tier.Stock < req.Quantity {
+		util.ErrorResponse(c, 400, "该档位库存不足")
+		return
+	}
+	if tier.Price > 0 && !h.payService.Enabled() {
+		util.ErrorResponse(c, 400, "支付未配置，暂无法购买付费档位")
+		return
+	}
+
+	// 创建待支付订单（同时锁定CDK库存）
+	order, err := h.orderService.CreateOrder(userID.(int), req.TierID, req.Quantity)
+	if errors.Is(err, service.ErrDailyLimitExceeded) {
+		util.ErrorResponse(c, 429, err.Error())
+		return
+	}
+	if errors.Is(err, service.ErrTrustLevelTooLow) {
+		util.ErrorResponse(c, 403, err.Error())
+		return
+	}
+	if errors.Is(err, service.ErrTierNotOnSale) {
+		util.ErrorResponse(c, 400, err.Error())
+		return
+	}
+	if err != nil {
+		util.ErrorResponse(c, 500, "创建订单失败: "+err.Error())
+		return
+	}
+
+	// 免费订单无需支付，直接完成并发放CDK
+	if order.TotalPrice == 0 {
+		if order, err = h.paymentService.CompleteFreeOrder(order.ID); err != nil {
+			util.ErrorResponse(c, 500, "完成订单失败: "+err.Error())
+			return
+		}
+		data := encodeOrder(order, tier.Name)
+		data["message"] = "下单成功"
+		util.SuccessResponse(c, data)
+		return
+	}
+
+	// 付费订单返回支付地址，前端跳转到LinuxDo Credit完成支付
+	payURL, err := h.payService.PayURL(order, paySubject(order, tier.Name))
+	if err != nil {
+		util.ErrorResponse(c, 500, "发起支付失败: "+err.Error())
+		return
+	}
+	data := encodeOrder(order, tier.Name)
+	data["message"] = "订单已创建，请在超时前完成支付"
+	data["pay_url"] = payURL // 明文返回，前端直接跳转
+	util.SuccessResponse(c, data)
+}
+
+// GetOrders 获取我的订单列表
+func (h *OrderHandler) GetOrders(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		util.ErrorResponse(c, 401, "未登录")
+		return
+	}
+
+	orders, err := h.orderService.GetUserOrders(userID.(int))
+	if err != nil {
+		util.ErrorResponse(c, 500, "获取订单列表失败: "+err.Error())
+		return
+	}
+
+	names := tierNames(h.tierService)
+	encryptedData := []gin.H{}
+	for i := range orders {
+		encryptedData = append(encryptedData, encodeOrder(&orders[i], names[orders[i].TierID]))
+	}
+
+	util.SuccessResponse(c, encryptedData)
+}
+
+// GetOrder 获取订单详情
+func (h *OrderHandler) GetOrder(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		util.ErrorResponse(c, 401, "未登录")
+		return
+	}
+
+	order, err := h.orderService.GetUserOrder(userID.(int), c.Param("order_no"))
+	if err != nil {
+		util.ErrorResponse(c, 404, err.Error())
+		return
+	}
+
+	util.SuccessResponse(c, encodeOrder(order, tierNames(h.tierService)[order.TierID]))
+}
+
+// CancelOrder 取消订单
+func (h *OrderHandler) CancelOrder(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		util.ErrorResponse(c, 401, "未登录")
+		return
+	}
+
+	order, err := h.orderService.CancelOrder(userID.(int), c.Param("order_no"))
+	if err != nil {
+		util.ErrorResponse(c, 400, "取消订单失败: "+err.Error())
+		return
+	}
+
+	util.SuccessResponse(c, gin.H{
+		"message":  "订单已取消",
+		"order_no": util.DoubleEncode(order.OrderNo),
+	})
+}
+
+// GetOrderCDKs 获取订单CDK（仅已完成订单）
+func (h *OrderHandler) GetOrderCDKs(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		util.ErrorResponse(c, 401, "未登录")
+		return
+	}
+
+	orderNo := c.Param("order_no")
+	cdks, err := h.orderService.GetUserOrderCDKs(userID.(int), orderNo)
+	if err != nil {
+		util.ErrorResponse(c, 400, "获取订单CDK失败: "+err.Error())
+		return
+	}
+
+	util.SuccessResponse(c, gin.H{
+		"order_no":  util.DoubleEncode(orderNo),
+		"cdk_codes": decodeCDKCodes(h.cdkService, cdks), // 明文返回给用户
+	})
+}
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+	"github.com/zhongruan0522/DuiDuiMao/internal/service"
+	"github.com/zhongruan0522/DuiDuiMao/internal/util"
+)
+
+// PayHandler 支付处理器
+type PayHandler struct {
+	payService   *service.PayService
+	orderService *service.OrderService
+	tierService  *service.TierService
+}
+
+// NewPayHandler 创建支付处理器
+func NewPayHandler(payService *service.PayService, orderService *service.OrderService, tierService *service.TierService) *PayHandler {
+	return &PayHandler{
+		payService:   payService,
+		orderService: orderService,
+		tierService:  tierService,
+	}
+}
+
+// paySubject 支付页面显示的商品名称
+func paySubject(order *model.Order, tierName string) string {
+	return fmt.Sprintf("%s x%d", tierName, order.Quantity)
+}
+
+// payParams 读取支付网关回传的参数（GET查询参数与POST表单均支持）
+func payParams(c *gin.Context) map[string]string {
+	_ = c.Request.ParseForm()
+	params := make(map[string]string)
+	for k, v := range c.Request.Form {
+		if len(v) > 0 {
+			params[k] = v[0]
+		}
+	}
+	return params
+}
+
+// Pay 获取待支付订单的支付地址，前端跳转到该地址完成支付
+func (h *PayHandler) Pay(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		util.ErrorResponse(c, 401, "未登录")
+		return
+	}
+
+	order, err := h.orderService.GetUserOrder(userID.(int), c.Param("order_no"))
+	if err != nil {
+		util.ErrorResponse(c, 404, err.Error())
+		return
+	}
+
+	payURL, err := h.payService.PayURL(order, paySubject(order, tierNames(h.tierService)[order.TierID]))
+	if err != nil {
+		util.ErrorResponse(c, 400, "发起支付失败: "+err.Error())
+		return
+	}
+
+	util.SuccessResponse(c, gin.H{
+		"order_no": util.DoubleEncode(order.OrderNo),
+		"pay_url":  payURL, // 明文返回，前端直接跳转
+	})
+}
+
+// Callback 支付完成后浏览器跳转回来，重定向到订单详情页（订单状态以异步通知为准）
+func (h *PayHandler) Callback(c *gin.Context) {
+	params := payParams(c)
+	if err := h.payService.Verify(params); err != nil {
+		log.Printf("支付跳转参数校验失败: %v", err)
+		c.Redirect(302, "/orders")
+		return
+	}
+	c.Redirect(302, "/orders/"+url.PathEscape(params["out_trade_no"]))
+}
+
+// Notify 支付网关异步通知，处理成功返回 success，否则返回 fail 等待网关重试
+func (h *PayHandler) Notify(c *gin.Context) {
+	order, err := h.payService.HandleNotify(payParams(c))
+	if err != nil {
+		log.Printf("处理支付通知失败: %v", err)
+		c.String(200, "fail")
+		return
+	}
+
+	log.Printf("订单 %s 支付通知处理完成，当前状态: %d", order.OrderNo, order.Status)
+	c.String(200, "success")
+}
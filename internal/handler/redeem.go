@@ -1,9 +1,11 @@
 package handler
 
 import (
+	"errors"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
 	"github.com/zhongruan0522/DuiDuiMao/internal/service"
 	"github.com/zhongruan0522/DuiDuiMao/internal/util"
 )
@@ -13,14 +15,16 @@ type RedeemHandler struct {
 	tierService      *service.TierService
 	cdkService       *service.CDKService
 	redeemLogService *service.RedeemLogService
+	userService      *service.UserService
 }
 
 // NewRedeemHandler 创建兑换处理器
-func NewRedeemHandler(tierService *service.TierService, cdkService *service.CDKService, redeemLogService *service.RedeemLogService) *RedeemHandler {
+func NewRedeemHandler(tierService *service.TierService, cdkService *service.CDKService, redeemLogService *service.RedeemLogService, userService *service.UserService) *RedeemHandler {
 	return &RedeemHandler{
 		tierService:      tierService,
 		cdkService:       cdkService,
 		redeemLogService: redeemLogService,
+		userService:      userService,
 	}
 }
 
@@ -51,33 +55,44 @@ func (h *RedeemHandler) Redeem(c *gin.Context) {
 		return
 	}
 
+	// 检查用户信任等级
+	if !checkTierEligible(c, h.userService, userID.(int), tier) {
+		return
+	}
+
+	// 检查开放时间
+	if err := h.tierService.CheckOnSale(tier); err != nil {
+		util.ErrorResponse(c, 400, err.Error())
+		return
+	}
+
 	// 检查库存
 	if tier.Stock <= 0 {
 		util.ErrorResponse(c, 400, "该档位已无库存")
 		return
 	}
 
-	// 获取一个可用的CDK
-	cdk, err := h.cdkService.GetAvailableCDKByTierID(tierID)
-	if err != nil {
-		util.ErrorResponse(c, 500, "获取CDK失败: "+err.Error())
+	// 原子地领取一个CDK（并发请求不会拿到同一个CDK）
+	cdk, err := h.cdkService.ClaimNextCDK(tierID, userID.(int))
+	if errors.Is(err, service.ErrDailyLimitExceeded) {
+		util.ErrorResponse(c, 429, err.Error())
 		return
 	}
-
-	// 标记CDK为已兑换
-	if err := h.cdkService.MarkCDKAsRedeemed(cdk.ID, userID.(int)); err != nil {
-		util.ErrorResponse(c, 500, "兑换失败: "+err.Error())
+	if errors.Is(err, service.ErrTrustLevelTooLow) {
+		util.ErrorResponse(c, 403, err.Error())
 		return
 	}
-
-	// 创建兑换记录
-	if err := h.redeemLogService.CreateRedeemLog(userID.(int), cdk.ID, tierID); err != nil {
-		// 记录失败不影响兑换（CDK已被标记为已兑换）
-		// 可以记录日志但不返回错误
+	if errors.Is(err, service.ErrTierNotOnSale) || errors.Is(err, service.ErrTierRequiresPayment) {
+		util.ErrorResponse(c, 400, err.Error())
+		return
+	}
+	if err != nil {
+		util.ErrorResponse(c, 500, "兑换失败: "+err.Error())
+		return
 	}
 
 	// 解密CDK返回给用户
-	cdkCode, err := util.DoubleDecode(cdk.Code)
+	cdkCode, err := h.cdkService.RevealCode(cdk)
 	if err != nil {
 		util.ErrorResponse(c, 500, "CDK解密失败")
 		return
@@ -116,21 +131,20 @@ func (h *RedeemHandler) GetHistory(c *gin.Context) {
 	}
 
 	cdks, _ := h.cdkService.GetCDKs(nil, nil)
-	cdkMap := make(map[int]string) // cdk_id -> cdk_code
-	for _, cdk := range cdks {
-		cdkMap[cdk.ID] = cdk.Code // 已加密
+	cdkMap := make(map[int]*model.CDK) // cdk_id -> cdk
+	for i := range cdks {
+		cdkMap[cdks[i].ID] = &cdks[i]
 	}
 
 	// 构建返回数据（加密）
 	encryptedData := []gin.H{}
 	for _, log := range redeemLogs {
 		tierName := tierMap[log.TierID]
-		cdkCode := cdkMap[log.CDKID]
 
 		// 解密CDK用于显示
-		decryptedCode, decErr := util.DoubleDecode(cdkCode)
-		if decErr != nil {
-			decryptedCode = "***" // 解密失败显示占位符
+		decryptedCode := "***" // 解密失败显示占位符
+		if cdk, ok := cdkMap[log.CDKID]; ok {
+			decryptedCode = revealCode(h.cdkService, cdk)
 		}
 
 		encryptedData = append(encryptedData, gin.H{
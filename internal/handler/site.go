@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zhongruan0522/DuiDuiMao/internal/service"
+	"github.com/zhongruan0522/DuiDuiMao/internal/util"
+)
+
+// SiteHandler 站点信息处理器（公开接口）
+type SiteHandler struct {
+	settingsService *service.SettingsService
+}
+
+// NewSiteHandler 创建站点信息处理器
+func NewSiteHandler(settingsService *service.SettingsService) *SiteHandler {
+	return &SiteHandler{settingsService: settingsService}
+}
+
+// GetSite 获取首页公告和是否开放兑换（无需登录）
+func (h *SiteHandler) GetSite(c *gin.Context) {
+	settings, err := h.settingsService.Get()
+	if err != nil {
+		util.ErrorResponse(c, 500, "获取站点信息失败: "+err.Error())
+		return
+	}
+
+	util.SuccessResponse(c, gin.H{
+		"announcement": util.DoubleEncode(settings.Announcement),
+		"open":         util.DoubleEncode(strconv.FormatBool(settings.GlobalEnabled)),
+	})
+}
@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
+	"github.com/zhongruan0522/DuiDuiMao/internal/service"
+	"github.com/zhongruan0522/DuiDuiMao/internal/util"
+)
+
+func TestGetSite(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := repository.NewCSVStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("创建存储失败: %v", err)
+	}
+	settings := service.NewSettingsService(store.Settings(), time.Now)
+	r := gin.New()
+	r.GET("/api/site", NewSiteHandler(settings).GetSite)
+
+	// getSite 请求站点信息并解密响应字段
+	getSite := func() (announcement, open string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/site", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /api/site 状态码 = %d, body = %s", w.Code, w.Body.String())
+		}
+		var resp struct {
+			Data struct {
+				Announcement string `json:"announcement"`
+				Open         string `json:"open"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("解析响应失败: %v", err)
+		}
+		announcement, err := util.DoubleDecode(resp.Data.Announcement)
+		if err != nil {
+			t.Fatalf("解密公告失败: %v", err)
+		}
+		open, err = util.DoubleDecode(resp.Data.Open)
+		if err != nil {
+			t.Fatalf("解密开关失败: %v", err)
+		}
+		return announcement, open
+	}
+
+	if _, open := getSite(); open != "true" {
+		t.Errorf("默认 open = %s, want true", open)
+	}
+
+	// 后台修改设置后无需重启即可读到新值
+	disabled, announcement := false, "系统维护中，今晚恢复"
+	if _, err := settings.Update(0, service.SettingsUpdate{GlobalEnabled: &disabled, Announcement: &announcement}, 1); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if got, open := getSite(); got != announcement || open != "false" {
+		t.Errorf("修改后 announcement = %q, open = %s, want %q, false", got, open, announcement)
+	}
+}
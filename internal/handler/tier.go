@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+	"github.com/zhongruan0522/DuiDuiMao/internal/service"
+	"github.com/zhongruan0522/DuiDuiMao/internal/util"
+)
+
+// TierHandler 档位处理器（用户端）
+type TierHandler struct {
+	tierService *service.TierService
+	userService *service.UserService
+}
+
+// NewTierHandler 创建档位处理器
+func NewTierHandler(tierService *service.TierService, userService *service.UserService) *TierHandler {
+	return &TierHandler{
+		tierService: tierService,
+		userService: userService,
+	}
+}
+
+// formatRFC3339 格式化为带时区的时间（供前端回填到时间输入框），零值返回空字符串
+func formatRFC3339(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// secondsUntil 距离t的秒数（向上取整），t为零值或已过时返回0
+func secondsUntil(t, now time.Time) int {
+	if t.IsZero() || !t.After(now) {
+		return 0
+	}
+	return int((t.Sub(now) + time.Second - 1) / time.Second)
+}
+
+// encodeTier 档位数据双重Base64加密
+// starts_at/ends_at/next_release_at 为RFC3339格式；opens_in/closes_in/next_release_in 为按服务端时钟计算的倒计时秒数（0表示已开放/不限/没有待放出的CDK）
+func encodeTier(tier *model.Tier, now time.Time) gin.H {
+	return gin.H{
+		"id":                 util.DoubleEncode(strconv.Itoa(tier.ID)),
+		"name":               util.DoubleEncode(tier.Name),
+		"quota":              util.DoubleEncode(strconv.Itoa(tier.Quota)),
+		"price":              util.DoubleEncode(strconv.Itoa(tier.Price)),
+		"required_level":     util.DoubleEncode(strconv.Itoa(tier.RequiredLevel)),
+		"daily_limit":        util.DoubleEncode(strconv.Itoa(tier.DailyLimit)),
+		"stock":              util.DoubleEncode(strconv.Itoa(tier.Stock)),
+		"is_active":          util.DoubleEncode(strconv.FormatBool(tier.IsActive)),
+		"sort_order":         util.DoubleEncode(strconv.Itoa(tier.SortOrder)),
+		"created_at":         util.DoubleEncode(tier.CreatedAt.Format("2006-01-02 15:04:05")),
+		"updated_at":         util.DoubleEncode(tier.UpdatedAt.Format("2006-01-02 15:04:05")),
+		"starts_at":          util.DoubleEncode(formatRFC3339(tier.StartsAt)),
+		"ends_at":            util.DoubleEncode(formatRFC3339(tier.EndsAt)),
+		"opens_in":           util.DoubleEncode(strconv.Itoa(secondsUntil(tier.StartsAt, now))),
+		"closes_in":          util.DoubleEncode(strconv.Itoa(secondsUntil(tier.EndsAt, now))),
+		"scheduled":          util.DoubleEncode(strconv.Itoa(tier.Scheduled)),
+		"next_release_at":    util.DoubleEncode(formatRFC3339(tier.NextReleaseAt)),
+		"next_release_in":    util.DoubleEncode(strconv.Itoa(secondsUntil(tier.NextReleaseAt, now))),
+		"next_release_count": util.DoubleEncode(strconv.Itoa(tier.NextReleaseCount)),
+	}
+}
+
+// checkTierEligible 校验当前用户的信任等级是否满足档位要求，不满足时写入403响应并返回false
+// 只用于在检查库存等之前给出提示，真正的校验由服务层在兑换/下单的事务中完成（ErrTrustLevelTooLow）
+func checkTierEligible(c *gin.Context, userService *service.UserService, userID int, tier *model.Tier) bool {
+	user, err := userService.GetUserByID(userID)
+	if err != nil {
+		util.ErrorResponse(c, 401, "用户不存在")
+		return false
+	}
+	if !service.TierEligible(tier, user) {
+		util.ErrorResponse(c, 403, fmt.Sprintf("信任等级不足：该档位需要等级%d，当前等级%d", tier.RequiredLevel, user.TrustLevel))
+		return false
+	}
+	return true
+}
+
+// GetTiers 获取启用且尚未结束的档位列表（含尚未开放的档位及其倒计时）
+// 已登录时每个档位额外返回 eligible，表示当前用户的信任等级是否满足要求
+func (h *TierHandler) GetTiers(c *gin.Context) {
+	tiers, err := h.tierService.GetActiveTiers()
+	if err != nil {
+		util.ErrorResponse(c, 500, "获取档位列表失败: "+err.Error())
+		return
+	}
+
+	var user *model.User
+	if userID, exists := c.Get("user_id"); exists {
+		user, _ = h.userService.GetUserByID(userID.(int))
+	}
+
+	now := h.tierService.Now()
+	encryptedData := []gin.H{}
+	for i := range tiers {
+		data := encodeTier(&tiers[i], now)
+		if user != nil {
+			data["eligible"] = util.DoubleEncode(strconv.FormatBool(service.TierEligible(&tiers[i], user)))
+		}
+		encryptedData = append(encryptedData, data)
+	}
+
+	util.SuccessResponse(c, encryptedData)
+}
@@ -1,30 +1,74 @@
 package handler
 
 import (
+	"encoding/json"
+	"strconv"
+	"time"
+
 	"github.com/gin-gonic/gin"
+	"github.com/zhongruan0522/DuiDuiMao/internal/service"
 	"github.com/zhongruan0522/DuiDuiMao/internal/util"
 )
 
 // UserHandler 用户处理器
-type UserHandler struct{}
+type UserHandler struct {
+	userService      *service.UserService
+	tierService      *service.TierService
+	redeemLogService *service.RedeemLogService
+}
 
 // NewUserHandler 创建用户处理器
-func NewUserHandler() *UserHandler {
-	return &UserHandler{}
+func NewUserHandler(userService *service.UserService, tierService *service.TierService, redeemLogService *service.RedeemLogService) *UserHandler {
+	return &UserHandler{
+		userService:      userService,
+		tierService:      tierService,
+		redeemLogService: redeemLogService,
+	}
 }
 
-// GetMe 获取当前用户信息（Mock）
+// GetMe 获取当前用户信息，以及累计兑换数量和各启用档位的今日兑换统计
 func (h *UserHandler) GetMe(c *gin.Context) {
-	// 从上下文获取用户ID（由AuthMiddleware设置）
-	userID, _ := c.Get("user_id")
-	isAdmin, _ := c.Get("is_admin")
+	userID, exists := c.Get("user_id")
+	if !exists {
+		util.ErrorResponse(c, 401, "未登录")
+		return
+	}
+
+	user, err := h.userService.GetUserByID(userID.(int))
+	if err != nil {
+		util.ErrorResponse(c, 404, err.Error())
+		return
+	}
+
+	tiers, err := h.tierService.GetActiveTiers()
+	if err != nil {
+		util.ErrorResponse(c, 500, "获取档位列表失败: "+err.Error())
+		return
+	}
+	stats, err := h.redeemLogService.GetUserStats(user.ID, tiers, time.Now())
+	if err != nil {
+		util.ErrorResponse(c, 500, "获取兑换统计失败: "+err.Error())
+		return
+	}
+
+	tierStats := []gin.H{}
+	for i := range stats.Tiers {
+		s := &stats.Tiers[i]
+		tierStats = append(tierStats, gin.H{
+			"tier_id":        util.DoubleEncode(strconv.Itoa(s.Tier.ID)),
+			"tier_name":      util.DoubleEncode(s.Tier.Name),
+			"daily_limit":    util.DoubleEncode(strconv.Itoa(s.Tier.DailyLimit)),
+			"redeemed_today": util.DoubleEncode(strconv.Itoa(s.RedeemedToday)),
+			"remaining":      util.DoubleEncode(strconv.Itoa(s.Remaining)), // -1表示不限购
+			"eligible":       util.DoubleEncode(strconv.FormatBool(service.TierEligible(&s.Tier, user))),
+		})
+	}
 
+	// 加密响应数据（用户信息与登录接口格式一致）
+	userData, _ := json.Marshal(user)
 	util.SuccessResponse(c, gin.H{
-		"id":          userID,
-		"linux_do_id": 12345,
-		"username":    "test_user",
-		"name":        "测试用户",
-		"trust_level": 2,
-		"is_admin":    isAdmin,
+		"user":           util.DoubleEncode(string(userData)),
+		"total_redeemed": util.DoubleEncode(strconv.Itoa(stats.TotalRedeemed)),
+		"tiers":          tierStats,
 	})
 }
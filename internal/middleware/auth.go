@@ -1,25 +1,38 @@
 package middleware
 
 import (
+	"errors"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+	"github.com/zhongruan0522/DuiDuiMao/internal/service"
 	"github.com/zhongruan0522/DuiDuiMao/internal/util"
 )
 
-// AuthMiddleware JWT认证中间件
-func AuthMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// 从Header或Cookie获取token
-		token := c.GetHeader("Authorization")
-		if token != "" {
-			// 去除Bearer前缀
-			token = strings.TrimPrefix(token, "Bearer ")
-		} else {
-			// 尝试从Cookie获取
-			token, _ = c.Cookie("token")
-		}
+// requestToken 从Header或Cookie获取token
+func requestToken(c *gin.Context) string {
+	token := c.GetHeader("Authorization")
+	if token != "" {
+		// 去除Bearer前缀
+		return strings.TrimPrefix(token, "Bearer ")
+	}
+	// 尝试从Cookie获取
+	token, _ = c.Cookie("token")
+	return token
+}
 
+// setClaims 将用户信息存入上下文，角色取用户当前的角色而不是签发token时的角色
+func setClaims(c *gin.Context, claims *util.Claims, user *model.User) {
+	c.Set("user_id", claims.UserID)
+	c.Set("role", user.Role)
+	c.Set("session_id", claims.ID)
+}
+
+// AuthMiddleware JWT认证中间件：校验签名后再确认会话未被登出或撤销
+func AuthMiddleware(sessions *service.SessionService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := requestToken(c)
 		if token == "" {
 			util.ErrorResponse(c, 401, "未登录")
 			c.Abort()
@@ -34,9 +47,33 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// 将用户信息存入上下文
-		c.Set("user_id", claims.UserID)
-		c.Set("is_admin", claims.IsAdmin)
+		// 校验会话并读取用户当前的角色
+		user, err := sessions.Authenticate(claims)
+		if err != nil {
+			if errors.Is(err, service.ErrSessionInvalid) {
+				util.ErrorResponse(c, 401, err.Error())
+			} else {
+				util.ErrorResponse(c, 500, "校验登录状态失败")
+			}
+			c.Abort()
+			return
+		}
+
+		setClaims(c, claims, user)
+		c.Next()
+	}
+}
+
+// OptionalAuthMiddleware 可选认证中间件：携带有效token时写入用户信息，否则按未登录继续处理
+func OptionalAuthMiddleware(sessions *service.SessionService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token := requestToken(c); token != "" {
+			if claims, err := util.ParseJWT(token); err == nil {
+				if user, err := sessions.Authenticate(claims); err == nil {
+					setClaims(c, claims, user)
+				}
+			}
+		}
 		c.Next()
 	}
 }
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/zhongruan0522/DuiDuiMao/internal/service"
+	"github.com/zhongruan0522/DuiDuiMao/internal/util"
+)
+
+// PurchaseSwitchMiddleware 全局开关中间件：管理员关闭全局开关后拒绝兑换、下单和发起支付（返回503）
+// 每次请求读取当前设置，修改后立即生效
+func PurchaseSwitchMiddleware(settings *service.SettingsService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		current, err := settings.Get()
+		if err != nil {
+			util.ErrorResponse(c, 500, "获取系统设置失败")
+			c.Abort()
+			return
+		}
+		if !current.GlobalEnabled {
+			util.ErrorResponse(c, 503, service.ErrPurchaseDisabled.Error())
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
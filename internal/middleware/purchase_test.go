@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
+	"github.com/zhongruan0522/DuiDuiMao/internal/service"
+)
+
+func TestPurchaseSwitchMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := repository.NewCSVStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("创建存储失败: %v", err)
+	}
+	settings := service.NewSettingsService(store.Settings(), time.Now)
+
+	// 与 cmd/server 中的路由一致：兑换、下单和发起支付经过全局开关
+	r := gin.New()
+	purchaseSwitch := PurchaseSwitchMiddleware(settings)
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	r.POST("/api/redeem/:tier_id", purchaseSwitch, ok)
+	r.POST("/api/orders", purchaseSwitch, ok)
+	r.POST("/api/pay/:order_no", purchaseSwitch, ok)
+	paths := []string{"/api/redeem/1", "/api/orders", "/api/pay/ORD123"}
+
+	check := func(want int) {
+		t.Helper()
+		for _, path := range paths {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, nil))
+			if w.Code != want {
+				t.Errorf("POST %s 状态码 = %d, want %d", path, w.Code, want)
+			}
+		}
+	}
+
+	t.Run("默认开启", func(t *testing.T) {
+		check(http.StatusOK)
+	})
+
+	disabled, enabled := false, true
+	t.Run("关闭后返回503", func(t *testing.T) {
+		if _, err := settings.Update(0, service.SettingsUpdate{GlobalEnabled: &disabled}, 1); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+		check(http.StatusServiceUnavailable)
+	})
+
+	t.Run("重新开启后恢复", func(t *testing.T) {
+		if _, err := settings.Update(1, service.SettingsUpdate{GlobalEnabled: &enabled}, 1); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+		check(http.StatusOK)
+	})
+}
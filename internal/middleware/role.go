@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+	"github.com/zhongruan0522/DuiDuiMao/internal/util"
+)
+
+// RoleMiddleware 管理员角色中间件（需要先经过AuthMiddleware）：只允许allowed中的角色访问，超级管理员总是允许
+func RoleMiddleware(allowed ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !model.RoleAllows(c.GetString("role"), allowed...) {
+			util.ErrorResponse(c, 403, "无管理员权限")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
+	"github.com/zhongruan0522/DuiDuiMao/internal/service"
+	"github.com/zhongruan0522/DuiDuiMao/internal/util"
+)
+
+func TestRoleMiddlewareUsesCurrentRole(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	util.InitJWT("test-secret")
+	store, err := repository.NewCSVStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("创建存储失败: %v", err)
+	}
+	now := time.Now()
+	user := &model.User{LinuxDoID: 1001, Username: "alice", IsAdmin: true, Role: model.RoleAuditor, CreatedAt: now, UpdatedAt: now}
+	if err := store.Users().Upsert(user); err != nil {
+		t.Fatalf("创建用户失败: %v", err)
+	}
+	sessions := service.NewSessionService(store, 15*time.Minute, time.Hour, time.Now)
+	tokens, err := sessions.Login(user)
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+
+	r := gin.New()
+	r.GET("/api/admin/orders", AuthMiddleware(sessions), RoleMiddleware(model.RoleAuditor), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	get := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/orders", nil)
+		req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := get(); code != http.StatusOK {
+		t.Fatalf("审计员 状态码 = %d, want 200", code)
+	}
+	// 收回角色后，未过期的access token也立即失去管理权限
+	if err := store.Users().UpdateRole(user.ID, "", time.Now()); err != nil {
+		t.Fatalf("UpdateRole() error = %v", err)
+	}
+	if code := get(); code != http.StatusForbidden {
+		t.Errorf("收回角色后 状态码 = %d, want 403", code)
+	}
+}
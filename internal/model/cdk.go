@@ -2,15 +2,37 @@ package model
 
 import "time"
 
+// CDK状态
+const (
+	CDKStatusAvailable = 0 // 未兑换
+	CDKStatusLocked    = 1 // 已锁定
+	CDKStatusRedeemed  = 2 // 已兑换
+	CDKStatusRevoked   = 3 // 已作废
+)
+
 // CDK CDK表
 type CDK struct {
-	ID         int       `json:"id"`
-	TierID     int       `json:"tier_id"`     // 所属档位ID
-	Code       string    `json:"code"`        // CDK内容（加密存储）
-	Status     int       `json:"status"`      // 0:未兑换 1:已锁定 2:已兑换 3:已作废
-	OrderID    int       `json:"order_id"`    // 关联订单ID（0表示未关联）
-	RedeemedBy int       `json:"redeemed_by"` // 兑换用户ID（0表示未兑换）
-	RedeemedAt time.Time `json:"redeemed_at"` // 兑换时间
-	CreatedAt  time.Time `json:"created_at"`  // 创建时间
-	UpdatedAt  time.Time `json:"updated_at"`  // 更新时间
+	ID          int       `json:"id"`
+	TierID      int       `json:"tier_id"`     // 所属档位ID
+	Code        string    `json:"code"`        // CDK内容（加密存储）
+	Status      int       `json:"status"`      // 0:未兑换 1:已锁定 2:已兑换 3:已作废
+	OrderID     int       `json:"order_id"`    // 关联订单ID（0表示未关联）
+	RedeemedBy  int       `json:"redeemed_by"` // 兑换用户ID（0表示未兑换）
+	RedeemedAt  time.Time `json:"redeemed_at"` // 兑换时间
+	CreatedAt   time.Time `json:"created_at"`  // 创建时间
+	UpdatedAt   time.Time `json:"updated_at"`  // 更新时间
+	Fingerprint string    `json:"-"`           // CDK明文的HMAC指纹，用于检测重复（空表示尚未计算）
+	ReleaseAt   time.Time `json:"release_at"`  // 定时放出的时间，此前不可兑换也不计入库存（零值表示立即可兑换）
+}
+
+// Released CDK在now时刻是否已放出
+func (c *CDK) Released(now time.Time) bool {
+	return c.ReleaseAt.IsZero() || !now.Before(c.ReleaseAt)
+}
+
+// CDKRelease 一批定时放出的CDK
+type CDKRelease struct {
+	TierID    int       `json:"tier_id"`
+	ReleaseAt time.Time `json:"release_at"`
+	Count     int       `json:"count"`
 }
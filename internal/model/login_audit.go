@@ -0,0 +1,14 @@
+package model
+
+import "time"
+
+// LoginAudit 管理员账密登录审计记录：每次登录尝试（成功或失败）一条
+type LoginAudit struct {
+	ID        int       `json:"id"`
+	Username  string    `json:"username"` // 尝试登录的用户名（可能不存在）
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Success   bool      `json:"success"`
+	Reason    string    `json:"reason"` // 失败原因（密码错误、已锁定等）
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,11 @@
+package model
+
+import "time"
+
+// OAuthState 发起LinuxDo登录时生成的一次性state，回调时校验并删除，防止登录CSRF
+type OAuthState struct {
+	State        string    `json:"state"`
+	CodeVerifier string    `json:"-"` // PKCE code_verifier（未启用PKCE时为空）
+	CreatedAt    time.Time `json:"created_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
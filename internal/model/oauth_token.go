@@ -0,0 +1,12 @@
+package model
+
+import "time"
+
+// OAuthToken 用户的LinuxDo Connect令牌，用于定期同步信任等级和账号状态
+type OAuthToken struct {
+	UserID       int       `json:"user_id"`
+	AccessToken  string    `json:"-"`          // 加密存储
+	RefreshToken string    `json:"-"`          // 加密存储（可能为空）
+	ExpiresAt    time.Time `json:"expires_at"` // access token过期时间（零值表示未知）
+	UpdatedAt    time.Time `json:"updated_at"`
+}
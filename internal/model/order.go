@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+// 订单状态
+const (
+	OrderStatusPending   = 0 // 待支付
+	OrderStatusCompleted = 1 // 已完成
+	OrderStatusCancelled = 2 // 已取消
+	OrderStatusFailed    = 3 // 已失败
+)
+
+// Order 订单表
+type Order struct {
+	ID         int       `json:"id"`
+	OrderNo    string    `json:"order_no"`    // 订单号（唯一）
+	UserID     int       `json:"user_id"`     // 用户ID
+	TierID     int       `json:"tier_id"`     // 档位ID
+	Quantity   int       `json:"quantity"`    // 购买数量
+	TotalQuota int       `json:"total_quota"` // 总额度（档位额度 × 数量）
+	TotalPrice int       `json:"total_price"` // 应付积分（档位单价 × 数量，0表示免费）
+	Status     int       `json:"status"`      // 0:待支付 1:已完成 2:已取消 3:已失败
+	CreatedAt  time.Time `json:"created_at"`  // 创建时间
+	UpdatedAt  time.Time `json:"updated_at"`  // 更新时间
+	PaidAt     time.Time `json:"paid_at"`     // 支付时间
+	ExpiredAt  time.Time `json:"expired_at"`  // 过期时间
+}
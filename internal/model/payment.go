@@ -0,0 +1,30 @@
+package model
+
+import "time"
+
+// 支付方式
+const (
+	PaymentTypePoints = "points" // LinuxDo积分支付
+	PaymentTypeFree   = "free"   // 免费档位，无需支付
+)
+
+// 支付状态
+const (
+	PaymentStatusProcessing = 0 // 处理中
+	PaymentStatusSuccess    = 1 // 成功
+	PaymentStatusFailed     = 2 // 失败
+)
+
+// Payment 支付记录表（每次支付尝试一条记录）
+type Payment struct {
+	ID            int       `json:"id"`
+	OrderID       int       `json:"order_id"`       // 关联订单ID
+	UserID        int       `json:"user_id"`        // 用户ID
+	Amount        int       `json:"amount"`         // 支付积分
+	PaymentType   string    `json:"payment_type"`   // 支付方式（points/free）
+	Status        int       `json:"status"`         // 0:处理中 1:成功 2:失败
+	TransactionID string    `json:"transaction_id"` // 第三方交易号（如有）
+	FailReason    string    `json:"fail_reason"`    // 失败原因
+	CreatedAt     time.Time `json:"created_at"`     // 创建时间
+	FinishedAt    time.Time `json:"finished_at"`    // 完成时间
+}
@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+// Session 登录会话：每次登录创建一个会话，access token的jti即会话ID
+type Session struct {
+	ID          string    `json:"id"`           // 会话ID（access token的jti）
+	UserID      int       `json:"user_id"`      // 所属用户ID
+	RefreshHash string    `json:"-"`            // 当前refresh token的SHA-256哈希（每次刷新后轮换）
+	CreatedAt   time.Time `json:"created_at"`   // 登录时间
+	RefreshedAt time.Time `json:"refreshed_at"` // 最近一次刷新时间
+	ExpiresAt   time.Time `json:"expires_at"`   // 过期时间（refresh token有效期，刷新时顺延）
+	RevokedAt   time.Time `json:"revoked_at"`   // 撤销时间（零值表示未撤销）
+}
+
+// Active 会话在now时刻是否有效
+func (s *Session) Active(now time.Time) bool {
+	return s.RevokedAt.IsZero() && now.Before(s.ExpiresAt)
+}
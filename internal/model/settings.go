@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// Settings 系统设置（管理后台可修改），只有一条记录
+type Settings struct {
+	GlobalEnabled      bool      `json:"global_enabled"`       // 全局开关（是否开放购买）
+	Announcement       string    `json:"announcement"`         // 公告内容
+	OrderExpireMinutes int       `json:"order_expire_minutes"` // 订单超时时间（分钟）
+	Version            int       `json:"version"`              // 每次保存加1，用于检测并发修改（0表示尚未保存过）
+	UpdatedAt          time.Time `json:"updated_at"`
+	UpdatedBy          int       `json:"updated_by"` // 最后修改的管理员用户ID
+}
@@ -7,11 +7,29 @@ type Tier struct {
 	ID            int       `json:"id"`
 	Name          string    `json:"name"`           // 档位名称
 	Quota         int       `json:"quota"`          // 额度值
+	Price         int       `json:"price"`          // 单价（LinuxDo积分，0表示免费）
 	RequiredLevel int       `json:"required_level"` // 所需信任等级
 	DailyLimit    int       `json:"daily_limit"`    // 每人每日限购（0=不限）
 	Stock         int       `json:"stock"`          // 当前库存
 	IsActive      bool      `json:"is_active"`      // 是否启用
 	SortOrder     int       `json:"sort_order"`     // 排序权重
+	StartsAt      time.Time `json:"starts_at"`      // 开放时间（零值表示不限）
+	EndsAt        time.Time `json:"ends_at"`        // 结束时间（零值表示不限）
 	CreatedAt     time.Time `json:"created_at"`     // 创建时间
 	UpdatedAt     time.Time `json:"updated_at"`     // 更新时间
+
+	// 以下字段由定时放出的CDK计算得出，不保存
+	Scheduled        int       `json:"scheduled"`          // 尚未放出的CDK数量（不计入库存）
+	NextReleaseAt    time.Time `json:"next_release_at"`    // 下一批CDK的放出时间（零值表示没有）
+	NextReleaseCount int       `json:"next_release_count"` // 下一批放出的CDK数量
+}
+
+// Started 档位在now时刻是否已到开放时间
+func (t *Tier) Started(now time.Time) bool {
+	return t.StartsAt.IsZero() || !now.Before(t.StartsAt)
+}
+
+// Ended 档位在now时刻是否已过结束时间
+func (t *Tier) Ended(now time.Time) bool {
+	return !t.EndsAt.IsZero() && !now.Before(t.EndsAt)
 }
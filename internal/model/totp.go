@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+// TOTP 管理员的两步验证（RFC 6238）设置
+type TOTP struct {
+	UserID    int       `json:"user_id"`
+	Secret    string    `json:"-"`          // 加密存储的Base32密钥
+	LastStep  int64     `json:"-"`          // 最近一次使用的时间步，同一验证码不能重复使用
+	CreatedAt time.Time `json:"created_at"` // 生成密钥的时间
+	EnabledAt time.Time `json:"enabled_at"` // 验证通过并启用的时间（零值表示尚未启用）
+}
+
+// Enabled 是否已启用两步验证
+func (t *TOTP) Enabled() bool {
+	return !t.EnabledAt.IsZero()
+}
+
+// RecoveryCode 两步验证的一次性恢复码（只保存哈希）
+type RecoveryCode struct {
+	ID       int       `json:"id"`
+	UserID   int       `json:"user_id"`
+	CodeHash string    `json:"-"`
+	UsedAt   time.Time `json:"used_at"` // 使用时间（零值表示未使用）
+}
@@ -2,6 +2,13 @@ package model
 
 import "time"
 
+// 管理员角色
+const (
+	RoleSuperAdmin  = "super_admin"  // 超级管理员：拥有全部权限
+	RoleCDKOperator = "cdk_operator" // CDK运营：查看档位，导入、查看和作废CDK
+	RoleAuditor     = "auditor"      // 审计员：只读查看档位、CDK（打码）、订单、对账和设置
+)
+
 // User 用户表
 type User struct {
 	ID         int       `json:"id"`
@@ -9,7 +16,21 @@ type User struct {
 	Username   string    `json:"username"`
 	Name       string    `json:"name"`
 	TrustLevel int       `json:"trust_level"`
-	IsAdmin    bool      `json:"is_admin"`
+	IsAdmin    bool      `json:"is_admin"` // 是否拥有任一管理员角色
+	Role       string    `json:"role"`     // 管理员角色（普通用户为空）
 	CreatedAt  time.Time `json:"created_at"`
 	UpdatedAt  time.Time `json:"updated_at"`
 }
+
+// RoleAllows 角色是否属于allowed之一，超级管理员拥有全部权限
+func RoleAllows(role string, allowed ...string) bool {
+	if role == RoleSuperAdmin {
+		return true
+	}
+	for _, r := range allowed {
+		if role != "" && role == r {
+			return true
+		}
+	}
+	return false
+}
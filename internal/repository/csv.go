@@ -0,0 +1,266 @@
+package repository
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// csvDataDir dev模式CSV数据目录
+const csvDataDir = "Temp"
+
+// csvTable CSV表定义
+type csvTable struct {
+	file   string
+	header []string
+}
+
+// csvLockFile 跨进程文件锁，防止多个进程同时改写CSV
+const csvLockFile = ".lock"
+
+// csvStore CSV文件存储（dev模式使用）
+type csvStore struct {
+	dir string
+	mu  *sync.Mutex // 串行化本进程内所有读-改-写操作
+	tx  *csvTx      // 非nil表示处于事务中（已持有锁）
+}
+
+// csvTx CSV事务：读写都作用于内存中的表副本，提交时才写回文件
+type csvTx struct {
+	tables map[string][][]string
+	dirty  map[string]csvTable
+}
+
+// NewCSVStore 创建CSV存储，数据文件存放在dir目录下
+func NewCSVStore(dir string) (Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &csvStore{dir: dir, mu: &sync.Mutex{}}, nil
+}
+
+func (s *csvStore) Users() UserRepository             { return &csvUserRepo{s} }
+func (s *csvStore) Tiers() TierRepository             { return &csvTierRepo{s} }
+func (s *csvStore) CDKs() CDKRepository               { return &csvCDKRepo{s} }
+func (s *csvStore) RedeemLogs() RedeemLogRepository   { return &csvRedeemLogRepo{s} }
+func (s *csvStore) Orders() OrderRepository           { return &csvOrderRepo{s} }
+func (s *csvStore) Payments() PaymentRepository       { return &csvPaymentRepo{s} }
+func (s *csvStore) Sessions() SessionRepository       { return &csvSessionRepo{s} }
+func (s *csvStore) OAuthTokens() OAuthTokenRepository { return &csvOAuthTokenRepo{s} }
+func (s *csvStore) OAuthStates() OAuthStateRepository { return &csvOAuthStateRepo{s} }
+func (s *csvStore) LoginAudits() LoginAuditRepository { return &csvLoginAuditRepo{s} }
+func (s *csvStore) TOTPs() TOTPRepository             { return &csvTOTPRepo{s} }
+func (s *csvStore) Settings() SettingsRepository      { return &csvSettingsRepo{s} }
+
+// Close CSV存储无需释放资源
+func (s *csvStore) Close() error { return nil }
+
+// Tx 持有存储锁执行fn，fn成功后才把修改过的表写回文件，失败则丢弃全部修改
+func (s *csvStore) Tx(fn func(tx Store) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	txStore := &csvStore{
+		dir: s.dir,
+		mu:  s.mu,
+		tx:  &csvTx{tables: make(map[string][][]string), dirty: make(map[string]csvTable)},
+	}
+	if err := fn(txStore); err != nil {
+		return err
+	}
+
+	return s.commit(txStore.tx)
+}
+
+// commit 写回事务中修改过的表：先把每张表写入临时文件，全部写成功后再逐个重命名，
+// 写入失败（如磁盘已满）时不会只提交一部分表。重命名之间进程崩溃仍可能只替换部分文件，dev模式不保证这种情况下的原子性
+func (s *csvStore) commit(tx *csvTx) error {
+	temps := make(map[string]string, len(tx.dirty))
+	removeTemps := func() {
+		for _, tmp := range temps {
+			os.Remove(tmp)
+		}
+	}
+	for file, t := range tx.dirty {
+		tmp, err := s.writeTemp(t, tx.tables[file])
+		if err != nil {
+			removeTemps()
+			return err
+		}
+		temps[file] = tmp
+	}
+	for file, tmp := range temps {
+		if err := os.Rename(tmp, filepath.Join(s.dir, file)); err != nil {
+			removeTemps()
+			return err
+		}
+	}
+	return nil
+}
+
+// lock 先获取进程内互斥锁，再获取数据目录的文件锁，返回解锁函数
+// 事务内已持有锁，直接返回空操作
+func (s *csvStore) lock() (func(), error) {
+	if s.tx != nil {
+		return func() {}, nil
+	}
+
+	s.mu.Lock()
+	file, err := os.OpenFile(filepath.Join(s.dir, csvLockFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("打开锁文件失败: %v", err)
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		s.mu.Unlock()
+		return nil, fmt.Errorf("获取文件锁失败: %v", err)
+	}
+
+	return func() {
+		_ = unlockFile(file)
+		file.Close()
+		s.mu.Unlock()
+	}, nil
+}
+
+// readTable 读取表中所有数据行（不含头部），文件不存在时返回空
+func (s *csvStore) readTable(t csvTable) ([][]string, error) {
+	if s.tx == nil {
+		return s.readFile(t)
+	}
+
+	rows, ok := s.tx.tables[t.file]
+	if !ok {
+		var err error
+		if rows, err = s.readFile(t); err != nil {
+			return nil, err
+		}
+		s.tx.tables[t.file] = rows
+	}
+	// 返回副本，避免调用方修改行切片影响缓存
+	return append([][]string{}, rows...), nil
+}
+
+// writeTable 写入表的所有数据行（事务内只更新内存副本）
+func (s *csvStore) writeTable(t csvTable, rows [][]string) error {
+	if s.tx == nil {
+		return s.writeFile(t, rows)
+	}
+	s.tx.tables[t.file] = rows
+	s.tx.dirty[t.file] = t
+	return nil
+}
+
+// readFile 从文件读取表数据
+func (s *csvStore) readFile(t csvTable) ([][]string, error) {
+	file, err := os.Open(filepath.Join(s.dir, t.file))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	rows := [][]string{}
+	for i, record := range records {
+		if i == 0 {
+			continue // 跳过头部
+		}
+		// 列数不足的旧数据补空值，便于后续新增字段
+		for len(record) < len(t.header) {
+			record = append(record, "")
+		}
+		rows = append(rows, record)
+	}
+	return rows, nil
+}
+
+// writeFile 写入表数据到文件（先写临时文件再重命名，避免写一半的文件）
+func (s *csvStore) writeFile(t csvTable, rows [][]string) error {
+	tmp, err := s.writeTemp(t, rows)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, t.file)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// writeTemp 把表数据写入数据目录下的临时文件，返回临时文件路径，失败时不留下临时文件
+func (s *csvStore) writeTemp(t csvTable, rows [][]string) (string, error) {
+	tmp, err := os.CreateTemp(s.dir, t.file+".tmp*")
+	if err != nil {
+		return "", err
+	}
+
+	writer := csv.NewWriter(tmp)
+	err = writer.Write(t.header)
+	if err == nil {
+		err = writer.WriteAll(rows)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// ========== 字段转换 ==========
+
+func atoi(s string) int {
+	v, _ := strconv.Atoi(s)
+	return v
+}
+
+func itoa(v int) string {
+	return strconv.Itoa(v)
+}
+
+func parseTime(s string) time.Time {
+	t, _ := time.Parse(time.RFC3339, s)
+	return t
+}
+
+// formatTime 格式化时间，零值写为空字符串
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// nextID 计算下一个自增ID（取最大ID+1）
+func nextID(rows [][]string) int {
+	maxID := 0
+	for _, row := range rows {
+		if id := atoi(row[0]); id > maxID {
+			maxID = id
+		}
+	}
+	return maxID + 1
+}
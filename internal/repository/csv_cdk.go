@@ -0,0 +1,473 @@
+package repository
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+)
+
+var cdkTable = csvTable{
+	file:   "cdk.csv",
+	header: []string{"id", "tier_id", "code", "status", "order_id", "redeemed_by", "redeemed_at", "created_at", "updated_at", "fingerprint", "release_at"},
+}
+
+// csvCDKRepo CDK CSV存储
+type csvCDKRepo struct {
+	s *csvStore
+}
+
+func decodeCDK(row []string) model.CDK {
+	return model.CDK{
+		ID:          atoi(row[0]),
+		TierID:      atoi(row[1]),
+		Code:        row[2], // 已加密存储
+		Status:      atoi(row[3]),
+		OrderID:     atoi(row[4]),
+		RedeemedBy:  atoi(row[5]),
+		RedeemedAt:  parseTime(row[6]),
+		CreatedAt:   parseTime(row[7]),
+		UpdatedAt:   parseTime(row[8]),
+		Fingerprint: row[9],
+		ReleaseAt:   parseTime(row[10]),
+	}
+}
+
+func encodeCDK(c *model.CDK) []string {
+	return []string{
+		itoa(c.ID),
+		itoa(c.TierID),
+		c.Code,
+		itoa(c.Status),
+		itoa(c.OrderID),
+		itoa(c.RedeemedBy),
+		formatTime(c.RedeemedAt),
+		formatTime(c.CreatedAt),
+		formatTime(c.UpdatedAt),
+		c.Fingerprint,
+		formatTime(c.ReleaseAt),
+	}
+}
+
+// readCDKs 读取所有CDK（调用方需持有锁）
+func (r *csvCDKRepo) readCDKs() ([]model.CDK, error) {
+	rows, err := r.s.readTable(cdkTable)
+	if err != nil {
+		return nil, err
+	}
+
+	cdks := []model.CDK{}
+	for _, row := range rows {
+		cdks = append(cdks, decodeCDK(row))
+	}
+	return cdks, nil
+}
+
+// BatchCreate 批量创建CDK
+func (r *csvCDKRepo) BatchCreate(cdks []model.CDK) error {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(cdkTable)
+	if err != nil {
+		return err
+	}
+
+	// 与数据库的唯一索引一致：非空指纹不能与已有CDK或本批中的其他CDK重复
+	fingerprints := make(map[string]bool)
+	for _, row := range rows {
+		if fp := decodeCDK(row).Fingerprint; fp != "" {
+			fingerprints[fp] = true
+		}
+	}
+	for _, cdk := range cdks {
+		if cdk.Fingerprint == "" {
+			continue
+		}
+		if fingerprints[cdk.Fingerprint] {
+			return fmt.Errorf("CDK指纹已存在: %s", cdk.Fingerprint)
+		}
+		fingerprints[cdk.Fingerprint] = true
+	}
+
+	newID := nextID(rows)
+	for i := range cdks {
+		cdks[i].ID = newID
+		rows = append(rows, encodeCDK(&cdks[i]))
+		newID++
+	}
+	return r.s.writeTable(cdkTable, rows)
+}
+
+// List 获取CDK列表（支持按档位和状态筛选）
+func (r *csvCDKRepo) List(tierID, status *int) ([]model.CDK, error) {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	cdks, err := r.readCDKs()
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := []model.CDK{}
+	for _, cdk := range cdks {
+		if tierID != nil && cdk.TierID != *tierID {
+			continue
+		}
+		if status != nil && cdk.Status != *status {
+			continue
+		}
+		filtered = append(filtered, cdk)
+	}
+	return filtered, nil
+}
+
+// GetByID 根据ID获取CDK
+func (r *csvCDKRepo) GetByID(id int) (*model.CDK, error) {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	cdks, err := r.readCDKs()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range cdks {
+		if cdks[i].ID == id {
+			return &cdks[i], nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Update 更新CDK
+func (r *csvCDKRepo) Update(cdk *model.CDK) error {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(cdkTable)
+	if err != nil {
+		return err
+	}
+
+	for i, row := range rows {
+		if atoi(row[0]) == cdk.ID {
+			rows[i] = encodeCDK(cdk)
+			return r.s.writeTable(cdkTable, rows)
+		}
+	}
+	return ErrNotFound
+}
+
+// UpdateCode 仅当code仍为oldCode时替换为newCode
+func (r *csvCDKRepo) UpdateCode(id int, oldCode, newCode string) error {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(cdkTable)
+	if err != nil {
+		return err
+	}
+
+	for i, row := range rows {
+		if atoi(row[0]) == id && row[2] == oldCode {
+			cdk := decodeCDK(row)
+			cdk.Code = newCode
+			rows[i] = encodeCDK(&cdk)
+			return r.s.writeTable(cdkTable, rows)
+		}
+	}
+	return ErrNotFound
+}
+
+// SetFingerprint 设置CDK的指纹，指纹与其他CDK重复时返回错误
+func (r *csvCDKRepo) SetFingerprint(id int, fingerprint string) error {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(cdkTable)
+	if err != nil {
+		return err
+	}
+
+	// 与数据库的唯一索引一致：非空指纹不能与其他CDK重复
+	index := -1
+	for i, row := range rows {
+		cdk := decodeCDK(row)
+		if cdk.ID == id {
+			index = i
+		} else if fingerprint != "" && cdk.Fingerprint == fingerprint {
+			return fmt.Errorf("CDK指纹已存在: %d", cdk.ID)
+		}
+	}
+	if index < 0 {
+		return ErrNotFound
+	}
+
+	cdk := decodeCDK(rows[index])
+	cdk.Fingerprint = fingerprint
+	rows[index] = encodeCDK(&cdk)
+	return r.s.writeTable(cdkTable, rows)
+}
+
+// FindByFingerprints 按指纹查找CDK
+func (r *csvCDKRepo) FindByFingerprints(fingerprints []string) ([]model.CDK, error) {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	cdks, err := r.readCDKs()
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[string]bool, len(fingerprints))
+	for _, fp := range fingerprints {
+		wanted[fp] = true
+	}
+	found := []model.CDK{}
+	for _, cdk := range cdks {
+		if cdk.Fingerprint != "" && wanted[cdk.Fingerprint] {
+			found = append(found, cdk)
+		}
+	}
+	return found, nil
+}
+
+// ClaimNext 原子地取出指定档位的一个已放出的未兑换CDK并标记为已兑换（持有互斥锁和文件锁完成读-改-写）
+func (r *csvCDKRepo) ClaimNext(tierID, userID int, now time.Time) (*model.CDK, error) {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(cdkTable)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, row := range rows {
+		cdk := decodeCDK(row)
+		if cdk.TierID != tierID || cdk.Status != model.CDKStatusAvailable || !cdk.Released(now) {
+			continue
+		}
+
+		cdk.Status = model.CDKStatusRedeemed
+		cdk.RedeemedBy = userID
+		cdk.RedeemedAt = now
+		cdk.UpdatedAt = now
+		rows[i] = encodeCDK(&cdk)
+		if err := r.s.writeTable(cdkTable, rows); err != nil {
+			return nil, err
+		}
+		return &cdk, nil
+	}
+	return nil, ErrNotFound
+}
+
+// LockForOrder 原子地取出订单档位的Quantity个已放出的未兑换CDK，关联到订单并标记为已锁定，数量不足时不写入任何修改
+func (r *csvCDKRepo) LockForOrder(order *model.Order, now time.Time) ([]model.CDK, error) {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(cdkTable)
+	if err != nil {
+		return nil, err
+	}
+
+	locked := []model.CDK{}
+	for i, row := range rows {
+		if len(locked) == order.Quantity {
+			break
+		}
+		cdk := decodeCDK(row)
+		if cdk.TierID != order.TierID || cdk.Status != model.CDKStatusAvailable || !cdk.Released(now) {
+			continue
+		}
+
+		cdk.Status = model.CDKStatusLocked
+		cdk.OrderID = order.ID
+		cdk.UpdatedAt = now
+		rows[i] = encodeCDK(&cdk)
+		locked = append(locked, cdk)
+	}
+
+	if len(locked) < order.Quantity {
+		return nil, ErrNotFound
+	}
+	if err := r.s.writeTable(cdkTable, rows); err != nil {
+		return nil, err
+	}
+	return locked, nil
+}
+
+// ListByOrder 获取订单关联的CDK
+func (r *csvCDKRepo) ListByOrder(orderID int) ([]model.CDK, error) {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	cdks, err := r.readCDKs()
+	if err != nil {
+		return nil, err
+	}
+
+	orderCDKs := []model.CDK{}
+	for _, cdk := range cdks {
+		if cdk.OrderID == orderID {
+			orderCDKs = append(orderCDKs, cdk)
+		}
+	}
+	return orderCDKs, nil
+}
+
+// CountAvailableByTier 统计各档位已放出的未兑换CDK数量
+func (r *csvCDKRepo) CountAvailableByTier(now time.Time) (map[int]int, error) {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	cdks, err := r.readCDKs()
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[int]int)
+	for _, cdk := range cdks {
+		if cdk.Status == model.CDKStatusAvailable && cdk.Released(now) {
+			counts[cdk.TierID]++
+		}
+	}
+	return counts, nil
+}
+
+// ScheduleRelease 将档位中ID最大的quantity个已放出的未兑换CDK改为在releaseAt放出，数量不足时不写入任何修改
+func (r *csvCDKRepo) ScheduleRelease(tierID, quantity int, releaseAt, now time.Time) error {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(cdkTable)
+	if err != nil {
+		return err
+	}
+
+	held := 0
+	for i := len(rows) - 1; i >= 0 && held < quantity; i-- {
+		cdk := decodeCDK(rows[i])
+		if cdk.TierID != tierID || cdk.Status != model.CDKStatusAvailable || !cdk.Released(now) {
+			continue
+		}
+		cdk.ReleaseAt = releaseAt
+		cdk.UpdatedAt = now
+		rows[i] = encodeCDK(&cdk)
+		held++
+	}
+
+	if held < quantity {
+		return ErrNotFound
+	}
+	return r.s.writeTable(cdkTable, rows)
+}
+
+// ReleaseNow 立即放出档位中所有尚未放出的未兑换CDK，返回放出的数量
+func (r *csvCDKRepo) ReleaseNow(tierID int, now time.Time) (int, error) {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(cdkTable)
+	if err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for i, row := range rows {
+		cdk := decodeCDK(row)
+		if cdk.TierID != tierID || cdk.Status != model.CDKStatusAvailable || cdk.Released(now) {
+			continue
+		}
+		cdk.ReleaseAt = time.Time{}
+		cdk.UpdatedAt = now
+		rows[i] = encodeCDK(&cdk)
+		released++
+	}
+
+	if released == 0 {
+		return 0, nil
+	}
+	return released, r.s.writeTable(cdkTable, rows)
+}
+
+// PendingReleases 按档位和放出时间分组统计尚未放出的未兑换CDK
+func (r *csvCDKRepo) PendingReleases(now time.Time) ([]model.CDKRelease, error) {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	cdks, err := r.readCDKs()
+	if err != nil {
+		return nil, err
+	}
+
+	type key struct {
+		tierID    int
+		releaseAt int64
+	}
+	index := make(map[key]int)
+	releases := []model.CDKRelease{}
+	for _, cdk := range cdks {
+		if cdk.Status != model.CDKStatusAvailable || cdk.Released(now) {
+			continue
+		}
+		k := key{cdk.TierID, cdk.ReleaseAt.Unix()}
+		if i, ok := index[k]; ok {
+			releases[i].Count++
+			continue
+		}
+		index[k] = len(releases)
+		releases = append(releases, model.CDKRelease{TierID: cdk.TierID, ReleaseAt: cdk.ReleaseAt, Count: 1})
+	}
+	sort.Slice(releases, func(i, j int) bool {
+		if !releases[i].ReleaseAt.Equal(releases[j].ReleaseAt) {
+			return releases[i].ReleaseAt.Before(releases[j].ReleaseAt)
+		}
+		return releases[i].TierID < releases[j].TierID
+	})
+	return releases, nil
+}
@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"strconv"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+)
+
+var loginAuditTable = csvTable{
+	file:   "login_audit.csv",
+	header: []string{"id", "username", "ip", "user_agent", "success", "reason", "created_at"},
+}
+
+// csvLoginAuditRepo 管理员登录审计CSV存储
+type csvLoginAuditRepo struct {
+	s *csvStore
+}
+
+func decodeLoginAudit(row []string) model.LoginAudit {
+	return model.LoginAudit{
+		ID:        atoi(row[0]),
+		Username:  row[1],
+		IP:        row[2],
+		UserAgent: row[3],
+		Success:   row[4] == "true",
+		Reason:    row[5],
+		CreatedAt: parseTime(row[6]),
+	}
+}
+
+func encodeLoginAudit(a *model.LoginAudit) []string {
+	return []string{
+		itoa(a.ID),
+		a.Username,
+		a.IP,
+		a.UserAgent,
+		strconv.FormatBool(a.Success),
+		a.Reason,
+		formatTime(a.CreatedAt),
+	}
+}
+
+// Create 创建审计记录
+func (r *csvLoginAuditRepo) Create(audit *model.LoginAudit) error {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(loginAuditTable)
+	if err != nil {
+		return err
+	}
+
+	audit.ID = nextID(rows)
+	rows = append(rows, encodeLoginAudit(audit))
+	return r.s.writeTable(loginAuditTable, rows)
+}
+
+// List 获取最近的limit条审计记录（最新的在前）
+func (r *csvLoginAuditRepo) List(limit int) ([]model.LoginAudit, error) {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(loginAuditTable)
+	if err != nil {
+		return nil, err
+	}
+
+	audits := []model.LoginAudit{}
+	for i := len(rows) - 1; i >= 0 && len(audits) < limit; i-- {
+		audits = append(audits, decodeLoginAudit(rows[i]))
+	}
+	return audits, nil
+}
@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+)
+
+var oauthStateTable = csvTable{
+	file:   "oauth_state.csv",
+	header: []string{"state", "code_verifier", "created_at", "expires_at"},
+}
+
+// csvOAuthStateRepo OAuth state CSV存储
+type csvOAuthStateRepo struct {
+	s *csvStore
+}
+
+func decodeOAuthState(row []string) model.OAuthState {
+	return model.OAuthState{
+		State:        row[0],
+		CodeVerifier: row[1],
+		CreatedAt:    parseTime(row[2]),
+		ExpiresAt:    parseTime(row[3]),
+	}
+}
+
+func encodeOAuthState(s *model.OAuthState) []string {
+	return []string{
+		s.State,
+		s.CodeVerifier,
+		formatTime(s.CreatedAt),
+		formatTime(s.ExpiresAt),
+	}
+}
+
+// Create 保存state
+func (r *csvOAuthStateRepo) Create(state *model.OAuthState) error {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(oauthStateTable)
+	if err != nil {
+		return err
+	}
+
+	rows = append(rows, encodeOAuthState(state))
+	return r.s.writeTable(oauthStateTable, rows)
+}
+
+// Take 取出并删除state
+func (r *csvOAuthStateRepo) Take(state string, now time.Time) (*model.OAuthState, error) {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(oauthStateTable)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, row := range rows {
+		if row[0] != state {
+			continue
+		}
+		found := decodeOAuthState(row)
+		if err := r.s.writeTable(oauthStateTable, append(rows[:i:i], rows[i+1:]...)); err != nil {
+			return nil, err
+		}
+		if !now.Before(found.ExpiresAt) {
+			return nil, ErrNotFound
+		}
+		return &found, nil
+	}
+	return nil, ErrNotFound
+}
+
+// DeleteExpired 删除在before之前已过期的state
+func (r *csvOAuthStateRepo) DeleteExpired(before time.Time) (int, error) {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(oauthStateTable)
+	if err != nil {
+		return 0, err
+	}
+
+	kept := [][]string{}
+	for _, row := range rows {
+		if parseTime(row[3]).Before(before) {
+			continue
+		}
+		kept = append(kept, row)
+	}
+	deleted := len(rows) - len(kept)
+	if deleted == 0 {
+		return 0, nil
+	}
+	return deleted, r.s.writeTable(oauthStateTable, kept)
+}
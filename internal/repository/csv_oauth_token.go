@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+)
+
+var oauthTokenTable = csvTable{
+	file:   "oauth_token.csv",
+	header: []string{"user_id", "access_token", "refresh_token", "expires_at", "updated_at"},
+}
+
+// csvOAuthTokenRepo LinuxDo令牌CSV存储
+type csvOAuthTokenRepo struct {
+	s *csvStore
+}
+
+func decodeOAuthToken(row []string) model.OAuthToken {
+	return model.OAuthToken{
+		UserID:       atoi(row[0]),
+		AccessToken:  row[1],
+		RefreshToken: row[2],
+		ExpiresAt:    parseTime(row[3]),
+		UpdatedAt:    parseTime(row[4]),
+	}
+}
+
+func encodeOAuthToken(t *model.OAuthToken) []string {
+	return []string{
+		itoa(t.UserID),
+		t.AccessToken,
+		t.RefreshToken,
+		formatTime(t.ExpiresAt),
+		formatTime(t.UpdatedAt),
+	}
+}
+
+// Save 创建或替换用户的令牌
+func (r *csvOAuthTokenRepo) Save(token *model.OAuthToken) error {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(oauthTokenTable)
+	if err != nil {
+		return err
+	}
+
+	for i, row := range rows {
+		if atoi(row[0]) == token.UserID {
+			rows[i] = encodeOAuthToken(token)
+			return r.s.writeTable(oauthTokenTable, rows)
+		}
+	}
+	rows = append(rows, encodeOAuthToken(token))
+	return r.s.writeTable(oauthTokenTable, rows)
+}
+
+// GetByUserID 获取用户的令牌
+func (r *csvOAuthTokenRepo) GetByUserID(userID int) (*model.OAuthToken, error) {
+	tokens, err := r.List()
+	if err != nil {
+		return nil, err
+	}
+	for i := range tokens {
+		if tokens[i].UserID == userID {
+			return &tokens[i], nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// List 获取所有令牌
+func (r *csvOAuthTokenRepo) List() ([]model.OAuthToken, error) {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(oauthTokenTable)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := []model.OAuthToken{}
+	for _, row := range rows {
+		tokens = append(tokens, decodeOAuthToken(row))
+	}
+	return tokens, nil
+}
+
+// UpdateCiphertexts 仅当两个令牌的密文仍为旧值时替换为新密文
+func (r *csvOAuthTokenRepo) UpdateCiphertexts(userID int, oldAccess, oldRefresh, newAccess, newRefresh string) error {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(oauthTokenTable)
+	if err != nil {
+		return err
+	}
+
+	for i, row := range rows {
+		if atoi(row[0]) == userID && row[1] == oldAccess && row[2] == oldRefresh {
+			rows[i][1] = newAccess
+			rows[i][2] = newRefresh
+			return r.s.writeTable(oauthTokenTable, rows)
+		}
+	}
+	return ErrNotFound
+}
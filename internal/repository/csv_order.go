@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"fmt"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+)
+
+var orderTable = csvTable{
+	file:   "order.csv",
+	header: []string{"id", "order_no", "user_id", "tier_id", "quantity", "total_quota", "status", "created_at", "updated_at", "paid_at", "expired_at", "total_price"},
+}
+
+// csvOrderRepo 订单CSV存储
+type csvOrderRepo struct {
+	s *csvStore
+}
+
+func decodeOrder(row []string) model.Order {
+	return model.Order{
+		ID:         atoi(row[0]),
+		OrderNo:    row[1],
+		UserID:     atoi(row[2]),
+		TierID:     atoi(row[3]),
+		Quantity:   atoi(row[4]),
+		TotalQuota: atoi(row[5]),
+		Status:     atoi(row[6]),
+		CreatedAt:  parseTime(row[7]),
+		UpdatedAt:  parseTime(row[8]),
+		PaidAt:     parseTime(row[9]),
+		ExpiredAt:  parseTime(row[10]),
+		TotalPrice: atoi(row[11]),
+	}
+}
+
+func encodeOrder(o *model.Order) []string {
+	return []string{
+		itoa(o.ID),
+		o.OrderNo,
+		itoa(o.UserID),
+		itoa(o.TierID),
+		itoa(o.Quantity),
+		itoa(o.TotalQuota),
+		itoa(o.Status),
+		formatTime(o.CreatedAt),
+		formatTime(o.UpdatedAt),
+		formatTime(o.PaidAt),
+		formatTime(o.ExpiredAt),
+		itoa(o.TotalPrice),
+	}
+}
+
+// Create 创建订单（订单号重复时返回错误）
+func (r *csvOrderRepo) Create(order *model.Order) error {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(orderTable)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		if row[1] == order.OrderNo {
+			return fmt.Errorf("订单号已存在: %s", order.OrderNo)
+		}
+	}
+
+	order.ID = nextID(rows)
+	rows = append(rows, encodeOrder(order))
+	return r.s.writeTable(orderTable, rows)
+}
+
+// GetByID 根据ID获取订单
+func (r *csvOrderRepo) GetByID(id int) (*model.Order, error) {
+	return r.find(func(o *model.Order) bool { return o.ID == id })
+}
+
+// GetByOrderNo 根据订单号获取订单
+func (r *csvOrderRepo) GetByOrderNo(orderNo string) (*model.Order, error) {
+	return r.find(func(o *model.Order) bool { return o.OrderNo == orderNo })
+}
+
+// find 查找第一个满足条件的订单
+func (r *csvOrderRepo) find(match func(o *model.Order) bool) (*model.Order, error) {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(orderTable)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		order := decodeOrder(row)
+		if match(&order) {
+			return &order, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Update 更新订单
+func (r *csvOrderRepo) Update(order *model.Order) error {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(orderTable)
+	if err != nil {
+		return err
+	}
+
+	for i, row := range rows {
+		if atoi(row[0]) == order.ID {
+			rows[i] = encodeOrder(order)
+			return r.s.writeTable(orderTable, rows)
+		}
+	}
+	return ErrNotFound
+}
+
+// UpdateIfStatus 仅当订单当前状态为status时才更新
+func (r *csvOrderRepo) UpdateIfStatus(order *model.Order, status int) error {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(orderTable)
+	if err != nil {
+		return err
+	}
+
+	for i, row := range rows {
+		if atoi(row[0]) == order.ID && atoi(row[6]) == status {
+			rows[i] = encodeOrder(order)
+			return r.s.writeTable(orderTable, rows)
+		}
+	}
+	return ErrNotFound
+}
+
+// List 按条件获取订单列表（最新的在前）
+func (r *csvOrderRepo) List(filter OrderFilter) ([]model.Order, error) {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(orderTable)
+	if err != nil {
+		return nil, err
+	}
+
+	orders := []model.Order{}
+	for i := len(rows) - 1; i >= 0; i-- {
+		order := decodeOrder(rows[i])
+		if filter.UserID != nil && order.UserID != *filter.UserID {
+			continue
+		}
+		if filter.TierID != nil && order.TierID != *filter.TierID {
+			continue
+		}
+		if filter.Status != nil && order.Status != *filter.Status {
+			continue
+		}
+		if !filter.From.IsZero() && order.CreatedAt.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && !order.CreatedAt.Before(filter.To) {
+			continue
+		}
+		orders = append(orders, order)
+	}
+	return orders, nil
+}
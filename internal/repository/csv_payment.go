@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+)
+
+var paymentTable = csvTable{
+	file:   "payment.csv",
+	header: []string{"id", "order_id", "user_id", "amount", "payment_type", "status", "transaction_id", "fail_reason", "created_at", "finished_at"},
+}
+
+// csvPaymentRepo 支付记录CSV存储
+type csvPaymentRepo struct {
+	s *csvStore
+}
+
+func decodePayment(row []string) model.Payment {
+	return model.Payment{
+		ID:            atoi(row[0]),
+		OrderID:       atoi(row[1]),
+		UserID:        atoi(row[2]),
+		Amount:        atoi(row[3]),
+		PaymentType:   row[4],
+		Status:        atoi(row[5]),
+		TransactionID: row[6],
+		FailReason:    row[7],
+		CreatedAt:     parseTime(row[8]),
+		FinishedAt:    parseTime(row[9]),
+	}
+}
+
+func encodePayment(p *model.Payment) []string {
+	return []string{
+		itoa(p.ID),
+		itoa(p.OrderID),
+		itoa(p.UserID),
+		itoa(p.Amount),
+		p.PaymentType,
+		itoa(p.Status),
+		p.TransactionID,
+		p.FailReason,
+		formatTime(p.CreatedAt),
+		formatTime(p.FinishedAt),
+	}
+}
+
+// Create 创建支付记录
+func (r *csvPaymentRepo) Create(payment *model.Payment) error {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(paymentTable)
+	if err != nil {
+		return err
+	}
+
+	payment.ID = nextID(rows)
+	rows = append(rows, encodePayment(payment))
+	return r.s.writeTable(paymentTable, rows)
+}
+
+// Update 更新支付记录
+func (r *csvPaymentRepo) Update(payment *model.Payment) error {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(paymentTable)
+	if err != nil {
+		return err
+	}
+
+	for i, row := range rows {
+		if atoi(row[0]) == payment.ID {
+			rows[i] = encodePayment(payment)
+			return r.s.writeTable(paymentTable, rows)
+		}
+	}
+	return ErrNotFound
+}
+
+// ListByOrder 获取订单的支付记录（最早的在前）
+func (r *csvPaymentRepo) ListByOrder(orderID int) ([]model.Payment, error) {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(paymentTable)
+	if err != nil {
+		return nil, err
+	}
+
+	payments := []model.Payment{}
+	for _, row := range rows {
+		if payment := decodePayment(row); payment.OrderID == orderID {
+			payments = append(payments, payment)
+		}
+	}
+	return payments, nil
+}
+
+// List 获取支付记录（最新的在前）
+func (r *csvPaymentRepo) List(status *int) ([]model.Payment, error) {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(paymentTable)
+	if err != nil {
+		return nil, err
+	}
+
+	payments := []model.Payment{}
+	for i := len(rows) - 1; i >= 0; i-- {
+		payment := decodePayment(rows[i])
+		if status != nil && payment.Status != *status {
+			continue
+		}
+		payments = append(payments, payment)
+	}
+	return payments, nil
+}
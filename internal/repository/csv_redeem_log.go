@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+)
+
+var redeemLogTable = csvTable{
+	file:   "redeem_log.csv",
+	header: []string{"id", "user_id", "cdk_id", "tier_id", "created_at"},
+}
+
+// csvRedeemLogRepo 兑换记录CSV存储
+type csvRedeemLogRepo struct {
+	s *csvStore
+}
+
+func decodeRedeemLog(row []string) model.RedeemLog {
+	return model.RedeemLog{
+		ID:        atoi(row[0]),
+		UserID:    atoi(row[1]),
+		CDKID:     atoi(row[2]),
+		TierID:    atoi(row[3]),
+		CreatedAt: parseTime(row[4]),
+	}
+}
+
+func encodeRedeemLog(l *model.RedeemLog) []string {
+	return []string{
+		itoa(l.ID),
+		itoa(l.UserID),
+		itoa(l.CDKID),
+		itoa(l.TierID),
+		formatTime(l.CreatedAt),
+	}
+}
+
+// Create 创建兑换记录
+func (r *csvRedeemLogRepo) Create(log *model.RedeemLog) error {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(redeemLogTable)
+	if err != nil {
+		return err
+	}
+
+	log.ID = nextID(rows)
+	rows = append(rows, encodeRedeemLog(log))
+	return r.s.writeTable(redeemLogTable, rows)
+}
+
+// ListByUser 获取用户的兑换记录
+func (r *csvRedeemLogRepo) ListByUser(userID int) ([]model.RedeemLog, error) {
+	logs, err := r.List()
+	if err != nil {
+		return nil, err
+	}
+
+	userLogs := []model.RedeemLog{}
+	for _, log := range logs {
+		if log.UserID == userID {
+			userLogs = append(userLogs, log)
+		}
+	}
+	return userLogs, nil
+}
+
+// CountByUserTier 统计用户在[from, to)时间内兑换该档位的数量
+func (r *csvRedeemLogRepo) CountByUserTier(userID, tierID int, from, to time.Time) (int, error) {
+	logs, err := r.List()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, log := range logs {
+		if log.UserID == userID && log.TierID == tierID && !log.CreatedAt.Before(from) && log.CreatedAt.Before(to) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// List 获取所有兑换记录
+func (r *csvRedeemLogRepo) List() ([]model.RedeemLog, error) {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(redeemLogTable)
+	if err != nil {
+		return nil, err
+	}
+
+	logs := []model.RedeemLog{}
+	for _, row := range rows {
+		logs = append(logs, decodeRedeemLog(row))
+	}
+	return logs, nil
+}
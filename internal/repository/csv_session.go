@@ -0,0 +1,191 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+)
+
+var sessionTable = csvTable{
+	file:   "session.csv",
+	header: []string{"id", "user_id", "refresh_hash", "created_at", "refreshed_at", "expires_at", "revoked_at"},
+}
+
+// csvSessionRepo 登录会话CSV存储
+type csvSessionRepo struct {
+	s *csvStore
+}
+
+func decodeSession(row []string) model.Session {
+	return model.Session{
+		ID:          row[0],
+		UserID:      atoi(row[1]),
+		RefreshHash: row[2],
+		CreatedAt:   parseTime(row[3]),
+		RefreshedAt: parseTime(row[4]),
+		ExpiresAt:   parseTime(row[5]),
+		RevokedAt:   parseTime(row[6]),
+	}
+}
+
+func encodeSession(s *model.Session) []string {
+	return []string{
+		s.ID,
+		itoa(s.UserID),
+		s.RefreshHash,
+		formatTime(s.CreatedAt),
+		formatTime(s.RefreshedAt),
+		formatTime(s.ExpiresAt),
+		formatTime(s.RevokedAt),
+	}
+}
+
+// Create 创建会话
+func (r *csvSessionRepo) Create(session *model.Session) error {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(sessionTable)
+	if err != nil {
+		return err
+	}
+
+	rows = append(rows, encodeSession(session))
+	return r.s.writeTable(sessionTable, rows)
+}
+
+// GetByID 根据ID获取会话
+func (r *csvSessionRepo) GetByID(id string) (*model.Session, error) {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(sessionTable)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		if row[0] == id {
+			session := decodeSession(row)
+			return &session, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Rotate 仅当会话未撤销且refresh token哈希仍为oldHash时更新
+func (r *csvSessionRepo) Rotate(session *model.Session, oldHash string) error {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(sessionTable)
+	if err != nil {
+		return err
+	}
+
+	for i, row := range rows {
+		current := decodeSession(row)
+		if current.ID != session.ID || current.RefreshHash != oldHash || !current.RevokedAt.IsZero() {
+			continue
+		}
+		current.RefreshHash = session.RefreshHash
+		current.RefreshedAt = session.RefreshedAt
+		current.ExpiresAt = session.ExpiresAt
+		rows[i] = encodeSession(&current)
+		return r.s.writeTable(sessionTable, rows)
+	}
+	return ErrNotFound
+}
+
+// Revoke 撤销会话
+func (r *csvSessionRepo) Revoke(id string, now time.Time) error {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(sessionTable)
+	if err != nil {
+		return err
+	}
+
+	for i, row := range rows {
+		if row[0] != id {
+			continue
+		}
+		session := decodeSession(row)
+		if !session.RevokedAt.IsZero() {
+			return nil
+		}
+		session.RevokedAt = now
+		rows[i] = encodeSession(&session)
+		return r.s.writeTable(sessionTable, rows)
+	}
+	return ErrNotFound
+}
+
+// RevokeByUser 撤销用户所有未撤销的会话
+func (r *csvSessionRepo) RevokeByUser(userID int, now time.Time) (int, error) {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(sessionTable)
+	if err != nil {
+		return 0, err
+	}
+
+	revoked := 0
+	for i, row := range rows {
+		session := decodeSession(row)
+		if session.UserID != userID || !session.RevokedAt.IsZero() {
+			continue
+		}
+		session.RevokedAt = now
+		rows[i] = encodeSession(&session)
+		revoked++
+	}
+	if revoked == 0 {
+		return 0, nil
+	}
+	return revoked, r.s.writeTable(sessionTable, rows)
+}
+
+// DeleteExpired 删除在before之前已过期的会话
+func (r *csvSessionRepo) DeleteExpired(before time.Time) (int, error) {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(sessionTable)
+	if err != nil {
+		return 0, err
+	}
+
+	kept := [][]string{}
+	for _, row := range rows {
+		if parseTime(row[5]).Before(before) {
+			continue
+		}
+		kept = append(kept, row)
+	}
+	deleted := len(rows) - len(kept)
+	if deleted == 0 {
+		return 0, nil
+	}
+	return deleted, r.s.writeTable(sessionTable, kept)
+}
@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"strconv"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+)
+
+var settingsTable = csvTable{
+	file:   "settings.csv",
+	header: []string{"global_enabled", "announcement", "order_expire_minutes", "version", "updated_at", "updated_by"},
+}
+
+// csvSettingsRepo 系统设置CSV存储（只有一行）
+type csvSettingsRepo struct {
+	s *csvStore
+}
+
+func decodeSettings(row []string) model.Settings {
+	return model.Settings{
+		GlobalEnabled:      row[0] == "true",
+		Announcement:       row[1],
+		OrderExpireMinutes: atoi(row[2]),
+		Version:            atoi(row[3]),
+		UpdatedAt:          parseTime(row[4]),
+		UpdatedBy:          atoi(row[5]),
+	}
+}
+
+func encodeSettings(s *model.Settings) []string {
+	return []string{
+		strconv.FormatBool(s.GlobalEnabled),
+		s.Announcement,
+		itoa(s.OrderExpireMinutes),
+		itoa(s.Version),
+		formatTime(s.UpdatedAt),
+		itoa(s.UpdatedBy),
+	}
+}
+
+// Get 获取系统设置
+func (r *csvSettingsRepo) Get() (*model.Settings, error) {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(settingsTable)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, ErrNotFound
+	}
+	settings := decodeSettings(rows[0])
+	return &settings, nil
+}
+
+// Save 仅当版本号未变化时保存，成功后版本号加1
+func (r *csvSettingsRepo) Save(settings *model.Settings) error {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(settingsTable)
+	if err != nil {
+		return err
+	}
+	current := 0
+	if len(rows) > 0 {
+		current = decodeSettings(rows[0]).Version
+	}
+	if current != settings.Version {
+		return ErrNotFound
+	}
+
+	saved := *settings
+	saved.Version++
+	if err := r.s.writeTable(settingsTable, [][]string{encodeSettings(&saved)}); err != nil {
+		return err
+	}
+	settings.Version = saved.Version
+	return nil
+}
@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"strconv"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+)
+
+var tierTable = csvTable{
+	file:   "tier.csv",
+	header: []string{"id", "name", "quota", "required_level", "daily_limit", "stock", "is_active", "sort_order", "created_at", "updated_at", "price", "starts_at", "ends_at"},
+}
+
+// csvTierRepo 档位CSV存储
+type csvTierRepo struct {
+	s *csvStore
+}
+
+func decodeTier(row []string) model.Tier {
+	return model.Tier{
+		ID:            atoi(row[0]),
+		Name:          row[1],
+		Quota:         atoi(row[2]),
+		RequiredLevel: atoi(row[3]),
+		DailyLimit:    atoi(row[4]),
+		Stock:         atoi(row[5]),
+		IsActive:      row[6] == "true",
+		SortOrder:     atoi(row[7]),
+		CreatedAt:     parseTime(row[8]),
+		UpdatedAt:     parseTime(row[9]),
+		Price:         atoi(row[10]),
+		StartsAt:      parseTime(row[11]),
+		EndsAt:        parseTime(row[12]),
+	}
+}
+
+func encodeTier(t *model.Tier) []string {
+	return []string{
+		itoa(t.ID),
+		t.Name,
+		itoa(t.Quota),
+		itoa(t.RequiredLevel),
+		itoa(t.DailyLimit),
+		itoa(t.Stock),
+		strconv.FormatBool(t.IsActive),
+		itoa(t.SortOrder),
+		formatTime(t.CreatedAt),
+		formatTime(t.UpdatedAt),
+		itoa(t.Price),
+		formatTime(t.StartsAt),
+		formatTime(t.EndsAt),
+	}
+}
+
+// List 获取所有档位
+func (r *csvTierRepo) List() ([]model.Tier, error) {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(tierTable)
+	if err != nil {
+		return nil, err
+	}
+
+	tiers := []model.Tier{}
+	for _, row := range rows {
+		tiers = append(tiers, decodeTier(row))
+	}
+	return tiers, nil
+}
+
+// GetByID 根据ID获取档位
+func (r *csvTierRepo) GetByID(id int) (*model.Tier, error) {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(tierTable)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		if atoi(row[0]) == id {
+			tier := decodeTier(row)
+			return &tier, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Create 创建档位
+func (r *csvTierRepo) Create(tier *model.Tier) error {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(tierTable)
+	if err != nil {
+		return err
+	}
+
+	tier.ID = nextID(rows)
+	rows = append(rows, encodeTier(tier))
+	return r.s.writeTable(tierTable, rows)
+}
+
+// Update 更新档位
+func (r *csvTierRepo) Update(tier *model.Tier) error {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(tierTable)
+	if err != nil {
+		return err
+	}
+
+	for i, row := range rows {
+		if atoi(row[0]) == tier.ID {
+			rows[i] = encodeTier(tier)
+			return r.s.writeTable(tierTable, rows)
+		}
+	}
+	return ErrNotFound
+}
+
+// Delete 删除档位
+func (r *csvTierRepo) Delete(id int) error {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(tierTable)
+	if err != nil {
+		return err
+	}
+
+	newRows := [][]string{}
+	found := false
+	for _, row := range rows {
+		if atoi(row[0]) == id {
+			found = true
+			continue
+		}
+		newRows = append(newRows, row)
+	}
+
+	if !found {
+		return ErrNotFound
+	}
+	return r.s.writeTable(tierTable, newRows)
+}
@@ -0,0 +1,275 @@
+package repository
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+)
+
+var totpTable = csvTable{
+	file:   "totp.csv",
+	header: []string{"user_id", "secret", "last_step", "created_at", "enabled_at"},
+}
+
+var recoveryCodeTable = csvTable{
+	file:   "recovery_code.csv",
+	header: []string{"id", "user_id", "code_hash", "used_at"},
+}
+
+// csvTOTPRepo 两步验证CSV存储
+type csvTOTPRepo struct {
+	s *csvStore
+}
+
+func decodeTOTP(row []string) model.TOTP {
+	step, _ := strconv.ParseInt(row[2], 10, 64)
+	return model.TOTP{
+		UserID:    atoi(row[0]),
+		Secret:    row[1],
+		LastStep:  step,
+		CreatedAt: parseTime(row[3]),
+		EnabledAt: parseTime(row[4]),
+	}
+}
+
+func encodeTOTP(t *model.TOTP) []string {
+	return []string{
+		itoa(t.UserID),
+		t.Secret,
+		strconv.FormatInt(t.LastStep, 10),
+		formatTime(t.CreatedAt),
+		formatTime(t.EnabledAt),
+	}
+}
+
+// findTOTP 在totp表中查找用户的行号，不存在时返回-1
+func findTOTP(rows [][]string, userID int) int {
+	for i, row := range rows {
+		if atoi(row[0]) == userID {
+			return i
+		}
+	}
+	return -1
+}
+
+// withoutRecoveryCodes 返回删除了用户所有恢复码的行
+func withoutRecoveryCodes(rows [][]string, userID int) [][]string {
+	kept := [][]string{}
+	for _, row := range rows {
+		if atoi(row[1]) != userID {
+			kept = append(kept, row)
+		}
+	}
+	return kept
+}
+
+// Get 获取用户的两步验证设置
+func (r *csvTOTPRepo) Get(userID int) (*model.TOTP, error) {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(totpTable)
+	if err != nil {
+		return nil, err
+	}
+	i := findTOTP(rows, userID)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	totp := decodeTOTP(rows[i])
+	return &totp, nil
+}
+
+// List 获取所有两步验证设置
+func (r *csvTOTPRepo) List() ([]model.TOTP, error) {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(totpTable)
+	if err != nil {
+		return nil, err
+	}
+	totps := []model.TOTP{}
+	for _, row := range rows {
+		totps = append(totps, decodeTOTP(row))
+	}
+	return totps, nil
+}
+
+// UpdateSecret 仅当密钥密文仍为oldSecret时替换为newSecret
+func (r *csvTOTPRepo) UpdateSecret(userID int, oldSecret, newSecret string) error {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(totpTable)
+	if err != nil {
+		return err
+	}
+	i := findTOTP(rows, userID)
+	if i < 0 || rows[i][1] != oldSecret {
+		return ErrNotFound
+	}
+	rows[i][1] = newSecret
+	return r.s.writeTable(totpTable, rows)
+}
+
+// Save 创建或替换用户的两步验证设置，同时删除旧的恢复码
+func (r *csvTOTPRepo) Save(totp *model.TOTP) error {
+	return r.s.Tx(func(tx Store) error {
+		s := tx.(*csvStore)
+		codes, err := s.readTable(recoveryCodeTable)
+		if err != nil {
+			return err
+		}
+		if err := s.writeTable(recoveryCodeTable, withoutRecoveryCodes(codes, totp.UserID)); err != nil {
+			return err
+		}
+
+		rows, err := s.readTable(totpTable)
+		if err != nil {
+			return err
+		}
+		if i := findTOTP(rows, totp.UserID); i >= 0 {
+			rows[i] = encodeTOTP(totp)
+		} else {
+			rows = append(rows, encodeTOTP(totp))
+		}
+		return s.writeTable(totpTable, rows)
+	})
+}
+
+// Enable 启用尚未启用的两步验证并替换恢复码
+func (r *csvTOTPRepo) Enable(userID int, enabledAt time.Time, codeHashes []string) error {
+	return r.s.Tx(func(tx Store) error {
+		s := tx.(*csvStore)
+		rows, err := s.readTable(totpTable)
+		if err != nil {
+			return err
+		}
+		i := findTOTP(rows, userID)
+		if i < 0 {
+			return ErrNotFound
+		}
+		totp := decodeTOTP(rows[i])
+		if totp.Enabled() {
+			return ErrNotFound
+		}
+		totp.EnabledAt = enabledAt
+		rows[i] = encodeTOTP(&totp)
+		if err := s.writeTable(totpTable, rows); err != nil {
+			return err
+		}
+
+		codes, err := s.readTable(recoveryCodeTable)
+		if err != nil {
+			return err
+		}
+		id := nextID(codes)
+		codes = withoutRecoveryCodes(codes, userID)
+		for _, hash := range codeHashes {
+			codes = append(codes, []string{itoa(id), itoa(userID), hash, ""})
+			id++
+		}
+		return s.writeTable(recoveryCodeTable, codes)
+	})
+}
+
+// UseStep 仅当step大于上次使用的时间步时更新
+func (r *csvTOTPRepo) UseStep(userID int, step int64) error {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(totpTable)
+	if err != nil {
+		return err
+	}
+	i := findTOTP(rows, userID)
+	if i < 0 {
+		return ErrNotFound
+	}
+	totp := decodeTOTP(rows[i])
+	if step <= totp.LastStep {
+		return ErrNotFound
+	}
+	totp.LastStep = step
+	rows[i] = encodeTOTP(&totp)
+	return r.s.writeTable(totpTable, rows)
+}
+
+// UseRecoveryCode 使用一条未使用的恢复码
+func (r *csvTOTPRepo) UseRecoveryCode(userID int, codeHash string, usedAt time.Time) error {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(recoveryCodeTable)
+	if err != nil {
+		return err
+	}
+	for i, row := range rows {
+		if atoi(row[1]) == userID && row[2] == codeHash && row[3] == "" {
+			rows[i][3] = formatTime(usedAt)
+			return r.s.writeTable(recoveryCodeTable, rows)
+		}
+	}
+	return ErrNotFound
+}
+
+// CountRecoveryCodes 统计未使用的恢复码数量
+func (r *csvTOTPRepo) CountRecoveryCodes(userID int) (int, error) {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(recoveryCodeTable)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, row := range rows {
+		if atoi(row[1]) == userID && row[3] == "" {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Delete 删除两步验证设置和恢复码
+func (r *csvTOTPRepo) Delete(userID int) error {
+	return r.s.Tx(func(tx Store) error {
+		s := tx.(*csvStore)
+		codes, err := s.readTable(recoveryCodeTable)
+		if err != nil {
+			return err
+		}
+		if err := s.writeTable(recoveryCodeTable, withoutRecoveryCodes(codes, userID)); err != nil {
+			return err
+		}
+
+		rows, err := s.readTable(totpTable)
+		if err != nil {
+			return err
+		}
+		if i := findTOTP(rows, userID); i >= 0 {
+			rows = append(rows[:i], rows[i+1:]...)
+		}
+		return s.writeTable(totpTable, rows)
+	})
+}
@@ -0,0 +1,193 @@
+package repository
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+)
+
+var userTable = csvTable{
+	file:   "user.csv",
+	header: []string{"id", "linux_do_id", "username", "name", "trust_level", "is_admin", "created_at", "updated_at", "role"},
+}
+
+// csvUserRepo 用户CSV存储
+type csvUserRepo struct {
+	s *csvStore
+}
+
+func decodeUser(row []string) model.User {
+	user := model.User{
+		ID:         atoi(row[0]),
+		LinuxDoID:  atoi(row[1]),
+		Username:   row[2],
+		Name:       row[3],
+		TrustLevel: atoi(row[4]),
+		IsAdmin:    row[5] == "true",
+		CreatedAt:  parseTime(row[6]),
+		UpdatedAt:  parseTime(row[7]),
+		Role:       row[8],
+	}
+	if user.IsAdmin && user.Role == "" {
+		user.Role = model.RoleSuperAdmin // 增加角色之前的管理员
+	}
+	return user
+}
+
+func encodeUser(u *model.User) []string {
+	return []string{
+		itoa(u.ID),
+		itoa(u.LinuxDoID),
+		u.Username,
+		u.Name,
+		itoa(u.TrustLevel),
+		strconv.FormatBool(u.IsAdmin),
+		formatTime(u.CreatedAt),
+		formatTime(u.UpdatedAt),
+		u.Role,
+	}
+}
+
+// Upsert 按LinuxDoID创建或更新用户
+func (r *csvUserRepo) Upsert(user *model.User) error {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(userTable)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for i, row := range rows {
+		existing := decodeUser(row)
+		if existing.LinuxDoID == user.LinuxDoID {
+			user.ID = existing.ID
+			user.CreatedAt = existing.CreatedAt
+			rows[i] = encodeUser(user)
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		user.ID = nextID(rows)
+		rows = append(rows, encodeUser(user))
+	}
+
+	return r.s.writeTable(userTable, rows)
+}
+
+// GetByID 根据ID获取用户
+func (r *csvUserRepo) GetByID(id int) (*model.User, error) {
+	return r.find(func(u *model.User) bool { return u.ID == id })
+}
+
+// LockByID 获取用户（CSV事务本身持有全局锁，无需行锁）
+func (r *csvUserRepo) LockByID(id int) (*model.User, error) {
+	return r.GetByID(id)
+}
+
+// GetByLinuxDoID 根据LinuxDo ID获取用户
+func (r *csvUserRepo) GetByLinuxDoID(linuxDoID int) (*model.User, error) {
+	return r.find(func(u *model.User) bool { return u.LinuxDoID == linuxDoID })
+}
+
+// UpdateTrustLevel 只更新用户的信任等级
+func (r *csvUserRepo) UpdateTrustLevel(id, trustLevel int, updatedAt time.Time) error {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(userTable)
+	if err != nil {
+		return err
+	}
+
+	for i, row := range rows {
+		if atoi(row[0]) == id {
+			user := decodeUser(row)
+			user.TrustLevel = trustLevel
+			user.UpdatedAt = updatedAt
+			rows[i] = encodeUser(&user)
+			return r.s.writeTable(userTable, rows)
+		}
+	}
+	return ErrNotFound
+}
+
+// ListAdmins 列出拥有管理员角色的用户
+func (r *csvUserRepo) ListAdmins() ([]model.User, error) {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(userTable)
+	if err != nil {
+		return nil, err
+	}
+
+	users := []model.User{}
+	for _, row := range rows {
+		if user := decodeUser(row); user.Role != "" {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+// UpdateRole 只更新用户的管理员角色
+func (r *csvUserRepo) UpdateRole(id int, role string, updatedAt time.Time) error {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(userTable)
+	if err != nil {
+		return err
+	}
+
+	for i, row := range rows {
+		if atoi(row[0]) == id {
+			user := decodeUser(row)
+			user.Role = role
+			user.IsAdmin = role != ""
+			user.UpdatedAt = updatedAt
+			rows[i] = encodeUser(&user)
+			return r.s.writeTable(userTable, rows)
+		}
+	}
+	return ErrNotFound
+}
+
+// find 查找第一个满足条件的用户
+func (r *csvUserRepo) find(match func(u *model.User) bool) (*model.User, error) {
+	unlock, err := r.s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	rows, err := r.s.readTable(userTable)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		user := decodeUser(row)
+		if match(&user) {
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
@@ -0,0 +1,15 @@
+//go:build !unix && !windows
+
+package repository
+
+import "os"
+
+// lockFile 当前平台不支持文件锁，仅依赖进程内互斥锁
+func lockFile(f *os.File) error {
+	return nil
+}
+
+// unlockFile 当前平台不支持文件锁
+func unlockFile(f *os.File) error {
+	return nil
+}
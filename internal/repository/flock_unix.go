@@ -0,0 +1,18 @@
+//go:build unix
+
+package repository
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile 获取文件排他锁（阻塞直到成功）
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+}
+
+// unlockFile 释放文件锁
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
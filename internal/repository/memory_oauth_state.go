@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"sync"
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+)
+
+// memoryOAuthStateRepo OAuth state内存存储（单实例部署可用，重启后未完成的登录需要重新发起）
+type memoryOAuthStateRepo struct {
+	mu     sync.Mutex
+	states map[string]model.OAuthState
+}
+
+// NewMemoryOAuthStateRepository 创建内存中的OAuth state存储，可安全地并发使用
+func NewMemoryOAuthStateRepository() OAuthStateRepository {
+	return &memoryOAuthStateRepo{states: make(map[string]model.OAuthState)}
+}
+
+// Create 保存state
+func (r *memoryOAuthStateRepo) Create(state *model.OAuthState) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.states[state.State] = *state
+	return nil
+}
+
+// Take 取出并删除state
+func (r *memoryOAuthStateRepo) Take(state string, now time.Time) (*model.OAuthState, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	found, ok := r.states[state]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	delete(r.states, state)
+	if !now.Before(found.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	return &found, nil
+}
+
+// DeleteExpired 删除在before之前已过期的state
+func (r *memoryOAuthStateRepo) DeleteExpired(before time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deleted := 0
+	for key, state := range r.states {
+		if state.ExpiresAt.Before(before) {
+			delete(r.states, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
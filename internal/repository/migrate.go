@@ -0,0 +1,320 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/postgres/*.sql migrations/sqlite/*.sql
+var migrationFS embed.FS
+
+// migrationLockID PostgreSQL advisory lock ID，防止多个实例同时执行迁移
+const migrationLockID = 20240601
+
+// Migration 单个版本的迁移
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus 迁移状态
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// ErrSchemaTooNew 数据库结构版本高于程序内置的最新迁移
+var ErrSchemaTooNew = errors.New("数据库结构版本高于当前程序，请升级程序后再启动")
+
+// loadMigrations 从目录中加载迁移文件（格式：0001_name.up.sql / 0001_name.down.sql），按版本升序返回
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		fileName := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(fileName, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(fileName, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		base := strings.TrimSuffix(fileName, "."+direction+".sql")
+		versionStr, name, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("迁移文件名格式错误: %s", fileName)
+		}
+		version, err := strconv.Atoi(versionStr)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("迁移文件版本号错误: %s", fileName)
+		}
+
+		content, err := fs.ReadFile(fsys, path.Join(dir, fileName))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("迁移版本 %d 存在多个名称: %s, %s", version, m.Name, name)
+		}
+
+		if direction == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := []Migration{}
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("迁移版本 %d 缺少 up 文件", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Migrator 数据库迁移执行器
+type Migrator struct {
+	db         *sql.DB
+	dialect    dialect
+	migrations []Migration
+}
+
+// NewPostgresMigrator 创建PostgreSQL迁移执行器（使用内置迁移文件）
+func NewPostgresMigrator(db *sql.DB) (*Migrator, error) {
+	return newMigrator(db, postgresDialect)
+}
+
+// NewSQLiteMigrator 创建SQLite迁移执行器（使用内置迁移文件）
+func NewSQLiteMigrator(db *sql.DB) (*Migrator, error) {
+	return newMigrator(db, sqliteDialect)
+}
+
+func newMigrator(db *sql.DB, d dialect) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationFS, d.migrationDir)
+	if err != nil {
+		return nil, fmt.Errorf("加载迁移文件失败: %v", err)
+	}
+	return &Migrator{db: db, dialect: d, migrations: migrations}, nil
+}
+
+// LatestVersion 程序内置的最新迁移版本
+func (m *Migrator) LatestVersion() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Up 执行所有未应用的迁移，返回本次应用的版本列表
+func (m *Migrator) Up() ([]int, error) {
+	applied := []int{}
+	err := m.withLock(func(conn *sql.Conn) error {
+		current, err := m.appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+		if err := m.checkVersion(current); err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := current[migration.Version]; ok {
+				continue
+			}
+			if err := m.apply(conn, migration, true); err != nil {
+				return err
+			}
+			applied = append(applied, migration.Version)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down 回滚最近一次应用的迁移，返回回滚的版本（无可回滚时返回0）
+func (m *Migrator) Down() (int, error) {
+	rolledBack := 0
+	err := m.withLock(func(conn *sql.Conn) error {
+		current, err := m.appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+		if err := m.checkVersion(current); err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := current[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("迁移版本 %d 不支持回滚", migration.Version)
+			}
+			if err := m.apply(conn, migration, false); err != nil {
+				return err
+			}
+			rolledBack = migration.Version
+			return nil
+		}
+		return nil
+	})
+	return rolledBack, err
+}
+
+// Status 获取所有内置迁移的应用状态
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	ctx := context.Background()
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := m.ensureTable(conn); err != nil {
+		return nil, err
+	}
+	current, err := m.appliedVersions(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []MigrationStatus{}
+	for _, migration := range m.migrations {
+		appliedAt, ok := current[migration.Version]
+		statuses = append(statuses, MigrationStatus{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			Applied:   ok,
+			AppliedAt: appliedAt,
+		})
+	}
+	return statuses, m.checkVersion(current)
+}
+
+// checkVersion 已应用的版本高于程序内置最新版本时拒绝继续
+func (m *Migrator) checkVersion(current map[int]time.Time) error {
+	latest := m.LatestVersion()
+	for version := range current {
+		if version > latest {
+			return fmt.Errorf("%w（数据库版本 %d，程序支持 %d）", ErrSchemaTooNew, version, latest)
+		}
+	}
+	return nil
+}
+
+// withLock 在独占连接上执行fn（PostgreSQL持有advisory lock；SQLite写事务本身互斥）
+func (m *Migrator) withLock(fn func(conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.dialect.name == postgresDialect.name {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+			return fmt.Errorf("获取迁移锁失败: %v", err)
+		}
+		defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockID)
+	}
+
+	if err := m.ensureTable(conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// ensureTable 确保迁移记录表存在
+func (m *Migrator) ensureTable(conn *sql.Conn) error {
+	_, err := conn.ExecContext(context.Background(), `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    INTEGER PRIMARY KEY,
+			name       TEXT NOT NULL,
+			applied_at `+m.dialect.timestampType+` NOT NULL
+		)`)
+	if err != nil {
+		return fmt.Errorf("创建迁移记录表失败: %v", err)
+	}
+	return nil
+}
+
+// appliedVersions 读取已应用的迁移（version -> applied_at）
+func (m *Migrator) appliedVersions(conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(context.Background(), "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		versions[version] = appliedAt
+	}
+	return versions, rows.Err()
+}
+
+// apply 在事务中执行单个迁移并更新迁移记录
+func (m *Migrator) apply(conn *sql.Conn, migration Migration, up bool) error {
+	ctx := context.Background()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	script := migration.Up
+	if !up {
+		script = migration.Down
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("执行迁移 %04d_%s 失败: %v", migration.Version, migration.Name, err)
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)",
+			migration.Version, migration.Name, time.Now())
+	} else {
+		_, err = tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1", migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,186 @@
+package repository
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    fstest.MapFS
+		versions []int
+		wantErr  bool
+	}{
+		{
+			name: "按版本号排序",
+			files: fstest.MapFS{
+				"m/0002_orders.up.sql":   {Data: []byte("CREATE TABLE orders ();")},
+				"m/0001_init.up.sql":     {Data: []byte("CREATE TABLE users ();")},
+				"m/0001_init.down.sql":   {Data: []byte("DROP TABLE users;")},
+				"m/0010_payments.up.sql": {Data: []byte("CREATE TABLE payments ();")},
+				"m/README.md":            {Data: []byte("忽略非SQL文件")},
+			},
+			versions: []int{1, 2, 10},
+		},
+		{
+			name: "缺少up文件",
+			files: fstest.MapFS{
+				"m/0001_init.down.sql": {Data: []byte("DROP TABLE users;")},
+			},
+			wantErr: true,
+		},
+		{
+			name: "版本号非法",
+			files: fstest.MapFS{
+				"m/abc_init.up.sql": {Data: []byte("SELECT 1;")},
+			},
+			wantErr: true,
+		},
+		{
+			name: "同一版本名称不一致",
+			files: fstest.MapFS{
+				"m/0001_init.up.sql":  {Data: []byte("SELECT 1;")},
+				"m/0001_other.up.sql": {Data: []byte("SELECT 1;")},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := loadMigrations(tt.files, "m")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadMigrations() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(migrations) != len(tt.versions) {
+				t.Fatalf("loadMigrations() 数量 = %d, want %d", len(migrations), len(tt.versions))
+			}
+			for i, m := range migrations {
+				if m.Version != tt.versions[i] {
+					t.Errorf("migrations[%d].Version = %d, want %d", i, m.Version, tt.versions[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	postgres, err := NewPostgresMigrator(nil)
+	if err != nil {
+		t.Fatalf("NewPostgresMigrator() error = %v", err)
+	}
+	sqlite, err := NewSQLiteMigrator(nil)
+	if err != nil {
+		t.Fatalf("NewSQLiteMigrator() error = %v", err)
+	}
+
+	// 两种数据库的迁移版本必须一一对应
+	if len(postgres.migrations) != len(sqlite.migrations) {
+		t.Fatalf("迁移数量不一致: postgres=%d sqlite=%d", len(postgres.migrations), len(sqlite.migrations))
+	}
+	for i, m := range postgres.migrations {
+		if m.Version != i+1 {
+			t.Errorf("迁移版本不连续: 第%d个为 %d", i+1, m.Version)
+		}
+		if m.Down == "" || sqlite.migrations[i].Down == "" {
+			t.Errorf("迁移 %04d_%s 缺少 down 文件", m.Version, m.Name)
+		}
+		if sqlite.migrations[i].Version != m.Version || sqlite.migrations[i].Name != m.Name {
+			t.Errorf("迁移不一致: postgres=%04d_%s sqlite=%04d_%s", m.Version, m.Name, sqlite.migrations[i].Version, sqlite.migrations[i].Name)
+		}
+	}
+}
+
+func TestCheckVersion(t *testing.T) {
+	m := &Migrator{migrations: []Migration{{Version: 1}, {Version: 2}}}
+
+	if err := m.checkVersion(map[int]time.Time{1: {}, 2: {}}); err != nil {
+		t.Errorf("checkVersion() error = %v", err)
+	}
+	if err := m.checkVersion(map[int]time.Time{1: {}, 3: {}}); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("checkVersion() error = %v, want ErrSchemaTooNew", err)
+	}
+}
+
+func TestMigrateUpDown(t *testing.T) {
+	t.Run("sqlite", func(t *testing.T) {
+		db, err := OpenSQLiteDB(filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatalf("打开SQLite失败: %v", err)
+		}
+		defer db.Close()
+
+		migrator, err := NewSQLiteMigrator(db)
+		if err != nil {
+			t.Fatalf("NewSQLiteMigrator() error = %v", err)
+		}
+		testMigrateUpDown(t, migrator)
+
+		// 数据库版本高于程序时拒绝启动
+		if _, err := db.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (9999, 'future', $1)", time.Now()); err != nil {
+			t.Fatalf("写入迁移记录失败: %v", err)
+		}
+		if _, err := migrator.Up(); !errors.Is(err, ErrSchemaTooNew) {
+			t.Errorf("Up() error = %v, want ErrSchemaTooNew", err)
+		}
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		url := os.Getenv(testPostgresURLEnv)
+		if url == "" {
+			t.Skipf("未设置 %s，跳过PostgreSQL测试", testPostgresURLEnv)
+		}
+		db, err := OpenPostgresDB(url)
+		if err != nil {
+			t.Fatalf("连接PostgreSQL失败: %v", err)
+		}
+		defer db.Close()
+
+		migrator, err := NewPostgresMigrator(db)
+		if err != nil {
+			t.Fatalf("NewPostgresMigrator() error = %v", err)
+		}
+		testMigrateUpDown(t, migrator)
+	})
+}
+
+// testMigrateUpDown 应用全部迁移后回滚最新一个再重新应用
+func testMigrateUpDown(t *testing.T, migrator *Migrator) {
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("Up() error = %v", err)
+	}
+
+	version, err := migrator.Down()
+	if err != nil {
+		t.Fatalf("Down() error = %v", err)
+	}
+	if version != migrator.LatestVersion() {
+		t.Errorf("Down() = %d, want %d", version, migrator.LatestVersion())
+	}
+
+	applied, err := migrator.Up()
+	if err != nil {
+		t.Fatalf("Up() error = %v", err)
+	}
+	if len(applied) != 1 || applied[0] != version {
+		t.Errorf("Up() = %v, want [%d]", applied, version)
+	}
+
+	statuses, err := migrator.Status()
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	for _, s := range statuses {
+		if !s.Applied {
+			t.Errorf("迁移 %04d 未应用", s.Version)
+		}
+	}
+}
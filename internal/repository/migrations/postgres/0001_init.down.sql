@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS redeem_logs;
+DROP TABLE IF EXISTS cdks;
+DROP TABLE IF EXISTS tiers;
+DROP TABLE IF EXISTS users;
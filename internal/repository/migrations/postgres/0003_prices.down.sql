@@ -0,0 +1,2 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS total_price;
+ALTER TABLE tiers DROP COLUMN IF EXISTS price;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_cdks_fingerprint;
+ALTER TABLE cdks DROP COLUMN IF EXISTS fingerprint;
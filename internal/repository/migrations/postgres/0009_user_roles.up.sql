@@ -0,0 +1,3 @@
+-- 管理员角色：super_admin、cdk_operator、auditor，普通用户为空；已有的管理员升级为超级管理员
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT '';
+UPDATE users SET role = 'super_admin' WHERE is_admin;
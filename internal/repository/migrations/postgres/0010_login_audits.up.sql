@@ -0,0 +1,11 @@
+-- 管理员账密登录审计：记录每次登录尝试，用于排查暴力破解
+CREATE TABLE IF NOT EXISTS login_audits (
+    id         SERIAL PRIMARY KEY,
+    username   TEXT NOT NULL,
+    ip         TEXT NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    success    BOOLEAN NOT NULL DEFAULT FALSE,
+    reason     TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_login_audits_created ON login_audits (created_at);
@@ -0,0 +1,3 @@
+ALTER TABLE cdks DROP COLUMN IF EXISTS release_at;
+ALTER TABLE tiers DROP COLUMN IF EXISTS ends_at;
+ALTER TABLE tiers DROP COLUMN IF EXISTS starts_at;
@@ -0,0 +1,4 @@
+-- 档位的开放/结束时间（NULL表示不限），CDK的定时放出时间（NULL表示立即可兑换）
+ALTER TABLE tiers ADD COLUMN IF NOT EXISTS starts_at TIMESTAMPTZ;
+ALTER TABLE tiers ADD COLUMN IF NOT EXISTS ends_at TIMESTAMPTZ;
+ALTER TABLE cdks ADD COLUMN IF NOT EXISTS release_at TIMESTAMPTZ;
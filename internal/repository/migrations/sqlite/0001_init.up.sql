@@ -0,0 +1,46 @@
+-- 初始表结构：用户、档位、CDK、兑换记录
+CREATE TABLE IF NOT EXISTS users (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    linux_do_id INTEGER NOT NULL UNIQUE,
+    username    TEXT NOT NULL DEFAULT '',
+    name        TEXT NOT NULL DEFAULT '',
+    trust_level INTEGER NOT NULL DEFAULT 0,
+    is_admin    BOOLEAN NOT NULL DEFAULT 0,
+    created_at  DATETIME NOT NULL,
+    updated_at  DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS tiers (
+    id             INTEGER PRIMARY KEY AUTOINCREMENT,
+    name           TEXT NOT NULL,
+    quota          INTEGER NOT NULL,
+    required_level INTEGER NOT NULL DEFAULT 0,
+    daily_limit    INTEGER NOT NULL DEFAULT 0,
+    stock          INTEGER NOT NULL DEFAULT 0,
+    is_active      BOOLEAN NOT NULL DEFAULT 0,
+    sort_order     INTEGER NOT NULL DEFAULT 0,
+    created_at     DATETIME NOT NULL,
+    updated_at     DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS cdks (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    tier_id     INTEGER NOT NULL,
+    code        TEXT NOT NULL,
+    status      INTEGER NOT NULL DEFAULT 0,
+    order_id    INTEGER NOT NULL DEFAULT 0,
+    redeemed_by INTEGER NOT NULL DEFAULT 0,
+    redeemed_at DATETIME,
+    created_at  DATETIME NOT NULL,
+    updated_at  DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_cdks_tier_status ON cdks (tier_id, status);
+
+CREATE TABLE IF NOT EXISTS redeem_logs (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id    INTEGER NOT NULL,
+    cdk_id     INTEGER NOT NULL,
+    tier_id    INTEGER NOT NULL,
+    created_at DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_redeem_logs_user ON redeem_logs (user_id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_cdks_order;
+DROP TABLE IF EXISTS orders;
@@ -0,0 +1,17 @@
+-- 订单表：一个订单包含同一档位的多个CDK（cdks.order_id 关联）
+CREATE TABLE IF NOT EXISTS orders (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    order_no    TEXT NOT NULL UNIQUE,
+    user_id     INTEGER NOT NULL,
+    tier_id     INTEGER NOT NULL,
+    quantity    INTEGER NOT NULL,
+    total_quota INTEGER NOT NULL,
+    status      INTEGER NOT NULL DEFAULT 0,
+    created_at  DATETIME NOT NULL,
+    updated_at  DATETIME NOT NULL,
+    paid_at     DATETIME,
+    expired_at  DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_orders_user ON orders (user_id);
+CREATE INDEX IF NOT EXISTS idx_orders_status ON orders (status);
+CREATE INDEX IF NOT EXISTS idx_cdks_order ON cdks (order_id);
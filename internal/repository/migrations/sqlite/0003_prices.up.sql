@@ -0,0 +1,3 @@
+-- 档位单价与订单应付积分（0表示免费，无需支付）
+ALTER TABLE tiers ADD COLUMN price INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN total_price INTEGER NOT NULL DEFAULT 0;
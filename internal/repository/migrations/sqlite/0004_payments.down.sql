@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_payments_status;
+DROP INDEX IF EXISTS idx_payments_order;
+DROP TABLE IF EXISTS payments;
@@ -0,0 +1,19 @@
+-- 支付记录表：每次支付尝试一条记录，用于对账
+CREATE TABLE IF NOT EXISTS payments (
+    id             INTEGER PRIMARY KEY AUTOINCREMENT,
+    order_id       INTEGER NOT NULL,
+    user_id        INTEGER NOT NULL,
+    amount         INTEGER NOT NULL DEFAULT 0,
+    payment_type   TEXT NOT NULL,
+    status         INTEGER NOT NULL DEFAULT 0,
+    transaction_id TEXT NOT NULL DEFAULT '',
+    fail_reason    TEXT NOT NULL DEFAULT '',
+    created_at     DATETIME NOT NULL,
+    finished_at    DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_payments_order ON payments (order_id);
+CREATE INDEX IF NOT EXISTS idx_payments_status ON payments (status);
+
+-- 已完成的免费订单补录支付记录，避免对账时被误报
+INSERT INTO payments (order_id, user_id, amount, payment_type, status, created_at, finished_at)
+SELECT id, user_id, 0, 'free', 1, created_at, paid_at FROM orders WHERE status = 1 AND total_price = 0;
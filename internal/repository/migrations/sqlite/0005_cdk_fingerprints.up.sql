@@ -0,0 +1,4 @@
+-- CDK明文的HMAC指纹：导入时按索引检测重复，无需解密全部CDK
+-- 已有数据的指纹由服务启动时回填（需要指纹密钥，无法在SQL中计算）
+ALTER TABLE cdks ADD COLUMN fingerprint TEXT NOT NULL DEFAULT '';
+CREATE UNIQUE INDEX IF NOT EXISTS idx_cdks_fingerprint ON cdks (fingerprint) WHERE fingerprint <> '';
@@ -0,0 +1,12 @@
+-- 登录会话：access token的jti即会话ID，认证时校验会话未撤销；refresh token只保存哈希，每次刷新后轮换
+CREATE TABLE IF NOT EXISTS sessions (
+    id           TEXT PRIMARY KEY,
+    user_id      INTEGER NOT NULL,
+    refresh_hash TEXT NOT NULL,
+    created_at   DATETIME NOT NULL,
+    refreshed_at DATETIME NOT NULL,
+    expires_at   DATETIME NOT NULL,
+    revoked_at   DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions (user_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_expires ON sessions (expires_at);
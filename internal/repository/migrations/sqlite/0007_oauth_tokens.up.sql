@@ -0,0 +1,8 @@
+-- 用户的LinuxDo Connect令牌（加密存储），用于定期同步信任等级和账号状态
+CREATE TABLE IF NOT EXISTS oauth_tokens (
+    user_id       INTEGER PRIMARY KEY,
+    access_token  TEXT NOT NULL,
+    refresh_token TEXT NOT NULL DEFAULT '',
+    expires_at    DATETIME,
+    updated_at    DATETIME NOT NULL
+);
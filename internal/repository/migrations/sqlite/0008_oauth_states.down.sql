@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_oauth_states_expires;
+DROP TABLE IF EXISTS oauth_states;
@@ -0,0 +1,8 @@
+-- 发起LinuxDo登录时生成的一次性state（含PKCE code_verifier），回调时取出并删除
+CREATE TABLE IF NOT EXISTS oauth_states (
+    state         TEXT PRIMARY KEY,
+    code_verifier TEXT NOT NULL DEFAULT '',
+    created_at    DATETIME NOT NULL,
+    expires_at    DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_oauth_states_expires ON oauth_states (expires_at);
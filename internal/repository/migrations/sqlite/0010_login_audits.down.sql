@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_login_audits_created;
+DROP TABLE IF EXISTS login_audits;
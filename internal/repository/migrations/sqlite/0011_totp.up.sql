@@ -0,0 +1,17 @@
+-- 管理员两步验证（TOTP）：密钥加密存储，last_step防止同一验证码被重复使用
+CREATE TABLE IF NOT EXISTS totps (
+    user_id    INTEGER PRIMARY KEY,
+    secret     TEXT NOT NULL,
+    last_step  INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL,
+    enabled_at DATETIME
+);
+
+-- 两步验证的一次性恢复码，只保存哈希
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id        INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id   INTEGER NOT NULL,
+    code_hash TEXT NOT NULL,
+    used_at   DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user ON recovery_codes (user_id);
@@ -0,0 +1,10 @@
+-- 系统设置：只有一条记录（id固定为1），version用于乐观并发控制，防止多个管理员互相覆盖
+CREATE TABLE IF NOT EXISTS settings (
+    id                   INTEGER PRIMARY KEY,
+    global_enabled       BOOLEAN NOT NULL,
+    announcement         TEXT NOT NULL DEFAULT '',
+    order_expire_minutes INTEGER NOT NULL,
+    version              INTEGER NOT NULL,
+    updated_at           DATETIME NOT NULL,
+    updated_by           INTEGER NOT NULL DEFAULT 0
+);
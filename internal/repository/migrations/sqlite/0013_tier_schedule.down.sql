@@ -0,0 +1,3 @@
+ALTER TABLE cdks DROP COLUMN release_at;
+ALTER TABLE tiers DROP COLUMN ends_at;
+ALTER TABLE tiers DROP COLUMN starts_at;
@@ -0,0 +1,4 @@
+-- 档位的开放/结束时间（NULL表示不限），CDK的定时放出时间（NULL表示立即可兑换）
+ALTER TABLE tiers ADD COLUMN starts_at DATETIME;
+ALTER TABLE tiers ADD COLUMN ends_at DATETIME;
+ALTER TABLE cdks ADD COLUMN release_at DATETIME;
@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	_ "github.com/lib/pq" // PostgreSQL驱动
+)
+
+// postgresDialect PostgreSQL方言（server模式）
+var postgresDialect = dialect{
+	name:          "postgres",
+	migrationDir:  "migrations/postgres",
+	timestampType: "TIMESTAMPTZ",
+	skipLocked:    " FOR UPDATE SKIP LOCKED",
+	forUpdate:     " FOR UPDATE",
+}
+
+// OpenPostgresDB 连接PostgreSQL（不执行迁移）
+func OpenPostgresDB(url string) (*sql.DB, error) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, fmt.Errorf("打开数据库失败: %v", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("连接数据库失败: %v", err)
+	}
+	return db, nil
+}
+
+// OpenPostgres 连接PostgreSQL并执行未应用的迁移
+func OpenPostgres(url string) (Store, error) {
+	db, err := OpenPostgresDB(url)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrateOnOpen(db, postgresDialect); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return newSQLStore(db, postgresDialect), nil
+}
+
+// migrateOnOpen 启动时执行未应用的迁移
+func migrateOnOpen(db *sql.DB, d dialect) error {
+	migrator, err := newMigrator(db, d)
+	if err != nil {
+		return err
+	}
+	applied, err := migrator.Up()
+	if err != nil {
+		return fmt.Errorf("数据库迁移失败: %w", err)
+	}
+	if len(applied) > 0 {
+		log.Printf("已应用数据库迁移: %v", applied)
+	}
+	return nil
+}
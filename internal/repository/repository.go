@@ -0,0 +1,223 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/config"
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+)
+
+// ErrNotFound 记录不存在
+var ErrNotFound = errors.New("记录不存在")
+
+// UserRepository 用户存储接口
+type UserRepository interface {
+	// Upsert 按LinuxDoID创建或更新用户，回填ID和创建时间
+	Upsert(user *model.User) error
+	GetByID(id int) (*model.User, error)
+	// LockByID 在事务中获取用户并锁定到事务结束，用于串行化同一用户的并发操作（如每日限购校验）
+	LockByID(id int) (*model.User, error)
+	GetByLinuxDoID(linuxDoID int) (*model.User, error)
+	// UpdateTrustLevel 只更新用户的信任等级
+	UpdateTrustLevel(id, trustLevel int, updatedAt time.Time) error
+	// ListAdmins 列出拥有管理员角色的用户
+	ListAdmins() ([]model.User, error)
+	// UpdateRole 只更新用户的管理员角色（同时更新is_admin）
+	UpdateRole(id int, role string, updatedAt time.Time) error
+}
+
+// TierRepository 档位存储接口
+type TierRepository interface {
+	List() ([]model.Tier, error)
+	GetByID(id int) (*model.Tier, error)
+	// Create 创建档位，回填ID
+	Create(tier *model.Tier) error
+	Update(tier *model.Tier) error
+	Delete(id int) error
+}
+
+// CDKRepository CDK存储接口
+type CDKRepository interface {
+	// BatchCreate 批量创建CDK，回填ID
+	BatchCreate(cdks []model.CDK) error
+	// List 获取CDK列表，tierID/status为nil表示不筛选
+	List(tierID, status *int) ([]model.CDK, error)
+	GetByID(id int) (*model.CDK, error)
+	Update(cdk *model.CDK) error
+	// UpdateCode 仅当CDK当前的code仍为oldCode时替换为newCode（只修改code列，不影响并发的状态变更），已变化时返回ErrNotFound
+	UpdateCode(id int, oldCode, newCode string) error
+	// SetFingerprint 设置CDK的指纹（只修改fingerprint列）
+	SetFingerprint(id int, fingerprint string) error
+	// FindByFingerprints 按指纹查找CDK（走索引，不需要解密）
+	FindByFingerprints(fingerprints []string) ([]model.CDK, error)
+	// ClaimNext 原子地取出指定档位的一个已放出（ReleaseAt不晚于now）的未兑换CDK并标记为该用户已兑换，无可用CDK时返回ErrNotFound
+	ClaimNext(tierID, userID int, now time.Time) (*model.CDK, error)
+	// LockForOrder 原子地取出订单档位的Quantity个已放出的未兑换CDK，关联到订单并标记为已锁定
+	// 可用数量不足时不做任何修改并返回ErrNotFound
+	LockForOrder(order *model.Order, now time.Time) ([]model.CDK, error)
+	// ListByOrder 获取订单关联的CDK
+	ListByOrder(orderID int) ([]model.CDK, error)
+	// CountAvailableByTier 统计各档位在now时刻已放出的未兑换CDK数量（tier_id -> 数量）
+	CountAvailableByTier(now time.Time) (map[int]int, error)
+	// ScheduleRelease 将档位中quantity个已放出的未兑换CDK改为在releaseAt放出（放出前不可兑换、不计入库存）
+	// 可用数量不足时不做任何修改并返回ErrNotFound
+	ScheduleRelease(tierID, quantity int, releaseAt, now time.Time) error
+	// ReleaseNow 立即放出档位中所有尚未放出的未兑换CDK，返回放出的数量
+	ReleaseNow(tierID int, now time.Time) (int, error)
+	// PendingReleases 按档位和放出时间分组统计在now时刻尚未放出的未兑换CDK，按放出时间排序
+	PendingReleases(now time.Time) ([]model.CDKRelease, error)
+}
+
+// RedeemLogRepository 兑换记录存储接口
+type RedeemLogRepository interface {
+	// Create 创建兑换记录，回填ID
+	Create(log *model.RedeemLog) error
+	ListByUser(userID int) ([]model.RedeemLog, error)
+	// CountByUserTier 统计用户在[from, to)时间内兑换该档位的数量
+	CountByUserTier(userID, tierID int, from, to time.Time) (int, error)
+	List() ([]model.RedeemLog, error)
+}
+
+// OrderFilter 订单筛选条件，nil或零值表示不筛选该项
+type OrderFilter struct {
+	UserID *int
+	TierID *int
+	Status *int
+	From   time.Time // 创建时间下限（含）
+	To     time.Time // 创建时间上限（不含）
+}
+
+// OrderRepository 订单存储接口
+type OrderRepository interface {
+	// Create 创建订单，回填ID
+	Create(order *model.Order) error
+	GetByID(id int) (*model.Order, error)
+	GetByOrderNo(orderNo string) (*model.Order, error)
+	Update(order *model.Order) error
+	// UpdateIfStatus 仅当订单当前状态为status时才更新（用于状态流转），状态已被改变时返回ErrNotFound
+	UpdateIfStatus(order *model.Order, status int) error
+	// List 按条件获取订单列表（按ID倒序，最新的在前）
+	List(filter OrderFilter) ([]model.Order, error)
+}
+
+// PaymentRepository 支付记录存储接口
+type PaymentRepository interface {
+	// Create 创建支付记录，回填ID
+	Create(payment *model.Payment) error
+	Update(payment *model.Payment) error
+	// ListByOrder 获取订单的支付记录（按ID升序，最早的在前）
+	ListByOrder(orderID int) ([]model.Payment, error)
+	// List 获取支付记录（按ID倒序），status为nil表示不筛选
+	List(status *int) ([]model.Payment, error)
+}
+
+// Store 存储层聚合
+type Store interface {
+	Users() UserRepository
+	Tiers() TierRepository
+	CDKs() CDKRepository
+	RedeemLogs() RedeemLogRepository
+	Orders() OrderRepository
+	Payments() PaymentRepository
+	Sessions() SessionRepository
+	OAuthTokens() OAuthTokenRepository
+	OAuthStates() OAuthStateRepository
+	LoginAudits() LoginAuditRepository
+	TOTPs() TOTPRepository
+	Settings() SettingsRepository
+	// Tx 在事务中执行fn，fn内须通过tx访问数据；fn返回错误时回滚所有修改
+	Tx(fn func(tx Store) error) error
+	Close() error
+}
+
+// Open 根据运行模式打开存储（dev使用CSV，sqlite使用SQLite文件，server使用PostgreSQL）
+func Open(cfg *config.Config) (Store, error) {
+	switch cfg.Server.Mode {
+	case config.ModeDev:
+		return NewCSVStore(csvDataDir)
+	case config.ModeSQLite:
+		return OpenSQLite(cfg.Database.SQLitePath)
+	case config.ModeServer:
+		if cfg.Database.URL == "" {
+			return nil, fmt.Errorf("server模式必须配置dburl")
+		}
+		return OpenPostgres(cfg.Database.URL)
+	default:
+		return nil, fmt.Errorf("未知的运行模式: %s", cfg.Server.Mode)
+	}
+}
+
+// SessionRepository 登录会话存储接口
+type SessionRepository interface {
+	Create(session *model.Session) error
+	GetByID(id string) (*model.Session, error)
+	// Rotate 仅当会话未撤销且refresh token哈希仍为oldHash时，更新为session中的新哈希、刷新时间与过期时间
+	// 已被并发刷新或撤销时返回ErrNotFound
+	Rotate(session *model.Session, oldHash string) error
+	// Revoke 撤销会话（已撤销的会话保持原撤销时间），会话不存在时返回ErrNotFound
+	Revoke(id string, now time.Time) error
+	// RevokeByUser 撤销用户所有未撤销的会话，返回撤销数量
+	RevokeByUser(userID int, now time.Time) (int, error)
+	// DeleteExpired 删除在before之前已过期的会话
+	DeleteExpired(before time.Time) (int, error)
+}
+
+// OAuthTokenRepository LinuxDo令牌存储接口（每个用户一条）
+type OAuthTokenRepository interface {
+	// Save 创建或替换用户的令牌
+	Save(token *model.OAuthToken) error
+	GetByUserID(userID int) (*model.OAuthToken, error)
+	List() ([]model.OAuthToken, error)
+	// UpdateCiphertexts 仅当两个令牌的密文仍为旧值时替换为新密文（用于轮换加密密钥，不修改其他列），已变化时返回ErrNotFound
+	UpdateCiphertexts(userID int, oldAccess, oldRefresh, newAccess, newRefresh string) error
+}
+
+// OAuthStateRepository OAuth state存储接口，state只能使用一次
+type OAuthStateRepository interface {
+	Create(state *model.OAuthState) error
+	// Take 取出并删除state，state不存在或在now时刻已过期时返回ErrNotFound
+	Take(state string, now time.Time) (*model.OAuthState, error)
+	// DeleteExpired 删除在before之前已过期的state
+	DeleteExpired(before time.Time) (int, error)
+}
+
+// LoginAuditRepository 管理员登录审计存储接口
+type LoginAuditRepository interface {
+	// Create 创建审计记录，回填ID
+	Create(audit *model.LoginAudit) error
+	// List 获取最近的limit条审计记录（最新的在前）
+	List(limit int) ([]model.LoginAudit, error)
+}
+
+// TOTPRepository 管理员两步验证存储接口
+type TOTPRepository interface {
+	// Get 获取用户的两步验证设置，未设置时返回ErrNotFound
+	Get(userID int) (*model.TOTP, error)
+	// List 获取所有两步验证设置（用于轮换加密密钥）
+	List() ([]model.TOTP, error)
+	// Save 创建或替换用户的两步验证设置（重新生成密钥），同时删除旧的恢复码
+	Save(totp *model.TOTP) error
+	// UpdateSecret 仅当密钥密文仍为oldSecret时替换为newSecret（用于轮换加密密钥），已变化时返回ErrNotFound
+	UpdateSecret(userID int, oldSecret, newSecret string) error
+	// Enable 启用尚未启用的两步验证并替换恢复码（只保存哈希），未设置或已启用时返回ErrNotFound
+	Enable(userID int, enabledAt time.Time, codeHashes []string) error
+	// UseStep 记录已使用的时间步，step不大于上次使用的时间步（验证码被重复使用）时返回ErrNotFound
+	UseStep(userID int, step int64) error
+	// UseRecoveryCode 使用一条未使用的恢复码，不存在或已使用时返回ErrNotFound
+	UseRecoveryCode(userID int, codeHash string, usedAt time.Time) error
+	// CountRecoveryCodes 统计未使用的恢复码数量
+	CountRecoveryCodes(userID int) (int, error)
+	// Delete 关闭两步验证，删除设置和恢复码
+	Delete(userID int) error
+}
+
+// SettingsRepository 系统设置存储接口（只有一条记录，带版本号）
+type SettingsRepository interface {
+	// Get 获取系统设置，尚未保存过时返回ErrNotFound
+	Get() (*model.Settings, error)
+	// Save 仅当已保存的版本号等于settings.Version（0表示尚未保存过）时保存，成功后settings.Version加1
+	// 版本号不一致（已被其他管理员修改）时返回ErrNotFound
+	Save(settings *model.Settings) error
+}
@@ -0,0 +1,1029 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+)
+
+// testPostgresURLEnv 设置后会同时对本地PostgreSQL运行存储测试
+const testPostgresURLEnv = "DUIDUIMAO_TEST_POSTGRES_URL"
+
+// forEachStore 对所有可用的存储实现运行同一组测试
+func forEachStore(t *testing.T, fn func(t *testing.T, store Store)) {
+	t.Run("csv", func(t *testing.T) {
+		store, err := NewCSVStore(t.TempDir())
+		if err != nil {
+			t.Fatalf("创建CSV存储失败: %v", err)
+		}
+		fn(t, store)
+	})
+
+	t.Run("sqlite", func(t *testing.T) {
+		store, err := OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatalf("打开SQLite失败: %v", err)
+		}
+		defer store.Close()
+		fn(t, store)
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		url := os.Getenv(testPostgresURLEnv)
+		if url == "" {
+			t.Skipf("未设置 %s，跳过PostgreSQL测试", testPostgresURLEnv)
+		}
+		store, err := OpenPostgres(url)
+		if err != nil {
+			t.Fatalf("连接PostgreSQL失败: %v", err)
+		}
+		defer store.Close()
+
+		db := store.(*sqlStore).db
+		if _, err := db.Exec("TRUNCATE users, tiers, cdks, redeem_logs, orders, payments, sessions, oauth_tokens, oauth_states, login_audits, totps, recovery_codes, settings RESTART IDENTITY"); err != nil {
+			t.Fatalf("清空测试数据失败: %v", err)
+		}
+		fn(t, store)
+	})
+}
+
+func TestUserRepository(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		repo := store.Users()
+		now := time.Now().Truncate(time.Second)
+
+		user := &model.User{LinuxDoID: 100, Username: "alice", Name: "Alice", TrustLevel: 1, CreatedAt: now, UpdatedAt: now}
+		if err := repo.Upsert(user); err != nil {
+			t.Fatalf("Upsert() error = %v", err)
+		}
+		if user.ID == 0 {
+			t.Fatal("Upsert() 未回填ID")
+		}
+
+		// 再次Upsert同一LinuxDoID应更新而不是新建
+		later := now.Add(time.Hour)
+		updated := &model.User{LinuxDoID: 100, Username: "alice2", Name: "Alice", TrustLevel: 3, CreatedAt: later, UpdatedAt: later}
+		if err := repo.Upsert(updated); err != nil {
+			t.Fatalf("Upsert() error = %v", err)
+		}
+		if updated.ID != user.ID {
+			t.Errorf("Upsert() ID = %d, want %d", updated.ID, user.ID)
+		}
+		if !updated.CreatedAt.Equal(now) {
+			t.Errorf("Upsert() 不应修改创建时间, got %v want %v", updated.CreatedAt, now)
+		}
+
+		got, err := repo.GetByLinuxDoID(100)
+		if err != nil {
+			t.Fatalf("GetByLinuxDoID() error = %v", err)
+		}
+		if got.Username != "alice2" || got.TrustLevel != 3 {
+			t.Errorf("GetByLinuxDoID() = %+v", got)
+		}
+
+		if _, err := repo.GetByID(999); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetByID() error = %v, want ErrNotFound", err)
+		}
+
+		// UpdateTrustLevel 只修改信任等级和更新时间
+		if err := repo.UpdateTrustLevel(user.ID, 1, later.Add(time.Hour)); err != nil {
+			t.Fatalf("UpdateTrustLevel() error = %v", err)
+		}
+		if got, _ := repo.GetByID(user.ID); got.TrustLevel != 1 || got.Username != "alice2" || !got.UpdatedAt.Equal(later.Add(time.Hour)) {
+			t.Errorf("UpdateTrustLevel() 后 = %+v", got)
+		}
+		if err := repo.UpdateTrustLevel(999, 1, now); !errors.Is(err, ErrNotFound) {
+			t.Errorf("UpdateTrustLevel(不存在) error = %v, want ErrNotFound", err)
+		}
+
+		// 管理员角色
+		admin := &model.User{LinuxDoID: 200, Username: "bob", IsAdmin: true, Role: model.RoleCDKOperator, CreatedAt: now, UpdatedAt: now}
+		if err := repo.Upsert(admin); err != nil {
+			t.Fatalf("Upsert() error = %v", err)
+		}
+		admins, err := repo.ListAdmins()
+		if err != nil {
+			t.Fatalf("ListAdmins() error = %v", err)
+		}
+		if len(admins) != 1 || admins[0].ID != admin.ID || admins[0].Role != model.RoleCDKOperator || !admins[0].IsAdmin {
+			t.Errorf("ListAdmins() = %+v", admins)
+		}
+		if err := repo.UpdateRole(admin.ID, "", now); err != nil {
+			t.Fatalf("UpdateRole() error = %v", err)
+		}
+		if got, _ := repo.GetByID(admin.ID); got.Role != "" || got.IsAdmin {
+			t.Errorf("UpdateRole() 后 = %+v", got)
+		}
+		if admins, _ := repo.ListAdmins(); len(admins) != 0 {
+			t.Errorf("收回角色后 ListAdmins() = %+v", admins)
+		}
+	})
+}
+
+func TestTierRepository(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		repo := store.Tiers()
+		now := time.Now().Truncate(time.Second)
+
+		tier := &model.Tier{Name: "小份", Quota: 10, RequiredLevel: 1, DailyLimit: 2, IsActive: true, CreatedAt: now, UpdatedAt: now}
+		if err := repo.Create(tier); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+
+		tier.Name = "中份"
+		tier.Quota = 50
+		tier.Price = 5
+		tier.StartsAt = now.Add(time.Hour)
+		tier.EndsAt = now.Add(2 * time.Hour)
+		if err := repo.Update(tier); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+
+		got, err := repo.GetByID(tier.ID)
+		if err != nil {
+			t.Fatalf("GetByID() error = %v", err)
+		}
+		if got.Name != "中份" || got.Quota != 50 || got.Price != 5 || !got.IsActive {
+			t.Errorf("GetByID() = %+v", got)
+		}
+		if !got.StartsAt.Equal(tier.StartsAt) || !got.EndsAt.Equal(tier.EndsAt) {
+			t.Errorf("GetByID() 开放时间 = %v ~ %v, want %v ~ %v", got.StartsAt, got.EndsAt, tier.StartsAt, tier.EndsAt)
+		}
+
+		if err := repo.Delete(tier.ID); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+		if err := repo.Delete(tier.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Delete() error = %v, want ErrNotFound", err)
+		}
+
+		tiers, err := repo.List()
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		if len(tiers) != 0 {
+			t.Errorf("List() 数量 = %d, want 0", len(tiers))
+		}
+	})
+}
+
+func TestCDKRepository(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		repo := store.CDKs()
+		now := time.Now().Truncate(time.Second)
+
+		cdks := []model.CDK{
+			{TierID: 1, Code: "a", CreatedAt: now, UpdatedAt: now},
+			{TierID: 1, Code: "b", CreatedAt: now, UpdatedAt: now},
+			{TierID: 2, Code: "c", CreatedAt: now, UpdatedAt: now},
+		}
+		if err := repo.BatchCreate(cdks); err != nil {
+			t.Fatalf("BatchCreate() error = %v", err)
+		}
+		if cdks[0].ID == 0 || cdks[0].ID == cdks[1].ID {
+			t.Fatalf("BatchCreate() 回填ID错误: %+v", cdks)
+		}
+
+		claimed, err := repo.ClaimNext(1, 7, now)
+		if err != nil {
+			t.Fatalf("ClaimNext() error = %v", err)
+		}
+		if claimed.ID != cdks[0].ID || claimed.Status != model.CDKStatusRedeemed || claimed.RedeemedBy != 7 {
+			t.Errorf("ClaimNext() = %+v", claimed)
+		}
+		if _, err := repo.ClaimNext(3, 7, now); !errors.Is(err, ErrNotFound) {
+			t.Errorf("ClaimNext() error = %v, want ErrNotFound", err)
+		}
+
+		counts, err := repo.CountAvailableByTier(now)
+		if err != nil {
+			t.Fatalf("CountAvailableByTier() error = %v", err)
+		}
+		if counts[1] != 1 || counts[2] != 1 {
+			t.Errorf("CountAvailableByTier() = %v", counts)
+		}
+
+		tierID, status := 1, model.CDKStatusRedeemed
+		list, err := repo.List(&tierID, &status)
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		if len(list) != 1 || list[0].RedeemedBy != 7 || !list[0].RedeemedAt.Equal(now) {
+			t.Errorf("List() = %+v", list)
+		}
+
+		all, err := repo.List(nil, nil)
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		if len(all) != 3 {
+			t.Errorf("List() 数量 = %d, want 3", len(all))
+		}
+
+		// UpdateCode 只在code未变化时替换，且不影响其他字段
+		target := all[0]
+		if err := repo.UpdateCode(target.ID, "wrong", "new"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("UpdateCode(旧值不匹配) error = %v, want ErrNotFound", err)
+		}
+		if err := repo.UpdateCode(target.ID, target.Code, "new"); err != nil {
+			t.Fatalf("UpdateCode() error = %v", err)
+		}
+		updated, _ := repo.GetByID(target.ID)
+		if updated.Code != "new" || updated.Status != target.Status || updated.RedeemedBy != target.RedeemedBy {
+			t.Errorf("UpdateCode() 后 = %+v, 原记录 %+v", updated, target)
+		}
+
+		// 指纹：未设置的CDK不会被空指纹匹配到，设置后可按指纹查找
+		if err := repo.SetFingerprint(all[1].ID, "fp-b"); err != nil {
+			t.Fatalf("SetFingerprint() error = %v", err)
+		}
+		if err := repo.SetFingerprint(999, "fp-x"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("SetFingerprint(不存在) error = %v, want ErrNotFound", err)
+		}
+		// 指纹唯一：不能与其他CDK重复，重复设置同一CDK的指纹或清空指纹不受影响
+		if err := repo.SetFingerprint(all[0].ID, "fp-b"); err == nil {
+			t.Error("SetFingerprint(重复指纹) error = nil, want 错误")
+		}
+		if err := repo.SetFingerprint(all[1].ID, "fp-b"); err != nil {
+			t.Errorf("SetFingerprint(相同CDK) error = %v", err)
+		}
+		if err := repo.SetFingerprint(all[0].ID, ""); err != nil {
+			t.Errorf("SetFingerprint(空指纹) error = %v", err)
+		}
+		found, err := repo.FindByFingerprints([]string{"fp-b", "fp-none", ""})
+		if err != nil {
+			t.Fatalf("FindByFingerprints() error = %v", err)
+		}
+		if len(found) != 1 || found[0].ID != all[1].ID || found[0].Fingerprint != "fp-b" {
+			t.Errorf("FindByFingerprints() = %+v", found)
+		}
+		if found, _ := repo.FindByFingerprints(nil); len(found) != 0 {
+			t.Errorf("FindByFingerprints(nil) = %+v", found)
+		}
+
+		// 批量创建同样检查指纹唯一，失败时整批都不写入
+		if err := repo.BatchCreate([]model.CDK{{TierID: 1, Code: "dup-old", Fingerprint: "fp-b", CreatedAt: now, UpdatedAt: now}}); err == nil {
+			t.Error("BatchCreate(与已有CDK指纹重复) error = nil, want 错误")
+		}
+		batch := []model.CDK{
+			{TierID: 1, Code: "dup-1", Fingerprint: "fp-new", CreatedAt: now, UpdatedAt: now},
+			{TierID: 1, Code: "dup-2", Fingerprint: "fp-new", CreatedAt: now, UpdatedAt: now},
+		}
+		if err := repo.BatchCreate(batch); err == nil {
+			t.Error("BatchCreate(本批内指纹重复) error = nil, want 错误")
+		}
+		if all, _ := repo.List(nil, nil); len(all) != 3 {
+			t.Errorf("指纹重复时写入了部分CDK: 数量 = %d, want 3", len(all))
+		}
+	})
+}
+
+func TestRedeemLogRepository(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		repo := store.RedeemLogs()
+		now := time.Now().Truncate(time.Second)
+
+		for _, userID := range []int{1, 2, 1} {
+			if err := repo.Create(&model.RedeemLog{UserID: userID, CDKID: 1, TierID: 1, CreatedAt: now}); err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+		}
+
+		logs, err := repo.ListByUser(1)
+		if err != nil {
+			t.Fatalf("ListByUser() error = %v", err)
+		}
+		if len(logs) != 2 {
+			t.Errorf("ListByUser() 数量 = %d, want 2", len(logs))
+		}
+
+		all, err := repo.List()
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		if len(all) != 3 {
+			t.Errorf("List() 数量 = %d, want 3", len(all))
+		}
+
+		// 统计区间为[from, to)
+		if err := repo.Create(&model.RedeemLog{UserID: 1, CDKID: 2, TierID: 1, CreatedAt: now.Add(-time.Hour)}); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		tests := []struct {
+			name     string
+			tierID   int
+			from, to time.Time
+			want     int
+		}{
+			{"包含起点", 1, now, now.Add(time.Hour), 2},
+			{"不含终点", 1, now.Add(-time.Hour), now, 1},
+			{"全部", 1, now.Add(-2 * time.Hour), now.Add(time.Hour), 3},
+			{"其他档位", 2, now.Add(-2 * time.Hour), now.Add(time.Hour), 0},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				got, err := repo.CountByUserTier(1, tt.tierID, tt.from, tt.to)
+				if err != nil {
+					t.Fatalf("CountByUserTier() error = %v", err)
+				}
+				if got != tt.want {
+					t.Errorf("CountByUserTier() = %d, want %d", got, tt.want)
+				}
+			})
+		}
+	})
+}
+
+func TestOrderRepository(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		repo := store.Orders()
+		now := time.Now().Truncate(time.Second)
+
+		orders := []*model.Order{
+			{OrderNo: "A001", UserID: 1, TierID: 1, Quantity: 2, TotalQuota: 20, TotalPrice: 4, CreatedAt: now.Add(-48 * time.Hour), UpdatedAt: now},
+			{OrderNo: "A002", UserID: 2, TierID: 1, Quantity: 1, TotalQuota: 10, CreatedAt: now, UpdatedAt: now},
+			{OrderNo: "A003", UserID: 1, TierID: 2, Quantity: 1, TotalQuota: 50, CreatedAt: now, UpdatedAt: now},
+		}
+		for _, order := range orders {
+			if err := repo.Create(order); err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+		}
+		if err := repo.Create(&model.Order{OrderNo: "A001", CreatedAt: now, UpdatedAt: now}); err == nil {
+			t.Error("Create() 重复订单号应返回错误")
+		}
+
+		got, err := repo.GetByOrderNo("A002")
+		if err != nil {
+			t.Fatalf("GetByOrderNo() error = %v", err)
+		}
+		if got.ID != orders[1].ID || got.UserID != 2 || !got.PaidAt.IsZero() {
+			t.Errorf("GetByOrderNo() = %+v", got)
+		}
+		if _, err := repo.GetByOrderNo("missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetByOrderNo() error = %v, want ErrNotFound", err)
+		}
+
+		got.Status = model.OrderStatusCompleted
+		got.PaidAt = now
+		if err := repo.Update(got); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+		got, _ = repo.GetByID(got.ID)
+		if got.Status != model.OrderStatusCompleted || !got.PaidAt.Equal(now) {
+			t.Errorf("Update() 后 = %+v", got)
+		}
+		if first, _ := repo.GetByID(orders[0].ID); first.TotalPrice != 4 {
+			t.Errorf("TotalPrice = %d, want 4", first.TotalPrice)
+		}
+
+		// 状态已不是待支付时，条件更新不应生效
+		got.Status = model.OrderStatusCancelled
+		if err := repo.UpdateIfStatus(got, model.OrderStatusPending); !errors.Is(err, ErrNotFound) {
+			t.Errorf("UpdateIfStatus() error = %v, want ErrNotFound", err)
+		}
+		if err := repo.UpdateIfStatus(got, model.OrderStatusCompleted); err != nil {
+			t.Errorf("UpdateIfStatus() error = %v", err)
+		}
+		got.Status = model.OrderStatusCompleted
+		if err := repo.Update(got); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+
+		userID, tierID, status := 1, 1, model.OrderStatusCompleted
+		tests := []struct {
+			name   string
+			filter OrderFilter
+			want   []string
+		}{
+			{"全部（最新在前）", OrderFilter{}, []string{"A003", "A002", "A001"}},
+			{"按用户", OrderFilter{UserID: &userID}, []string{"A003", "A001"}},
+			{"按用户和档位", OrderFilter{UserID: &userID, TierID: &tierID}, []string{"A001"}},
+			{"按状态", OrderFilter{Status: &status}, []string{"A002"}},
+			{"按时间范围", OrderFilter{From: now.Add(-time.Hour), To: now.Add(time.Hour)}, []string{"A003", "A002"}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				list, err := repo.List(tt.filter)
+				if err != nil {
+					t.Fatalf("List() error = %v", err)
+				}
+				gotNos := []string{}
+				for _, o := range list {
+					gotNos = append(gotNos, o.OrderNo)
+				}
+				if strings.Join(gotNos, ",") != strings.Join(tt.want, ",") {
+					t.Errorf("List() = %v, want %v", gotNos, tt.want)
+				}
+			})
+		}
+	})
+}
+
+func TestPaymentRepository(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		repo := store.Payments()
+		now := time.Now().Truncate(time.Second)
+
+		payments := []*model.Payment{
+			{OrderID: 1, UserID: 1, Amount: 10, PaymentType: model.PaymentTypePoints, Status: model.PaymentStatusProcessing, CreatedAt: now},
+			{OrderID: 2, UserID: 2, PaymentType: model.PaymentTypeFree, Status: model.PaymentStatusSuccess, CreatedAt: now, FinishedAt: now},
+			{OrderID: 1, UserID: 1, Amount: 10, PaymentType: model.PaymentTypePoints, Status: model.PaymentStatusProcessing, CreatedAt: now},
+		}
+		for _, p := range payments {
+			if err := repo.Create(p); err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+		}
+
+		first := payments[0]
+		first.Status = model.PaymentStatusFailed
+		first.TransactionID = "T100"
+		first.FailReason = "支付金额不匹配"
+		first.FinishedAt = now
+		if err := repo.Update(first); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+		if err := repo.Update(&model.Payment{ID: 999}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Update(不存在) error = %v, want ErrNotFound", err)
+		}
+
+		byOrder, err := repo.ListByOrder(1)
+		if err != nil {
+			t.Fatalf("ListByOrder() error = %v", err)
+		}
+		if len(byOrder) != 2 || byOrder[0].ID != first.ID {
+			t.Fatalf("ListByOrder() = %+v", byOrder)
+		}
+		got := byOrder[0]
+		if got.TransactionID != "T100" || got.FailReason != "支付金额不匹配" || got.Amount != 10 || !got.FinishedAt.Equal(now) {
+			t.Errorf("更新后记录 = %+v", got)
+		}
+		if !byOrder[1].FinishedAt.IsZero() {
+			t.Errorf("未完成记录 FinishedAt = %v, want 零值", byOrder[1].FinishedAt)
+		}
+
+		success := model.PaymentStatusSuccess
+		tests := []struct {
+			name   string
+			status *int
+			want   []int
+		}{
+			{"全部", nil, []int{payments[2].ID, payments[1].ID, payments[0].ID}},
+			{"支付成功", &success, []int{payments[1].ID}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				list, err := repo.List(tt.status)
+				if err != nil {
+					t.Fatalf("List() error = %v", err)
+				}
+				ids := []int{}
+				for _, p := range list {
+					ids = append(ids, p.ID)
+				}
+				if fmt.Sprint(ids) != fmt.Sprint(tt.want) {
+					t.Errorf("List() ids = %v, want %v", ids, tt.want)
+				}
+			})
+		}
+	})
+}
+
+func TestSessionRepository(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		repo := store.Sessions()
+		now := time.Now().Truncate(time.Second)
+
+		sessions := []*model.Session{
+			{ID: "s1", UserID: 1, RefreshHash: "h1", CreatedAt: now, RefreshedAt: now, ExpiresAt: now.Add(time.Hour)},
+			{ID: "s2", UserID: 1, RefreshHash: "h2", CreatedAt: now, RefreshedAt: now, ExpiresAt: now.Add(time.Hour)},
+			{ID: "s3", UserID: 2, RefreshHash: "h3", CreatedAt: now, RefreshedAt: now, ExpiresAt: now.Add(-time.Minute)},
+		}
+		for _, s := range sessions {
+			if err := repo.Create(s); err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+		}
+
+		got, err := repo.GetByID("s1")
+		if err != nil {
+			t.Fatalf("GetByID() error = %v", err)
+		}
+		if got.UserID != 1 || got.RefreshHash != "h1" || !got.ExpiresAt.Equal(now.Add(time.Hour)) || !got.RevokedAt.IsZero() {
+			t.Errorf("GetByID() = %+v", got)
+		}
+		if _, err := repo.GetByID("missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetByID(不存在) error = %v, want ErrNotFound", err)
+		}
+
+		// Rotate 只在旧哈希匹配时更新
+		rotated := *got
+		rotated.RefreshHash = "h1-new"
+		rotated.ExpiresAt = now.Add(2 * time.Hour)
+		if err := repo.Rotate(&rotated, "wrong"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Rotate(旧哈希不匹配) error = %v, want ErrNotFound", err)
+		}
+		if err := repo.Rotate(&rotated, "h1"); err != nil {
+			t.Fatalf("Rotate() error = %v", err)
+		}
+		if got, _ := repo.GetByID("s1"); got.RefreshHash != "h1-new" || !got.ExpiresAt.Equal(now.Add(2*time.Hour)) {
+			t.Errorf("Rotate() 后 = %+v", got)
+		}
+
+		// 撤销后不能再轮换，重复撤销保留原撤销时间
+		if err := repo.Revoke("s1", now); err != nil {
+			t.Fatalf("Revoke() error = %v", err)
+		}
+		if err := repo.Revoke("s1", now.Add(time.Minute)); err != nil {
+			t.Fatalf("Revoke() 重复 error = %v", err)
+		}
+		if got, _ := repo.GetByID("s1"); !got.RevokedAt.Equal(now) {
+			t.Errorf("RevokedAt = %v, want %v", got.RevokedAt, now)
+		}
+		if err := repo.Rotate(&rotated, "h1-new"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Rotate(已撤销) error = %v, want ErrNotFound", err)
+		}
+		if err := repo.Revoke("missing", now); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Revoke(不存在) error = %v, want ErrNotFound", err)
+		}
+
+		if n, err := repo.RevokeByUser(1, now); err != nil || n != 1 {
+			t.Errorf("RevokeByUser() = %d, %v, want 1", n, err)
+		}
+		if n, err := repo.DeleteExpired(now); err != nil || n != 1 {
+			t.Errorf("DeleteExpired() = %d, %v, want 1", n, err)
+		}
+		if _, err := repo.GetByID("s3"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("过期会话未被删除: %v", err)
+		}
+	})
+}
+
+func TestOAuthTokenRepository(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		repo := store.OAuthTokens()
+		now := time.Now().Truncate(time.Second)
+
+		if _, err := repo.GetByUserID(1); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetByUserID(不存在) error = %v, want ErrNotFound", err)
+		}
+
+		tokens := []*model.OAuthToken{
+			{UserID: 1, AccessToken: "a1", RefreshToken: "r1", ExpiresAt: now.Add(time.Hour), UpdatedAt: now},
+			{UserID: 2, AccessToken: "a2", UpdatedAt: now},
+		}
+		for _, token := range tokens {
+			if err := repo.Save(token); err != nil {
+				t.Fatalf("Save() error = %v", err)
+			}
+		}
+
+		// 再次保存同一用户的令牌应覆盖而不是新增
+		if err := repo.Save(&model.OAuthToken{UserID: 1, AccessToken: "a1-new", RefreshToken: "r1-new", ExpiresAt: now.Add(2 * time.Hour), UpdatedAt: now.Add(time.Minute)}); err != nil {
+			t.Fatalf("Save() 覆盖 error = %v", err)
+		}
+		got, err := repo.GetByUserID(1)
+		if err != nil {
+			t.Fatalf("GetByUserID() error = %v", err)
+		}
+		if got.AccessToken != "a1-new" || got.RefreshToken != "r1-new" || !got.ExpiresAt.Equal(now.Add(2*time.Hour)) || !got.UpdatedAt.Equal(now.Add(time.Minute)) {
+			t.Errorf("GetByUserID() = %+v", got)
+		}
+		if got, _ := repo.GetByUserID(2); got.RefreshToken != "" || !got.ExpiresAt.IsZero() {
+			t.Errorf("GetByUserID(无过期时间) = %+v", got)
+		}
+
+		list, err := repo.List()
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		if len(list) != 2 {
+			t.Errorf("List() 数量 = %d, want 2", len(list))
+		}
+
+		// 轮换密钥时只在两个密文都未变化时替换，不修改过期时间
+		if err := repo.UpdateCiphertexts(1, "a1", "r1", "a1-b", "r1-b"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("UpdateCiphertexts(密文已变化) error = %v, want ErrNotFound", err)
+		}
+		if err := repo.UpdateCiphertexts(1, "a1-new", "r1-new", "a1-b", "r1-b"); err != nil {
+			t.Errorf("UpdateCiphertexts() error = %v", err)
+		}
+		if got, _ := repo.GetByUserID(1); got.AccessToken != "a1-b" || got.RefreshToken != "r1-b" || !got.ExpiresAt.Equal(now.Add(2*time.Hour)) {
+			t.Errorf("UpdateCiphertexts() 后 GetByUserID() = %+v", got)
+		}
+	})
+}
+
+func TestOAuthStateRepository(t *testing.T) {
+	check := func(t *testing.T, repo OAuthStateRepository) {
+		now := time.Now().Truncate(time.Second)
+		states := []*model.OAuthState{
+			{State: "s1", CodeVerifier: "v1", CreatedAt: now, ExpiresAt: now.Add(10 * time.Minute)},
+			{State: "s2", CreatedAt: now, ExpiresAt: now.Add(10 * time.Minute)},
+			{State: "s3", CreatedAt: now.Add(-time.Hour), ExpiresAt: now.Add(-time.Minute)},
+		}
+		for _, state := range states {
+			if err := repo.Create(state); err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+		}
+
+		got, err := repo.Take("s1", now)
+		if err != nil {
+			t.Fatalf("Take() error = %v", err)
+		}
+		if got.CodeVerifier != "v1" || !got.ExpiresAt.Equal(now.Add(10*time.Minute)) {
+			t.Errorf("Take() = %+v", got)
+		}
+		// state只能使用一次
+		if _, err := repo.Take("s1", now); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Take(已使用) error = %v, want ErrNotFound", err)
+		}
+		if _, err := repo.Take("s2", now.Add(10*time.Minute)); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Take(已过期) error = %v, want ErrNotFound", err)
+		}
+
+		if n, err := repo.DeleteExpired(now); err != nil || n != 1 {
+			t.Errorf("DeleteExpired() = %d, %v, want 1", n, err)
+		}
+		if _, err := repo.Take("s3", now.Add(-2*time.Minute)); !errors.Is(err, ErrNotFound) {
+			t.Errorf("过期state未被删除: %v", err)
+		}
+	}
+
+	forEachStore(t, func(t *testing.T, store Store) {
+		check(t, store.OAuthStates())
+	})
+	t.Run("memory", func(t *testing.T) {
+		check(t, NewMemoryOAuthStateRepository())
+	})
+}
+
+func TestLoginAuditRepository(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		repo := store.LoginAudits()
+		now := time.Now().Truncate(time.Second)
+
+		audits := []*model.LoginAudit{
+			{Username: "root", IP: "10.0.0.1", UserAgent: "curl/8.0", Reason: "密码错误", CreatedAt: now},
+			{Username: "admin", IP: "10.0.0.2", Reason: "用户名错误", CreatedAt: now.Add(time.Second)},
+			{Username: "root", IP: "10.0.0.1", UserAgent: "Mozilla/5.0", Success: true, CreatedAt: now.Add(2 * time.Second)},
+		}
+		for _, audit := range audits {
+			if err := repo.Create(audit); err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+			if audit.ID == 0 {
+				t.Error("Create() 未设置ID")
+			}
+		}
+
+		// 按时间倒序返回最近的记录
+		list, err := repo.List(2)
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		if len(list) != 2 || list[0].ID != audits[2].ID || list[1].ID != audits[1].ID {
+			t.Fatalf("List(2) = %+v", list)
+		}
+		if got := list[0]; !got.Success || got.UserAgent != "Mozilla/5.0" || !got.CreatedAt.Equal(now.Add(2*time.Second)) {
+			t.Errorf("List()[0] = %+v", got)
+		}
+		if got := list[1]; got.Success || got.Reason != "用户名错误" || got.IP != "10.0.0.2" {
+			t.Errorf("List()[1] = %+v", got)
+		}
+		if list, _ := repo.List(10); len(list) != 3 {
+			t.Errorf("List(10) 数量 = %d, want 3", len(list))
+		}
+	})
+}
+
+func TestTOTPRepository(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		repo := store.TOTPs()
+		now := time.Now().Truncate(time.Second)
+
+		if _, err := repo.Get(1); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(未设置) error = %v, want ErrNotFound", err)
+		}
+		if err := repo.Enable(1, now, nil); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Enable(未设置) error = %v, want ErrNotFound", err)
+		}
+
+		if err := repo.Save(&model.TOTP{UserID: 1, Secret: "s1", CreatedAt: now}); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+		if got, err := repo.Get(1); err != nil || got.Secret != "s1" || got.Enabled() {
+			t.Fatalf("Get() = %+v, %v", got, err)
+		}
+		if err := repo.Enable(1, now, []string{"h1", "h2", "h2"}); err != nil {
+			t.Fatalf("Enable() error = %v", err)
+		}
+		if err := repo.Enable(1, now, []string{"h3"}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Enable(已启用) error = %v, want ErrNotFound", err)
+		}
+		if got, _ := repo.Get(1); !got.EnabledAt.Equal(now) {
+			t.Errorf("Enable() 后 EnabledAt = %v, want %v", got.EnabledAt, now)
+		}
+
+		// 时间步只能递增
+		if err := repo.UseStep(1, 100); err != nil {
+			t.Errorf("UseStep(100) error = %v", err)
+		}
+		if err := repo.UseStep(1, 100); !errors.Is(err, ErrNotFound) {
+			t.Errorf("UseStep(重复) error = %v, want ErrNotFound", err)
+		}
+		if err := repo.UseStep(1, 101); err != nil {
+			t.Errorf("UseStep(101) error = %v", err)
+		}
+
+		// 恢复码只能使用一次（相同哈希的两条各用一次）
+		for i, want := range []error{nil, nil, ErrNotFound} {
+			if err := repo.UseRecoveryCode(1, "h2", now); !errors.Is(err, want) {
+				t.Errorf("第%d次 UseRecoveryCode(h2) error = %v, want %v", i+1, err, want)
+			}
+		}
+		if err := repo.UseRecoveryCode(2, "h1", now); !errors.Is(err, ErrNotFound) {
+			t.Errorf("UseRecoveryCode(其他用户) error = %v, want ErrNotFound", err)
+		}
+		if n, err := repo.CountRecoveryCodes(1); err != nil || n != 1 {
+			t.Errorf("CountRecoveryCodes() = %d, %v, want 1", n, err)
+		}
+
+		// 重新生成密钥后恢复码作废
+		if err := repo.Save(&model.TOTP{UserID: 1, Secret: "s2", CreatedAt: now}); err != nil {
+			t.Fatalf("Save(覆盖) error = %v", err)
+		}
+		if got, _ := repo.Get(1); got.Secret != "s2" || got.Enabled() || got.LastStep != 0 {
+			t.Errorf("Save(覆盖) 后 Get() = %+v", got)
+		}
+		if n, _ := repo.CountRecoveryCodes(1); n != 0 {
+			t.Errorf("Save(覆盖) 后恢复码数量 = %d, want 0", n)
+		}
+
+		if err := repo.Enable(1, now, []string{"h4"}); err != nil {
+			t.Fatalf("Enable() error = %v", err)
+		}
+
+		// 轮换密钥时只在密文未变化时替换，且不影响启用状态
+		if err := repo.UpdateSecret(1, "s1", "s3"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("UpdateSecret(密文已变化) error = %v, want ErrNotFound", err)
+		}
+		if err := repo.UpdateSecret(1, "s2", "s3"); err != nil {
+			t.Errorf("UpdateSecret() error = %v", err)
+		}
+		if list, err := repo.List(); err != nil || len(list) != 1 || list[0].Secret != "s3" || !list[0].Enabled() {
+			t.Errorf("List() = %+v, %v", list, err)
+		}
+
+		if err := repo.Delete(1); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+		if _, err := repo.Get(1); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Delete() 后 Get() error = %v, want ErrNotFound", err)
+		}
+		if n, _ := repo.CountRecoveryCodes(1); n != 0 {
+			t.Errorf("Delete() 后恢复码数量 = %d, want 0", n)
+		}
+	})
+}
+
+func TestSettingsRepository(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		repo := store.Settings()
+		now := time.Now().Truncate(time.Second)
+
+		if _, err := repo.Get(); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(未保存) error = %v, want ErrNotFound", err)
+		}
+
+		first := &model.Settings{GlobalEnabled: true, Announcement: "公告, 含逗号", OrderExpireMinutes: 15, UpdatedAt: now, UpdatedBy: 1}
+		if err := repo.Save(first); err != nil || first.Version != 1 {
+			t.Fatalf("Save(首次) = %v, Version = %d, want 1", err, first.Version)
+		}
+		// 另一个管理员也基于"尚未保存"的状态提交
+		if err := repo.Save(&model.Settings{OrderExpireMinutes: 30, UpdatedAt: now}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Save(并发首次保存) error = %v, want ErrNotFound", err)
+		}
+
+		// 两个管理员基于同一版本修改，后提交的失败
+		a := &model.Settings{GlobalEnabled: false, Announcement: "维护中", OrderExpireMinutes: 20, Version: 1, UpdatedAt: now, UpdatedBy: 2}
+		b := &model.Settings{GlobalEnabled: true, Announcement: "覆盖", OrderExpireMinutes: 30, Version: 1, UpdatedAt: now, UpdatedBy: 3}
+		if err := repo.Save(a); err != nil || a.Version != 2 {
+			t.Fatalf("Save(a) = %v, Version = %d, want 2", err, a.Version)
+		}
+		if err := repo.Save(b); !errors.Is(err, ErrNotFound) || b.Version != 1 {
+			t.Errorf("Save(b) = %v, Version = %d, want ErrNotFound, 1", err, b.Version)
+		}
+
+		got, err := repo.Get()
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		want := model.Settings{GlobalEnabled: false, Announcement: "维护中", OrderExpireMinutes: 20, Version: 2, UpdatedAt: now, UpdatedBy: 2}
+		if !got.UpdatedAt.Equal(want.UpdatedAt) {
+			t.Errorf("UpdatedAt = %v, want %v", got.UpdatedAt, want.UpdatedAt)
+		}
+		got.UpdatedAt = want.UpdatedAt
+		if *got != want {
+			t.Errorf("Get() = %+v, want %+v", *got, want)
+		}
+	})
+}
+
+func TestCDKLockForOrder(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		repo := store.CDKs()
+		now := time.Now().Truncate(time.Second)
+
+		cdks := []model.CDK{
+			{TierID: 1, Code: "a", CreatedAt: now, UpdatedAt: now},
+			{TierID: 1, Code: "b", CreatedAt: now, UpdatedAt: now},
+			{TierID: 1, Code: "c", CreatedAt: now, UpdatedAt: now},
+		}
+		if err := repo.BatchCreate(cdks); err != nil {
+			t.Fatalf("BatchCreate() error = %v", err)
+		}
+
+		// 数量不足时不应锁定任何CDK
+		if _, err := repo.LockForOrder(&model.Order{ID: 9, UserID: 5, TierID: 1, Quantity: 4}, now); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("LockForOrder() error = %v, want ErrNotFound", err)
+		}
+		counts, _ := repo.CountAvailableByTier(now)
+		if counts[1] != 3 {
+			t.Fatalf("锁定失败后可用数量 = %d, want 3", counts[1])
+		}
+
+		locked, err := repo.LockForOrder(&model.Order{ID: 10, UserID: 5, TierID: 1, Quantity: 2}, now)
+		if err != nil {
+			t.Fatalf("LockForOrder() error = %v", err)
+		}
+		if len(locked) != 2 || locked[0].ID != cdks[0].ID || locked[1].ID != cdks[1].ID {
+			t.Fatalf("LockForOrder() = %+v", locked)
+		}
+
+		list, err := repo.ListByOrder(10)
+		if err != nil {
+			t.Fatalf("ListByOrder() error = %v", err)
+		}
+		if len(list) != 2 {
+			t.Fatalf("ListByOrder() 数量 = %d, want 2", len(list))
+		}
+		for _, cdk := range list {
+			if cdk.OrderID != 10 || cdk.RedeemedBy != 0 || cdk.Status != model.CDKStatusLocked {
+				t.Errorf("ListByOrder() = %+v", cdk)
+			}
+		}
+	})
+}
+
+func TestCDKScheduleRelease(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		repo := store.CDKs()
+		now := time.Now().Truncate(time.Second)
+		at := now.Add(time.Hour)
+
+		cdks := []model.CDK{
+			{TierID: 1, Code: "a", CreatedAt: now, UpdatedAt: now},
+			{TierID: 1, Code: "b", CreatedAt: now, UpdatedAt: now},
+			{TierID: 1, Code: "c", CreatedAt: now, UpdatedAt: now},
+			{TierID: 2, Code: "d", CreatedAt: now, UpdatedAt: now},
+		}
+		if err := repo.BatchCreate(cdks); err != nil {
+			t.Fatalf("BatchCreate() error = %v", err)
+		}
+
+		// 数量不足时不应暂扣任何CDK
+		if err := repo.ScheduleRelease(1, 4, at, now); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("ScheduleRelease() error = %v, want ErrNotFound", err)
+		}
+		if err := repo.ScheduleRelease(1, 2, at, now); err != nil {
+			t.Fatalf("ScheduleRelease() error = %v", err)
+		}
+
+		counts, _ := repo.CountAvailableByTier(now)
+		if counts[1] != 1 || counts[2] != 1 {
+			t.Errorf("放出前可用数量 = %v, want map[1:1 2:1]", counts)
+		}
+		if counts, _ := repo.CountAvailableByTier(at); counts[1] != 3 {
+			t.Errorf("放出后可用数量 = %d, want 3", counts[1])
+		}
+
+		releases, err := repo.PendingReleases(now)
+		if err != nil {
+			t.Fatalf("PendingReleases() error = %v", err)
+		}
+		if len(releases) != 1 || releases[0].TierID != 1 || releases[0].Count != 2 || !releases[0].ReleaseAt.Equal(at) {
+			t.Errorf("PendingReleases() = %+v", releases)
+		}
+
+		// 未放出的CDK不能被兑换或锁定
+		claimed, err := repo.ClaimNext(1, 7, now)
+		if err != nil || claimed.ID != cdks[0].ID {
+			t.Fatalf("ClaimNext() = %+v, %v", claimed, err)
+		}
+		if _, err := repo.ClaimNext(1, 7, now); !errors.Is(err, ErrNotFound) {
+			t.Errorf("ClaimNext() error = %v, want ErrNotFound", err)
+		}
+		if _, err := repo.LockForOrder(&model.Order{ID: 9, TierID: 1, Quantity: 1}, now); !errors.Is(err, ErrNotFound) {
+			t.Errorf("LockForOrder() error = %v, want ErrNotFound", err)
+		}
+		if locked, err := repo.LockForOrder(&model.Order{ID: 9, TierID: 1, Quantity: 1}, at); err != nil || len(locked) != 1 {
+			t.Errorf("到时间后LockForOrder() = %+v, %v", locked, err)
+		}
+
+		released, err := repo.ReleaseNow(1, now)
+		if err != nil || released != 1 {
+			t.Fatalf("ReleaseNow() = %d, %v, want 1", released, err)
+		}
+		if releases, _ := repo.PendingReleases(now); len(releases) != 0 {
+			t.Errorf("ReleaseNow() 后 PendingReleases() = %+v", releases)
+		}
+		if counts, _ := repo.CountAvailableByTier(now); counts[1] != 1 {
+			t.Errorf("ReleaseNow() 后可用数量 = %d, want 1", counts[1])
+		}
+	})
+}
+
+func TestStoreTx(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		now := time.Now().Truncate(time.Second)
+		errRollback := errors.New("回滚")
+
+		// 事务失败时所有修改都应被丢弃
+		err := store.Tx(func(tx Store) error {
+			if err := tx.Tiers().Create(&model.Tier{Name: "回滚档位", Quota: 1, CreatedAt: now, UpdatedAt: now}); err != nil {
+				return err
+			}
+			if err := tx.RedeemLogs().Create(&model.RedeemLog{UserID: 1, CDKID: 1, TierID: 1, CreatedAt: now}); err != nil {
+				return err
+			}
+			return errRollback
+		})
+		if !errors.Is(err, errRollback) {
+			t.Fatalf("Tx() error = %v, want %v", err, errRollback)
+		}
+		tiers, _ := store.Tiers().List()
+		logs, _ := store.RedeemLogs().List()
+		if len(tiers) != 0 || len(logs) != 0 {
+			t.Fatalf("回滚后仍有数据: tiers=%d logs=%d", len(tiers), len(logs))
+		}
+
+		// 事务成功时修改可见，且事务内能读到自己的写入
+		err = store.Tx(func(tx Store) error {
+			tier := &model.Tier{Name: "提交档位", Quota: 1, CreatedAt: now, UpdatedAt: now}
+			if err := tx.Tiers().Create(tier); err != nil {
+				return err
+			}
+			if _, err := tx.Tiers().GetByID(tier.ID); err != nil {
+				return err
+			}
+			return tx.CDKs().BatchCreate([]model.CDK{{TierID: tier.ID, Code: "x", CreatedAt: now, UpdatedAt: now}})
+		})
+		if err != nil {
+			t.Fatalf("Tx() error = %v", err)
+		}
+		tiers, _ = store.Tiers().List()
+		cdks, _ := store.CDKs().List(nil, nil)
+		if len(tiers) != 1 || len(cdks) != 1 {
+			t.Errorf("提交后数据不完整: tiers=%d cdks=%d", len(tiers), len(cdks))
+		}
+	})
+}
+
+func TestCSVStoreTxCommit(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewCSVStore(dir)
+	if err != nil {
+		t.Fatalf("创建CSV存储失败: %v", err)
+	}
+	now := time.Now().Truncate(time.Second)
+	if err := store.Tiers().Create(&model.Tier{Name: "已有档位", Quota: 1, CreatedAt: now, UpdatedAt: now}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	// 其中一张表无法写入时，其他修改过的表也不能被替换
+	broken := csvTable{file: filepath.Join("missing", "broken.csv"), header: []string{"id"}}
+	err = store.Tx(func(tx Store) error {
+		if err := tx.Tiers().Create(&model.Tier{Name: "新档位", Quota: 1, CreatedAt: now, UpdatedAt: now}); err != nil {
+			return err
+		}
+		return tx.(*csvStore).writeTable(broken, [][]string{{"1"}})
+	})
+	if err == nil {
+		t.Fatal("Tx() error = nil, want 写入失败")
+	}
+	if tiers, _ := store.Tiers().List(); len(tiers) != 1 {
+		t.Errorf("提交失败后档位数 = %d, want 1", len(tiers))
+	}
+	if temps, _ := filepath.Glob(filepath.Join(dir, "*.tmp*")); len(temps) != 0 {
+		t.Errorf("提交失败后残留临时文件: %v", temps)
+	}
+}
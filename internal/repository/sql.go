@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// querier *sql.DB 与 *sql.Tx 的公共查询接口
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// dialect 不同数据库之间的差异
+type dialect struct {
+	name          string // 驱动名称
+	migrationDir  string // 内置迁移文件目录
+	timestampType string // 时间列类型
+	skipLocked    string // 并发领取时的行锁子句（SQLite写事务锁整库，无需行锁）
+	forUpdate     string // 事务内锁定所读行的子句（同上，SQLite无需行锁）
+}
+
+// utcQuerier 执行前把时间参数统一转换为UTC
+// SQLite以文本保存时间，只有时区一致时按字符串比较（范围查询）的结果才正确
+type utcQuerier struct {
+	q querier
+}
+
+func (u utcQuerier) Exec(query string, args ...any) (sql.Result, error) {
+	return u.q.Exec(query, utcArgs(args)...)
+}
+
+func (u utcQuerier) Query(query string, args ...any) (*sql.Rows, error) {
+	return u.q.Query(query, utcArgs(args)...)
+}
+
+func (u utcQuerier) QueryRow(query string, args ...any) *sql.Row {
+	return u.q.QueryRow(query, utcArgs(args)...)
+}
+
+// utcArgs 返回时间参数已转换为UTC的参数副本
+func utcArgs(args []any) []any {
+	converted := make([]any, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case time.Time:
+			converted[i] = v.UTC()
+		case sql.NullTime:
+			v.Time = v.Time.UTC()
+			converted[i] = v
+		default:
+			converted[i] = arg
+		}
+	}
+	return converted
+}
+
+// sqlStore 基于database/sql的存储（PostgreSQL与SQLite共用）
+type sqlStore struct {
+	db      *sql.DB
+	q       querier // 事务内包装 *sql.Tx，否则包装 *sql.DB
+	inTx    bool
+	dialect dialect
+}
+
+func newSQLStore(db *sql.DB, d dialect) *sqlStore {
+	return &sqlStore{db: db, q: utcQuerier{db}, dialect: d}
+}
+
+func (s *sqlStore) Users() UserRepository             { return &sqlUserRepo{s.q, s.dialect.forUpdate} }
+func (s *sqlStore) Tiers() TierRepository             { return &sqlTierRepo{s.q} }
+func (s *sqlStore) CDKs() CDKRepository               { return &sqlCDKRepo{s} }
+func (s *sqlStore) RedeemLogs() RedeemLogRepository   { return &sqlRedeemLogRepo{s.q} }
+func (s *sqlStore) Orders() OrderRepository           { return &sqlOrderRepo{s.q} }
+func (s *sqlStore) Payments() PaymentRepository       { return &sqlPaymentRepo{s.q} }
+func (s *sqlStore) Sessions() SessionRepository       { return &sqlSessionRepo{s.q} }
+func (s *sqlStore) OAuthTokens() OAuthTokenRepository { return &sqlOAuthTokenRepo{s.q} }
+func (s *sqlStore) OAuthStates() OAuthStateRepository { return &sqlOAuthStateRepo{s.q} }
+func (s *sqlStore) LoginAudits() LoginAuditRepository { return &sqlLoginAuditRepo{s.q} }
+func (s *sqlStore) TOTPs() TOTPRepository             { return &sqlTOTPRepo{s} }
+func (s *sqlStore) Settings() SettingsRepository      { return &sqlSettingsRepo{s.q} }
+
+// Tx 在数据库事务中执行fn，fn返回错误时回滚（已在事务中时直接复用当前事务）
+func (s *sqlStore) Tx(fn func(tx Store) error) error {
+	if s.inTx {
+		return fn(s)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(&sqlStore{db: s.db, q: utcQuerier{tx}, inTx: true, dialect: s.dialect}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Close 关闭数据库连接
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+// nullTime 零值时间写为NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// notFound 将sql.ErrNoRows转换为ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// checkAffected 更新/删除未命中任何行时返回ErrNotFound
+func checkAffected(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// rowsAffected 返回更新/删除影响的行数
+func rowsAffected(res sql.Result, err error) (int, error) {
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,276 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+)
+
+// sqlCDKRepo CDK数据库存储
+type sqlCDKRepo struct {
+	s *sqlStore
+}
+
+const cdkColumns = "id, tier_id, code, status, order_id, redeemed_by, redeemed_at, created_at, updated_at, fingerprint, release_at"
+
+func scanCDK(row interface{ Scan(...any) error }) (*model.CDK, error) {
+	var c model.CDK
+	var redeemedAt, releaseAt sql.NullTime
+	err := row.Scan(&c.ID, &c.TierID, &c.Code, &c.Status, &c.OrderID, &c.RedeemedBy, &redeemedAt, &c.CreatedAt, &c.UpdatedAt, &c.Fingerprint, &releaseAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	c.RedeemedAt = redeemedAt.Time
+	c.ReleaseAt = releaseAt.Time
+	return &c, nil
+}
+
+// queryCDKs 执行查询并扫描CDK列表
+func (r *sqlCDKRepo) queryCDKs(query string, args ...any) ([]model.CDK, error) {
+	rows, err := r.s.q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cdks := []model.CDK{}
+	for rows.Next() {
+		cdk, err := scanCDK(rows)
+		if err != nil {
+			return nil, err
+		}
+		cdks = append(cdks, *cdk)
+	}
+	return cdks, rows.Err()
+}
+
+// BatchCreate 批量创建CDK（单个事务内插入）
+func (r *sqlCDKRepo) BatchCreate(cdks []model.CDK) error {
+	return r.s.Tx(func(tx Store) error {
+		q := tx.(*sqlStore).q
+		for i := range cdks {
+			c := &cdks[i]
+			err := q.QueryRow(`
+				INSERT INTO cdks (tier_id, code, status, order_id, redeemed_by, redeemed_at, created_at, updated_at, fingerprint, release_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+				RETURNING id`,
+				c.TierID, c.Code, c.Status, c.OrderID, c.RedeemedBy, nullTime(c.RedeemedAt), c.CreatedAt, c.UpdatedAt, c.Fingerprint, nullTime(c.ReleaseAt),
+			).Scan(&c.ID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// List 获取CDK列表（支持按档位和状态筛选）
+func (r *sqlCDKRepo) List(tierID, status *int) ([]model.CDK, error) {
+	conds := []string{}
+	args := []any{}
+	if tierID != nil {
+		args = append(args, *tierID)
+		conds = append(conds, fmt.Sprintf("tier_id = $%d", len(args)))
+	}
+	if status != nil {
+		args = append(args, *status)
+		conds = append(conds, fmt.Sprintf("status = $%d", len(args)))
+	}
+
+	query := "SELECT " + cdkColumns + " FROM cdks"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	return r.queryCDKs(query+" ORDER BY id", args...)
+}
+
+// GetByID 根据ID获取CDK
+func (r *sqlCDKRepo) GetByID(id int) (*model.CDK, error) {
+	return scanCDK(r.s.q.QueryRow("SELECT "+cdkColumns+" FROM cdks WHERE id = $1", id))
+}
+
+// Update 更新CDK
+func (r *sqlCDKRepo) Update(cdk *model.CDK) error {
+	return checkAffected(r.s.q.Exec(`
+		UPDATE cdks SET tier_id = $1, code = $2, status = $3, order_id = $4, redeemed_by = $5,
+			redeemed_at = $6, updated_at = $7
+		WHERE id = $8`,
+		cdk.TierID, cdk.Code, cdk.Status, cdk.OrderID, cdk.RedeemedBy, nullTime(cdk.RedeemedAt), cdk.UpdatedAt, cdk.ID,
+	))
+}
+
+// UpdateCode 仅当code仍为oldCode时替换为newCode
+func (r *sqlCDKRepo) UpdateCode(id int, oldCode, newCode string) error {
+	return checkAffected(r.s.q.Exec("UPDATE cdks SET code = $1 WHERE id = $2 AND code = $3", newCode, id, oldCode))
+}
+
+// SetFingerprint 设置CDK的指纹
+func (r *sqlCDKRepo) SetFingerprint(id int, fingerprint string) error {
+	return checkAffected(r.s.q.Exec("UPDATE cdks SET fingerprint = $1 WHERE id = $2", fingerprint, id))
+}
+
+// fingerprintBatchSize 按指纹查找时每条查询的参数个数上限（SQLite默认限制为999个参数）
+const fingerprintBatchSize = 500
+
+// FindByFingerprints 按指纹查找CDK，分批使用 IN 查询
+func (r *sqlCDKRepo) FindByFingerprints(fingerprints []string) ([]model.CDK, error) {
+	found := []model.CDK{}
+	for start := 0; start < len(fingerprints); start += fingerprintBatchSize {
+		batch := fingerprints[start:min(start+fingerprintBatchSize, len(fingerprints))]
+		placeholders := make([]string, len(batch))
+		args := make([]any, len(batch))
+		for i, fp := range batch {
+			placeholders[i] = fmt.Sprintf("$%d", i+1)
+			args[i] = fp
+		}
+		cdks, err := r.queryCDKs("SELECT "+cdkColumns+" FROM cdks WHERE fingerprint <> '' AND fingerprint IN ("+strings.Join(placeholders, ", ")+") ORDER BY id", args...)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, cdks...)
+	}
+	return found, nil
+}
+
+// releasedCond 已放出CDK的查询条件（占位符为now）
+const releasedCond = "(release_at IS NULL OR release_at <= $%d)"
+
+// ClaimNext 原子地取出指定档位的一个已放出的未兑换CDK并标记为已兑换
+// 单条UPDATE完成选取与更新；PostgreSQL通过 FOR UPDATE SKIP LOCKED 让并发请求各自领取不同的行
+func (r *sqlCDKRepo) ClaimNext(tierID, userID int, now time.Time) (*model.CDK, error) {
+	return scanCDK(r.s.q.QueryRow(`
+		UPDATE cdks SET status = $1, redeemed_by = $2, redeemed_at = $3, updated_at = $3
+		WHERE id = (
+			SELECT id FROM cdks WHERE tier_id = $4 AND status = $5 AND `+fmt.Sprintf(releasedCond, 3)+`
+			ORDER BY id LIMIT 1`+r.s.dialect.skipLocked+`
+		)
+		RETURNING `+cdkColumns,
+		model.CDKStatusRedeemed, userID, now, tierID, model.CDKStatusAvailable,
+	))
+}
+
+// LockForOrder 原子地取出订单档位的Quantity个已放出的未兑换CDK，关联到订单并标记为已锁定
+// 在事务中执行，可用数量不足时回滚，保证不会出现只锁定部分CDK的订单
+func (r *sqlCDKRepo) LockForOrder(order *model.Order, now time.Time) ([]model.CDK, error) {
+	var locked []model.CDK
+	err := r.s.Tx(func(tx Store) error {
+		var err error
+		locked, err = (&sqlCDKRepo{tx.(*sqlStore)}).queryCDKs(`
+			UPDATE cdks SET status = $1, order_id = $2, updated_at = $3
+			WHERE id IN (
+				SELECT id FROM cdks WHERE tier_id = $4 AND status = $5 AND `+fmt.Sprintf(releasedCond, 3)+`
+				ORDER BY id LIMIT $6`+r.s.dialect.skipLocked+`
+			)
+			RETURNING `+cdkColumns,
+			model.CDKStatusLocked, order.ID, now, order.TierID, model.CDKStatusAvailable, order.Quantity,
+		)
+		if err != nil {
+			return err
+		}
+		if len(locked) < order.Quantity {
+			return ErrNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(locked, func(i, j int) bool { return locked[i].ID < locked[j].ID })
+	return locked, nil
+}
+
+// ListByOrder 获取订单关联的CDK
+func (r *sqlCDKRepo) ListByOrder(orderID int) ([]model.CDK, error) {
+	return r.queryCDKs("SELECT "+cdkColumns+" FROM cdks WHERE order_id = $1 ORDER BY id", orderID)
+}
+
+// CountAvailableByTier 统计各档位已放出的未兑换CDK数量
+func (r *sqlCDKRepo) CountAvailableByTier(now time.Time) (map[int]int, error) {
+	rows, err := r.s.q.Query(
+		"SELECT tier_id, COUNT(*) FROM cdks WHERE status = $1 AND "+fmt.Sprintf(releasedCond, 2)+" GROUP BY tier_id",
+		model.CDKStatusAvailable, now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[int]int)
+	for rows.Next() {
+		var tierID, count int
+		if err := rows.Scan(&tierID, &count); err != nil {
+			return nil, err
+		}
+		counts[tierID] = count
+	}
+	return counts, rows.Err()
+}
+
+// ScheduleRelease 将档位中ID最大的quantity个已放出的未兑换CDK改为在releaseAt放出
+// 在事务中执行，数量不足时回滚
+func (r *sqlCDKRepo) ScheduleRelease(tierID, quantity int, releaseAt, now time.Time) error {
+	return r.s.Tx(func(tx Store) error {
+		result, err := tx.(*sqlStore).q.Exec(`
+			UPDATE cdks SET release_at = $1, updated_at = $2
+			WHERE id IN (
+				SELECT id FROM cdks WHERE tier_id = $3 AND status = $4 AND `+fmt.Sprintf(releasedCond, 2)+`
+				ORDER BY id DESC LIMIT $5`+r.s.dialect.skipLocked+`
+			)`,
+			releaseAt, now, tierID, model.CDKStatusAvailable, quantity,
+		)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if int(n) < quantity {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// ReleaseNow 立即放出档位中所有尚未放出的未兑换CDK，返回放出的数量
+func (r *sqlCDKRepo) ReleaseNow(tierID int, now time.Time) (int, error) {
+	result, err := r.s.q.Exec(
+		"UPDATE cdks SET release_at = NULL, updated_at = $1 WHERE tier_id = $2 AND status = $3 AND release_at > $1",
+		now, tierID, model.CDKStatusAvailable,
+	)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// PendingReleases 按档位和放出时间分组统计尚未放出的未兑换CDK
+func (r *sqlCDKRepo) PendingReleases(now time.Time) ([]model.CDKRelease, error) {
+	rows, err := r.s.q.Query(`
+		SELECT tier_id, release_at, COUNT(*) FROM cdks
+		WHERE status = $1 AND release_at > $2
+		GROUP BY tier_id, release_at
+		ORDER BY release_at, tier_id`,
+		model.CDKStatusAvailable, now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	releases := []model.CDKRelease{}
+	for rows.Next() {
+		var release model.CDKRelease
+		if err := rows.Scan(&release.TierID, &release.ReleaseAt, &release.Count); err != nil {
+			return nil, err
+		}
+		releases = append(releases, release)
+	}
+	return releases, rows.Err()
+}
@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+)
+
+// sqlLoginAuditRepo 管理员登录审计数据库存储
+type sqlLoginAuditRepo struct {
+	q querier
+}
+
+const loginAuditColumns = "id, username, ip, user_agent, success, reason, created_at"
+
+// Create 创建审计记录
+func (r *sqlLoginAuditRepo) Create(audit *model.LoginAudit) error {
+	return r.q.QueryRow(`
+		INSERT INTO login_audits (username, ip, user_agent, success, reason, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id`,
+		audit.Username, audit.IP, audit.UserAgent, audit.Success, audit.Reason, audit.CreatedAt,
+	).Scan(&audit.ID)
+}
+
+// List 获取最近的limit条审计记录（最新的在前）
+func (r *sqlLoginAuditRepo) List(limit int) ([]model.LoginAudit, error) {
+	rows, err := r.q.Query("SELECT "+loginAuditColumns+" FROM login_audits ORDER BY id DESC LIMIT $1", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	audits := []model.LoginAudit{}
+	for rows.Next() {
+		var a model.LoginAudit
+		if err := rows.Scan(&a.ID, &a.Username, &a.IP, &a.UserAgent, &a.Success, &a.Reason, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		audits = append(audits, a)
+	}
+	return audits, rows.Err()
+}
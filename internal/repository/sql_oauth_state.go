@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+)
+
+// sqlOAuthStateRepo OAuth state数据库存储
+type sqlOAuthStateRepo struct {
+	q querier
+}
+
+// Create 保存state
+func (r *sqlOAuthStateRepo) Create(state *model.OAuthState) error {
+	_, err := r.q.Exec(`
+		INSERT INTO oauth_states (state, code_verifier, created_at, expires_at)
+		VALUES ($1, $2, $3, $4)`,
+		state.State, state.CodeVerifier, state.CreatedAt, state.ExpiresAt,
+	)
+	return err
+}
+
+// Take 取出并删除state（单条DELETE ... RETURNING，并发回调只有一个能取到）
+func (r *sqlOAuthStateRepo) Take(state string, now time.Time) (*model.OAuthState, error) {
+	found := model.OAuthState{State: state}
+	err := r.q.QueryRow(
+		"DELETE FROM oauth_states WHERE state = $1 RETURNING code_verifier, created_at, expires_at", state,
+	).Scan(&found.CodeVerifier, &found.CreatedAt, &found.ExpiresAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if !now.Before(found.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	return &found, nil
+}
+
+// DeleteExpired 删除在before之前已过期的state
+func (r *sqlOAuthStateRepo) DeleteExpired(before time.Time) (int, error) {
+	return rowsAffected(r.q.Exec("DELETE FROM oauth_states WHERE expires_at < $1", before))
+}
@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"database/sql"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+)
+
+// sqlOAuthTokenRepo LinuxDo令牌数据库存储
+type sqlOAuthTokenRepo struct {
+	q querier
+}
+
+const oauthTokenColumns = "user_id, access_token, refresh_token, expires_at, updated_at"
+
+func scanOAuthToken(row interface{ Scan(...any) error }) (*model.OAuthToken, error) {
+	var t model.OAuthToken
+	var expiresAt sql.NullTime
+	err := row.Scan(&t.UserID, &t.AccessToken, &t.RefreshToken, &expiresAt, &t.UpdatedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	t.ExpiresAt = expiresAt.Time
+	return &t, nil
+}
+
+// Save 创建或替换用户的令牌
+func (r *sqlOAuthTokenRepo) Save(token *model.OAuthToken) error {
+	_, err := r.q.Exec(`
+		INSERT INTO oauth_tokens (user_id, access_token, refresh_token, expires_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id) DO UPDATE SET
+			access_token = EXCLUDED.access_token,
+			refresh_token = EXCLUDED.refresh_token,
+			expires_at = EXCLUDED.expires_at,
+			updated_at = EXCLUDED.updated_at`,
+		token.UserID, token.AccessToken, token.RefreshToken, nullTime(token.ExpiresAt), token.UpdatedAt,
+	)
+	return err
+}
+
+// GetByUserID 获取用户的令牌
+func (r *sqlOAuthTokenRepo) GetByUserID(userID int) (*model.OAuthToken, error) {
+	return scanOAuthToken(r.q.QueryRow("SELECT "+oauthTokenColumns+" FROM oauth_tokens WHERE user_id = $1", userID))
+}
+
+// List 获取所有令牌
+func (r *sqlOAuthTokenRepo) List() ([]model.OAuthToken, error) {
+	rows, err := r.q.Query("SELECT " + oauthTokenColumns + " FROM oauth_tokens ORDER BY user_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []model.OAuthToken{}
+	for rows.Next() {
+		token, err := scanOAuthToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *token)
+	}
+	return tokens, rows.Err()
+}
+
+// UpdateCiphertexts 仅当两个令牌的密文仍为旧值时替换为新密文
+func (r *sqlOAuthTokenRepo) UpdateCiphertexts(userID int, oldAccess, oldRefresh, newAccess, newRefresh string) error {
+	return checkAffected(r.q.Exec(
+		"UPDATE oauth_tokens SET access_token = $1, refresh_token = $2 WHERE user_id = $3 AND access_token = $4 AND refresh_token = $5",
+		newAccess, newRefresh, userID, oldAccess, oldRefresh,
+	))
+}
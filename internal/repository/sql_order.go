@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+)
+
+// sqlOrderRepo 订单数据库存储
+type sqlOrderRepo struct {
+	q querier
+}
+
+const orderColumns = "id, order_no, user_id, tier_id, quantity, total_quota, total_price, status, created_at, updated_at, paid_at, expired_at"
+
+func scanOrder(row interface{ Scan(...any) error }) (*model.Order, error) {
+	var o model.Order
+	var paidAt, expiredAt sql.NullTime
+	err := row.Scan(&o.ID, &o.OrderNo, &o.UserID, &o.TierID, &o.Quantity, &o.TotalQuota, &o.TotalPrice, &o.Status, &o.CreatedAt, &o.UpdatedAt, &paidAt, &expiredAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	o.PaidAt = paidAt.Time
+	o.ExpiredAt = expiredAt.Time
+	return &o, nil
+}
+
+// Create 创建订单
+func (r *sqlOrderRepo) Create(order *model.Order) error {
+	return r.q.QueryRow(`
+		INSERT INTO orders (order_no, user_id, tier_id, quantity, total_quota, total_price, status, created_at, updated_at, paid_at, expired_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id`,
+		order.OrderNo, order.UserID, order.TierID, order.Quantity, order.TotalQuota, order.TotalPrice, order.Status,
+		order.CreatedAt, order.UpdatedAt, nullTime(order.PaidAt), nullTime(order.ExpiredAt),
+	).Scan(&order.ID)
+}
+
+// GetByID 根据ID获取订单
+func (r *sqlOrderRepo) GetByID(id int) (*model.Order, error) {
+	return scanOrder(r.q.QueryRow("SELECT "+orderColumns+" FROM orders WHERE id = $1", id))
+}
+
+// GetByOrderNo 根据订单号获取订单
+func (r *sqlOrderRepo) GetByOrderNo(orderNo string) (*model.Order, error) {
+	return scanOrder(r.q.QueryRow("SELECT "+orderColumns+" FROM orders WHERE order_no = $1", orderNo))
+}
+
+// Update 更新订单
+func (r *sqlOrderRepo) Update(order *model.Order) error {
+	return checkAffected(r.q.Exec(`
+		UPDATE orders SET user_id = $1, tier_id = $2, quantity = $3, total_quota = $4, total_price = $5, status = $6,
+			updated_at = $7, paid_at = $8, expired_at = $9
+		WHERE id = $10`,
+		order.UserID, order.TierID, order.Quantity, order.TotalQuota, order.TotalPrice, order.Status,
+		order.UpdatedAt, nullTime(order.PaidAt), nullTime(order.ExpiredAt), order.ID,
+	))
+}
+
+// UpdateIfStatus 仅当订单当前状态为status时才更新
+// PostgreSQL中并发的状态流转会在该行上排队，后到的事务重新检查条件后不再命中
+func (r *sqlOrderRepo) UpdateIfStatus(order *model.Order, status int) error {
+	return checkAffected(r.q.Exec(`
+		UPDATE orders SET user_id = $1, tier_id = $2, quantity = $3, total_quota = $4, total_price = $5, status = $6,
+			updated_at = $7, paid_at = $8, expired_at = $9
+		WHERE id = $10 AND status = $11`,
+		order.UserID, order.TierID, order.Quantity, order.TotalQuota, order.TotalPrice, order.Status,
+		order.UpdatedAt, nullTime(order.PaidAt), nullTime(order.ExpiredAt), order.ID, status,
+	))
+}
+
+// List 按条件获取订单列表（最新的在前）
+func (r *sqlOrderRepo) List(filter OrderFilter) ([]model.Order, error) {
+	conds := []string{}
+	args := []any{}
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	if filter.UserID != nil {
+		add("user_id = $%d", *filter.UserID)
+	}
+	if filter.TierID != nil {
+		add("tier_id = $%d", *filter.TierID)
+	}
+	if filter.Status != nil {
+		add("status = $%d", *filter.Status)
+	}
+	if !filter.From.IsZero() {
+		add("created_at >= $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		add("created_at < $%d", filter.To)
+	}
+
+	query := "SELECT " + orderColumns + " FROM orders"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	rows, err := r.q.Query(query+" ORDER BY id DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []model.Order{}
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, *order)
+	}
+	return orders, rows.Err()
+}
@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"database/sql"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+)
+
+// sqlPaymentRepo 支付记录数据库存储
+type sqlPaymentRepo struct {
+	q querier
+}
+
+const paymentColumns = "id, order_id, user_id, amount, payment_type, status, transaction_id, fail_reason, created_at, finished_at"
+
+func scanPayment(row interface{ Scan(...any) error }) (*model.Payment, error) {
+	var p model.Payment
+	var finishedAt sql.NullTime
+	err := row.Scan(&p.ID, &p.OrderID, &p.UserID, &p.Amount, &p.PaymentType, &p.Status, &p.TransactionID, &p.FailReason, &p.CreatedAt, &finishedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	p.FinishedAt = finishedAt.Time
+	return &p, nil
+}
+
+// Create 创建支付记录
+func (r *sqlPaymentRepo) Create(payment *model.Payment) error {
+	return r.q.QueryRow(`
+		INSERT INTO payments (order_id, user_id, amount, payment_type, status, transaction_id, fail_reason, created_at, finished_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id`,
+		payment.OrderID, payment.UserID, payment.Amount, payment.PaymentType, payment.Status,
+		payment.TransactionID, payment.FailReason, payment.CreatedAt, nullTime(payment.FinishedAt),
+	).Scan(&payment.ID)
+}
+
+// Update 更新支付记录
+func (r *sqlPaymentRepo) Update(payment *model.Payment) error {
+	return checkAffected(r.q.Exec(`
+		UPDATE payments SET order_id = $1, user_id = $2, amount = $3, payment_type = $4, status = $5,
+			transaction_id = $6, fail_reason = $7, finished_at = $8
+		WHERE id = $9`,
+		payment.OrderID, payment.UserID, payment.Amount, payment.PaymentType, payment.Status,
+		payment.TransactionID, payment.FailReason, nullTime(payment.FinishedAt), payment.ID,
+	))
+}
+
+// ListByOrder 获取订单的支付记录（最早的在前）
+func (r *sqlPaymentRepo) ListByOrder(orderID int) ([]model.Payment, error) {
+	return r.list("SELECT "+paymentColumns+" FROM payments WHERE order_id = $1 ORDER BY id", orderID)
+}
+
+// List 获取支付记录（最新的在前）
+func (r *sqlPaymentRepo) List(status *int) ([]model.Payment, error) {
+	if status != nil {
+		return r.list("SELECT "+paymentColumns+" FROM payments WHERE status = $1 ORDER BY id DESC", *status)
+	}
+	return r.list("SELECT " + paymentColumns + " FROM payments ORDER BY id DESC")
+}
+
+func (r *sqlPaymentRepo) list(query string, args ...any) ([]model.Payment, error) {
+	rows, err := r.q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []model.Payment{}
+	for rows.Next() {
+		payment, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, *payment)
+	}
+	return payments, rows.Err()
+}
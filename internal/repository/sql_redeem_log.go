@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+)
+
+// sqlRedeemLogRepo 兑换记录数据库存储
+type sqlRedeemLogRepo struct {
+	q querier
+}
+
+const redeemLogColumns = "id, user_id, cdk_id, tier_id, created_at"
+
+// queryRedeemLogs 执行查询并扫描兑换记录列表
+func (r *sqlRedeemLogRepo) queryRedeemLogs(query string, args ...any) ([]model.RedeemLog, error) {
+	rows, err := r.q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	logs := []model.RedeemLog{}
+	for rows.Next() {
+		var l model.RedeemLog
+		if err := rows.Scan(&l.ID, &l.UserID, &l.CDKID, &l.TierID, &l.CreatedAt); err != nil {
+			return nil, err
+		}
+		logs = append(logs, l)
+	}
+	return logs, rows.Err()
+}
+
+// Create 创建兑换记录
+func (r *sqlRedeemLogRepo) Create(log *model.RedeemLog) error {
+	return r.q.QueryRow(
+		"INSERT INTO redeem_logs (user_id, cdk_id, tier_id, created_at) VALUES ($1, $2, $3, $4) RETURNING id",
+		log.UserID, log.CDKID, log.TierID, log.CreatedAt,
+	).Scan(&log.ID)
+}
+
+// ListByUser 获取用户的兑换记录
+func (r *sqlRedeemLogRepo) ListByUser(userID int) ([]model.RedeemLog, error) {
+	return r.queryRedeemLogs("SELECT "+redeemLogColumns+" FROM redeem_logs WHERE user_id = $1 ORDER BY id", userID)
+}
+
+// CountByUserTier 统计用户在[from, to)时间内兑换该档位的数量
+func (r *sqlRedeemLogRepo) CountByUserTier(userID, tierID int, from, to time.Time) (int, error) {
+	var count int
+	err := r.q.QueryRow(
+		"SELECT COUNT(*) FROM redeem_logs WHERE user_id = $1 AND tier_id = $2 AND created_at >= $3 AND created_at < $4",
+		userID, tierID, from, to,
+	).Scan(&count)
+	return count, err
+}
+
+// List 获取所有兑换记录
+func (r *sqlRedeemLogRepo) List() ([]model.RedeemLog, error) {
+	return r.queryRedeemLogs("SELECT " + redeemLogColumns + " FROM redeem_logs ORDER BY id")
+}
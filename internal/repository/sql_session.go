@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+)
+
+// sqlSessionRepo 登录会话数据库存储
+type sqlSessionRepo struct {
+	q querier
+}
+
+const sessionColumns = "id, user_id, refresh_hash, created_at, refreshed_at, expires_at, revoked_at"
+
+func scanSession(row interface{ Scan(...any) error }) (*model.Session, error) {
+	var s model.Session
+	var revokedAt sql.NullTime
+	err := row.Scan(&s.ID, &s.UserID, &s.RefreshHash, &s.CreatedAt, &s.RefreshedAt, &s.ExpiresAt, &revokedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	s.RevokedAt = revokedAt.Time
+	return &s, nil
+}
+
+// Create 创建会话
+func (r *sqlSessionRepo) Create(session *model.Session) error {
+	_, err := r.q.Exec(`
+		INSERT INTO sessions (id, user_id, refresh_hash, created_at, refreshed_at, expires_at, revoked_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		session.ID, session.UserID, session.RefreshHash, session.CreatedAt, session.RefreshedAt,
+		session.ExpiresAt, nullTime(session.RevokedAt),
+	)
+	return err
+}
+
+// GetByID 根据ID获取会话
+func (r *sqlSessionRepo) GetByID(id string) (*model.Session, error) {
+	return scanSession(r.q.QueryRow("SELECT "+sessionColumns+" FROM sessions WHERE id = $1", id))
+}
+
+// Rotate 仅当会话未撤销且refresh token哈希仍为oldHash时更新（单条UPDATE完成比较与替换）
+func (r *sqlSessionRepo) Rotate(session *model.Session, oldHash string) error {
+	return checkAffected(r.q.Exec(`
+		UPDATE sessions SET refresh_hash = $1, refreshed_at = $2, expires_at = $3
+		WHERE id = $4 AND refresh_hash = $5 AND revoked_at IS NULL`,
+		session.RefreshHash, session.RefreshedAt, session.ExpiresAt, session.ID, oldHash,
+	))
+}
+
+// Revoke 撤销会话
+func (r *sqlSessionRepo) Revoke(id string, now time.Time) error {
+	return checkAffected(r.q.Exec(
+		"UPDATE sessions SET revoked_at = COALESCE(revoked_at, $1) WHERE id = $2", now, id,
+	))
+}
+
+// RevokeByUser 撤销用户所有未撤销的会话
+func (r *sqlSessionRepo) RevokeByUser(userID int, now time.Time) (int, error) {
+	return rowsAffected(r.q.Exec(
+		"UPDATE sessions SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL", now, userID,
+	))
+}
+
+// DeleteExpired 删除在before之前已过期的会话
+func (r *sqlSessionRepo) DeleteExpired(before time.Time) (int, error) {
+	return rowsAffected(r.q.Exec("DELETE FROM sessions WHERE expires_at < $1", before))
+}
@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+)
+
+// settingsID 系统设置表中唯一一条记录的ID
+const settingsID = 1
+
+// sqlSettingsRepo 系统设置数据库存储
+type sqlSettingsRepo struct {
+	q querier
+}
+
+// Get 获取系统设置
+func (r *sqlSettingsRepo) Get() (*model.Settings, error) {
+	var s model.Settings
+	err := r.q.QueryRow(`
+		SELECT global_enabled, announcement, order_expire_minutes, version, updated_at, updated_by
+		FROM settings WHERE id = $1`, settingsID,
+	).Scan(&s.GlobalEnabled, &s.Announcement, &s.OrderExpireMinutes, &s.Version, &s.UpdatedAt, &s.UpdatedBy)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &s, nil
+}
+
+// Save 仅当版本号未变化时保存（单条语句完成比较与替换），成功后版本号加1
+func (r *sqlSettingsRepo) Save(settings *model.Settings) error {
+	var err error
+	if settings.Version == 0 {
+		err = checkAffected(r.q.Exec(`
+			INSERT INTO settings (id, global_enabled, announcement, order_expire_minutes, version, updated_at, updated_by)
+			VALUES ($1, $2, $3, $4, 1, $5, $6)
+			ON CONFLICT (id) DO NOTHING`,
+			settingsID, settings.GlobalEnabled, settings.Announcement, settings.OrderExpireMinutes, settings.UpdatedAt, settings.UpdatedBy,
+		))
+	} else {
+		err = checkAffected(r.q.Exec(`
+			UPDATE settings SET
+				global_enabled = $1, announcement = $2, order_expire_minutes = $3,
+				version = version + 1, updated_at = $4, updated_by = $5
+			WHERE id = $6 AND version = $7`,
+			settings.GlobalEnabled, settings.Announcement, settings.OrderExpireMinutes, settings.UpdatedAt, settings.UpdatedBy,
+			settingsID, settings.Version,
+		))
+	}
+	if err != nil {
+		return err
+	}
+	settings.Version++
+	return nil
+}
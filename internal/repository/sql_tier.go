@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"database/sql"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+)
+
+// sqlTierRepo 档位数据库存储
+type sqlTierRepo struct {
+	q querier
+}
+
+const tierColumns = "id, name, quota, price, required_level, daily_limit, stock, is_active, sort_order, created_at, updated_at, starts_at, ends_at"
+
+func scanTier(row interface{ Scan(...any) error }) (*model.Tier, error) {
+	var t model.Tier
+	var startsAt, endsAt sql.NullTime
+	err := row.Scan(&t.ID, &t.Name, &t.Quota, &t.Price, &t.RequiredLevel, &t.DailyLimit, &t.Stock, &t.IsActive, &t.SortOrder, &t.CreatedAt, &t.UpdatedAt, &startsAt, &endsAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	t.StartsAt = startsAt.Time
+	t.EndsAt = endsAt.Time
+	return &t, nil
+}
+
+// List 获取所有档位
+func (r *sqlTierRepo) List() ([]model.Tier, error) {
+	rows, err := r.q.Query("SELECT " + tierColumns + " FROM tiers ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tiers := []model.Tier{}
+	for rows.Next() {
+		tier, err := scanTier(rows)
+		if err != nil {
+			return nil, err
+		}
+		tiers = append(tiers, *tier)
+	}
+	return tiers, rows.Err()
+}
+
+// GetByID 根据ID获取档位
+func (r *sqlTierRepo) GetByID(id int) (*model.Tier, error) {
+	return scanTier(r.q.QueryRow("SELECT "+tierColumns+" FROM tiers WHERE id = $1", id))
+}
+
+// Create 创建档位
+func (r *sqlTierRepo) Create(tier *model.Tier) error {
+	return r.q.QueryRow(`
+		INSERT INTO tiers (name, quota, price, required_level, daily_limit, stock, is_active, sort_order, created_at, updated_at, starts_at, ends_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id`,
+		tier.Name, tier.Quota, tier.Price, tier.RequiredLevel, tier.DailyLimit, tier.Stock, tier.IsActive, tier.SortOrder, tier.CreatedAt, tier.UpdatedAt,
+		nullTime(tier.StartsAt), nullTime(tier.EndsAt),
+	).Scan(&tier.ID)
+}
+
+// Update 更新档位
+func (r *sqlTierRepo) Update(tier *model.Tier) error {
+	return checkAffected(r.q.Exec(`
+		UPDATE tiers SET name = $1, quota = $2, price = $3, required_level = $4, daily_limit = $5, stock = $6,
+			is_active = $7, sort_order = $8, updated_at = $9, starts_at = $10, ends_at = $11
+		WHERE id = $12`,
+		tier.Name, tier.Quota, tier.Price, tier.RequiredLevel, tier.DailyLimit, tier.Stock, tier.IsActive, tier.SortOrder, tier.UpdatedAt,
+		nullTime(tier.StartsAt), nullTime(tier.EndsAt), tier.ID,
+	))
+}
+
+// Delete 删除档位
+func (r *sqlTierRepo) Delete(id int) error {
+	return checkAffected(r.q.Exec("DELETE FROM tiers WHERE id = $1", id))
+}
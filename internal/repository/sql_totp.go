@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+)
+
+// sqlTOTPRepo 两步验证数据库存储
+type sqlTOTPRepo struct {
+	s *sqlStore
+}
+
+const totpColumns = "user_id, secret, last_step, created_at, enabled_at"
+
+func scanTOTP(row interface{ Scan(...any) error }) (*model.TOTP, error) {
+	var t model.TOTP
+	var enabledAt sql.NullTime
+	err := row.Scan(&t.UserID, &t.Secret, &t.LastStep, &t.CreatedAt, &enabledAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	t.EnabledAt = enabledAt.Time
+	return &t, nil
+}
+
+// Get 获取用户的两步验证设置
+func (r *sqlTOTPRepo) Get(userID int) (*model.TOTP, error) {
+	return scanTOTP(r.s.q.QueryRow("SELECT "+totpColumns+" FROM totps WHERE user_id = $1", userID))
+}
+
+// List 获取所有两步验证设置
+func (r *sqlTOTPRepo) List() ([]model.TOTP, error) {
+	rows, err := r.s.q.Query("SELECT " + totpColumns + " FROM totps ORDER BY user_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totps := []model.TOTP{}
+	for rows.Next() {
+		totp, err := scanTOTP(rows)
+		if err != nil {
+			return nil, err
+		}
+		totps = append(totps, *totp)
+	}
+	return totps, rows.Err()
+}
+
+// UpdateSecret 仅当密钥密文仍为oldSecret时替换为newSecret
+func (r *sqlTOTPRepo) UpdateSecret(userID int, oldSecret, newSecret string) error {
+	return checkAffected(r.s.q.Exec("UPDATE totps SET secret = $1 WHERE user_id = $2 AND secret = $3", newSecret, userID, oldSecret))
+}
+
+// Save 创建或替换用户的两步验证设置，同时删除旧的恢复码
+func (r *sqlTOTPRepo) Save(totp *model.TOTP) error {
+	return r.s.Tx(func(tx Store) error {
+		q := tx.(*sqlStore).q
+		if _, err := q.Exec("DELETE FROM recovery_codes WHERE user_id = $1", totp.UserID); err != nil {
+			return err
+		}
+		_, err := q.Exec(`
+			INSERT INTO totps (user_id, secret, last_step, created_at, enabled_at)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (user_id) DO UPDATE SET
+				secret = EXCLUDED.secret,
+				last_step = EXCLUDED.last_step,
+				created_at = EXCLUDED.created_at,
+				enabled_at = EXCLUDED.enabled_at`,
+			totp.UserID, totp.Secret, totp.LastStep, totp.CreatedAt, nullTime(totp.EnabledAt),
+		)
+		return err
+	})
+}
+
+// Enable 启用尚未启用的两步验证并替换恢复码
+func (r *sqlTOTPRepo) Enable(userID int, enabledAt time.Time, codeHashes []string) error {
+	return r.s.Tx(func(tx Store) error {
+		q := tx.(*sqlStore).q
+		err := checkAffected(q.Exec(
+			"UPDATE totps SET enabled_at = $1 WHERE user_id = $2 AND enabled_at IS NULL", enabledAt, userID,
+		))
+		if err != nil {
+			return err
+		}
+		if _, err := q.Exec("DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+			return err
+		}
+		for _, hash := range codeHashes {
+			if _, err := q.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)", userID, hash); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// UseStep 仅当step大于上次使用的时间步时更新（单条UPDATE完成比较与替换）
+func (r *sqlTOTPRepo) UseStep(userID int, step int64) error {
+	return checkAffected(r.s.q.Exec(
+		"UPDATE totps SET last_step = $1 WHERE user_id = $2 AND last_step < $1", step, userID,
+	))
+}
+
+// UseRecoveryCode 使用一条未使用的恢复码
+func (r *sqlTOTPRepo) UseRecoveryCode(userID int, codeHash string, usedAt time.Time) error {
+	return checkAffected(r.s.q.Exec(`
+		UPDATE recovery_codes SET used_at = $1
+		WHERE id = (SELECT MIN(id) FROM recovery_codes WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL)
+			AND used_at IS NULL`,
+		usedAt, userID, codeHash,
+	))
+}
+
+// CountRecoveryCodes 统计未使用的恢复码数量
+func (r *sqlTOTPRepo) CountRecoveryCodes(userID int) (int, error) {
+	var n int
+	err := r.s.q.QueryRow(
+		"SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL", userID,
+	).Scan(&n)
+	return n, err
+}
+
+// Delete 删除两步验证设置和恢复码
+func (r *sqlTOTPRepo) Delete(userID int) error {
+	return r.s.Tx(func(tx Store) error {
+		q := tx.(*sqlStore).q
+		if _, err := q.Exec("DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+			return err
+		}
+		_, err := q.Exec("DELETE FROM totps WHERE user_id = $1", userID)
+		return err
+	})
+}
@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+)
+
+// sqlUserRepo 用户数据库存储
+type sqlUserRepo struct {
+	q         querier
+	forUpdate string
+}
+
+const userColumns = "id, linux_do_id, username, name, trust_level, is_admin, role, created_at, updated_at"
+
+func scanUser(row interface{ Scan(...any) error }) (*model.User, error) {
+	var u model.User
+	err := row.Scan(&u.ID, &u.LinuxDoID, &u.Username, &u.Name, &u.TrustLevel, &u.IsAdmin, &u.Role, &u.CreatedAt, &u.UpdatedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &u, nil
+}
+
+// Upsert 按LinuxDoID创建或更新用户
+func (r *sqlUserRepo) Upsert(user *model.User) error {
+	return r.q.QueryRow(`
+		INSERT INTO users (linux_do_id, username, name, trust_level, is_admin, role, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (linux_do_id) DO UPDATE SET
+			username = EXCLUDED.username,
+			name = EXCLUDED.name,
+			trust_level = EXCLUDED.trust_level,
+			is_admin = EXCLUDED.is_admin,
+			role = EXCLUDED.role,
+			updated_at = EXCLUDED.updated_at
+		RETURNING id, created_at`,
+		user.LinuxDoID, user.Username, user.Name, user.TrustLevel, user.IsAdmin, user.Role, user.CreatedAt, user.UpdatedAt,
+	).Scan(&user.ID, &user.CreatedAt)
+}
+
+// GetByID 根据ID获取用户
+func (r *sqlUserRepo) GetByID(id int) (*model.User, error) {
+	return scanUser(r.q.QueryRow("SELECT "+userColumns+" FROM users WHERE id = $1", id))
+}
+
+// LockByID 获取用户并锁定该行直到事务结束
+func (r *sqlUserRepo) LockByID(id int) (*model.User, error) {
+	return scanUser(r.q.QueryRow("SELECT "+userColumns+" FROM users WHERE id = $1"+r.forUpdate, id))
+}
+
+// GetByLinuxDoID 根据LinuxDo ID获取用户
+func (r *sqlUserRepo) GetByLinuxDoID(linuxDoID int) (*model.User, error) {
+	return scanUser(r.q.QueryRow("SELECT "+userColumns+" FROM users WHERE linux_do_id = $1", linuxDoID))
+}
+
+// UpdateTrustLevel 只更新用户的信任等级
+func (r *sqlUserRepo) UpdateTrustLevel(id, trustLevel int, updatedAt time.Time) error {
+	return checkAffected(r.q.Exec("UPDATE users SET trust_level = $1, updated_at = $2 WHERE id = $3", trustLevel, updatedAt, id))
+}
+
+// ListAdmins 列出拥有管理员角色的用户
+func (r *sqlUserRepo) ListAdmins() ([]model.User, error) {
+	rows, err := r.q.Query("SELECT " + userColumns + " FROM users WHERE role <> '' ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []model.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	return users, rows.Err()
+}
+
+// UpdateRole 只更新用户的管理员角色
+func (r *sqlUserRepo) UpdateRole(id int, role string, updatedAt time.Time) error {
+	return checkAffected(r.q.Exec(
+		"UPDATE users SET role = $1, is_admin = $2, updated_at = $3 WHERE id = $4", role, role != "", updatedAt, id,
+	))
+}
@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	_ "modernc.org/sqlite" // 纯Go实现的SQLite驱动，无需CGO
+)
+
+// sqliteDialect SQLite方言（sqlite模式）
+var sqliteDialect = dialect{
+	name:          "sqlite",
+	migrationDir:  "migrations/sqlite",
+	timestampType: "DATETIME",
+}
+
+// OpenSQLiteDB 打开SQLite数据库文件（不执行迁移），目录不存在时自动创建
+func OpenSQLiteDB(path string) (*sql.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("创建数据库目录失败: %v", err)
+	}
+
+	// WAL模式提升并发读；写事务使用IMMEDIATE在开始时即获取写锁，避免并发升级锁时死锁
+	// 时间按SQLite标准格式写入（不含Go的单调时钟读数），配合UTC参数可直接按文本比较
+	dsn := "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)&_txlock=immediate&_time_format=sqlite"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("打开数据库失败: %v", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("连接数据库失败: %v", err)
+	}
+	return db, nil
+}
+
+// OpenSQLite 打开SQLite数据库并执行未应用的迁移
+func OpenSQLite(path string) (Store, error) {
+	db, err := OpenSQLiteDB(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrateOnOpen(db, sqliteDialect); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return newSQLStore(db, sqliteDialect), nil
+}
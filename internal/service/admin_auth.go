@@ -0,0 +1,185 @@
+package service
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/config"
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
+	"github.com/zhongruan0522/DuiDuiMao/internal/util"
+)
+
+// ErrInvalidCredentials 管理员账号或密码错误
+var ErrInvalidCredentials = errors.New("账号或密码错误")
+
+// LoginLockedError 登录失败次数过多，暂时锁定
+type LoginLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginLockedError) Error() string {
+	return fmt.Sprintf("登录失败次数过多，请在%d秒后重试", e.Seconds())
+}
+
+// Seconds 剩余锁定秒数（向上取整）
+func (e *LoginLockedError) Seconds() int {
+	return int(math.Ceil(e.RetryAfter.Seconds()))
+}
+
+// 审计记录中用户名和User-Agent的最大长度，避免恶意请求写入超长数据
+const (
+	maxAuditUsername  = 64
+	maxAuditUserAgent = 256
+)
+
+// AdminAuthService 管理员账密登录服务：校验密码哈希和两步验证码，按IP和用户名限流，并记录审计日志
+type AdminAuthService struct {
+	mu      sync.RWMutex // 保护cfg（重新加载配置时更新）
+	cfg     config.AdminConfig
+	users   *UserService
+	limiter *LoginLimiter
+	store   repository.Store
+	cipher  *util.CodeCipher // 两步验证密钥与CDK使用同一套密钥加密存储
+	now     Clock
+}
+
+// NewAdminAuthService 创建管理员账密登录服务
+func NewAdminAuthService(cfg config.AdminConfig, users *UserService, limiter *LoginLimiter, store repository.Store, cipher *util.CodeCipher, clock Clock) *AdminAuthService {
+	return &AdminAuthService{cfg: cfg, users: users, limiter: limiter, store: store, cipher: cipher, now: clock}
+}
+
+// SetConfig 更新管理员账号密码（重新加载配置后调用）
+func (s *AdminAuthService) SetConfig(cfg config.AdminConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cfg = cfg
+}
+
+// adminConfig 当前的管理员配置
+func (s *AdminAuthService) adminConfig() config.AdminConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cfg
+}
+
+// LoginAttempt 一次管理员登录尝试
+type LoginAttempt struct {
+	Username  string
+	Password  string
+	Code      string // 两步验证码或恢复码，未启用两步验证时忽略
+	IP        string
+	UserAgent string
+}
+
+// limiterKeys 同时按IP和用户名限流
+func limiterKeys(attempt LoginAttempt) []string {
+	return []string{"ip:" + attempt.IP, "user:" + attempt.Username}
+}
+
+// Login 校验管理员账号密码（启用两步验证时还要校验验证码），成功后返回管理员用户
+// 失败次数过多时返回*LoginLockedError，账号或密码错误返回ErrInvalidCredentials，
+// 密码正确但未提供验证码时返回ErrTOTPRequired，验证码错误返回ErrInvalidTOTPCode
+func (s *AdminAuthService) Login(attempt LoginAttempt) (*model.User, error) {
+	keys := limiterKeys(attempt)
+	if wait := s.limiter.Wait(keys...); wait > 0 {
+		s.audit(attempt, false, "已锁定")
+		return nil, &LoginLockedError{RetryAfter: wait}
+	}
+
+	// 用户名不匹配时也校验密码，避免通过响应时间判断用户名是否正确
+	cfg := s.adminConfig()
+	usernameOK := subtle.ConstantTimeCompare([]byte(attempt.Username), []byte(cfg.Username)) == 1
+	passwordOK := util.CheckPassword(cfg.Password, attempt.Password)
+	if !usernameOK || !passwordOK {
+		wait := s.limiter.Fail(keys...)
+		reason := "密码错误"
+		if !usernameOK {
+			reason = "用户名错误"
+		}
+		if wait > 0 {
+			reason += fmt.Sprintf("，锁定%d秒", (&LoginLockedError{RetryAfter: wait}).Seconds())
+		}
+		s.audit(attempt, false, reason)
+		return nil, ErrInvalidCredentials
+	}
+
+	user, err := s.users.CreateOrUpdateUser(
+		0,                    // 管理员没有LinuxDoID，使用0
+		cfg.Username,         // 使用配置的管理员用户名
+		"管理员",                // 昵称
+		4,                    // 最高信任等级
+		model.RoleSuperAdmin, // 账密登录的管理员为超级管理员
+	)
+	if err != nil {
+		return nil, fmt.Errorf("创建用户记录失败: %v", err)
+	}
+
+	reason := ""
+	totp, err := s.store.TOTPs().Get(user.ID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return nil, err
+	}
+	if err == nil && totp.Enabled() {
+		if attempt.Code == "" {
+			s.audit(attempt, false, "等待两步验证")
+			return nil, ErrTOTPRequired
+		}
+		recovery, err := s.verifySecondFactor(totp, attempt.Code)
+		if errors.Is(err, ErrInvalidTOTPCode) {
+			reason := "两步验证码错误"
+			if wait := s.limiter.Fail(keys...); wait > 0 {
+				reason += fmt.Sprintf("，锁定%d秒", (&LoginLockedError{RetryAfter: wait}).Seconds())
+			}
+			s.audit(attempt, false, reason)
+			return nil, err
+		}
+		if err != nil {
+			return nil, err
+		}
+		if recovery {
+			reason = "使用恢复码"
+		}
+	}
+
+	s.limiter.Reset(keys...)
+	s.audit(attempt, true, reason)
+	return user, nil
+}
+
+// audit 记录审计日志（写入失败只打印日志，不影响登录结果）
+func (s *AdminAuthService) audit(attempt LoginAttempt, success bool, reason string) {
+	entry := &model.LoginAudit{
+		Username:  truncate(attempt.Username, maxAuditUsername),
+		IP:        attempt.IP,
+		UserAgent: truncate(attempt.UserAgent, maxAuditUserAgent),
+		Success:   success,
+		Reason:    reason,
+		CreatedAt: s.now(),
+	}
+	if err := s.store.LoginAudits().Create(entry); err != nil {
+		log.Printf("记录管理员登录审计失败: %v", err)
+	}
+	if !success {
+		log.Printf("管理员登录失败: 用户名=%q IP=%s 原因=%s", entry.Username, entry.IP, reason)
+	}
+}
+
+// truncate 按字符截断字符串
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max])
+}
+
+// ListAudits 获取最近的limit条登录审计记录
+func (s *AdminAuthService) ListAudits(limit int) ([]model.LoginAudit, error) {
+	return s.store.LoginAudits().List(limit)
+}
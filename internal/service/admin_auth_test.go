@@ -0,0 +1,290 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/config"
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
+	"github.com/zhongruan0522/DuiDuiMao/internal/util"
+)
+
+func TestLoginLimiter(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewLoginLimiter(2, time.Second, 5*time.Second, clock.Now)
+
+	// 前2次失败不锁定，之后锁定1s、2s、4s，最长5s
+	wants := []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, want := range wants {
+		if got := limiter.Fail("ip:1"); got != want {
+			t.Errorf("第%d次失败锁定 = %v, want %v", i+1, got, want)
+		}
+	}
+	if got := limiter.Wait("ip:1", "user:root"); got != 5*time.Second {
+		t.Errorf("Wait() = %v, want 5s", got)
+	}
+	if got := limiter.Wait("ip:2"); got != 0 {
+		t.Errorf("Wait(其他IP) = %v, want 0", got)
+	}
+	clock.Advance(5 * time.Second)
+	if got := limiter.Wait("ip:1"); got != 0 {
+		t.Errorf("锁定到期后 Wait() = %v, want 0", got)
+	}
+
+	// 长时间没有失败后重新计数
+	clock.Advance(time.Minute)
+	limiter.Fail("ip:2")
+	if got := limiter.Fail("ip:1"); got != 0 {
+		t.Errorf("重新计数后第1次失败锁定 = %v, want 0", got)
+	}
+
+	limiter.Reset("ip:1")
+	limiter.Fail("ip:1")
+	if got := limiter.Fail("ip:1"); got != 0 {
+		t.Errorf("Reset() 后第2次失败锁定 = %v, want 0", got)
+	}
+}
+
+func TestAdminLogin(t *testing.T) {
+	hash, err := util.HashPassword("s3cret")
+	if err != nil {
+		t.Fatalf("HashPassword() error = %v", err)
+	}
+	cfg := config.AdminConfig{Username: "root", Password: hash}
+
+	forEachStore(t, func(t *testing.T, store repository.Store) {
+		clock := newFakeClock()
+		limiter := NewLoginLimiter(2, time.Second, time.Minute, clock.Now)
+		auth := NewAdminAuthService(cfg, NewUserService(store.Users(), cfg), limiter, store, testCipher, clock.Now)
+		attempt := func(username, password, ip string) error {
+			_, err := auth.Login(LoginAttempt{Username: username, Password: password, IP: ip, UserAgent: "test"})
+			return err
+		}
+
+		// 同一IP连续失败：超过2次后被锁定
+		for i := 0; i < 3; i++ {
+			if err := attempt("root", "wrong", "10.0.0.1"); !errors.Is(err, ErrInvalidCredentials) {
+				t.Fatalf("第%d次密码错误 error = %v, want ErrInvalidCredentials", i+1, err)
+			}
+		}
+		var locked *LoginLockedError
+		if err := attempt("root", "s3cret", "10.0.0.1"); !errors.As(err, &locked) || locked.Seconds() != 1 {
+			t.Fatalf("锁定期间登录 error = %v, want 锁定1秒", err)
+		}
+
+		// 按用户名限流：换IP也处于锁定状态
+		if err := attempt("root", "s3cret", "10.0.0.2"); !errors.As(err, &locked) {
+			t.Errorf("换IP登录 error = %v, want LoginLockedError", err)
+		}
+		// 其他用户名、其他IP不受影响
+		if err := attempt("admin", "s3cret", "10.0.0.3"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("其他用户名 error = %v, want ErrInvalidCredentials", err)
+		}
+
+		// 锁定时间指数增长
+		clock.Advance(time.Second)
+		if err := attempt("root", "wrong", "10.0.0.1"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("锁定到期后密码错误 error = %v", err)
+		}
+		if err := attempt("root", "s3cret", "10.0.0.1"); !errors.As(err, &locked) || locked.Seconds() != 2 {
+			t.Errorf("第4次失败后 error = %v, want 锁定2秒", err)
+		}
+
+		// 锁定到期后登录成功，失败记录被清除
+		clock.Advance(2 * time.Second)
+		user, err := auth.Login(LoginAttempt{Username: "root", Password: "s3cret", IP: "10.0.0.1", UserAgent: "test"})
+		if err != nil {
+			t.Fatalf("Login() error = %v", err)
+		}
+		if stored, err := store.Users().GetByID(user.ID); err != nil || stored.Role != model.RoleSuperAdmin {
+			t.Errorf("登录后管理员用户 = %+v, %v", stored, err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := attempt("root", "wrong", "10.0.0.1"); !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("登录成功后第%d次失败 error = %v, want ErrInvalidCredentials", i+1, err)
+			}
+		}
+
+		// 每次尝试都记录审计日志
+		audits, err := auth.ListAudits(100)
+		if err != nil {
+			t.Fatalf("ListAudits() error = %v", err)
+		}
+		if len(audits) != 11 {
+			t.Fatalf("审计记录数量 = %d, want 11", len(audits))
+		}
+		if got := audits[2]; !got.Success || got.IP != "10.0.0.1" || got.UserAgent != "test" {
+			t.Errorf("登录成功的审计记录 = %+v", got)
+		}
+		if got := audits[4]; got.Success || got.Reason != "密码错误，锁定2秒" {
+			t.Errorf("锁定的审计记录 = %+v", got)
+		}
+		if got := audits[5]; got.Reason != "用户名错误" || got.Username != "admin" {
+			t.Errorf("用户名错误的审计记录 = %+v", got)
+		}
+	})
+}
+
+func TestAdminTOTP(t *testing.T) {
+	cfg := config.AdminConfig{Username: "root", Password: "s3cret"}
+
+	forEachStore(t, func(t *testing.T, store repository.Store) {
+		clock := newFakeClock()
+		users := NewUserService(store.Users(), cfg)
+		auth := NewAdminAuthService(cfg, users, NewLoginLimiter(5, time.Second, time.Minute, clock.Now), store, testCipher, clock.Now)
+		login := func(code string) error {
+			_, err := auth.Login(LoginAttempt{Username: "root", Password: "s3cret", Code: code, IP: "10.0.0.1"})
+			return err
+		}
+		codeAt := func(secret string, d time.Duration) string {
+			t.Helper()
+			code, err := util.TOTPCode(secret, util.TOTPStep(clock.Now().Add(d)))
+			if err != nil {
+				t.Fatalf("TOTPCode() error = %v", err)
+			}
+			return code
+		}
+
+		// 未启用两步验证时只需密码
+		admin, err := auth.Login(LoginAttempt{Username: "root", Password: "s3cret", IP: "10.0.0.1"})
+		if err != nil {
+			t.Fatalf("Login() error = %v", err)
+		}
+
+		// LinuxDo用户不能设置两步验证
+		linuxDoUser := createTestUser(t, store, 101)
+		if _, err := auth.SetupTOTP(linuxDoUser.ID); err == nil {
+			t.Error("SetupTOTP(LinuxDo用户) 应返回错误")
+		}
+
+		setup, err := auth.SetupTOTP(admin.ID)
+		if err != nil {
+			t.Fatalf("SetupTOTP() error = %v", err)
+		}
+		if stored, _ := store.TOTPs().Get(admin.ID); stored.Secret == setup.Secret {
+			t.Error("两步验证密钥未加密保存")
+		}
+		// 尚未验证，登录仍只需密码
+		if err := login(""); err != nil {
+			t.Errorf("未启用时 Login() error = %v", err)
+		}
+		if _, err := auth.EnableTOTP(admin.ID, "000000"); !errors.Is(err, ErrInvalidTOTPCode) {
+			t.Errorf("EnableTOTP(错误验证码) error = %v, want ErrInvalidTOTPCode", err)
+		}
+		enableCode := codeAt(setup.Secret, 0)
+		recoveryCodes, err := auth.EnableTOTP(admin.ID, enableCode)
+		if err != nil {
+			t.Fatalf("EnableTOTP() error = %v", err)
+		}
+		if len(recoveryCodes) != recoveryCodeCount {
+			t.Fatalf("恢复码数量 = %d, want %d", len(recoveryCodes), recoveryCodeCount)
+		}
+		if _, err := auth.SetupTOTP(admin.ID); err == nil {
+			t.Error("已启用时 SetupTOTP() 应返回错误")
+		}
+
+		// 启用后密码正确也需要验证码
+		if err := login(""); !errors.Is(err, ErrTOTPRequired) {
+			t.Errorf("Login(无验证码) error = %v, want ErrTOTPRequired", err)
+		}
+		if err := login("123456"); !errors.Is(err, ErrInvalidTOTPCode) {
+			t.Errorf("Login(错误验证码) error = %v, want ErrInvalidTOTPCode", err)
+		}
+		// 启用时使用的验证码不能再用于登录
+		if err := login(enableCode); !errors.Is(err, ErrInvalidTOTPCode) {
+			t.Errorf("Login(启用时的验证码) error = %v, want ErrInvalidTOTPCode", err)
+		}
+
+		clock.Advance(30 * time.Second)
+		code := codeAt(setup.Secret, 0)
+		if err := login(code); err != nil {
+			t.Errorf("Login(正确验证码) error = %v", err)
+		}
+		if err := login(code); !errors.Is(err, ErrInvalidTOTPCode) {
+			t.Errorf("Login(重复使用验证码) error = %v, want ErrInvalidTOTPCode", err)
+		}
+		// 允许一个时间步的时钟误差
+		if err := login(codeAt(setup.Secret, 30*time.Second)); err != nil {
+			t.Errorf("Login(下一个时间步的验证码) error = %v", err)
+		}
+		if err := login(codeAt(setup.Secret, 2*time.Minute)); !errors.Is(err, ErrInvalidTOTPCode) {
+			t.Errorf("Login(超出误差的验证码) error = %v, want ErrInvalidTOTPCode", err)
+		}
+
+		// 恢复码只能使用一次，忽略大小写和连字符
+		recovery := strings.ToLower(strings.ReplaceAll(recoveryCodes[0], "-", ""))
+		if err := login(recovery); err != nil {
+			t.Errorf("Login(恢复码) error = %v", err)
+		}
+		if err := login(recoveryCodes[0]); !errors.Is(err, ErrInvalidTOTPCode) {
+			t.Errorf("Login(已使用的恢复码) error = %v, want ErrInvalidTOTPCode", err)
+		}
+		if status, err := auth.TOTPStatus(admin.ID); err != nil || !status.Enabled || status.RecoveryCodes != recoveryCodeCount-1 {
+			t.Errorf("TOTPStatus() = %+v, %v", status, err)
+		}
+
+		// 关闭两步验证需要验证码
+		if err := auth.DisableTOTP(admin.ID, "000000"); !errors.Is(err, ErrInvalidTOTPCode) {
+			t.Errorf("DisableTOTP(错误验证码) error = %v, want ErrInvalidTOTPCode", err)
+		}
+		if err := auth.DisableTOTP(admin.ID, recoveryCodes[1]); err != nil {
+			t.Fatalf("DisableTOTP() error = %v", err)
+		}
+		if err := login(""); err != nil {
+			t.Errorf("关闭后 Login() error = %v", err)
+		}
+	})
+}
+
+func TestRotateKeysTOTP(t *testing.T) {
+	cfg := config.AdminConfig{Username: "root", Password: "s3cret"}
+
+	forEachStore(t, func(t *testing.T, store repository.Store) {
+		clock := newFakeClock()
+		users := NewUserService(store.Users(), cfg)
+		newAuth := func(cipher *util.CodeCipher) *AdminAuthService {
+			return NewAdminAuthService(cfg, users, NewLoginLimiter(5, time.Second, time.Minute, clock.Now), store, cipher, clock.Now)
+		}
+		codeNow := func(secret string) string {
+			t.Helper()
+			code, err := util.TOTPCode(secret, util.TOTPStep(clock.Now()))
+			if err != nil {
+				t.Fatalf("TOTPCode() error = %v", err)
+			}
+			return code
+		}
+
+		// 用密钥a加密保存两步验证密钥并启用
+		auth := newAuth(testCipher)
+		admin, err := auth.Login(LoginAttempt{Username: "root", Password: "s3cret", IP: "10.0.0.1"})
+		if err != nil {
+			t.Fatalf("Login() error = %v", err)
+		}
+		setup, err := auth.SetupTOTP(admin.ID)
+		if err != nil {
+			t.Fatalf("SetupTOTP() error = %v", err)
+		}
+		if _, err := auth.EnableTOTP(admin.ID, codeNow(setup.Secret)); err != nil {
+			t.Fatalf("EnableTOTP() error = %v", err)
+		}
+
+		// 新增密钥b并轮换，两步验证密钥也应计入
+		result, err := newTestKeyRotation(store, mustCipher("b", map[string]string{"a": testKeyA, "b": testKeyB})).RotateKeys()
+		if err != nil {
+			t.Fatalf("RotateKeys() error = %v", err)
+		}
+		if result.Total != 1 || result.Rotated != 1 || result.Failed != 0 {
+			t.Errorf("RotateKeys() = %+v, want 1个全部轮换", result)
+		}
+
+		// 移除密钥a后仍能使用两步验证登录
+		clock.Advance(30 * time.Second)
+		onlyB := newAuth(mustCipher("b", map[string]string{"b": testKeyB}))
+		if _, err := onlyB.Login(LoginAttempt{Username: "root", Password: "s3cret", Code: codeNow(setup.Secret), IP: "10.0.0.1"}); err != nil {
+			t.Errorf("轮换后只保留新密钥 Login() error = %v", err)
+		}
+	})
+}
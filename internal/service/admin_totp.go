@@ -0,0 +1,212 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
+	"github.com/zhongruan0522/DuiDuiMao/internal/util"
+)
+
+var (
+	// ErrTOTPRequired 密码正确，但管理员已启用两步验证，需要再提交验证码
+	ErrTOTPRequired = errors.New("请输入两步验证码")
+	// ErrInvalidTOTPCode 两步验证码或恢复码错误（含已使用过的验证码）
+	ErrInvalidTOTPCode = errors.New("两步验证码错误")
+)
+
+// 两步验证参数
+const (
+	totpIssuer        = "DuiDuiMao" // 验证器App中显示的服务名称
+	totpSkew          = 1           // 允许前后各1个时间步（30秒）的时钟误差
+	recoveryCodeCount = 10          // 启用时生成的恢复码数量
+)
+
+// TOTPStatus 两步验证状态
+type TOTPStatus struct {
+	Enabled       bool `json:"enabled"`
+	RecoveryCodes int  `json:"recovery_codes"` // 剩余未使用的恢复码数量
+}
+
+// TOTPSetup 新生成的两步验证密钥，验证通过后才启用
+type TOTPSetup struct {
+	Secret string `json:"secret"` // Base32密钥，供无法扫码时手动输入
+	URI    string `json:"uri"`    // otpauth链接，前端生成二维码
+}
+
+// totpUser 获取可以设置两步验证的用户：只有账密登录的管理员（LinuxDo用户由LinuxDo负责账号安全）
+func (s *AdminAuthService) totpUser(userID int) (*model.User, error) {
+	user, err := s.users.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.LinuxDoID != 0 {
+		return nil, fmt.Errorf("只有账密登录的管理员可以设置两步验证")
+	}
+	return user, nil
+}
+
+// TOTPStatus 获取管理员的两步验证状态
+func (s *AdminAuthService) TOTPStatus(userID int) (*TOTPStatus, error) {
+	totp, err := s.store.TOTPs().Get(userID)
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && !totp.Enabled()) {
+		return &TOTPStatus{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	n, err := s.store.TOTPs().CountRecoveryCodes(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &TOTPStatus{Enabled: true, RecoveryCodes: n}, nil
+}
+
+// SetupTOTP 为管理员生成新的两步验证密钥（尚未启用，用EnableTOTP验证后启用）
+func (s *AdminAuthService) SetupTOTP(userID int) (*TOTPSetup, error) {
+	user, err := s.totpUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := s.store.TOTPs().Get(userID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return nil, err
+	}
+	if err == nil && existing.Enabled() {
+		return nil, fmt.Errorf("两步验证已启用，请先关闭")
+	}
+
+	secret, err := util.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := s.cipher.Encrypt(secret)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.TOTPs().Save(&model.TOTP{UserID: userID, Secret: encrypted, CreatedAt: s.now()}); err != nil {
+		return nil, fmt.Errorf("保存两步验证密钥失败: %v", err)
+	}
+	return &TOTPSetup{Secret: secret, URI: util.TOTPURI(totpIssuer, user.Username, secret)}, nil
+}
+
+// EnableTOTP 校验验证器App生成的验证码后启用两步验证，返回一次性恢复码（只在此时返回明文）
+func (s *AdminAuthService) EnableTOTP(userID int, code string) ([]string, error) {
+	totp, err := s.store.TOTPs().Get(userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, fmt.Errorf("请先生成两步验证密钥")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if totp.Enabled() {
+		return nil, fmt.Errorf("两步验证已启用")
+	}
+	secret, err := s.cipher.Decrypt(totp.Secret)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := util.VerifyTOTP(secret, code, s.now(), totpSkew)
+	if !ok {
+		return nil, ErrInvalidTOTPCode
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		if codes[i], err = generateRecoveryCode(); err != nil {
+			return nil, err
+		}
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	err = s.store.TOTPs().Enable(userID, s.now(), hashes)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, fmt.Errorf("两步验证已启用")
+	}
+	if err != nil {
+		return nil, err
+	}
+	// 启用时使用的验证码不能再用于登录
+	if err := s.store.TOTPs().UseStep(userID, step); err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP 校验验证码或恢复码后关闭两步验证
+func (s *AdminAuthService) DisableTOTP(userID int, code string) error {
+	totp, err := s.store.TOTPs().Get(userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if totp.Enabled() {
+		if _, err := s.verifySecondFactor(totp, code); err != nil {
+			return err
+		}
+	}
+	return s.store.TOTPs().Delete(userID)
+}
+
+// verifySecondFactor 校验验证码（每个时间步只能使用一次）或恢复码（每个只能使用一次），返回是否使用了恢复码
+func (s *AdminAuthService) verifySecondFactor(totp *model.TOTP, code string) (bool, error) {
+	secret, err := s.cipher.Decrypt(totp.Secret)
+	if err != nil {
+		return false, err
+	}
+	if step, ok := util.VerifyTOTP(secret, code, s.now(), totpSkew); ok {
+		err := s.store.TOTPs().UseStep(totp.UserID, step)
+		if errors.Is(err, repository.ErrNotFound) {
+			return false, ErrInvalidTOTPCode
+		}
+		return false, err
+	}
+
+	err = s.store.TOTPs().UseRecoveryCode(totp.UserID, hashRecoveryCode(code), s.now())
+	if errors.Is(err, repository.ErrNotFound) {
+		return false, ErrInvalidTOTPCode
+	}
+	return err == nil, err
+}
+
+// generateRecoveryCode 生成格式为 XXXX-XXXX 的恢复码
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("生成随机数失败: %v", err)
+	}
+	code := base32.StdEncoding.EncodeToString(b)
+	return code[:4] + "-" + code[4:], nil
+}
+
+// hashRecoveryCode 计算恢复码的哈希，忽略大小写、空格和连字符
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// Reencrypt 用当前密钥重新加密所有两步验证密钥
+func (s *AdminAuthService) Reencrypt(result *RotateKeysResult) error {
+	totps, err := s.store.TOTPs().List()
+	if err != nil {
+		return err
+	}
+	for _, totp := range totps {
+		err := rotateValue(s.cipher, result, fmt.Sprintf("用户 %d 的两步验证密钥", totp.UserID), totp.Secret, func(encrypted string) error {
+			return s.store.TOTPs().UpdateSecret(totp.UserID, totp.Secret, encrypted)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
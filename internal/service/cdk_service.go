@@ -1,31 +1,33 @@
 package service
 
 import (
-	"encoding/csv"
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
-	"strconv"
+	"log"
 	"strings"
 	"time"
 
-	"github.com/zhongruan0522/DuiDuiMao/internal/config"
 	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
 	"github.com/zhongruan0522/DuiDuiMao/internal/util"
 )
 
+// ErrTierRequiresPayment 收费档位只能下单支付，不能直接兑换
+var ErrTierRequiresPayment = errors.New("该档位需要下单支付，不能直接兑换")
+
 // CDKService CDK服务
 type CDKService struct {
-	mode string // dev 或 server
+	store  repository.Store // 兑换需要在同一事务中更新CDK并写入兑换记录
+	repo   repository.CDKRepository
+	cipher *util.CodeCipher // CDK加密存储
+	now    Clock
 }
 
 // NewCDKService 创建CDK服务
-func NewCDKService(mode string) *CDKService {
-	return &CDKService{mode: mode}
+func NewCDKService(store repository.Store, cipher *util.CodeCipher, clock Clock) *CDKService {
+	return &CDKService{store: store, repo: store.CDKs(), cipher: cipher, now: clock}
 }
 
-const cdkCSVPath = "Temp/cdk.csv"
-
 // ImportCDKsRequest 批量导入CDK请求
 type ImportCDKsRequest struct {
 	TierID int      `json:"tier_id"` // 所属档位ID
@@ -34,345 +36,253 @@ type ImportCDKsRequest struct {
 
 // ImportCDKsResult 批量导入CDK结果
 type ImportCDKsResult struct {
-	SuccessCount int      `json:"success_count"` // 成功导入数量
-	FailedCount  int      `json:"failed_count"`  // 失败数量
-	FailedCodes  []string `json:"failed_codes"`  // 失败的CDK列表（重复的）
-}
-
-// BatchImportCDKs 批量导入CDK
-func (s *CDKService) BatchImportCDKs(tierID int, codes []string) (*ImportCDKsResult, error) {
-	if s.mode == config.ModeDev {
-		return s.batchImportCDKsCSV(tierID, codes)
-	}
-	// TODO: 实现数据库版本
-	return nil, fmt.Errorf("数据库模式暂未实现")
-}
-
-// GetCDKs 获取CDK列表（支持按档位和状态筛选）
-func (s *CDKService) GetCDKs(tierID *int, status *int) ([]model.CDK, error) {
-	if s.mode == config.ModeDev {
-		return s.getCDKsCSV(tierID, status)
-	}
-	// TODO: 实现数据库版本
-	return nil, fmt.Errorf("数据库模式暂未实现")
+	SuccessCount int               `json:"success_count"` // 成功导入数量
+	FailedCount  int               `json:"failed_count"`  // 失败数量
+	FailedCodes  []string          `json:"failed_codes"`  // 失败的CDK列表（重复的）
+	Duplicates   []ImportDuplicate `json:"duplicates"`    // 重复CDK的详情
 }
 
-// RevokeCDK 作废CDK
-func (s *CDKService) RevokeCDK(id int) error {
-	if s.mode == config.ModeDev {
-		return s.revokeCDKCSV(id)
-	}
-	// TODO: 实现数据库版本
-	return fmt.Errorf("数据库模式暂未实现")
-}
-
-// GetAvailableCDKByTierID 获取指定档位的一个可用CDK（用于兑换）
-func (s *CDKService) GetAvailableCDKByTierID(tierID int) (*model.CDK, error) {
-	if s.mode == config.ModeDev {
-		return s.getAvailableCDKByTierIDCSV(tierID)
-	}
-	// TODO: 实现数据库版本
-	return nil, fmt.Errorf("数据库模式暂未实现")
+// ImportDuplicate 导入时发现的重复CDK
+type ImportDuplicate struct {
+	Code     string `json:"code"`      // 重复的CDK
+	TierID   int    `json:"tier_id"`   // 已存在的CDK所属档位（与本次导入的其他行重复时为目标档位）
+	SameTier bool   `json:"same_tier"` // 是否与目标档位相同
 }
 
-// MarkCDKAsRedeemed 标记CDK为已兑换（兑换时使用）
-func (s *CDKService) MarkCDKAsRedeemed(cdkID, userID int) error {
-	if s.mode == config.ModeDev {
-		return s.markCDKAsRedeemedCSV(cdkID, userID)
+// BatchImportCDKs 批量导入CDK
+// 重复检测按CDK指纹查询索引，跨所有档位生效，不需要解密已有的CDK
+func (s *CDKService) BatchImportCDKs(tierID int, codes []string) (*ImportCDKsResult, error) {
+	if len(codes) == 0 {
+		return nil, fmt.Errorf("CDK列表不能为空")
 	}
-	// TODO: 实现数据库版本
-	return fmt.Errorf("数据库模式暂未实现")
-}
 
-// ========== CSV模式实现 ==========
-
-// ensureCDKCSV 确保CDK CSV文件存在
-func (s *CDKService) ensureCDKCSV() error {
-	dir := filepath.Dir(cdkCSVPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return err
+	trimmedCodes := []string{}
+	fingerprints := []string{}
+	for _, code := range codes {
+		trimmedCode := strings.TrimSpace(code)
+		if trimmedCode == "" {
+			continue // 跳过空行
+		}
+		trimmedCodes = append(trimmedCodes, trimmedCode)
+		fingerprints = append(fingerprints, s.cipher.Fingerprint(trimmedCode))
 	}
 
-	_, statErr := os.Stat(cdkCSVPath)
-	if os.IsNotExist(statErr) {
-		file, createErr := os.Create(cdkCSVPath)
-		if createErr != nil {
-			return createErr
+	var result *ImportCDKsResult
+	err := s.store.Tx(func(tx repository.Store) error {
+		existing, err := tx.CDKs().FindByFingerprints(fingerprints)
+		if err != nil {
+			return err
+		}
+		// 指纹 -> 已存在CDK所属档位
+		existingTiers := make(map[string]int)
+		for _, cdk := range existing {
+			existingTiers[cdk.Fingerprint] = cdk.TierID
 		}
-		defer file.Close()
 
-		writer := csv.NewWriter(file)
-		// 写入CSV头部
-		// 字段：id, tier_id, code, status, order_id, redeemed_by, redeemed_at, created_at, updated_at
-		header := []string{"id", "tier_id", "code", "status", "order_id", "redeemed_by", "redeemed_at", "created_at", "updated_at"}
-		if writeErr := writer.Write(header); writeErr != nil {
-			return writeErr
+		result = &ImportCDKsResult{
+			FailedCodes: []string{},
+			Duplicates:  []ImportDuplicate{},
 		}
-		writer.Flush()
-	}
-	return nil
-}
+		now := s.now()
+		newCDKs := []model.CDK{}
+		for i, code := range trimmedCodes {
+			fp := fingerprints[i]
+			if existingTier, ok := existingTiers[fp]; ok {
+				result.FailedCount++
+				result.FailedCodes = append(result.FailedCodes, code)
+				result.Duplicates = append(result.Duplicates, ImportDuplicate{Code: code, TierID: existingTier, SameTier: existingTier == tierID})
+				continue
+			}
 
-// readCDKsCSV 读取所有CDK
-func (s *CDKService) readCDKsCSV() ([]model.CDK, error) {
-	if err := s.ensureCDKCSV(); err != nil {
-		return nil, err
-	}
+			encrypted, err := s.cipher.Encrypt(code)
+			if err != nil {
+				return fmt.Errorf("加密CDK失败: %v", err)
+			}
+			newCDKs = append(newCDKs, model.CDK{
+				TierID:      tierID,
+				Code:        encrypted, // 加密存储
+				Status:      model.CDKStatusAvailable,
+				CreatedAt:   now,
+				UpdatedAt:   now,
+				Fingerprint: fp,
+			})
+			existingTiers[fp] = tierID // 同一批次内的重复行
+			result.SuccessCount++
+		}
 
-	file, err := os.Open(cdkCSVPath)
+		if len(newCDKs) > 0 {
+			return tx.CDKs().BatchCreate(newCDKs)
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
+	return result, nil
+}
+
+// RebuildFingerprintsResult CDK指纹重建结果
+type RebuildFingerprintsResult struct {
+	Total   int `json:"total"`   // CDK总数
+	Updated int `json:"updated"` // 本次写入指纹的数量
+	Failed  int `json:"failed"`  // 无法解密或指纹冲突（已存在重复CDK）的数量
+}
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
+// RebuildFingerprints 计算并写入CDK指纹
+// all为false时只处理尚未计算指纹的CDK（升级后启动时回填），为true时重新计算全部（更换指纹密钥后执行）
+func (s *CDKService) RebuildFingerprints(all bool) (*RebuildFingerprintsResult, error) {
+	cdks, err := s.repo.List(nil, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	cdks := []model.CDK{}
-	for i, record := range records {
-		if i == 0 || len(record) < 9 {
-			continue // 跳过头部或不完整的行
+	result := &RebuildFingerprintsResult{Total: len(cdks)}
+	for _, cdk := range cdks {
+		if cdk.Fingerprint != "" && !all {
+			continue
 		}
-
-		id, _ := strconv.Atoi(record[0])
-		tierID, _ := strconv.Atoi(record[1])
-		status, _ := strconv.Atoi(record[3])
-		orderID, _ := strconv.Atoi(record[4])
-		redeemedBy, _ := strconv.Atoi(record[5])
-		redeemedAt, _ := time.Parse(time.RFC3339, record[6])
-		createdAt, _ := time.Parse(time.RFC3339, record[7])
-		updatedAt, _ := time.Parse(time.RFC3339, record[8])
-
-		cdks = append(cdks, model.CDK{
-			ID:         id,
-			TierID:     tierID,
-			Code:       record[2], // 已加密存储
-			Status:     status,
-			OrderID:    orderID,
-			RedeemedBy: redeemedBy,
-			RedeemedAt: redeemedAt,
-			CreatedAt:  createdAt,
-			UpdatedAt:  updatedAt,
-		})
+		plain, err := s.cipher.Decrypt(cdk.Code)
+		if err != nil {
+			log.Printf("CDK %d 解密失败，跳过: %v", cdk.ID, err)
+			result.Failed++
+			continue
+		}
+		fp := s.cipher.Fingerprint(plain)
+		if fp == cdk.Fingerprint {
+			continue
+		}
+		if err := s.repo.SetFingerprint(cdk.ID, fp); err != nil {
+			log.Printf("CDK %d 写入指纹失败（可能与其他CDK重复）: %v", cdk.ID, err)
+			result.Failed++
+			continue
+		}
+		result.Updated++
 	}
-	return cdks, nil
+	return result, nil
 }
 
-// writeCDKsCSV 写入所有CDK
-func (s *CDKService) writeCDKsCSV(cdks []model.CDK) error {
-	file, err := os.Create(cdkCSVPath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+// RevealCode 解密CDK明文
+func (s *CDKService) RevealCode(cdk *model.CDK) (string, error) {
+	return s.cipher.Decrypt(cdk.Code)
+}
 
-	// 写入头部
-	header := []string{"id", "tier_id", "code", "status", "order_id", "redeemed_by", "redeemed_at", "created_at", "updated_at"}
-	if err := writer.Write(header); err != nil {
+// Reencrypt 用当前密钥重新加密所有非当前密钥加密的CDK（包括旧的双重Base64数据）
+func (s *CDKService) Reencrypt(result *RotateKeysResult) error {
+	cdks, err := s.repo.List(nil, nil)
+	if err != nil {
 		return err
 	}
-
-	// 写入数据
 	for _, cdk := range cdks {
-		redeemedAtStr := ""
-		if !cdk.RedeemedAt.IsZero() {
-			redeemedAtStr = cdk.RedeemedAt.Format(time.RFC3339)
-		}
-
-		record := []string{
-			strconv.Itoa(cdk.ID),
-			strconv.Itoa(cdk.TierID),
-			cdk.Code, // 已加密
-			strconv.Itoa(cdk.Status),
-			strconv.Itoa(cdk.OrderID),
-			strconv.Itoa(cdk.RedeemedBy),
-			redeemedAtStr,
-			cdk.CreatedAt.Format(time.RFC3339),
-			cdk.UpdatedAt.Format(time.RFC3339),
-		}
-		if err := writer.Write(record); err != nil {
+		err := rotateValue(s.cipher, result, fmt.Sprintf("CDK %d", cdk.ID), cdk.Code, func(encrypted string) error {
+			return s.repo.UpdateCode(cdk.ID, cdk.Code, encrypted)
+		})
+		if err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-// batchImportCDKsCSV 批量导入CDK（CSV模式）
-func (s *CDKService) batchImportCDKsCSV(tierID int, codes []string) (*ImportCDKsResult, error) {
-	if len(codes) == 0 {
-		return nil, fmt.Errorf("CDK列表不能为空")
-	}
+// GetCDKs 获取CDK列表（支持按档位和状态筛选）
+func (s *CDKService) GetCDKs(tierID *int, status *int) ([]model.CDK, error) {
+	return s.repo.List(tierID, status)
+}
 
-	cdks, err := s.readCDKsCSV()
-	if err != nil {
-		return nil, err
+// RevokeCDK 作废CDK
+func (s *CDKService) RevokeCDK(id int) error {
+	cdk, err := s.repo.GetByID(id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return fmt.Errorf("CDK不存在")
 	}
-
-	// 获取新ID起始值
-	newID := 1
-	if len(cdks) > 0 {
-		newID = cdks[len(cdks)-1].ID + 1
+	if err != nil {
+		return err
 	}
 
-	// 构建已存在CDK的映射（用于检测重复）
-	existingCodes := make(map[string]bool)
-	for _, cdk := range cdks {
-		// 解密后比对（假设存储时已加密）
-		decryptedCode, decErr := util.DoubleDecode(cdk.Code)
-		if decErr == nil {
-			existingCodes[decryptedCode] = true
-		}
+	if cdk.Status == model.CDKStatusRedeemed {
+		return fmt.Errorf("CDK已被兑换，无法作废")
 	}
-
-	result := &ImportCDKsResult{
-		SuccessCount: 0,
-		FailedCount:  0,
-		FailedCodes:  []string{},
+	if cdk.Status == model.CDKStatusLocked {
+		return fmt.Errorf("CDK已被待支付订单锁定，无法作废")
 	}
+	cdk.Status = model.CDKStatusRevoked
+	cdk.UpdatedAt = s.now()
+	return s.repo.Update(cdk)
+}
 
-	now := time.Now()
-
-	// 批量添加CDK
-	for _, code := range codes {
-		trimmedCode := strings.TrimSpace(code)
-		if trimmedCode == "" {
-			continue // 跳过空行
+// ClaimNextCDK 为用户领取指定档位的下一个CDK：原子地标记为已兑换，并在同一事务中写入兑换记录
+// 各存储实现保证并发调用时同一个CDK不会被发放两次，信任等级和每日限购的校验也在同一事务中完成
+// 收费档位返回ErrTierRequiresPayment，只能通过下单支付购买
+func (s *CDKService) ClaimNextCDK(tierID, userID int) (*model.CDK, error) {
+	var claimed *model.CDK
+	err := s.store.Tx(func(tx repository.Store) error {
+		tier, err := tx.Tiers().GetByID(tierID)
+		if errors.Is(err, repository.ErrNotFound) {
+			return fmt.Errorf("档位不存在")
 		}
-
-		// 检查重复
-		if existingCodes[trimmedCode] {
-			result.FailedCount++
-			result.FailedCodes = append(result.FailedCodes, trimmedCode)
-			continue
+		if err != nil {
+			return err
 		}
-
-		// 加密存储
-		encryptedCode := util.DoubleEncode(trimmedCode)
-
-		newCDK := model.CDK{
-			ID:         newID,
-			TierID:     tierID,
-			Code:       encryptedCode,
-			Status:     0, // 0=未兑换
-			OrderID:    0,
-			RedeemedBy: 0,
-			RedeemedAt: time.Time{}, // 零值
-			CreatedAt:  now,
-			UpdatedAt:  now,
+		if !tier.IsActive {
+			return fmt.Errorf("该档位未启用")
+		}
+		if tier.Price > 0 {
+			return ErrTierRequiresPayment
 		}
 
-		cdks = append(cdks, newCDK)
-		existingCodes[trimmedCode] = true
-		result.SuccessCount++
-		newID++
-	}
-
-	// 写回CSV
-	if err := s.writeCDKsCSV(cdks); err != nil {
-		return nil, err
-	}
-
-	return result, nil
-}
-
-// getCDKsCSV 获取CDK列表（CSV模式，支持筛选）
-func (s *CDKService) getCDKsCSV(tierID *int, status *int) ([]model.CDK, error) {
-	cdks, err := s.readCDKsCSV()
-	if err != nil {
-		return nil, err
-	}
-
-	// 筛选
-	filtered := []model.CDK{}
-	for _, cdk := range cdks {
-		// 按档位筛选
-		if tierID != nil && cdk.TierID != *tierID {
-			continue
+		now := s.now()
+		if err := checkTierOnSale(tier, now); err != nil {
+			return err
 		}
-		// 按状态筛选
-		if status != nil && cdk.Status != *status {
-			continue
+		if err := checkBuyer(tx, tier, userID, 1, now); err != nil {
+			return err
 		}
-		filtered = append(filtered, cdk)
-	}
-
-	return filtered, nil
-}
-
-// revokeCDKCSV 作废CDK（CSV模式）
-func (s *CDKService) revokeCDKCSV(id int) error {
-	cdks, err := s.readCDKsCSV()
-	if err != nil {
-		return err
-	}
 
-	found := false
-	for i := range cdks {
-		if cdks[i].ID == id {
-			if cdks[i].Status == 2 {
-				return fmt.Errorf("CDK已被兑换，无法作废")
-			}
-			cdks[i].Status = 3 // 3=已作废
-			cdks[i].UpdatedAt = time.Now()
-			found = true
-			break
+		cdk, err := tx.CDKs().ClaimNext(tierID, userID, now)
+		if errors.Is(err, repository.ErrNotFound) {
+			return fmt.Errorf("该档位暂无可用CDK")
+		}
+		if err != nil {
+			return err
 		}
-	}
-
-	if !found {
-		return fmt.Errorf("CDK不存在")
-	}
 
-	return s.writeCDKsCSV(cdks)
-}
+		err = tx.RedeemLogs().Create(&model.RedeemLog{
+			UserID:    userID,
+			CDKID:     cdk.ID,
+			TierID:    tierID,
+			CreatedAt: now,
+		})
+		if err != nil {
+			return fmt.Errorf("写入兑换记录失败: %v", err)
+		}
 
-// getAvailableCDKByTierIDCSV 获取指定档位的一个可用CDK（CSV模式）
-func (s *CDKService) getAvailableCDKByTierIDCSV(tierID int) (*model.CDK, error) {
-	cdks, err := s.readCDKsCSV()
+		claimed = cdk
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-
-	for i := range cdks {
-		if cdks[i].TierID == tierID && cdks[i].Status == 0 {
-			return &cdks[i], nil
-		}
-	}
-
-	return nil, fmt.Errorf("该档位暂无可用CDK")
+	return claimed, nil
 }
 
-// markCDKAsRedeemedCSV 标记CDK为已兑换（CSV模式）
-func (s *CDKService) markCDKAsRedeemedCSV(cdkID, userID int) error {
-	cdks, err := s.readCDKsCSV()
-	if err != nil {
-		return err
+// ScheduleRelease 定时放出：把档位中quantity个可用CDK暂扣，到releaseAt时自动放出
+// 暂扣的CDK放出前不计入库存，也不能被兑换或下单
+func (s *CDKService) ScheduleRelease(tierID, quantity int, releaseAt time.Time) error {
+	if quantity <= 0 {
+		return fmt.Errorf("放出数量必须大于0")
 	}
-
-	found := false
-	for i := range cdks {
-		if cdks[i].ID == cdkID {
-			if cdks[i].Status != 0 {
-				return fmt.Errorf("CDK已被兑换或作废")
-			}
-			cdks[i].Status = 2 // 2=已兑换
-			cdks[i].RedeemedBy = userID
-			cdks[i].RedeemedAt = time.Now()
-			cdks[i].UpdatedAt = time.Now()
-			found = true
-			break
-		}
+	now := s.now()
+	if !releaseAt.After(now) {
+		return fmt.Errorf("放出时间必须晚于当前时间")
 	}
 
-	if !found {
-		return fmt.Errorf("CDK不存在")
+	err := s.repo.ScheduleRelease(tierID, quantity, releaseAt, now)
+	if errors.Is(err, repository.ErrNotFound) {
+		return fmt.Errorf("该档位可用CDK不足%d个", quantity)
 	}
+	return err
+}
 
-	return s.writeCDKsCSV(cdks)
+// ReleaseNow 立即放出档位中所有尚未放出的CDK，返回放出的数量
+func (s *CDKService) ReleaseNow(tierID int) (int, error) {
+	return s.repo.ReleaseNow(tierID, s.now())
 }
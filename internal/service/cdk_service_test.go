@@ -0,0 +1,280 @@
+package service
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
+	"github.com/zhongruan0522/DuiDuiMao/internal/util"
+)
+
+// 测试用CDK加密密钥（32字节）
+var (
+	testKeyA = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	testKeyB = base64.StdEncoding.EncodeToString([]byte("fedcba9876543210fedcba9876543210"))
+)
+
+// testCipher 测试中默认使用的CDK加解密器
+var testCipher = mustCipher("a", map[string]string{"a": testKeyA})
+
+func mustCipher(keyID string, keys map[string]string) *util.CodeCipher {
+	c, err := util.NewCodeCipher(keyID, keys, "")
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// openSharedStores 打开指向同一份数据的两个独立存储实例，模拟多进程/多实例并发
+func openSharedStores(t *testing.T) map[string][2]repository.Store {
+	t.Helper()
+	stores := make(map[string][2]repository.Store)
+
+	csvDir := t.TempDir()
+	csvA, err := repository.NewCSVStore(csvDir)
+	if err != nil {
+		t.Fatalf("创建CSV存储失败: %v", err)
+	}
+	csvB, err := repository.NewCSVStore(csvDir)
+	if err != nil {
+		t.Fatalf("创建CSV存储失败: %v", err)
+	}
+	stores["csv"] = [2]repository.Store{csvA, csvB}
+
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	sqliteA, err := repository.OpenSQLite(dbPath)
+	if err != nil {
+		t.Fatalf("打开SQLite失败: %v", err)
+	}
+	sqliteB, err := repository.OpenSQLite(dbPath)
+	if err != nil {
+		t.Fatalf("打开SQLite失败: %v", err)
+	}
+	t.Cleanup(func() {
+		sqliteA.Close()
+		sqliteB.Close()
+	})
+	stores["sqlite"] = [2]repository.Store{sqliteA, sqliteB}
+
+	if url := os.Getenv("DUIDUIMAO_TEST_POSTGRES_URL"); url != "" {
+		pgA, err := repository.OpenPostgres(url)
+		if err != nil {
+			t.Fatalf("连接PostgreSQL失败: %v", err)
+		}
+		pgB, err := repository.OpenPostgres(url)
+		if err != nil {
+			t.Fatalf("连接PostgreSQL失败: %v", err)
+		}
+		t.Cleanup(func() {
+			pgA.Close()
+			pgB.Close()
+		})
+		stores["postgres"] = [2]repository.Store{pgA, pgB}
+	}
+
+	return stores
+}
+
+// TestClaimNextCDKConcurrent 并发领取压力测试：每个CDK只能被发放一次
+func TestClaimNextCDKConcurrent(t *testing.T) {
+	const (
+		cdkCount = 40
+		workers  = 100
+	)
+
+	for name, pair := range openSharedStores(t) {
+		pair := pair
+		t.Run(name, func(t *testing.T) {
+			tierID := createTestTier(t, pair[0], 10, true, 0).ID
+			services := [2]*CDKService{NewCDKService(pair[0], testCipher, time.Now), NewCDKService(pair[1], testCipher, time.Now)}
+			codes := make([]string, cdkCount)
+			for i := range codes {
+				codes[i] = fmt.Sprintf("%s-CDK-%03d", name, i)
+			}
+			if _, err := services[0].BatchImportCDKs(tierID, codes); err != nil {
+				t.Fatalf("BatchImportCDKs() error = %v", err)
+			}
+
+			var (
+				wg      sync.WaitGroup
+				mu      sync.Mutex
+				claimed = make(map[int]int) // cdk_id -> user_id
+				dupes   []int
+				failed  int
+			)
+			for i := 0; i < workers; i++ {
+				wg.Add(1)
+				go func(userID int) {
+					defer wg.Done()
+					cdk, err := services[userID%2].ClaimNextCDK(tierID, userID)
+
+					mu.Lock()
+					defer mu.Unlock()
+					if err != nil {
+						if !strings.Contains(err.Error(), "暂无可用CDK") {
+							t.Errorf("ClaimNextCDK() 非预期错误: %v", err)
+						}
+						failed++
+						return
+					}
+					if _, ok := claimed[cdk.ID]; ok {
+						dupes = append(dupes, cdk.ID)
+					}
+					claimed[cdk.ID] = userID
+				}(i + 1)
+			}
+			wg.Wait()
+
+			if len(dupes) > 0 {
+				t.Fatalf("CDK被重复发放: %v", dupes)
+			}
+			if len(claimed) != cdkCount || failed != workers-cdkCount {
+				t.Fatalf("成功领取 %d 个（期望 %d），失败 %d 次（期望 %d）", len(claimed), cdkCount, failed, workers-cdkCount)
+			}
+
+			// 存储中的状态与兑换记录必须和领取结果一致
+			cdks, err := services[0].GetCDKs(&tierID, nil)
+			if err != nil {
+				t.Fatalf("GetCDKs() error = %v", err)
+			}
+			for _, cdk := range cdks {
+				if cdk.RedeemedBy != claimed[cdk.ID] {
+					t.Errorf("CDK %d 兑换用户 = %d, want %d", cdk.ID, cdk.RedeemedBy, claimed[cdk.ID])
+				}
+			}
+
+			logs, err := pair[1].RedeemLogs().List()
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if len(logs) != cdkCount {
+				t.Errorf("兑换记录数量 = %d, want %d", len(logs), cdkCount)
+			}
+		})
+	}
+}
+
+func TestClaimNextCDKPricedTier(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store repository.Store) {
+		cdks := NewCDKService(store, testCipher, time.Now)
+		user := createTestUser(t, store, 1)
+		tier := createTestTier(t, store, 10, true, 1)
+		tier.Price = 5
+		if err := store.Tiers().Update(tier); err != nil {
+			t.Fatalf("更新档位失败: %v", err)
+		}
+
+		// 收费档位不能绕过下单支付直接兑换，CDK保持可用
+		if _, err := cdks.ClaimNextCDK(tier.ID, user.ID); !errors.Is(err, ErrTierRequiresPayment) {
+			t.Fatalf("ClaimNextCDK() error = %v, want ErrTierRequiresPayment", err)
+		}
+		available, err := store.CDKs().CountAvailableByTier(time.Now())
+		if err != nil {
+			t.Fatalf("CountAvailableByTier() error = %v", err)
+		}
+		if available[tier.ID] != 1 {
+			t.Errorf("可用CDK = %d, want 1", available[tier.ID])
+		}
+	})
+}
+
+func TestRotateKeys(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store repository.Store) {
+		tier := createTestTier(t, store, 10, true, 0)
+
+		// 旧的双重Base64数据 + 密钥a加密的数据
+		now := time.Now()
+		legacy := []model.CDK{{TierID: tier.ID, Code: util.DoubleEncode("LEGACY-CODE"), CreatedAt: now, UpdatedAt: now}}
+		if err := store.CDKs().BatchCreate(legacy); err != nil {
+			t.Fatalf("BatchCreate() error = %v", err)
+		}
+		if _, err := NewCDKService(store, testCipher, time.Now).BatchImportCDKs(tier.ID, []string{"CODE-A1", "CODE-A2"}); err != nil {
+			t.Fatalf("BatchImportCDKs() error = %v", err)
+		}
+
+		// 新增密钥b并设为当前密钥后执行轮换
+		rotated := newTestKeyRotation(store, mustCipher("b", map[string]string{"a": testKeyA, "b": testKeyB}))
+		result, err := rotated.RotateKeys()
+		if err != nil {
+			t.Fatalf("RotateKeys() error = %v", err)
+		}
+		if result.Total != 3 || result.Rotated != 3 || result.Failed != 0 {
+			t.Errorf("RotateKeys() = %+v, want 3个全部轮换", result)
+		}
+		if again, _ := rotated.RotateKeys(); again.Rotated != 0 {
+			t.Errorf("重复轮换 Rotated = %d, want 0", again.Rotated)
+		}
+
+		// 轮换后只保留密钥b也能解密全部CDK，且存储中不再有明文可还原的数据
+		onlyB := NewCDKService(store, mustCipher("b", map[string]string{"b": testKeyB}), time.Now)
+		cdks, _ := onlyB.GetCDKs(nil, nil)
+		got := []string{}
+		for i := range cdks {
+			if util.KeyID(cdks[i].Code) != "b" {
+				t.Errorf("CDK %d 密钥 = %q, want b", cdks[i].ID, util.KeyID(cdks[i].Code))
+			}
+			code, err := onlyB.RevealCode(&cdks[i])
+			if err != nil {
+				t.Fatalf("RevealCode() error = %v", err)
+			}
+			got = append(got, code)
+		}
+		if strings.Join(got, ",") != "LEGACY-CODE,CODE-A1,CODE-A2" {
+			t.Errorf("轮换后CDK = %v", got)
+		}
+	})
+}
+
+func TestBatchImportDuplicates(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store repository.Store) {
+		tierA := createTestTier(t, store, 10, true, 0)
+		tierB := createTestTier(t, store, 10, true, 0)
+		svc := NewCDKService(store, testCipher, time.Now)
+
+		// 升级前导入的CDK没有指纹，回填后才能参与重复检测
+		now := time.Now()
+		legacy := []model.CDK{{TierID: tierB.ID, Code: util.DoubleEncode("LEGACY"), CreatedAt: now, UpdatedAt: now}}
+		if err := store.CDKs().BatchCreate(legacy); err != nil {
+			t.Fatalf("BatchCreate() error = %v", err)
+		}
+		if result, err := svc.RebuildFingerprints(false); err != nil || result.Updated != 1 {
+			t.Fatalf("RebuildFingerprints() = %+v, %v, want 回填1个", result, err)
+		}
+		if _, err := svc.BatchImportCDKs(tierA.ID, []string{"A-1", "A-2"}); err != nil {
+			t.Fatalf("BatchImportCDKs() error = %v", err)
+		}
+
+		result, err := svc.BatchImportCDKs(tierA.ID, []string{"A-1", " LEGACY ", "NEW", "NEW", ""})
+		if err != nil {
+			t.Fatalf("BatchImportCDKs() error = %v", err)
+		}
+		if result.SuccessCount != 1 || result.FailedCount != 3 {
+			t.Errorf("SuccessCount/FailedCount = %d/%d, want 1/3", result.SuccessCount, result.FailedCount)
+		}
+		want := []ImportDuplicate{
+			{Code: "A-1", TierID: tierA.ID, SameTier: true},
+			{Code: "LEGACY", TierID: tierB.ID, SameTier: false},
+			{Code: "NEW", TierID: tierA.ID, SameTier: true},
+		}
+		if fmt.Sprint(result.Duplicates) != fmt.Sprint(want) {
+			t.Errorf("Duplicates = %+v, want %+v", result.Duplicates, want)
+		}
+
+		// 更换加密密钥不影响指纹，重复检测仍然生效
+		rotated := NewCDKService(store, mustCipher("b", map[string]string{"a": testKeyA, "b": testKeyB}), time.Now)
+		if _, err := newTestKeyRotation(store, mustCipher("b", map[string]string{"a": testKeyA, "b": testKeyB})).RotateKeys(); err != nil {
+			t.Fatalf("RotateKeys() error = %v", err)
+		}
+		if result, _ := rotated.BatchImportCDKs(tierB.ID, []string{"A-2"}); result.SuccessCount != 0 || len(result.Duplicates) != 1 || result.Duplicates[0].SameTier {
+			t.Errorf("轮换后导入结果 = %+v, want 与其他档位重复", result)
+		}
+	})
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/config"
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
+)
+
+// ErrDailyLimitExceeded 超出档位每日限购
+var ErrDailyLimitExceeded = errors.New("已达到该档位每日限购数量")
+
+// defaultLocation 未加载配置时使用的业务时区（UTC+8）
+var defaultLocation = time.FixedZone("UTC+8", 8*60*60)
+
+// BusinessLocation 业务时区（读取当前配置，支持热更新）
+func BusinessLocation() *time.Location {
+	if cfg := config.Get(); cfg != nil && cfg.Server.Location != nil {
+		return cfg.Server.Location
+	}
+	return defaultLocation
+}
+
+// dayRange 返回now在业务时区所在自然日的起止时间 [start, end)
+func dayRange(now time.Time) (time.Time, time.Time) {
+	local := now.In(BusinessLocation())
+	start := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+	return start, start.AddDate(0, 0, 1)
+}
+
+// dailyUsed 统计用户今日在该档位已占用的数量：今日兑换记录 + 待支付订单锁定的数量
+func dailyUsed(tx repository.Store, userID, tierID int, now time.Time) (int, error) {
+	start, end := dayRange(now)
+	used, err := tx.RedeemLogs().CountByUserTier(userID, tierID, start, end)
+	if err != nil {
+		return 0, err
+	}
+
+	pending := model.OrderStatusPending
+	orders, err := tx.Orders().List(repository.OrderFilter{UserID: &userID, TierID: &tierID, Status: &pending})
+	if err != nil {
+		return 0, err
+	}
+	for _, order := range orders {
+		used += order.Quantity
+	}
+	return used, nil
+}
+
+// checkBuyer 在事务tx中校验用户能否在该档位再取quantity个：信任等级满足要求且不超过每日限购
+// 先锁定用户行，使同一用户的并发兑换/下单串行执行，校验使用事务内的最新用户信息，统计与领取CDK在同一事务中完成
+// 档位既没有等级要求也没有每日限购时不需要读取用户
+func checkBuyer(tx repository.Store, tier *model.Tier, userID, quantity int, now time.Time) error {
+	if tier.RequiredLevel <= 0 && tier.DailyLimit <= 0 {
+		return nil
+	}
+
+	user, err := tx.Users().LockByID(userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return fmt.Errorf("用户不存在")
+	}
+	if err != nil {
+		return err
+	}
+	if !TierEligible(tier, user) {
+		return fmt.Errorf("%w：该档位需要等级%d，当前等级%d", ErrTrustLevelTooLow, tier.RequiredLevel, user.TrustLevel)
+	}
+	return checkDailyLimit(tx, tier, userID, quantity, now)
+}
+
+// checkDailyLimit 校验用户本次再取quantity个是否超过档位每日限购（调用方须已在事务中锁定用户行）
+func checkDailyLimit(tx repository.Store, tier *model.Tier, userID, quantity int, now time.Time) error {
+	if tier.DailyLimit <= 0 {
+		return nil
+	}
+
+	used, err := dailyUsed(tx, userID, tier.ID, now)
+	if err != nil {
+		return err
+	}
+	if used+quantity > tier.DailyLimit {
+		return fmt.Errorf("%w：每人每日限购%d个，今日已购%d个", ErrDailyLimitExceeded, tier.DailyLimit, used)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
+)
+
+// createLimitedTier 创建每日限购为dailyLimit的档位
+func createLimitedTier(t *testing.T, store repository.Store, dailyLimit, cdkCount int) *model.Tier {
+	t.Helper()
+	tier := createTestTier(t, store, 10, true, cdkCount)
+	tier.DailyLimit = dailyLimit
+	if err := store.Tiers().Update(tier); err != nil {
+		t.Fatalf("更新档位失败: %v", err)
+	}
+	return tier
+}
+
+// createTestUser 创建测试用户
+func createTestUser(t *testing.T, store repository.Store, linuxDoID int) *model.User {
+	t.Helper()
+	now := time.Now()
+	user := &model.User{LinuxDoID: linuxDoID, Username: "user", CreatedAt: now, UpdatedAt: now}
+	if err := store.Users().Upsert(user); err != nil {
+		t.Fatalf("创建用户失败: %v", err)
+	}
+	return user
+}
+
+func TestDayRange(t *testing.T) {
+	tests := []struct {
+		name string
+		now  time.Time
+		want time.Time // UTC+8 当天零点
+	}{
+		{"UTC+8 白天", time.Date(2024, 1, 1, 4, 0, 0, 0, time.UTC), time.Date(2023, 12, 31, 16, 0, 0, 0, time.UTC)},
+		{"UTC下午已是UTC+8次日", time.Date(2024, 1, 1, 16, 30, 0, 0, time.UTC), time.Date(2024, 1, 1, 16, 0, 0, 0, time.UTC)},
+		{"UTC+8 零点前一秒", time.Date(2024, 1, 1, 15, 59, 59, 0, time.UTC), time.Date(2023, 12, 31, 16, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := dayRange(tt.now)
+			if !start.Equal(tt.want) || !end.Equal(tt.want.Add(24*time.Hour)) {
+				t.Errorf("dayRange() = [%v, %v), want 从 %v 开始的一天", start.UTC(), end.UTC(), tt.want)
+			}
+		})
+	}
+}
+
+func TestDailyLimitOrders(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store repository.Store) {
+		clock := newFakeClock() // 2024-01-01 12:00 UTC，即UTC+8当天20:00
+		svc := NewOrderService(store, clock.Now)
+		user := createTestUser(t, store, 1)
+		tier := createLimitedTier(t, store, 3, 10)
+
+		first, err := svc.CreateOrder(user.ID, tier.ID, 2)
+		if err != nil {
+			t.Fatalf("CreateOrder() error = %v", err)
+		}
+		// 待支付订单锁定的数量同样计入限购
+		if _, err := svc.CreateOrder(user.ID, tier.ID, 2); !errors.Is(err, ErrDailyLimitExceeded) {
+			t.Errorf("超出限购 error = %v, want ErrDailyLimitExceeded", err)
+		}
+		second, err := svc.CreateOrder(user.ID, tier.ID, 1)
+		if err != nil {
+			t.Fatalf("CreateOrder() error = %v", err)
+		}
+		for _, order := range []*model.Order{first, second} {
+			if _, err := svc.CompleteOrder(order.ID); err != nil {
+				t.Fatalf("CompleteOrder() error = %v", err)
+			}
+		}
+
+		// 其他用户不受影响
+		other := createTestUser(t, store, 2)
+		if _, err := svc.CreateOrder(other.ID, tier.ID, 1); err != nil {
+			t.Errorf("其他用户 CreateOrder() error = %v", err)
+		}
+
+		clock.Advance(3*time.Hour + 59*time.Minute) // UTC+8 23:59
+		if _, err := svc.CreateOrder(user.ID, tier.ID, 1); !errors.Is(err, ErrDailyLimitExceeded) {
+			t.Errorf("当天 error = %v, want ErrDailyLimitExceeded", err)
+		}
+		clock.Advance(time.Minute) // UTC+8 次日零点
+		if _, err := svc.CreateOrder(user.ID, tier.ID, 1); err != nil {
+			t.Errorf("次日 CreateOrder() error = %v", err)
+		}
+	})
+}
+
+// TestDailyLimitConcurrent 同一用户并发兑换：无论有多少并发请求，成功数量都不超过每日限购
+func TestDailyLimitConcurrent(t *testing.T) {
+	const (
+		dailyLimit = 3
+		workers    = 20
+	)
+
+	for name, pair := range openSharedStores(t) {
+		pair := pair
+		t.Run(name, func(t *testing.T) {
+			user := createTestUser(t, pair[0], 1)
+			tier := createLimitedTier(t, pair[0], dailyLimit, workers)
+			services := [2]*CDKService{NewCDKService(pair[0], testCipher, time.Now), NewCDKService(pair[1], testCipher, time.Now)}
+
+			var (
+				wg      sync.WaitGroup
+				mu      sync.Mutex
+				success int
+			)
+			for i := 0; i < workers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					_, err := services[i%2].ClaimNextCDK(tier.ID, user.ID)
+
+					mu.Lock()
+					defer mu.Unlock()
+					if err != nil {
+						if !errors.Is(err, ErrDailyLimitExceeded) {
+							t.Errorf("ClaimNextCDK() 非预期错误: %v", err)
+						}
+						return
+					}
+					success++
+				}(i)
+			}
+			wg.Wait()
+
+			if success != dailyLimit {
+				t.Errorf("成功兑换 %d 个, want %d", success, dailyLimit)
+			}
+		})
+	}
+}
+
+func TestGetUserStats(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store repository.Store) {
+		clock := newFakeClock() // UTC+8 2024-01-01 20:00
+		orders := NewOrderService(store, clock.Now)
+		logs := NewRedeemLogService(store)
+		user := createTestUser(t, store, 1)
+		limited := createLimitedTier(t, store, 3, 10)
+		unlimited := createTestTier(t, store, 10, true, 10)
+
+		// 昨天兑换1个，今天限购档位兑换1个、另有1个待支付，不限购档位兑换2个
+		yesterday := clock.Now().AddDate(0, 0, -1)
+		if err := store.RedeemLogs().Create(&model.RedeemLog{UserID: user.ID, CDKID: 100, TierID: limited.ID, CreatedAt: yesterday}); err != nil {
+			t.Fatalf("创建兑换记录失败: %v", err)
+		}
+		done, _ := orders.CreateOrder(user.ID, limited.ID, 1)
+		if _, err := orders.CompleteOrder(done.ID); err != nil {
+			t.Fatalf("CompleteOrder() error = %v", err)
+		}
+		if _, err := orders.CreateOrder(user.ID, limited.ID, 1); err != nil {
+			t.Fatalf("CreateOrder() error = %v", err)
+		}
+		paid, _ := orders.CreateOrder(user.ID, unlimited.ID, 2)
+		if _, err := orders.CompleteOrder(paid.ID); err != nil {
+			t.Fatalf("CompleteOrder() error = %v", err)
+		}
+
+		stats, err := logs.GetUserStats(user.ID, []model.Tier{*limited, *unlimited}, clock.Now())
+		if err != nil {
+			t.Fatalf("GetUserStats() error = %v", err)
+		}
+		if stats.TotalRedeemed != 4 {
+			t.Errorf("TotalRedeemed = %d, want 4", stats.TotalRedeemed)
+		}
+		tests := []struct {
+			name          string
+			got           TierRedeemStats
+			redeemedToday int
+			remaining     int
+		}{
+			{"限购档位扣除待支付订单", stats.Tiers[0], 1, 1},
+			{"不限购档位", stats.Tiers[1], 2, -1},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if tt.got.RedeemedToday != tt.redeemedToday || tt.got.Remaining != tt.remaining {
+					t.Errorf("RedeemedToday/Remaining = %d/%d, want %d/%d", tt.got.RedeemedToday, tt.got.Remaining, tt.redeemedToday, tt.remaining)
+				}
+			})
+		}
+	})
+}
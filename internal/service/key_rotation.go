@@ -0,0 +1,78 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
+	"github.com/zhongruan0522/DuiDuiMao/internal/util"
+)
+
+// Reencrypter 持有加密数据的服务：用当前密钥重新加密自己负责的数据，并计入result
+// 新增使用CDK密钥加密的数据时，由持有该数据的服务实现此接口，并注册到KeyRotationService
+type Reencrypter interface {
+	Reencrypt(result *RotateKeysResult) error
+}
+
+// RotateKeysResult 密钥轮换结果
+type RotateKeysResult struct {
+	KeyID   string `json:"key_id"`  // 当前密钥ID
+	Total   int    `json:"total"`   // 加密数据总数（CDK、两步验证密钥和LinuxDo令牌）
+	Rotated int    `json:"rotated"` // 本次重新加密的数量
+	Failed  int    `json:"failed"`  // 无法解密的数量（缺少旧密钥或数据损坏）
+}
+
+// KeyRotationService 密钥轮换服务：依次让各持有加密数据的服务用当前密钥重新加密
+type KeyRotationService struct {
+	cipher *util.CodeCipher
+	owners []Reencrypter
+}
+
+// NewKeyRotationService 创建密钥轮换服务，owners为所有持有加密数据的服务
+func NewKeyRotationService(cipher *util.CodeCipher, owners ...Reencrypter) *KeyRotationService {
+	return &KeyRotationService{cipher: cipher, owners: owners}
+}
+
+// RotateKeys 用当前密钥重新加密所有非当前密钥加密的数据：CDK（包括旧的双重Base64数据）、管理员的两步验证密钥和用户的LinuxDo令牌
+// 每条数据只替换密文且要求密文未变化，可以在服务运行时执行，也可重复执行
+func (s *KeyRotationService) RotateKeys() (*RotateKeysResult, error) {
+	if !s.cipher.Enabled() {
+		return nil, fmt.Errorf("未配置CDK加密密钥")
+	}
+
+	result := &RotateKeysResult{KeyID: s.cipher.CurrentKeyID()}
+	for _, owner := range s.owners {
+		if err := owner.Reencrypt(result); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// rotateValue 用当前密钥重新加密一条数据，update只在密文未变化时写入（已变化时返回ErrNotFound）
+func rotateValue(cipher *util.CodeCipher, result *RotateKeysResult, name, stored string, update func(encrypted string) error) error {
+	result.Total++
+	if !cipher.NeedsRotation(stored) {
+		return nil
+	}
+	plain, err := cipher.Decrypt(stored)
+	if err != nil {
+		log.Printf("%s 解密失败，跳过: %v", name, err)
+		result.Failed++
+		return nil
+	}
+	encrypted, err := cipher.Encrypt(plain)
+	if err != nil {
+		return fmt.Errorf("加密%s失败: %v", name, err)
+	}
+	err = update(encrypted)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil // 已被其他轮换任务处理或已被修改
+	}
+	if err != nil {
+		return err
+	}
+	result.Rotated++
+	return nil
+}
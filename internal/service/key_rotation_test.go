@@ -0,0 +1,27 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/config"
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
+	"github.com/zhongruan0522/DuiDuiMao/internal/util"
+)
+
+// newTestKeyRotation 与 cmd/server 一样注册所有持有加密数据的服务
+func newTestKeyRotation(store repository.Store, cipher *util.CodeCipher) *KeyRotationService {
+	return NewKeyRotationService(cipher,
+		NewCDKService(store, cipher, time.Now),
+		NewAdminAuthService(config.AdminConfig{}, nil, nil, store, cipher, time.Now),
+		NewUserSyncService(store, nil, nil, cipher, time.Now),
+	)
+}
+
+func TestKeyRotationWithoutKeys(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store repository.Store) {
+		if _, err := newTestKeyRotation(store, mustCipher("", nil)).RotateKeys(); err == nil {
+			t.Error("未配置密钥时 RotateKeys() error = nil, want 错误")
+		}
+	})
+}
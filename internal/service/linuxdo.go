@@ -0,0 +1,210 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/config"
+)
+
+// ErrAccountBlocked LinuxDo账号未激活或已被禁言，不允许登录
+var ErrAccountBlocked = errors.New("LinuxDo账号状态异常")
+
+// LinuxDoUser LinuxDo用户信息
+type LinuxDoUser struct {
+	ID         int    `json:"id"`
+	Username   string `json:"username"`
+	Name       string `json:"name"`
+	Active     bool   `json:"active"`
+	TrustLevel int    `json:"trust_level"`
+	Silenced   bool   `json:"silenced"`
+}
+
+// CheckAccount 校验账号状态：未激活或已被禁言的账号不允许登录
+func (u *LinuxDoUser) CheckAccount() error {
+	if !u.Active {
+		return fmt.Errorf("%w：账号未激活", ErrAccountBlocked)
+	}
+	if u.Silenced {
+		return fmt.Errorf("%w：账号已被禁言", ErrAccountBlocked)
+	}
+	return nil
+}
+
+// LinuxDoToken LinuxDo Connect令牌响应
+type LinuxDoToken struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// OAuthProvider OAuth登录提供方，默认为LinuxDo Connect，也可以是其他基于Discourse的Connect服务
+type OAuthProvider interface {
+	// AuthURL 构造授权跳转地址，codeChallenge不为空时附带PKCE参数
+	AuthURL(state, codeChallenge string) string
+	// ExchangeCode 用授权码换取令牌，codeVerifier为PKCE code_verifier（未启用PKCE时为空）
+	ExchangeCode(code, codeVerifier string) (*LinuxDoToken, error)
+	// RefreshToken 用refresh token换取新的令牌
+	RefreshToken(refreshToken string) (*LinuxDoToken, error)
+	// GetUser 用access token获取用户信息
+	GetUser(accessToken string) (*LinuxDoUser, error)
+}
+
+// LinuxDoClient LinuxDo Connect客户端（接口地址可通过配置替换为其他Connect服务）
+type LinuxDoClient struct {
+	mu        sync.RWMutex // 保护cfg和client（重新加载配置时更新）
+	cfg       config.OAuthConfig
+	client    *http.Client
+	ownClient bool // client是否按配置的超时时间创建（重新加载配置时随之更新）
+}
+
+// NewLinuxDoClient 创建LinuxDo Connect客户端，httpClient为nil时使用按配置超时时间创建的客户端
+func NewLinuxDoClient(cfg config.OAuthConfig, httpClient *http.Client) *LinuxDoClient {
+	c := &LinuxDoClient{cfg: cfg, client: httpClient}
+	if httpClient == nil {
+		c.client = &http.Client{Timeout: time.Duration(cfg.TimeoutSeconds) * time.Second}
+		c.ownClient = true
+	}
+	return c
+}
+
+// SetConfig 更新接口地址、Client ID/Secret和超时时间（重新加载配置后调用）
+func (c *LinuxDoClient) SetConfig(cfg config.OAuthConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cfg = cfg
+	if c.ownClient {
+		c.client = &http.Client{Timeout: time.Duration(cfg.TimeoutSeconds) * time.Second}
+	}
+}
+
+// current 当前的配置和HTTP客户端
+func (c *LinuxDoClient) current() (config.OAuthConfig, *http.Client) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cfg, c.client
+}
+
+// AuthURL 构造授权跳转地址，codeChallenge不为空时附带PKCE参数
+func (c *LinuxDoClient) AuthURL(state, codeChallenge string) string {
+	cfg, _ := c.current()
+	authURL := fmt.Sprintf(
+		"%s?client_id=%s&response_type=code&redirect_uri=%s&state=%s",
+		cfg.AuthorizeURL,
+		url.QueryEscape(cfg.AppClientID),
+		url.QueryEscape(cfg.RedirectURI),
+		url.QueryEscape(state),
+	)
+	if codeChallenge != "" {
+		authURL += "&code_challenge=" + url.QueryEscape(codeChallenge) + "&code_challenge_method=S256"
+	}
+	return authURL
+}
+
+// ExchangeCode 用授权码换取令牌，codeVerifier为发起登录时生成的PKCE code_verifier（未启用PKCE时为空）
+func (c *LinuxDoClient) ExchangeCode(code, codeVerifier string) (*LinuxDoToken, error) {
+	cfg, _ := c.current()
+	data := url.Values{}
+	data.Set("grant_type", "authorization_code")
+	data.Set("code", code)
+	data.Set("redirect_uri", cfg.RedirectURI)
+	if codeVerifier != "" {
+		data.Set("code_verifier", codeVerifier)
+	}
+	return c.requestToken(data)
+}
+
+// RefreshToken 用refresh token换取新的令牌
+func (c *LinuxDoClient) RefreshToken(refreshToken string) (*LinuxDoToken, error) {
+	data := url.Values{}
+	data.Set("grant_type", "refresh_token")
+	data.Set("refresh_token", refreshToken)
+	return c.requestToken(data)
+}
+
+// maxErrorBody 错误信息中最多保留的响应内容长度
+const maxErrorBody = 200
+
+// do 发送请求并读取响应，非200响应返回包含状态码和响应内容的错误
+func (c *LinuxDoClient) do(req *http.Request) ([]byte, error) {
+	_, client := c.current()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取响应失败: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if len(body) > maxErrorBody {
+			body = body[:maxErrorBody]
+		}
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// requestToken 请求令牌接口
+func (c *LinuxDoClient) requestToken(data url.Values) (*LinuxDoToken, error) {
+	cfg, _ := c.current()
+	req, err := http.NewRequest("POST", cfg.TokenURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	// 使用Basic Auth
+	req.SetBasicAuth(cfg.AppClientID, cfg.AppClientSecret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	body, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var token LinuxDoToken
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, fmt.Errorf("解析令牌响应失败: %v", err)
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("响应中没有access_token")
+	}
+	return &token, nil
+}
+
+// GetUser 用access token获取用户信息
+func (c *LinuxDoClient) GetUser(accessToken string) (*LinuxDoUser, error) {
+	cfg, _ := c.current()
+	req, err := http.NewRequest("GET", cfg.UserURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	body, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var user LinuxDoUser
+	if err := json.Unmarshal(body, &user); err != nil {
+		return nil, fmt.Errorf("解析用户信息失败: %v", err)
+	}
+	if user.ID == 0 {
+		return nil, fmt.Errorf("响应中没有用户信息")
+	}
+	return &user, nil
+}
@@ -0,0 +1,256 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/config"
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
+)
+
+const (
+	testOAuthClientID     = "test-client"
+	testOAuthClientSecret = "test-secret"
+	testOAuthRedirectURI  = "http://localhost:3001/api/auth/callback"
+)
+
+// fakeAuthCode 假Connect服务签发的授权码
+type fakeAuthCode struct {
+	challenge   string // PKCE code_challenge
+	accessToken string // 换取到的access token
+}
+
+// fakeLinuxDo 模拟LinuxDo Connect：授权后签发授权码，按access token返回用户信息，支持refresh token换取新令牌
+type fakeLinuxDo struct {
+	server    *httptest.Server
+	mu        sync.Mutex
+	login     LinuxDoUser             // 授权页上登录的用户
+	codes     map[string]fakeAuthCode // 授权码 -> 授权信息
+	users     map[string]*LinuxDoUser // access token -> 用户信息
+	refreshes map[string]string       // refresh token -> 新access token
+}
+
+func newFakeLinuxDo(t *testing.T) *fakeLinuxDo {
+	f := &fakeLinuxDo{codes: map[string]fakeAuthCode{}, users: map[string]*LinuxDoUser{}, refreshes: map[string]string{}}
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		switch r.URL.Path {
+		case "/oauth2/authorize":
+			query := r.URL.Query()
+			if query.Get("client_id") != testOAuthClientID || query.Get("redirect_uri") != testOAuthRedirectURI || query.Get("response_type") != "code" {
+				http.Error(w, "invalid_request", http.StatusBadRequest)
+				return
+			}
+			code := fmt.Sprintf("code-%d", len(f.codes)+1)
+			accessToken := "at-" + code
+			login := f.login
+			f.users[accessToken] = &login
+			f.codes[code] = fakeAuthCode{challenge: query.Get("code_challenge"), accessToken: accessToken}
+			http.Redirect(w, r, testOAuthRedirectURI+"?code="+code+"&state="+url.QueryEscape(query.Get("state")), http.StatusFound)
+		case "/oauth2/token":
+			if id, secret, ok := r.BasicAuth(); !ok || id != testOAuthClientID || secret != testOAuthClientSecret {
+				writeOAuthError(w, http.StatusUnauthorized, "invalid_client")
+				return
+			}
+			_ = r.ParseForm()
+			switch r.Form.Get("grant_type") {
+			case "authorization_code":
+				code, ok := f.codes[r.Form.Get("code")]
+				if !ok || r.Form.Get("redirect_uri") != testOAuthRedirectURI {
+					writeOAuthError(w, http.StatusBadRequest, "invalid_grant")
+					return
+				}
+				if code.challenge != "" && codeChallenge(r.Form.Get("code_verifier")) != code.challenge {
+					writeOAuthError(w, http.StatusBadRequest, "invalid_grant")
+					return
+				}
+				delete(f.codes, r.Form.Get("code"))
+				json.NewEncoder(w).Encode(LinuxDoToken{AccessToken: code.accessToken, RefreshToken: "rt-" + code.accessToken, TokenType: "bearer", ExpiresIn: 3600})
+			case "refresh_token":
+				accessToken, ok := f.refreshes[r.Form.Get("refresh_token")]
+				if !ok {
+					writeOAuthError(w, http.StatusBadRequest, "invalid_grant")
+					return
+				}
+				json.NewEncoder(w).Encode(LinuxDoToken{AccessToken: accessToken, ExpiresIn: 3600})
+			default:
+				writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type")
+			}
+		case "/api/user":
+			user, ok := f.users[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+			if !ok {
+				writeOAuthError(w, http.StatusUnauthorized, "invalid_token")
+				return
+			}
+			json.NewEncoder(w).Encode(user)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// writeOAuthError 返回OAuth错误响应
+func writeOAuthError(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+// setUser 设置access token对应的用户信息
+func (f *fakeLinuxDo) setUser(accessToken string, user LinuxDoUser) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.users[accessToken] = &user
+}
+
+// setRefresh 设置refresh token可换取的access token
+func (f *fakeLinuxDo) setRefresh(refreshToken, accessToken string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.refreshes[refreshToken] = accessToken
+}
+
+// config 指向假Connect服务的配置
+func (f *fakeLinuxDo) config() config.OAuthConfig {
+	return config.OAuthConfig{
+		AppClientID:     testOAuthClientID,
+		AppClientSecret: testOAuthClientSecret,
+		RedirectURI:     testOAuthRedirectURI,
+		AuthorizeURL:    f.server.URL + "/oauth2/authorize",
+		TokenURL:        f.server.URL + "/oauth2/token",
+		UserURL:         f.server.URL + "/api/user",
+		TimeoutSeconds:  5,
+	}
+}
+
+// client 创建连接到假Connect服务的客户端
+func (f *fakeLinuxDo) client() *LinuxDoClient {
+	return NewLinuxDoClient(f.config(), nil)
+}
+
+// authorize 模拟用户在授权页同意授权，返回回调地址中的授权码和state
+func (f *fakeLinuxDo) authorize(t *testing.T, authURL string) (code, state string) {
+	t.Helper()
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noRedirect.Get(authURL)
+	if err != nil {
+		t.Fatalf("访问授权地址失败: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("授权失败: HTTP %d", resp.StatusCode)
+	}
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("回调地址无效: %v", err)
+	}
+	return callback.Query().Get("code"), callback.Query().Get("state")
+}
+
+func TestCheckAccount(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    LinuxDoUser
+		blocked bool
+	}{
+		{"正常账号", LinuxDoUser{Active: true}, false},
+		{"未激活", LinuxDoUser{Active: false}, true},
+		{"已被禁言", LinuxDoUser{Active: true, Silenced: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.user.CheckAccount()
+			if got := errors.Is(err, ErrAccountBlocked); got != tt.blocked {
+				t.Errorf("CheckAccount() error = %v, blocked = %v, want %v", err, got, tt.blocked)
+			}
+		})
+	}
+}
+
+func TestLinuxDoLoginFlow(t *testing.T) {
+	for _, pkce := range []bool{false, true} {
+		t.Run(fmt.Sprintf("pkce=%v", pkce), func(t *testing.T) {
+			linuxDo := newFakeLinuxDo(t)
+			linuxDo.login = LinuxDoUser{ID: 42, Username: "neo", Active: true, TrustLevel: 2}
+			var provider OAuthProvider = linuxDo.client()
+			states := NewOAuthStateService(repository.NewMemoryOAuthStateRepository(), 10*time.Minute, pkce, newFakeClock().Now)
+
+			login, err := states.Begin()
+			if err != nil {
+				t.Fatalf("Begin() error = %v", err)
+			}
+			code, state := linuxDo.authorize(t, provider.AuthURL(login.State, login.CodeChallenge))
+			if state != login.State {
+				t.Fatalf("回调state = %q, want %q", state, login.State)
+			}
+			verifier, err := states.Verify(state, login.State)
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+
+			// 启用PKCE时缺少code_verifier无法换取令牌
+			if pkce {
+				if _, err := provider.ExchangeCode(code, ""); err == nil {
+					t.Error("ExchangeCode(缺少code_verifier) 应返回错误")
+				}
+			}
+			token, err := provider.ExchangeCode(code, verifier)
+			if err != nil {
+				t.Fatalf("ExchangeCode() error = %v", err)
+			}
+			user, err := provider.GetUser(token.AccessToken)
+			if err != nil {
+				t.Fatalf("GetUser() error = %v", err)
+			}
+			if user.ID != 42 || user.Username != "neo" || user.TrustLevel != 2 {
+				t.Errorf("GetUser() = %+v", user)
+			}
+
+			// 授权码只能使用一次
+			if _, err := provider.ExchangeCode(code, verifier); err == nil {
+				t.Error("ExchangeCode(授权码已使用) 应返回错误")
+			}
+		})
+	}
+}
+
+func TestLinuxDoErrorResponses(t *testing.T) {
+	linuxDo := newFakeLinuxDo(t)
+
+	// 非200响应不再被当作空JSON解析，错误中带有状态码和响应内容
+	badSecret := linuxDo.config()
+	badSecret.AppClientSecret = "wrong"
+	if _, err := NewLinuxDoClient(badSecret, nil).RefreshToken("rt"); err == nil || !strings.Contains(err.Error(), "HTTP 401") || !strings.Contains(err.Error(), "invalid_client") {
+		t.Errorf("RefreshToken(密钥错误) error = %v, want HTTP 401 invalid_client", err)
+	}
+	if _, err := linuxDo.client().GetUser("unknown"); err == nil || !strings.Contains(err.Error(), "HTTP 401") {
+		t.Errorf("GetUser(令牌无效) error = %v, want HTTP 401", err)
+	}
+	notFound := linuxDo.config()
+	notFound.UserURL = linuxDo.server.URL + "/missing"
+	if _, err := NewLinuxDoClient(notFound, nil).GetUser("unknown"); err == nil || !strings.Contains(err.Error(), "HTTP 404") {
+		t.Errorf("GetUser(地址错误) error = %v, want HTTP 404", err)
+	}
+
+	// 注入的HTTP客户端超时生效
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+	slowCfg := linuxDo.config()
+	slowCfg.UserURL = slow.URL
+	if _, err := NewLinuxDoClient(slowCfg, &http.Client{Timeout: 20 * time.Millisecond}).GetUser("at"); err == nil {
+		t.Error("GetUser(超时) 应返回错误")
+	}
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// loginAttempts 某个IP或用户名的连续登录失败情况
+type loginAttempts struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// LoginLimiter 登录失败限流：超过免费次数后每次失败锁定的时长按指数增长，直到上限
+// 锁定期间的尝试不计入失败次数；距最近一次失败超过forget后重新计数
+type LoginLimiter struct {
+	mu      sync.Mutex
+	entries map[string]*loginAttempts
+	free    int           // 不锁定的连续失败次数
+	base    time.Duration // 第一次锁定的时长，此后每次翻倍
+	max     time.Duration // 单次锁定的最长时长
+	forget  time.Duration // 多久没有失败后清零
+	now     Clock
+}
+
+// NewLoginLimiter 创建登录限流器
+func NewLoginLimiter(free int, base, max time.Duration, clock Clock) *LoginLimiter {
+	return &LoginLimiter{
+		entries: make(map[string]*loginAttempts),
+		free:    free,
+		base:    base,
+		max:     max,
+		forget:  4 * max,
+		now:     clock,
+	}
+}
+
+// SetLimits 更新不锁定的失败次数和单次锁定的最长时长（重新加载配置后调用，已有的锁定不受影响）
+func (l *LoginLimiter) SetLimits(free int, max time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.free = free
+	l.max = max
+	l.forget = 4 * max
+}
+
+// Wait 返回keys中最长的剩余锁定时长，未锁定时返回0
+func (l *LoginLimiter) Wait(keys ...string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	var wait time.Duration
+	for _, key := range keys {
+		if entry, ok := l.entries[key]; ok && entry.lockedUntil.After(now) {
+			if d := entry.lockedUntil.Sub(now); d > wait {
+				wait = d
+			}
+		}
+	}
+	return wait
+}
+
+// Fail 记录一次登录失败，返回因此产生的最长锁定时长（未锁定时返回0）
+func (l *LoginLimiter) Fail(keys ...string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	var wait time.Duration
+	for _, key := range keys {
+		entry, ok := l.entries[key]
+		if !ok {
+			entry = &loginAttempts{}
+			l.entries[key] = entry
+		}
+		entry.failures++
+		entry.lastFailure = now
+		if entry.failures <= l.free {
+			continue
+		}
+		lock := l.max
+		if shift := entry.failures - l.free - 1; shift < 32 {
+			if d := l.base << shift; d > 0 && d < l.max {
+				lock = d
+			}
+		}
+		entry.lockedUntil = now.Add(lock)
+		if lock > wait {
+			wait = lock
+		}
+	}
+	return wait
+}
+
+// Reset 登录成功后清除keys的失败记录
+func (l *LoginLimiter) Reset(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		delete(l.entries, key)
+	}
+}
+
+// sweep 清理长时间没有失败且未锁定的记录，避免大量IP占用内存
+func (l *LoginLimiter) sweep(now time.Time) {
+	for key, entry := range l.entries {
+		if now.Sub(entry.lastFailure) > l.forget && !entry.lockedUntil.After(now) {
+			delete(l.entries, key)
+		}
+	}
+}
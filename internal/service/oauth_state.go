@@ -0,0 +1,105 @@
+package service
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
+)
+
+// ErrOAuthStateInvalid state不存在、已使用、已过期或与浏览器Cookie不一致
+var ErrOAuthStateInvalid = errors.New("登录请求无效或已过期，请重新登录")
+
+// OAuthStateService OAuth state服务：发起登录时生成一次性state（及PKCE参数），回调时校验
+type OAuthStateService struct {
+	repo repository.OAuthStateRepository
+	mu   sync.RWMutex  // 保护ttl和pkce（重新加载配置时更新）
+	ttl  time.Duration // state有效期
+	pkce bool          // 是否使用PKCE（code_challenge_method=S256）
+	now  Clock
+}
+
+// NewOAuthStateService 创建OAuth state服务
+func NewOAuthStateService(repo repository.OAuthStateRepository, ttl time.Duration, pkce bool, clock Clock) *OAuthStateService {
+	return &OAuthStateService{repo: repo, ttl: ttl, pkce: pkce, now: clock}
+}
+
+// OAuthLogin 发起登录时生成的参数
+type OAuthLogin struct {
+	State         string    // 授权地址中的state，同时写入浏览器Cookie
+	CodeChallenge string    // PKCE code_challenge（未启用PKCE时为空）
+	ExpiresAt     time.Time // state过期时间
+}
+
+// codeChallenge 按S256方法计算code_verifier对应的code_challenge
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// SetOptions 更新state有效期和是否使用PKCE（重新加载配置后调用）
+func (s *OAuthStateService) SetOptions(ttl time.Duration, pkce bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ttl = ttl
+	s.pkce = pkce
+}
+
+// Begin 生成并保存一次性state，启用PKCE时同时生成code_verifier
+func (s *OAuthStateService) Begin() (*OAuthLogin, error) {
+	now := s.now()
+	// 顺带清理过期的state（用户发起登录后未完成授权）
+	if _, err := s.repo.DeleteExpired(now); err != nil {
+		log.Printf("清理过期的OAuth state失败: %v", err)
+	}
+
+	state, err := randomToken(24)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	ttl, pkce := s.ttl, s.pkce
+	s.mu.RUnlock()
+
+	stored := &model.OAuthState{State: state, CreatedAt: now, ExpiresAt: now.Add(ttl)}
+	login := &OAuthLogin{State: state, ExpiresAt: stored.ExpiresAt}
+	if pkce {
+		// 32字节随机数编码后为43个字符，满足RFC 7636对code_verifier长度的要求
+		if stored.CodeVerifier, err = randomToken(32); err != nil {
+			return nil, err
+		}
+		login.CodeChallenge = codeChallenge(stored.CodeVerifier)
+	}
+
+	if err := s.repo.Create(stored); err != nil {
+		return nil, fmt.Errorf("保存OAuth state失败: %v", err)
+	}
+	return login, nil
+}
+
+// Verify 校验回调中的state与浏览器Cookie中的一致且未使用、未过期，返回PKCE code_verifier
+// state无论校验是否通过都会被删除，不能重复使用
+func (s *OAuthStateService) Verify(state, cookieState string) (string, error) {
+	if state == "" {
+		return "", ErrOAuthStateInvalid
+	}
+	stored, err := s.repo.Take(state, s.now())
+	if errors.Is(err, repository.ErrNotFound) {
+		return "", ErrOAuthStateInvalid
+	}
+	if err != nil {
+		return "", err
+	}
+	// 登录CSRF防护：state必须属于发起登录的同一浏览器
+	if subtle.ConstantTimeCompare([]byte(state), []byte(cookieState)) != 1 {
+		return "", ErrOAuthStateInvalid
+	}
+	return stored.CodeVerifier, nil
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/config"
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
+)
+
+func TestOAuthState(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store repository.Store) {
+		clock := newFakeClock()
+		states := NewOAuthStateService(store.OAuthStates(), 10*time.Minute, false, clock.Now)
+
+		first, err := states.Begin()
+		if err != nil {
+			t.Fatalf("Begin() error = %v", err)
+		}
+		if first.CodeChallenge != "" {
+			t.Errorf("未启用PKCE时 CodeChallenge = %q, want 空", first.CodeChallenge)
+		}
+		second, _ := states.Begin()
+		third, _ := states.Begin()
+
+		tests := []struct {
+			name   string
+			state  string
+			cookie string
+			ok     bool
+		}{
+			{"Cookie不一致", first.State, second.State, false},
+			{"已因Cookie不一致而作废", first.State, first.State, false},
+			{"缺少Cookie", second.State, "", false},
+			{"缺少state", "", "", false},
+			{"未知state", "forged", "forged", false},
+			{"正常", third.State, third.State, true},
+			{"重复使用", third.State, third.State, false},
+		}
+		for _, tt := range tests {
+			_, err := states.Verify(tt.state, tt.cookie)
+			if tt.ok && err != nil {
+				t.Errorf("%s: Verify() error = %v", tt.name, err)
+			}
+			if !tt.ok && !errors.Is(err, ErrOAuthStateInvalid) {
+				t.Errorf("%s: Verify() error = %v, want ErrOAuthStateInvalid", tt.name, err)
+			}
+		}
+
+		// 超过有效期未完成授权
+		expired, _ := states.Begin()
+		clock.Advance(10 * time.Minute)
+		if _, err := states.Verify(expired.State, expired.State); !errors.Is(err, ErrOAuthStateInvalid) {
+			t.Errorf("Verify(已过期) error = %v, want ErrOAuthStateInvalid", err)
+		}
+	})
+}
+
+func TestOAuthStatePKCE(t *testing.T) {
+	clock := newFakeClock()
+	states := NewOAuthStateService(repository.NewMemoryOAuthStateRepository(), 10*time.Minute, true, clock.Now)
+	login, err := states.Begin()
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+
+	client := NewLinuxDoClient(config.OAuthConfig{AppClientID: "test-client", RedirectURI: "http://localhost/callback", AuthorizeURL: "http://localhost/oauth2/authorize"}, nil)
+	authURL, err := url.Parse(client.AuthURL(login.State, login.CodeChallenge))
+	if err != nil {
+		t.Fatalf("AuthURL() 不是有效的URL: %v", err)
+	}
+	query := authURL.Query()
+	if query.Get("state") != login.State || query.Get("code_challenge") != login.CodeChallenge || query.Get("code_challenge_method") != "S256" {
+		t.Errorf("AuthURL() = %s", authURL)
+	}
+
+	verifier, err := states.Verify(login.State, login.State)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if len(verifier) < 43 || strings.ContainsAny(verifier, "+/=") {
+		t.Errorf("code_verifier = %q，不符合RFC 7636", verifier)
+	}
+	if codeChallenge(verifier) != login.CodeChallenge {
+		t.Errorf("code_challenge 与 code_verifier 不匹配")
+	}
+}
@@ -0,0 +1,349 @@
+package service
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
+)
+
+// MaxOrderQuantity 单个订单最多购买的CDK数量
+const MaxOrderQuantity = 100
+
+// defaultOrderExpireMinutes 未配置订单超时时间时的默认值（分钟）
+const defaultOrderExpireMinutes = 15
+
+// Clock 时间来源（测试中可替换为可控的时钟）
+type Clock func() time.Time
+
+// OrderService 订单服务
+type OrderService struct {
+	store repository.Store // 下单/完成/关闭需要在同一事务中更新订单、CDK和兑换记录
+	repo  repository.OrderRepository
+	now   Clock
+}
+
+// NewOrderService 创建订单服务
+func NewOrderService(store repository.Store, clock Clock) *OrderService {
+	return &OrderService{store: store, repo: store.Orders(), now: clock}
+}
+
+// newOrderNo 生成订单号：时间戳 + 6位随机数
+func newOrderNo(now time.Time) (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%06d", now.Format("20060102150405"), n.Int64()), nil
+}
+
+// orderExpireDuration 订单超时时间（读取当前系统设置，修改后立即生效）
+func orderExpireDuration(repo repository.SettingsRepository) (time.Duration, error) {
+	settings, err := loadSettings(repo)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(settings.OrderExpireMinutes) * time.Minute, nil
+}
+
+// CreateOrder 创建待支付订单：在同一事务中校验档位、信任等级和每日限购，锁定quantity个CDK并关联到订单
+// 订单在超时时间内未完成时，由OrderSweeper关闭并释放锁定的CDK
+func (s *OrderService) CreateOrder(userID, tierID, quantity int) (*model.Order, error) {
+	if quantity < 1 || quantity > MaxOrderQuantity {
+		return nil, fmt.Errorf("购买数量必须在1到%d之间", MaxOrderQuantity)
+	}
+
+	var order *model.Order
+	err := s.store.Tx(func(tx repository.Store) error {
+		tier, err := tx.Tiers().GetByID(tierID)
+		if errors.Is(err, repository.ErrNotFound) {
+			return fmt.Errorf("档位不存在")
+		}
+		if err != nil {
+			return err
+		}
+		if !tier.IsActive {
+			return fmt.Errorf("该档位未启用")
+		}
+
+		now := s.now()
+		if err := checkTierOnSale(tier, now); err != nil {
+			return err
+		}
+		if err := checkBuyer(tx, tier, userID, quantity, now); err != nil {
+			return err
+		}
+
+		expire, err := orderExpireDuration(tx.Settings())
+		if err != nil {
+			return err
+		}
+		orderNo, err := newOrderNo(now)
+		if err != nil {
+			return fmt.Errorf("生成订单号失败: %v", err)
+		}
+		order = &model.Order{
+			OrderNo:    orderNo,
+			UserID:     userID,
+			TierID:     tierID,
+			Quantity:   quantity,
+			TotalQuota: tier.Quota * quantity,
+			TotalPrice: tier.Price * quantity,
+			Status:     model.OrderStatusPending,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+			ExpiredAt:  now.Add(expire),
+		}
+		if err := tx.Orders().Create(order); err != nil {
+			return fmt.Errorf("创建订单失败: %v", err)
+		}
+
+		_, err = tx.CDKs().LockForOrder(order, now)
+		if errors.Is(err, repository.ErrNotFound) {
+			return fmt.Errorf("该档位库存不足")
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// CompleteOrder 完成待支付订单：把锁定的CDK发放给下单用户并写入兑换记录
+// 对已完成的订单重复调用直接返回该订单（支付回调可能重复通知）
+func (s *OrderService) CompleteOrder(orderID int) (*model.Order, error) {
+	var order *model.Order
+	err := s.store.Tx(func(tx repository.Store) error {
+		var err error
+		order, err = s.completeOrder(tx, orderID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// completeOrder 在事务tx中完成订单（供需要同时写入支付记录的调用方复用）
+func (s *OrderService) completeOrder(tx repository.Store, orderID int) (*model.Order, error) {
+	order, err := tx.Orders().GetByID(orderID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, fmt.Errorf("订单不存在")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if order.Status == model.OrderStatusCompleted {
+		return order, nil
+	}
+	if order.Status != model.OrderStatusPending {
+		return nil, fmt.Errorf("订单已关闭")
+	}
+
+	now := s.now()
+	order.Status = model.OrderStatusCompleted
+	order.PaidAt = now
+	order.UpdatedAt = now
+	err = tx.Orders().UpdateIfStatus(order, model.OrderStatusPending)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, fmt.Errorf("订单状态已变化")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cdks, err := tx.CDKs().ListByOrder(order.ID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range cdks {
+		cdk := &cdks[i]
+		if cdk.Status != model.CDKStatusLocked {
+			continue
+		}
+		cdk.Status = model.CDKStatusRedeemed
+		cdk.RedeemedBy = order.UserID
+		cdk.RedeemedAt = now
+		cdk.UpdatedAt = now
+		if err := tx.CDKs().Update(cdk); err != nil {
+			return nil, err
+		}
+
+		err := tx.RedeemLogs().Create(&model.RedeemLog{
+			UserID:    order.UserID,
+			CDKID:     cdk.ID,
+			TierID:    order.TierID,
+			CreatedAt: now,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("写入兑换记录失败: %v", err)
+		}
+	}
+	return order, nil
+}
+
+// closeOrder 关闭待支付订单（取消/超时/失败），释放订单锁定的CDK，并把处理中的支付记录标记为失败
+// 订单已不是待支付状态时返回repository.ErrNotFound
+func (s *OrderService) closeOrder(tx repository.Store, order *model.Order, status int) error {
+	now := s.now()
+	order.Status = status
+	order.UpdatedAt = now
+	if err := tx.Orders().UpdateIfStatus(order, model.OrderStatusPending); err != nil {
+		return err
+	}
+
+	cdks, err := tx.CDKs().ListByOrder(order.ID)
+	if err != nil {
+		return err
+	}
+	for i := range cdks {
+		cdk := &cdks[i]
+		if cdk.Status != model.CDKStatusLocked {
+			continue
+		}
+		cdk.Status = model.CDKStatusAvailable
+		cdk.OrderID = 0
+		cdk.UpdatedAt = now
+		if err := tx.CDKs().Update(cdk); err != nil {
+			return err
+		}
+	}
+
+	payments, err := tx.Payments().ListByOrder(order.ID)
+	if err != nil {
+		return err
+	}
+	for i := range payments {
+		payment := &payments[i]
+		if payment.Status != model.PaymentStatusProcessing {
+			continue
+		}
+		payment.Status = model.PaymentStatusFailed
+		payment.FailReason = "订单已关闭"
+		payment.FinishedAt = now
+		if err := tx.Payments().Update(payment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExpireOrders 关闭所有已超时的待支付订单并释放锁定的CDK，返回关闭的订单数量
+func (s *OrderService) ExpireOrders() (int, error) {
+	status := model.OrderStatusPending
+	orders, err := s.repo.List(repository.OrderFilter{Status: &status})
+	if err != nil {
+		return 0, err
+	}
+
+	now := s.now()
+	expired := 0
+	for i := range orders {
+		order := &orders[i]
+		if order.ExpiredAt.IsZero() || now.Before(order.ExpiredAt) {
+			continue
+		}
+
+		err := s.store.Tx(func(tx repository.Store) error {
+			return s.closeOrder(tx, order, model.OrderStatusCancelled)
+		})
+		if errors.Is(err, repository.ErrNotFound) {
+			continue // 订单已在此期间完成或取消
+		}
+		if err != nil {
+			return expired, fmt.Errorf("关闭超时订单 %s 失败: %v", order.OrderNo, err)
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// GetOrders 按条件获取订单列表（管理员使用）
+func (s *OrderService) GetOrders(filter repository.OrderFilter) ([]model.Order, error) {
+	return s.repo.List(filter)
+}
+
+// GetUserOrders 获取用户的订单列表
+func (s *OrderService) GetUserOrders(userID int) ([]model.Order, error) {
+	return s.repo.List(repository.OrderFilter{UserID: &userID})
+}
+
+// GetOrderByNo 根据订单号获取订单
+func (s *OrderService) GetOrderByNo(orderNo string) (*model.Order, error) {
+	order, err := s.repo.GetByOrderNo(orderNo)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, fmt.Errorf("订单不存在")
+	}
+	return order, err
+}
+
+// GetOrderByID 根据ID获取订单
+func (s *OrderService) GetOrderByID(id int) (*model.Order, error) {
+	order, err := s.repo.GetByID(id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, fmt.Errorf("订单不存在")
+	}
+	return order, err
+}
+
+// GetUserOrder 获取用户自己的订单（不属于该用户时同样返回订单不存在）
+func (s *OrderService) GetUserOrder(userID int, orderNo string) (*model.Order, error) {
+	order, err := s.GetOrderByNo(orderNo)
+	if err != nil {
+		return nil, err
+	}
+	if order.UserID != userID {
+		return nil, fmt.Errorf("订单不存在")
+	}
+	return order, nil
+}
+
+// GetOrderCDKs 获取订单关联的CDK
+func (s *OrderService) GetOrderCDKs(orderID int) ([]model.CDK, error) {
+	return s.store.CDKs().ListByOrder(orderID)
+}
+
+// GetUserOrderCDKs 获取用户已完成订单的CDK
+func (s *OrderService) GetUserOrderCDKs(userID int, orderNo string) ([]model.CDK, error) {
+	order, err := s.GetUserOrder(userID, orderNo)
+	if err != nil {
+		return nil, err
+	}
+	if order.Status != model.OrderStatusCompleted {
+		return nil, fmt.Errorf("订单未完成，无法查看CDK")
+	}
+	return s.GetOrderCDKs(order.ID)
+}
+
+// CancelOrder 取消用户的待支付订单，并释放订单锁定的CDK
+func (s *OrderService) CancelOrder(userID int, orderNo string) (*model.Order, error) {
+	var order *model.Order
+	err := s.store.Tx(func(tx repository.Store) error {
+		var err error
+		order, err = tx.Orders().GetByOrderNo(orderNo)
+		if errors.Is(err, repository.ErrNotFound) || (err == nil && order.UserID != userID) {
+			return fmt.Errorf("订单不存在")
+		}
+		if err != nil {
+			return err
+		}
+		if order.Status != model.OrderStatusPending {
+			return fmt.Errorf("只能取消待支付的订单")
+		}
+
+		err = s.closeOrder(tx, order, model.OrderStatusCancelled)
+		if errors.Is(err, repository.ErrNotFound) {
+			return fmt.Errorf("只能取消待支付的订单")
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
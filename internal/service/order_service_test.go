@@ -0,0 +1,266 @@
+package service
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
+)
+
+// forEachStore 对CSV和SQLite存储分别运行同一组测试
+func forEachStore(t *testing.T, fn func(t *testing.T, store repository.Store)) {
+	t.Run("csv", func(t *testing.T) {
+		store, err := repository.NewCSVStore(t.TempDir())
+		if err != nil {
+			t.Fatalf("创建CSV存储失败: %v", err)
+		}
+		fn(t, store)
+	})
+
+	t.Run("sqlite", func(t *testing.T) {
+		store, err := repository.OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatalf("打开SQLite失败: %v", err)
+		}
+		defer store.Close()
+		fn(t, store)
+	})
+}
+
+// fakeClock 可手动推进的测试时钟
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// createTestTier 创建档位并导入指定数量的CDK
+func createTestTier(t *testing.T, store repository.Store, quota int, active bool, cdkCount int) *model.Tier {
+	t.Helper()
+	now := time.Now()
+	tier := &model.Tier{Name: "测试档位", Quota: quota, IsActive: active, CreatedAt: now, UpdatedAt: now}
+	if err := store.Tiers().Create(tier); err != nil {
+		t.Fatalf("创建档位失败: %v", err)
+	}
+
+	codes := []string{}
+	for i := 0; i < cdkCount; i++ {
+		codes = append(codes, fmt.Sprintf("TIER%d-CDK-%03d", tier.ID, i))
+	}
+	if cdkCount > 0 {
+		if _, err := NewCDKService(store, testCipher, time.Now).BatchImportCDKs(tier.ID, codes); err != nil {
+			t.Fatalf("导入CDK失败: %v", err)
+		}
+	}
+	return tier
+}
+
+func TestCreateOrder(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store repository.Store) {
+		clock := newFakeClock()
+		svc := NewOrderService(store, clock.Now)
+		tier := createTestTier(t, store, 10, true, 3)
+
+		order, err := svc.CreateOrder(1, tier.ID, 2)
+		if err != nil {
+			t.Fatalf("CreateOrder() error = %v", err)
+		}
+		if order.Status != model.OrderStatusPending || order.TotalQuota != 20 || order.OrderNo == "" {
+			t.Errorf("CreateOrder() = %+v", order)
+		}
+		if want := clock.Now().Add(defaultOrderExpireMinutes * time.Minute); !order.ExpiredAt.Equal(want) {
+			t.Errorf("ExpiredAt = %v, want %v", order.ExpiredAt, want)
+		}
+
+		// 待支付订单的CDK处于锁定状态，不计入库存，也不能查看
+		counts, _ := store.CDKs().CountAvailableByTier(time.Now())
+		if counts[tier.ID] != 1 {
+			t.Errorf("下单后可用CDK = %d, want 1", counts[tier.ID])
+		}
+		if _, err := svc.GetUserOrderCDKs(1, order.OrderNo); err == nil {
+			t.Error("待支付订单不应能查看CDK")
+		}
+
+		// 重复完成订单是幂等的
+		for i := 0; i < 2; i++ {
+			completed, err := svc.CompleteOrder(order.ID)
+			if err != nil {
+				t.Fatalf("CompleteOrder() error = %v", err)
+			}
+			if completed.Status != model.OrderStatusCompleted || !completed.PaidAt.Equal(clock.Now()) {
+				t.Errorf("CompleteOrder() = %+v", completed)
+			}
+		}
+
+		cdks, err := svc.GetUserOrderCDKs(1, order.OrderNo)
+		if err != nil {
+			t.Fatalf("GetUserOrderCDKs() error = %v", err)
+		}
+		if len(cdks) != 2 {
+			t.Fatalf("订单CDK数量 = %d, want 2", len(cdks))
+		}
+		for _, cdk := range cdks {
+			if cdk.OrderID != order.ID || cdk.RedeemedBy != 1 || cdk.Status != model.CDKStatusRedeemed {
+				t.Errorf("订单CDK = %+v", cdk)
+			}
+		}
+
+		logs, _ := store.RedeemLogs().ListByUser(1)
+		if len(logs) != 2 {
+			t.Errorf("兑换记录数量 = %d, want 2", len(logs))
+		}
+		if _, err := svc.GetUserOrderCDKs(2, order.OrderNo); err == nil || !strings.Contains(err.Error(), "订单不存在") {
+			t.Errorf("其他用户查看订单CDK error = %v, want 订单不存在", err)
+		}
+		if _, err := svc.CancelOrder(1, order.OrderNo); err == nil || !strings.Contains(err.Error(), "只能取消待支付的订单") {
+			t.Errorf("CancelOrder() error = %v, want 只能取消待支付的订单", err)
+		}
+	})
+}
+
+func TestCancelOrder(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store repository.Store) {
+		svc := NewOrderService(store, newFakeClock().Now)
+		tier := createTestTier(t, store, 10, true, 2)
+
+		order, err := svc.CreateOrder(1, tier.ID, 2)
+		if err != nil {
+			t.Fatalf("CreateOrder() error = %v", err)
+		}
+		if _, err := svc.CancelOrder(2, order.OrderNo); err == nil || !strings.Contains(err.Error(), "订单不存在") {
+			t.Errorf("其他用户取消订单 error = %v, want 订单不存在", err)
+		}
+
+		cancelled, err := svc.CancelOrder(1, order.OrderNo)
+		if err != nil {
+			t.Fatalf("CancelOrder() error = %v", err)
+		}
+		if cancelled.Status != model.OrderStatusCancelled {
+			t.Errorf("CancelOrder() 状态 = %d, want %d", cancelled.Status, model.OrderStatusCancelled)
+		}
+
+		// 取消后CDK释放回库存，订单不能再完成
+		counts, _ := store.CDKs().CountAvailableByTier(time.Now())
+		if counts[tier.ID] != 2 {
+			t.Errorf("取消后可用CDK = %d, want 2", counts[tier.ID])
+		}
+		if cdks, _ := svc.GetOrderCDKs(order.ID); len(cdks) != 0 {
+			t.Errorf("取消后订单仍关联 %d 个CDK", len(cdks))
+		}
+		if _, err := svc.CompleteOrder(order.ID); err == nil || !strings.Contains(err.Error(), "订单已关闭") {
+			t.Errorf("CompleteOrder() error = %v, want 订单已关闭", err)
+		}
+	})
+}
+
+func TestOrderSweeper(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store repository.Store) {
+		clock := newFakeClock()
+		svc := NewOrderService(store, clock.Now)
+		tier := createTestTier(t, store, 10, true, 3)
+
+		stale, err := svc.CreateOrder(1, tier.ID, 2)
+		if err != nil {
+			t.Fatalf("CreateOrder() error = %v", err)
+		}
+		clock.Advance(10 * time.Minute)
+		fresh, err := svc.CreateOrder(2, tier.ID, 1)
+		if err != nil {
+			t.Fatalf("CreateOrder() error = %v", err)
+		}
+
+		// 未到超时时间时不应关闭任何订单
+		if n, err := svc.ExpireOrders(); err != nil || n != 0 {
+			t.Fatalf("ExpireOrders() = %d, %v, want 0", n, err)
+		}
+
+		// 推进到第一个订单超时、第二个订单未超时
+		clock.Advance(defaultOrderExpireMinutes*time.Minute - 10*time.Minute)
+		sweeper := NewOrderSweeper(svc, 5*time.Millisecond)
+		sweeper.Start()
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			order, _ := svc.GetOrderByNo(stale.OrderNo)
+			if order.Status == model.OrderStatusCancelled {
+				break
+			}
+			if time.Now().After(deadline) {
+				sweeper.Stop()
+				t.Fatalf("超时订单未被关闭: %+v", order)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		sweeper.Stop()
+
+		counts, _ := store.CDKs().CountAvailableByTier(time.Now())
+		if counts[tier.ID] != 2 {
+			t.Errorf("超时释放后可用CDK = %d, want 2", counts[tier.ID])
+		}
+		order, _ := svc.GetOrderByNo(fresh.OrderNo)
+		if order.Status != model.OrderStatusPending {
+			t.Errorf("未超时订单状态 = %d, want %d", order.Status, model.OrderStatusPending)
+		}
+		if cdks, _ := svc.GetOrderCDKs(fresh.ID); len(cdks) != 1 || cdks[0].Status != model.CDKStatusLocked {
+			t.Errorf("未超时订单的CDK = %+v", cdks)
+		}
+	})
+}
+
+func TestCreateOrderRejected(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store repository.Store) {
+		svc := NewOrderService(store, time.Now)
+		tier := createTestTier(t, store, 10, true, 1)
+		inactive := createTestTier(t, store, 10, false, 0)
+
+		tests := []struct {
+			name     string
+			tierID   int
+			quantity int
+			wantErr  string
+		}{
+			{"数量为0", tier.ID, 0, "购买数量必须在1到"},
+			{"数量超过上限", tier.ID, MaxOrderQuantity + 1, "购买数量必须在1到"},
+			{"档位不存在", 999, 1, "档位不存在"},
+			{"档位未启用", inactive.ID, 1, "该档位未启用"},
+			{"库存不足", tier.ID, 2, "该档位库存不足"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := svc.CreateOrder(1, tt.tierID, tt.quantity)
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("CreateOrder() error = %v, want %s", err, tt.wantErr)
+				}
+			})
+		}
+
+		// 失败的下单不应留下订单，也不应占用CDK
+		orders, _ := svc.GetOrders(repository.OrderFilter{})
+		if len(orders) != 0 {
+			t.Errorf("失败下单后订单数量 = %d, want 0", len(orders))
+		}
+		counts, _ := store.CDKs().CountAvailableByTier(time.Now())
+		if counts[tier.ID] != 1 {
+			t.Errorf("失败下单后可用CDK = %d, want 1", counts[tier.ID])
+		}
+	})
+}
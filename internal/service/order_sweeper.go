@@ -0,0 +1,64 @@
+package service
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// OrderSweeper 订单超时清理器：定期关闭超时未支付的订单并释放锁定的CDK
+type OrderSweeper struct {
+	orders   *OrderService
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+// NewOrderSweeper 创建订单超时清理器，interval为扫描间隔（是否超时由OrderService的时钟判断）
+func NewOrderSweeper(orders *OrderService, interval time.Duration) *OrderSweeper {
+	return &OrderSweeper{
+		orders:   orders,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start 在后台协程中开始定期扫描
+func (w *OrderSweeper) Start() {
+	go w.run()
+}
+
+// Stop 停止扫描并等待正在进行的一轮扫描结束（须在Start之后调用）
+func (w *OrderSweeper) Stop() {
+	w.once.Do(func() { close(w.stop) })
+	<-w.done
+}
+
+func (w *OrderSweeper) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		w.sweep()
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweep 执行一轮超时订单清理
+func (w *OrderSweeper) sweep() {
+	expired, err := w.orders.ExpireOrders()
+	if err != nil {
+		log.Printf("清理超时订单失败: %v", err)
+	}
+	if expired > 0 {
+		log.Printf("已关闭 %d 个超时订单", expired)
+	}
+}
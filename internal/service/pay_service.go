@@ -0,0 +1,162 @@
+package service
+
+import (
+	"crypto/md5"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/config"
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+)
+
+// PayTradeSuccess 支付网关通知中表示交易成功的状态
+const PayTradeSuccess = "TRADE_SUCCESS"
+
+// PayService LinuxDo Credit 支付服务（易支付协议，MD5签名）
+type PayService struct {
+	mu       sync.RWMutex // 保护cfg（重新加载配置时更新）
+	cfg      config.PayConfig
+	orders   *OrderService
+	payments *PaymentService
+}
+
+// NewPayService 创建支付服务
+func NewPayService(cfg config.PayConfig, orders *OrderService, payments *PaymentService) *PayService {
+	return &PayService{cfg: cfg, orders: orders, payments: payments}
+}
+
+// SetConfig 更新支付配置（重新加载配置后调用）
+func (s *PayService) SetConfig(cfg config.PayConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cfg = cfg
+}
+
+// payConfig 当前的支付配置
+func (s *PayService) payConfig() config.PayConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cfg
+}
+
+// Enabled 是否已配置支付
+func (s *PayService) Enabled() bool {
+	return s.payConfig().Enabled()
+}
+
+// signParams 计算参数签名：跳过sign、sign_type和空值，按参数名升序拼接为 k1=v1&k2=v2，末尾追加密钥后取MD5
+func signParams(params map[string]string, secret string) string {
+	keys := make([]string, 0, len(params))
+	for k, v := range params {
+		if k == "sign" || k == "sign_type" || v == "" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+params[k])
+	}
+	sum := md5.Sum([]byte(strings.Join(pairs, "&") + secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// formatMoney 积分格式化为网关要求的金额字符串
+func formatMoney(points int) string {
+	return strconv.Itoa(points) + ".00"
+}
+
+// PayURL 生成订单的支付跳转地址（浏览器打开后进入LinuxDo Credit支付页面），并记录一次支付尝试
+func (s *PayService) PayURL(order *model.Order, subject string) (string, error) {
+	cfg := s.payConfig()
+	if !cfg.Enabled() {
+		return "", fmt.Errorf("支付未配置")
+	}
+	if order.Status != model.OrderStatusPending {
+		return "", fmt.Errorf("订单不是待支付状态")
+	}
+	if order.TotalPrice <= 0 {
+		return "", fmt.Errorf("免费订单无需支付")
+	}
+
+	params := map[string]string{
+		"pid":          cfg.ClientID,
+		"type":         "epay",
+		"out_trade_no": order.OrderNo,
+		"notify_url":   cfg.NotifyURL,
+		"return_url":   cfg.CallbackURL,
+		"name":         subject,
+		"money":        formatMoney(order.TotalPrice),
+	}
+	params["sign"] = signParams(params, cfg.ClientSecret)
+	params["sign_type"] = "MD5"
+
+	if _, err := s.payments.StartPayment(order); err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	for k, v := range params {
+		query.Set(k, v)
+	}
+	return strings.TrimRight(cfg.APIURL, "/") + "/pay/submit.php?" + query.Encode(), nil
+}
+
+// Verify 校验支付网关回传参数的商户号与签名
+func (s *PayService) Verify(params map[string]string) error {
+	cfg := s.payConfig()
+	if !cfg.Enabled() {
+		return fmt.Errorf("支付未配置")
+	}
+	if params["pid"] != cfg.ClientID {
+		return fmt.Errorf("商户号不匹配")
+	}
+	expected := signParams(params, cfg.ClientSecret)
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(params["sign"]))) != 1 {
+		return fmt.Errorf("签名校验失败")
+	}
+	return nil
+}
+
+// HandleNotify 处理支付网关的异步通知：校验签名和金额后记录支付结果，支付成功时完成订单并发放CDK
+// 网关可能重复通知，已处理过的交易直接返回成功
+func (s *PayService) HandleNotify(params map[string]string) (*model.Order, error) {
+	if err := s.Verify(params); err != nil {
+		return nil, err
+	}
+
+	order, err := s.orders.GetOrderByNo(params["out_trade_no"])
+	if err != nil {
+		return nil, err
+	}
+
+	if params["trade_status"] != PayTradeSuccess {
+		err := fmt.Errorf("交易未成功: %s", params["trade_status"])
+		return nil, s.recordFailure(order, params["trade_no"], err)
+	}
+	money, err := strconv.ParseFloat(params["money"], 64)
+	if err != nil || math.Round(money*100) != float64(order.TotalPrice*100) {
+		err := fmt.Errorf("支付金额不匹配: %s", params["money"])
+		return nil, s.recordFailure(order, params["trade_no"], err)
+	}
+
+	return s.payments.PayOrder(order.ID, params["trade_no"])
+}
+
+// recordFailure 记录失败的支付尝试，返回原始失败原因
+func (s *PayService) recordFailure(order *model.Order, transactionID string, reason error) error {
+	if err := s.payments.FailPayment(order, transactionID, reason.Error()); err != nil {
+		log.Printf("记录订单 %s 支付失败: %v", order.OrderNo, err)
+	}
+	return reason
+}
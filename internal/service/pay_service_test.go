@@ -0,0 +1,253 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/config"
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
+)
+
+const (
+	testPayClientID     = "test-pid"
+	testPayClientSecret = "test-secret"
+)
+
+// fakePayGateway 模拟LinuxDo Credit支付网关：校验下单签名后向notify_url发送签名的支付成功通知
+type fakePayGateway struct {
+	server  *httptest.Server
+	mu      sync.Mutex
+	replies []string // 商户对每次通知的响应
+}
+
+func newFakePayGateway(t *testing.T, notifyTimes int) *fakePayGateway {
+	g := &fakePayGateway{}
+	g.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/pay/submit.php" {
+			http.NotFound(w, r)
+			return
+		}
+		params := map[string]string{}
+		for k, v := range r.URL.Query() {
+			params[k] = v[0]
+		}
+		if params["pid"] != testPayClientID || params["sign"] != signParams(params, testPayClientSecret) {
+			http.Error(w, "签名错误", http.StatusBadRequest)
+			return
+		}
+
+		// 模拟用户完成支付，网关可能多次发送同一通知
+		notify := map[string]string{
+			"pid":          testPayClientID,
+			"trade_no":     "T" + params["out_trade_no"],
+			"out_trade_no": params["out_trade_no"],
+			"type":         params["type"],
+			"name":         params["name"],
+			"money":        params["money"],
+			"trade_status": PayTradeSuccess,
+		}
+		notify["sign"] = signParams(notify, testPayClientSecret)
+		notify["sign_type"] = "MD5"
+		form := url.Values{}
+		for k, v := range notify {
+			form.Set(k, v)
+		}
+		for i := 0; i < notifyTimes; i++ {
+			resp, err := http.PostForm(params["notify_url"], form)
+			if err != nil {
+				t.Errorf("发送支付通知失败: %v", err)
+				return
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			g.mu.Lock()
+			g.replies = append(g.replies, string(body))
+			g.mu.Unlock()
+		}
+		http.Redirect(w, r, params["return_url"], http.StatusFound)
+	}))
+	t.Cleanup(g.server.Close)
+	return g
+}
+
+// newTestPayService 创建连接到假网关的支付服务，并启动接收异步通知的商户端服务
+func newTestPayService(t *testing.T, gatewayURL string, orders *OrderService) *PayService {
+	svc := &PayService{orders: orders, payments: NewPaymentService(orders.store, orders)}
+	merchant := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		params := map[string]string{}
+		for k, v := range r.Form {
+			params[k] = v[0]
+		}
+		if _, err := svc.HandleNotify(params); err != nil {
+			io.WriteString(w, "fail")
+			return
+		}
+		io.WriteString(w, "success")
+	}))
+	t.Cleanup(merchant.Close)
+
+	svc.cfg = config.PayConfig{
+		APIURL:       gatewayURL,
+		ClientID:     testPayClientID,
+		ClientSecret: testPayClientSecret,
+		CallbackURL:  merchant.URL + "/callback",
+		NotifyURL:    merchant.URL + "/notify",
+	}
+	return svc
+}
+
+// createPricedOrder 创建单价为price的档位并下单
+func createPricedOrder(t *testing.T, store repository.Store, orders *OrderService, price, quantity int) *model.Order {
+	t.Helper()
+	tier := createTestTier(t, store, 10, true, quantity)
+	tier.Price = price
+	if err := store.Tiers().Update(tier); err != nil {
+		t.Fatalf("更新档位失败: %v", err)
+	}
+	order, err := orders.CreateOrder(1, tier.ID, quantity)
+	if err != nil {
+		t.Fatalf("CreateOrder() error = %v", err)
+	}
+	return order
+}
+
+func TestPayFlow(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store repository.Store) {
+		orders := NewOrderService(store, time.Now)
+		gateway := newFakePayGateway(t, 2)
+		pay := newTestPayService(t, gateway.server.URL, orders)
+		order := createPricedOrder(t, store, orders, 5, 2)
+		if order.TotalPrice != 10 {
+			t.Fatalf("TotalPrice = %d, want 10", order.TotalPrice)
+		}
+
+		payURL, err := pay.PayURL(order, "测试档位 x2")
+		if err != nil {
+			t.Fatalf("PayURL() error = %v", err)
+		}
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+		resp, err := client.Get(payURL)
+		if err != nil {
+			t.Fatalf("请求支付网关失败: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusFound {
+			t.Fatalf("支付网关响应 = %d, want 302", resp.StatusCode)
+		}
+
+		// 重复通知都应返回success，且CDK只发放一次
+		if strings.Join(gateway.replies, ",") != "success,success" {
+			t.Errorf("通知响应 = %v, want [success success]", gateway.replies)
+		}
+		got, _ := orders.GetOrderByNo(order.OrderNo)
+		if got.Status != model.OrderStatusCompleted || got.PaidAt.IsZero() {
+			t.Errorf("支付后订单 = %+v", got)
+		}
+		cdks, _ := orders.GetOrderCDKs(order.ID)
+		for _, cdk := range cdks {
+			if cdk.Status != model.CDKStatusRedeemed || cdk.RedeemedBy != 1 {
+				t.Errorf("支付后CDK = %+v", cdk)
+			}
+		}
+		if logs, _ := store.RedeemLogs().ListByUser(1); len(logs) != 2 {
+			t.Errorf("兑换记录数量 = %d, want 2", len(logs))
+		}
+
+		// 发起支付记录的处理中记录被更新为成功，重复通知不新增记录
+		payments, _ := store.Payments().ListByOrder(order.ID)
+		if len(payments) != 1 {
+			t.Fatalf("支付记录数量 = %d, want 1", len(payments))
+		}
+		p := payments[0]
+		if p.Status != model.PaymentStatusSuccess || p.TransactionID != "T"+order.OrderNo || p.Amount != 10 || p.PaymentType != model.PaymentTypePoints || p.FinishedAt.IsZero() {
+			t.Errorf("支付记录 = %+v", p)
+		}
+	})
+}
+
+func TestHandleNotifyRejected(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store repository.Store) {
+		orders := NewOrderService(store, time.Now)
+		pay := newTestPayService(t, "http://127.0.0.1:0", orders)
+		order := createPricedOrder(t, store, orders, 5, 1)
+
+		signed := func(modify func(p map[string]string)) map[string]string {
+			p := map[string]string{
+				"pid":          testPayClientID,
+				"trade_no":     "T1",
+				"out_trade_no": order.OrderNo,
+				"money":        "5.00",
+				"trade_status": PayTradeSuccess,
+			}
+			modify(p)
+			p["sign"] = signParams(p, testPayClientSecret)
+			return p
+		}
+
+		tests := []struct {
+			name    string
+			params  map[string]string
+			wantErr string
+		}{
+			{"签名错误", func() map[string]string {
+				p := signed(func(map[string]string) {})
+				p["sign"] = "0000"
+				return p
+			}(), "签名校验失败"},
+			{"篡改金额", func() map[string]string {
+				p := signed(func(map[string]string) {})
+				p["money"] = "0.01"
+				return p
+			}(), "签名校验失败"},
+			{"商户号不匹配", signed(func(p map[string]string) { p["pid"] = "other" }), "商户号不匹配"},
+			{"交易未成功", signed(func(p map[string]string) { p["trade_status"] = "WAIT_BUYER_PAY" }), "交易未成功"},
+			{"金额不匹配", signed(func(p map[string]string) { p["money"] = "1.00" }), "支付金额不匹配"},
+			{"订单不存在", signed(func(p map[string]string) { p["out_trade_no"] = "missing" }), "订单不存在"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := pay.HandleNotify(tt.params)
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("HandleNotify() error = %v, want %s", err, tt.wantErr)
+				}
+			})
+		}
+
+		got, _ := orders.GetOrderByNo(order.OrderNo)
+		if got.Status != model.OrderStatusPending {
+			t.Errorf("无效通知后订单状态 = %d, want %d", got.Status, model.OrderStatusPending)
+		}
+		// 只有通过签名校验的失败通知才记录，同一交易号的重复失败只记一次
+		payments, _ := store.Payments().ListByOrder(order.ID)
+		if len(payments) != 1 || payments[0].Status != model.PaymentStatusFailed || !strings.Contains(payments[0].FailReason, "交易未成功") {
+			t.Errorf("失败支付记录 = %+v", payments)
+		}
+
+		// 订单已关闭时确认通知（避免网关无限重试），但不发放CDK
+		if _, err := orders.CancelOrder(1, order.OrderNo); err != nil {
+			t.Fatalf("CancelOrder() error = %v", err)
+		}
+		closed, err := pay.HandleNotify(signed(func(map[string]string) {}))
+		if err != nil {
+			t.Fatalf("HandleNotify() error = %v", err)
+		}
+		if closed.Status != model.OrderStatusCancelled {
+			t.Errorf("已关闭订单状态 = %d, want %d", closed.Status, model.OrderStatusCancelled)
+		}
+		report, err := pay.payments.Reconcile()
+		if err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+		if len(report.OrphanPayments) != 1 || report.OrphanPayments[0].OrderID != order.ID {
+			t.Errorf("OrphanPayments = %+v, want 订单 %d 的支付", report.OrphanPayments, order.ID)
+		}
+	})
+}
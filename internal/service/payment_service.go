@@ -0,0 +1,207 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
+)
+
+// PaymentService 支付记录服务：记录每一次支付尝试，并提供对账
+type PaymentService struct {
+	store  repository.Store // 完成订单与写入支付记录需要在同一事务中
+	repo   repository.PaymentRepository
+	orders *OrderService
+}
+
+// NewPaymentService 创建支付记录服务
+func NewPaymentService(store repository.Store, orders *OrderService) *PaymentService {
+	return &PaymentService{store: store, repo: store.Payments(), orders: orders}
+}
+
+// ReconcileReport 对账结果
+type ReconcileReport struct {
+	UnpaidOrders   []model.Order   // 已完成（已发放CDK）但没有成功支付记录的订单
+	OrphanPayments []model.Payment // 支付成功但订单未完成的支付记录
+}
+
+// StartPayment 发起支付时记录一次处理中的积分支付尝试
+func (s *PaymentService) StartPayment(order *model.Order) (*model.Payment, error) {
+	payment := &model.Payment{
+		OrderID:     order.ID,
+		UserID:      order.UserID,
+		Amount:      order.TotalPrice,
+		PaymentType: model.PaymentTypePoints,
+		Status:      model.PaymentStatusProcessing,
+		CreatedAt:   s.orders.now(),
+	}
+	if err := s.repo.Create(payment); err != nil {
+		return nil, fmt.Errorf("记录支付失败: %v", err)
+	}
+	return payment, nil
+}
+
+// settle 结束订单的一次支付尝试：同一交易号已记录过相同结果时直接返回（网关重复通知），
+// 否则更新最近一条处理中的记录，没有处理中的记录时新建一条
+func (s *PaymentService) settle(tx repository.Store, order *model.Order, transactionID string, status int, failReason string) (*model.Payment, error) {
+	payments, err := tx.Payments().ListByOrder(order.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var processing *model.Payment
+	for i := range payments {
+		payment := &payments[i]
+		if transactionID != "" && payment.TransactionID == transactionID && payment.Status == status {
+			return payment, nil
+		}
+		if payment.Status == model.PaymentStatusProcessing {
+			processing = payment
+		}
+	}
+
+	now := s.orders.now()
+	payment := processing
+	if payment == nil {
+		payment = &model.Payment{
+			OrderID:     order.ID,
+			UserID:      order.UserID,
+			Amount:      order.TotalPrice,
+			PaymentType: model.PaymentTypePoints,
+			CreatedAt:   now,
+		}
+	}
+	payment.Status = status
+	payment.TransactionID = transactionID
+	payment.FailReason = failReason
+	payment.FinishedAt = now
+
+	if payment.ID == 0 {
+		err = tx.Payments().Create(payment)
+	} else {
+		err = tx.Payments().Update(payment)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("记录支付失败: %v", err)
+	}
+	return payment, nil
+}
+
+// PayOrder 记录订单支付成功并完成订单，同一交易号重复调用不会重复发放
+// 订单已关闭时仍记录支付成功（积分已扣除），由对账报告列出后人工处理
+func (s *PaymentService) PayOrder(orderID int, transactionID string) (*model.Order, error) {
+	var order *model.Order
+	err := s.store.Tx(func(tx repository.Store) error {
+		var err error
+		order, err = tx.Orders().GetByID(orderID)
+		if errors.Is(err, repository.ErrNotFound) {
+			return fmt.Errorf("订单不存在")
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := s.settle(tx, order, transactionID, model.PaymentStatusSuccess, ""); err != nil {
+			return err
+		}
+		if order.Status == model.OrderStatusCancelled || order.Status == model.OrderStatusFailed {
+			log.Printf("订单 %s 已关闭，但收到支付成功通知（交易号 %s）", order.OrderNo, transactionID)
+			return nil
+		}
+		order, err = s.orders.completeOrder(tx, order.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// FailPayment 记录订单的一次失败支付
+func (s *PaymentService) FailPayment(order *model.Order, transactionID, reason string) error {
+	return s.store.Tx(func(tx repository.Store) error {
+		_, err := s.settle(tx, order, transactionID, model.PaymentStatusFailed, reason)
+		return err
+	})
+}
+
+// CompleteFreeOrder 完成免费订单，并在同一事务中记录一条免费支付记录
+func (s *PaymentService) CompleteFreeOrder(orderID int) (*model.Order, error) {
+	var order *model.Order
+	err := s.store.Tx(func(tx repository.Store) error {
+		var err error
+		order, err = tx.Orders().GetByID(orderID)
+		if errors.Is(err, repository.ErrNotFound) {
+			return fmt.Errorf("订单不存在")
+		}
+		if err != nil {
+			return err
+		}
+		if order.TotalPrice != 0 {
+			return fmt.Errorf("订单需要支付")
+		}
+		if order.Status == model.OrderStatusCompleted {
+			return nil
+		}
+
+		if order, err = s.orders.completeOrder(tx, order.ID); err != nil {
+			return err
+		}
+		err = tx.Payments().Create(&model.Payment{
+			OrderID:     order.ID,
+			UserID:      order.UserID,
+			PaymentType: model.PaymentTypeFree,
+			Status:      model.PaymentStatusSuccess,
+			CreatedAt:   order.PaidAt,
+			FinishedAt:  order.PaidAt,
+		})
+		if err != nil {
+			return fmt.Errorf("记录支付失败: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// GetOrderPayments 获取订单的支付记录
+func (s *PaymentService) GetOrderPayments(orderID int) ([]model.Payment, error) {
+	return s.repo.ListByOrder(orderID)
+}
+
+// Reconcile 对账：找出已完成但没有成功支付记录的订单，以及支付成功但订单未完成的支付记录
+func (s *PaymentService) Reconcile() (*ReconcileReport, error) {
+	completed := model.OrderStatusCompleted
+	orders, err := s.orders.GetOrders(repository.OrderFilter{Status: &completed})
+	if err != nil {
+		return nil, err
+	}
+	success := model.PaymentStatusSuccess
+	payments, err := s.repo.List(&success)
+	if err != nil {
+		return nil, err
+	}
+
+	paidOrders := make(map[int]bool)
+	for _, payment := range payments {
+		paidOrders[payment.OrderID] = true
+	}
+	completedOrders := make(map[int]bool)
+	report := &ReconcileReport{UnpaidOrders: []model.Order{}, OrphanPayments: []model.Payment{}}
+	for _, order := range orders {
+		completedOrders[order.ID] = true
+		if !paidOrders[order.ID] {
+			report.UnpaidOrders = append(report.UnpaidOrders, order)
+		}
+	}
+	for _, payment := range payments {
+		if !completedOrders[payment.OrderID] {
+			report.OrphanPayments = append(report.OrphanPayments, payment)
+		}
+	}
+	return report, nil
+}
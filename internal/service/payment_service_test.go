@@ -0,0 +1,80 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
+)
+
+func TestReconcile(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store repository.Store) {
+		clock := newFakeClock()
+		orders := NewOrderService(store, clock.Now)
+		payments := NewPaymentService(store, orders)
+		free := createTestTier(t, store, 10, true, 5)
+
+		// 免费订单：完成时同时记录免费支付，重复完成不重复记录
+		freeOrder, err := orders.CreateOrder(1, free.ID, 1)
+		if err != nil {
+			t.Fatalf("CreateOrder() error = %v", err)
+		}
+		for i := 0; i < 2; i++ {
+			if _, err := payments.CompleteFreeOrder(freeOrder.ID); err != nil {
+				t.Fatalf("CompleteFreeOrder() error = %v", err)
+			}
+		}
+		if list, _ := payments.GetOrderPayments(freeOrder.ID); len(list) != 1 || list[0].PaymentType != model.PaymentTypeFree || list[0].Status != model.PaymentStatusSuccess {
+			t.Errorf("免费订单支付记录 = %+v", list)
+		}
+
+		// 绕过支付直接完成的订单：对账时应被列出
+		bypassed, _ := orders.CreateOrder(1, free.ID, 1)
+		if _, err := orders.CompleteOrder(bypassed.ID); err != nil {
+			t.Fatalf("CompleteOrder() error = %v", err)
+		}
+
+		// 付费订单正常支付
+		paid := createPricedOrder(t, store, orders, 3, 1)
+		if _, err := payments.StartPayment(paid); err != nil {
+			t.Fatalf("StartPayment() error = %v", err)
+		}
+		if _, err := payments.PayOrder(paid.ID, "T-PAID"); err != nil {
+			t.Fatalf("PayOrder() error = %v", err)
+		}
+
+		// 发起支付后超时关闭：处理中的支付记录标记为失败，不影响对账
+		expired := createPricedOrder(t, store, orders, 3, 1)
+		if _, err := payments.StartPayment(expired); err != nil {
+			t.Fatalf("StartPayment() error = %v", err)
+		}
+		clock.Advance(time.Hour)
+		if _, err := orders.ExpireOrders(); err != nil {
+			t.Fatalf("ExpireOrders() error = %v", err)
+		}
+		if list, _ := payments.GetOrderPayments(expired.ID); len(list) != 1 || list[0].Status != model.PaymentStatusFailed || list[0].FailReason != "订单已关闭" {
+			t.Errorf("超时订单支付记录 = %+v", list)
+		}
+
+		// 订单关闭后才到达的支付成功：记录支付但不完成订单，对账时应被列出
+		late := createPricedOrder(t, store, orders, 3, 1)
+		if _, err := orders.CancelOrder(1, late.OrderNo); err != nil {
+			t.Fatalf("CancelOrder() error = %v", err)
+		}
+		if _, err := payments.PayOrder(late.ID, "T-LATE"); err != nil {
+			t.Fatalf("PayOrder() error = %v", err)
+		}
+
+		report, err := payments.Reconcile()
+		if err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+		if len(report.UnpaidOrders) != 1 || report.UnpaidOrders[0].ID != bypassed.ID {
+			t.Errorf("UnpaidOrders = %+v, want 订单 %d", report.UnpaidOrders, bypassed.ID)
+		}
+		if len(report.OrphanPayments) != 1 || report.OrphanPayments[0].TransactionID != "T-LATE" {
+			t.Errorf("OrphanPayments = %+v, want 交易号 T-LATE", report.OrphanPayments)
+		}
+	})
+}
@@ -1,201 +1,81 @@
 package service
 
 import (
-	"encoding/csv"
-	"fmt"
-	"os"
-	"path/filepath"
-	"strconv"
 	"time"
 
-	"github.com/zhongruan0522/DuiDuiMao/internal/config"
 	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
 )
 
 // RedeemLogService 兑换记录服务
 type RedeemLogService struct {
-	mode string // dev 或 server
+	store repository.Store // 统计每日剩余额度时还需要读取待支付订单
+	repo  repository.RedeemLogRepository
 }
 
 // NewRedeemLogService 创建兑换记录服务
-func NewRedeemLogService(mode string) *RedeemLogService {
-	return &RedeemLogService{mode: mode}
+func NewRedeemLogService(store repository.Store) *RedeemLogService {
+	return &RedeemLogService{store: store, repo: store.RedeemLogs()}
 }
 
-const redeemLogCSVPath = "Temp/redeem_log.csv"
+// UserRedeemStats 用户兑换统计
+type UserRedeemStats struct {
+	TotalRedeemed int               // 累计兑换数量
+	Tiers         []TierRedeemStats // 各档位今日统计
+}
+
+// TierRedeemStats 用户在某个档位的今日统计（自然日按业务时区划分）
+type TierRedeemStats struct {
+	Tier          model.Tier
+	RedeemedToday int // 今日已兑换数量
+	Remaining     int // 今日剩余可兑换数量（已扣除待支付订单占用），-1表示不限购
+}
 
 // CreateRedeemLog 创建兑换记录
 func (s *RedeemLogService) CreateRedeemLog(userID, cdkID, tierID int) error {
-	if s.mode == config.ModeDev {
-		return s.createRedeemLogCSV(userID, cdkID, tierID)
-	}
-	// TODO: 实现数据库版本
-	return fmt.Errorf("数据库模式暂未实现")
+	return s.repo.Create(&model.RedeemLog{
+		UserID:    userID,
+		CDKID:     cdkID,
+		TierID:    tierID,
+		CreatedAt: time.Now(),
+	})
 }
 
 // GetUserRedeemLogs 获取用户的兑换历史
 func (s *RedeemLogService) GetUserRedeemLogs(userID int) ([]model.RedeemLog, error) {
-	if s.mode == config.ModeDev {
-		return s.getUserRedeemLogsCSV(userID)
-	}
-	// TODO: 实现数据库版本
-	return nil, fmt.Errorf("数据库模式暂未实现")
+	return s.repo.ListByUser(userID)
 }
 
 // GetAllRedeemLogs 获取所有兑换记录（管理员使用）
 func (s *RedeemLogService) GetAllRedeemLogs() ([]model.RedeemLog, error) {
-	if s.mode == config.ModeDev {
-		return s.getAllRedeemLogsCSV()
-	}
-	// TODO: 实现数据库版本
-	return nil, fmt.Errorf("数据库模式暂未实现")
+	return s.repo.List()
 }
 
-// ========== CSV模式实现 ==========
-
-// ensureRedeemLogCSV 确保兑换记录CSV文件存在
-func (s *RedeemLogService) ensureRedeemLogCSV() error {
-	dir := filepath.Dir(redeemLogCSVPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return err
-	}
-
-	_, statErr := os.Stat(redeemLogCSVPath)
-	if os.IsNotExist(statErr) {
-		file, createErr := os.Create(redeemLogCSVPath)
-		if createErr != nil {
-			return createErr
-		}
-		defer file.Close()
-
-		writer := csv.NewWriter(file)
-		// 写入CSV头部
-		header := []string{"id", "user_id", "cdk_id", "tier_id", "created_at"}
-		if writeErr := writer.Write(header); writeErr != nil {
-			return writeErr
-		}
-		writer.Flush()
-	}
-	return nil
-}
-
-// readRedeemLogsCSV 读取所有兑换记录
-func (s *RedeemLogService) readRedeemLogsCSV() ([]model.RedeemLog, error) {
-	if err := s.ensureRedeemLogCSV(); err != nil {
-		return nil, err
-	}
-
-	file, err := os.Open(redeemLogCSVPath)
+// GetUserStats 统计用户累计兑换数量，以及在给定档位上今日的兑换数量和剩余额度
+// 剩余额度与兑换/下单时的每日限购校验使用同一口径
+func (s *RedeemLogService) GetUserStats(userID int, tiers []model.Tier, now time.Time) (*UserRedeemStats, error) {
+	logs, err := s.repo.ListByUser(userID)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
-	if err != nil {
-		return nil, err
-	}
-
-	logs := []model.RedeemLog{}
-	for i, record := range records {
-		if i == 0 || len(record) < 5 {
-			continue // 跳过头部或不完整的行
+	stats := &UserRedeemStats{TotalRedeemed: len(logs), Tiers: []TierRedeemStats{}}
+	start, end := dayRange(now)
+	for _, tier := range tiers {
+		redeemedToday, err := s.repo.CountByUserTier(userID, tier.ID, start, end)
+		if err != nil {
+			return nil, err
 		}
 
-		id, _ := strconv.Atoi(record[0])
-		userID, _ := strconv.Atoi(record[1])
-		cdkID, _ := strconv.Atoi(record[2])
-		tierID, _ := strconv.Atoi(record[3])
-		createdAt, _ := time.Parse(time.RFC3339, record[4])
-
-		logs = append(logs, model.RedeemLog{
-			ID:        id,
-			UserID:    userID,
-			CDKID:     cdkID,
-			TierID:    tierID,
-			CreatedAt: createdAt,
-		})
-	}
-	return logs, nil
-}
-
-// writeRedeemLogsCSV 写入所有兑换记录
-func (s *RedeemLogService) writeRedeemLogsCSV(logs []model.RedeemLog) error {
-	file, err := os.Create(redeemLogCSVPath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
-
-	// 写入头部
-	header := []string{"id", "user_id", "cdk_id", "tier_id", "created_at"}
-	if err := writer.Write(header); err != nil {
-		return err
-	}
-
-	// 写入数据
-	for _, log := range logs {
-		record := []string{
-			strconv.Itoa(log.ID),
-			strconv.Itoa(log.UserID),
-			strconv.Itoa(log.CDKID),
-			strconv.Itoa(log.TierID),
-			log.CreatedAt.Format(time.RFC3339),
-		}
-		if err := writer.Write(record); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-// createRedeemLogCSV 创建兑换记录（CSV模式）
-func (s *RedeemLogService) createRedeemLogCSV(userID, cdkID, tierID int) error {
-	logs, err := s.readRedeemLogsCSV()
-	if err != nil {
-		return err
-	}
-
-	// 获取新ID
-	newID := 1
-	if len(logs) > 0 {
-		newID = logs[len(logs)-1].ID + 1
-	}
-
-	newLog := model.RedeemLog{
-		ID:        newID,
-		UserID:    userID,
-		CDKID:     cdkID,
-		TierID:    tierID,
-		CreatedAt: time.Now(),
-	}
-
-	logs = append(logs, newLog)
-	return s.writeRedeemLogsCSV(logs)
-}
-
-// getUserRedeemLogsCSV 获取用户的兑换历史（CSV模式）
-func (s *RedeemLogService) getUserRedeemLogsCSV(userID int) ([]model.RedeemLog, error) {
-	logs, err := s.readRedeemLogsCSV()
-	if err != nil {
-		return nil, err
-	}
-
-	userLogs := []model.RedeemLog{}
-	for _, log := range logs {
-		if log.UserID == userID {
-			userLogs = append(userLogs, log)
+		remaining := -1
+		if tier.DailyLimit > 0 {
+			used, err := dailyUsed(s.store, userID, tier.ID, now)
+			if err != nil {
+				return nil, err
+			}
+			remaining = max(tier.DailyLimit-used, 0)
 		}
+		stats.Tiers = append(stats.Tiers, TierRedeemStats{Tier: tier, RedeemedToday: redeemedToday, Remaining: remaining})
 	}
-
-	return userLogs, nil
-}
-
-// getAllRedeemLogsCSV 获取所有兑换记录（CSV模式）
-func (s *RedeemLogService) getAllRedeemLogsCSV() ([]model.RedeemLog, error) {
-	return s.readRedeemLogsCSV()
+	return stats, nil
 }
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
+	"github.com/zhongruan0522/DuiDuiMao/internal/util"
+)
+
+// ErrSessionInvalid 会话不存在、已撤销或已过期，需要重新登录
+var ErrSessionInvalid = errors.New("登录已失效，请重新登录")
+
+// SessionService 登录会话服务：签发短期access token与可轮换的refresh token，支持登出和登出所有设备
+type SessionService struct {
+	store      repository.Store // 认证和刷新时需要读取用户的最新信息
+	repo       repository.SessionRepository
+	mu         sync.RWMutex  // 保护accessTTL和refreshTTL（重新加载配置时更新）
+	accessTTL  time.Duration // access token有效期
+	refreshTTL time.Duration // 会话（refresh token）有效期，每次刷新后顺延
+	now        Clock
+}
+
+// NewSessionService 创建登录会话服务
+func NewSessionService(store repository.Store, accessTTL, refreshTTL time.Duration, clock Clock) *SessionService {
+	return &SessionService{store: store, repo: store.Sessions(), accessTTL: accessTTL, refreshTTL: refreshTTL, now: clock}
+}
+
+// TokenPair 登录或刷新后签发的令牌
+type TokenPair struct {
+	AccessToken  string // 访问令牌（JWT，jti为会话ID）
+	RefreshToken string // 刷新令牌，格式为 会话ID.随机串，每次刷新后旧令牌失效
+	ExpiresIn    int    // 访问令牌有效期（秒）
+}
+
+// randomToken 生成URL安全的随机串
+func randomToken(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("生成随机数失败: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// SetTTL 更新access token和会话的有效期（重新加载配置后调用，只影响此后签发和刷新的令牌）
+func (s *SessionService) SetTTL(accessTTL, refreshTTL time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accessTTL = accessTTL
+	s.refreshTTL = refreshTTL
+}
+
+// ttl 当前的access token和会话有效期
+func (s *SessionService) ttl() (time.Duration, time.Duration) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.accessTTL, s.refreshTTL
+}
+
+// hashRefreshToken 计算refresh token随机串的哈希（数据库中只保存哈希）
+func hashRefreshToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// issue 为会话签发access token，并拼接refresh token
+func (s *SessionService) issue(user *model.User, sessionID, secret string) (*TokenPair, error) {
+	accessTTL, _ := s.ttl()
+	accessToken, err := util.GenerateJWT(user.ID, user.Role, sessionID, accessTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: sessionID + "." + secret,
+		ExpiresIn:    int(accessTTL.Seconds()),
+	}, nil
+}
+
+// Login 为用户创建新的登录会话
+func (s *SessionService) Login(user *model.User) (*TokenPair, error) {
+	now := s.now()
+	// 顺带清理已过期的会话，避免会话表无限增长
+	if _, err := s.repo.DeleteExpired(now); err != nil {
+		log.Printf("清理过期会话失败: %v", err)
+	}
+
+	_, refreshTTL := s.ttl()
+	sessionID, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	session := &model.Session{
+		ID:          sessionID,
+		UserID:      user.ID,
+		RefreshHash: hashRefreshToken(secret),
+		CreatedAt:   now,
+		RefreshedAt: now,
+		ExpiresAt:   now.Add(refreshTTL),
+	}
+	if err := s.repo.Create(session); err != nil {
+		return nil, fmt.Errorf("创建会话失败: %v", err)
+	}
+	return s.issue(user, sessionID, secret)
+}
+
+// Refresh 用refresh token换取新的令牌，旧的refresh token随即失效
+// 已轮换掉的refresh token被再次使用说明令牌可能泄露，此时撤销整个会话
+func (s *SessionService) Refresh(refreshToken string) (*TokenPair, error) {
+	sessionID, secret, ok := strings.Cut(refreshToken, ".")
+	if !ok || sessionID == "" || secret == "" {
+		return nil, ErrSessionInvalid
+	}
+
+	now := s.now()
+	session, err := s.repo.GetByID(sessionID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrSessionInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !session.Active(now) {
+		return nil, ErrSessionInvalid
+	}
+	if subtle.ConstantTimeCompare([]byte(session.RefreshHash), []byte(hashRefreshToken(secret))) != 1 {
+		log.Printf("会话 %s 的旧refresh token被重复使用，已撤销该会话", sessionID)
+		if err := s.repo.Revoke(sessionID, now); err != nil {
+			log.Printf("撤销会话 %s 失败: %v", sessionID, err)
+		}
+		return nil, ErrSessionInvalid
+	}
+
+	user, err := s.store.Users().GetByID(session.UserID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrSessionInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	newSecret, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	oldHash := session.RefreshHash
+	session.RefreshHash = hashRefreshToken(newSecret)
+	session.RefreshedAt = now
+	_, refreshTTL := s.ttl()
+	session.ExpiresAt = now.Add(refreshTTL)
+	err = s.repo.Rotate(session, oldHash)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrSessionInvalid // 并发刷新或会话刚被撤销
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.issue(user, sessionID, newSecret)
+}
+
+// Authenticate 校验access token对应的会话仍然有效（未登出、未过期），返回用户的当前信息
+// 管理员角色以用户表为准而不是token中签发时的角色，收回或调整角色后立即生效
+func (s *SessionService) Authenticate(claims *util.Claims) (*model.User, error) {
+	if claims.ID == "" {
+		return nil, ErrSessionInvalid // 升级前签发的不带会话的token
+	}
+	session, err := s.repo.GetByID(claims.ID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrSessionInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if session.UserID != claims.UserID || !session.Active(s.now()) {
+		return nil, ErrSessionInvalid
+	}
+
+	user, err := s.store.Users().GetByID(session.UserID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrSessionInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Logout 撤销指定会话
+func (s *SessionService) Logout(sessionID string) error {
+	err := s.repo.Revoke(sessionID, s.now())
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// LogoutAll 撤销用户的所有会话（登出所有设备），返回撤销数量
+func (s *SessionService) LogoutAll(userID int) (int, error) {
+	return s.repo.RevokeByUser(userID, s.now())
+}
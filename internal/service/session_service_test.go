@@ -0,0 +1,144 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
+	"github.com/zhongruan0522/DuiDuiMao/internal/util"
+)
+
+// authenticate 解析access token并校验会话
+func authenticate(sessions *SessionService, accessToken string) error {
+	claims, err := util.ParseJWT(accessToken)
+	if err != nil {
+		return err
+	}
+	_, err = sessions.Authenticate(claims)
+	return err
+}
+
+func TestSessionLifecycle(t *testing.T) {
+	util.InitJWT("test-secret")
+	forEachStore(t, func(t *testing.T, store repository.Store) {
+		clock := newFakeClock()
+		sessions := NewSessionService(store, 15*time.Minute, time.Hour, clock.Now)
+		user := createTestUser(t, store, 1001)
+
+		first, err := sessions.Login(user)
+		if err != nil {
+			t.Fatalf("Login() error = %v", err)
+		}
+		if err := authenticate(sessions, first.AccessToken); err != nil {
+			t.Fatalf("登录后 Authenticate() error = %v", err)
+		}
+		if first.ExpiresIn != 900 {
+			t.Errorf("ExpiresIn = %d, want 900", first.ExpiresIn)
+		}
+
+		// 刷新后旧的refresh token失效，新的access token属于同一会话
+		clock.Advance(30 * time.Minute)
+		second, err := sessions.Refresh(first.RefreshToken)
+		if err != nil {
+			t.Fatalf("Refresh() error = %v", err)
+		}
+		if second.RefreshToken == first.RefreshToken {
+			t.Fatalf("刷新后refresh token未轮换")
+		}
+		if err := authenticate(sessions, second.AccessToken); err != nil {
+			t.Errorf("刷新后 Authenticate() error = %v", err)
+		}
+
+		// 刷新顺延了会话有效期：距登录超过1小时但距上次刷新不足1小时
+		clock.Advance(45 * time.Minute)
+		third, err := sessions.Refresh(second.RefreshToken)
+		if err != nil {
+			t.Fatalf("顺延后 Refresh() error = %v", err)
+		}
+
+		// 重复使用已轮换的refresh token视为泄露，整个会话被撤销
+		if _, err := sessions.Refresh(first.RefreshToken); !errors.Is(err, ErrSessionInvalid) {
+			t.Errorf("重用旧refresh token error = %v, want ErrSessionInvalid", err)
+		}
+		if _, err := sessions.Refresh(third.RefreshToken); !errors.Is(err, ErrSessionInvalid) {
+			t.Errorf("会话撤销后 Refresh() error = %v, want ErrSessionInvalid", err)
+		}
+		if err := authenticate(sessions, third.AccessToken); !errors.Is(err, ErrSessionInvalid) {
+			t.Errorf("会话撤销后 Authenticate() error = %v, want ErrSessionInvalid", err)
+		}
+
+		// 超过有效期未刷新的会话失效
+		idle, _ := sessions.Login(user)
+		clock.Advance(2 * time.Hour)
+		if _, err := sessions.Refresh(idle.RefreshToken); !errors.Is(err, ErrSessionInvalid) {
+			t.Errorf("过期会话 Refresh() error = %v, want ErrSessionInvalid", err)
+		}
+
+		tests := []struct {
+			name  string
+			token string
+		}{
+			{"格式错误", "no-dot"},
+			{"会话不存在", "missing.secret"},
+			{"空令牌", ""},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if _, err := sessions.Refresh(tt.token); !errors.Is(err, ErrSessionInvalid) {
+					t.Errorf("Refresh(%q) error = %v, want ErrSessionInvalid", tt.token, err)
+				}
+			})
+		}
+	})
+}
+
+func TestSessionLogout(t *testing.T) {
+	util.InitJWT("test-secret")
+	forEachStore(t, func(t *testing.T, store repository.Store) {
+		clock := newFakeClock()
+		sessions := NewSessionService(store, 15*time.Minute, time.Hour, clock.Now)
+		user := createTestUser(t, store, 1001)
+		other := createTestUser(t, store, 1002)
+
+		laptop, _ := sessions.Login(user)
+		phone, _ := sessions.Login(user)
+		otherDevice, _ := sessions.Login(other)
+
+		// 登出只影响当前会话
+		claims, _ := util.ParseJWT(laptop.AccessToken)
+		if err := sessions.Logout(claims.ID); err != nil {
+			t.Fatalf("Logout() error = %v", err)
+		}
+		if err := authenticate(sessions, laptop.AccessToken); !errors.Is(err, ErrSessionInvalid) {
+			t.Errorf("登出后 Authenticate() error = %v, want ErrSessionInvalid", err)
+		}
+		if _, err := sessions.Refresh(laptop.RefreshToken); !errors.Is(err, ErrSessionInvalid) {
+			t.Errorf("登出后 Refresh() error = %v, want ErrSessionInvalid", err)
+		}
+		if err := authenticate(sessions, phone.AccessToken); err != nil {
+			t.Errorf("其他设备 Authenticate() error = %v", err)
+		}
+
+		// 登出所有设备不影响其他用户
+		revoked, err := sessions.LogoutAll(user.ID)
+		if err != nil {
+			t.Fatalf("LogoutAll() error = %v", err)
+		}
+		if revoked != 1 {
+			t.Errorf("LogoutAll() = %d, want 1", revoked)
+		}
+		if err := authenticate(sessions, phone.AccessToken); !errors.Is(err, ErrSessionInvalid) {
+			t.Errorf("登出所有设备后 Authenticate() error = %v, want ErrSessionInvalid", err)
+		}
+		if err := authenticate(sessions, otherDevice.AccessToken); err != nil {
+			t.Errorf("其他用户 Authenticate() error = %v", err)
+		}
+
+		// 不带会话ID的旧token不再被接受
+		legacy, _ := util.GenerateJWT(user.ID, "", "", time.Hour)
+		if err := authenticate(sessions, legacy); !errors.Is(err, ErrSessionInvalid) {
+			t.Errorf("旧token Authenticate() error = %v, want ErrSessionInvalid", err)
+		}
+	})
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/config"
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
+)
+
+var (
+	// ErrSettingsConflict 提交的版本号不是最新版本（设置已被其他管理员修改）
+	ErrSettingsConflict = errors.New("设置已被其他管理员修改，请刷新后重试")
+	// ErrPurchaseDisabled 管理员通过全局开关暂停了兑换和购买
+	ErrPurchaseDisabled = errors.New("系统维护中，暂停兑换和购买，请稍后再试")
+)
+
+// maxOrderExpireMinutes 订单超时时间上限（分钟）
+const maxOrderExpireMinutes = 24 * 60
+
+// SettingsService 系统设置服务：设置保存在数据库（dev模式为CSV）中，不再写回配置文件
+type SettingsService struct {
+	repo repository.SettingsRepository
+	now  Clock
+}
+
+// NewSettingsService 创建系统设置服务
+func NewSettingsService(repo repository.SettingsRepository, clock Clock) *SettingsService {
+	return &SettingsService{repo: repo, now: clock}
+}
+
+// SettingsUpdate 要修改的设置项，nil表示不修改
+type SettingsUpdate struct {
+	GlobalEnabled      *bool
+	Announcement       *string
+	OrderExpireMinutes *int
+}
+
+// loadSettings 读取系统设置，尚未在后台保存过时使用配置文件中的初始值（版本号为0）
+func loadSettings(repo repository.SettingsRepository) (*model.Settings, error) {
+	settings, err := repo.Get()
+	if !errors.Is(err, repository.ErrNotFound) {
+		return settings, err
+	}
+
+	settings = &model.Settings{GlobalEnabled: true, OrderExpireMinutes: defaultOrderExpireMinutes}
+	if cfg := config.Get(); cfg != nil {
+		settings.GlobalEnabled = cfg.Settings.GlobalEnabled
+		settings.Announcement = cfg.Settings.Announcement
+		if cfg.Settings.OrderExpireMinutes > 0 {
+			settings.OrderExpireMinutes = cfg.Settings.OrderExpireMinutes
+		}
+	}
+	return settings, nil
+}
+
+// Get 获取当前系统设置
+func (s *SettingsService) Get() (*model.Settings, error) {
+	return loadSettings(s.repo)
+}
+
+// Update 在version版本的基础上修改设置，version不是最新版本时返回ErrSettingsConflict
+func (s *SettingsService) Update(version int, update SettingsUpdate, userID int) (*model.Settings, error) {
+	if update.OrderExpireMinutes != nil && (*update.OrderExpireMinutes < 1 || *update.OrderExpireMinutes > maxOrderExpireMinutes) {
+		return nil, fmt.Errorf("订单超时时间必须在1到%d分钟之间", maxOrderExpireMinutes)
+	}
+
+	settings, err := loadSettings(s.repo)
+	if err != nil {
+		return nil, err
+	}
+	if settings.Version != version {
+		return nil, ErrSettingsConflict
+	}
+
+	if update.GlobalEnabled != nil {
+		settings.GlobalEnabled = *update.GlobalEnabled
+	}
+	if update.Announcement != nil {
+		settings.Announcement = *update.Announcement
+	}
+	if update.OrderExpireMinutes != nil {
+		settings.OrderExpireMinutes = *update.OrderExpireMinutes
+	}
+	settings.UpdatedAt = s.now()
+	settings.UpdatedBy = userID
+
+	// 读取之后可能已被其他请求修改，由存储层按版本号比较后再写入
+	err = s.repo.Save(settings)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrSettingsConflict
+	}
+	if err != nil {
+		return nil, fmt.Errorf("保存设置失败: %v", err)
+	}
+	return settings, nil
+}
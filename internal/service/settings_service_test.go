@@ -0,0 +1,57 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
+)
+
+func TestSettingsService(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store repository.Store) {
+		clock := newFakeClock()
+		svc := NewSettingsService(store.Settings(), clock.Now)
+
+		// 尚未保存过时使用默认值，版本号为0
+		settings, err := svc.Get()
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if settings.Version != 0 || settings.OrderExpireMinutes != defaultOrderExpireMinutes {
+			t.Errorf("Get(未保存) = %+v", settings)
+		}
+
+		disabled, minutes := false, 30
+		updated, err := svc.Update(0, SettingsUpdate{GlobalEnabled: &disabled, OrderExpireMinutes: &minutes}, 7)
+		if err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+		if updated.Version != 1 || updated.GlobalEnabled || updated.OrderExpireMinutes != 30 || updated.UpdatedBy != 7 || !updated.UpdatedAt.Equal(clock.Now()) {
+			t.Errorf("Update() = %+v", updated)
+		}
+
+		// 基于旧版本的修改被拒绝，已保存的设置不变
+		announcement := "覆盖"
+		if _, err := svc.Update(0, SettingsUpdate{Announcement: &announcement}, 8); !errors.Is(err, ErrSettingsConflict) {
+			t.Errorf("Update(旧版本) error = %v, want ErrSettingsConflict", err)
+		}
+		invalid := 0
+		if _, err := svc.Update(1, SettingsUpdate{OrderExpireMinutes: &invalid}, 7); err == nil {
+			t.Error("Update(超时时间为0) 应返回错误")
+		}
+		if got, _ := svc.Get(); got.Version != 1 || got.Announcement != "" {
+			t.Errorf("Get() = %+v", got)
+		}
+
+		// 新下单使用修改后的超时时间
+		tier := createTestTier(t, store, 10, true, 1)
+		order, err := NewOrderService(store, clock.Now).CreateOrder(1, tier.ID, 1)
+		if err != nil {
+			t.Fatalf("CreateOrder() error = %v", err)
+		}
+		if want := clock.Now().Add(30 * time.Minute); !order.ExpiredAt.Equal(want) {
+			t.Errorf("ExpiredAt = %v, want %v", order.ExpiredAt, want)
+		}
+	})
+}
@@ -0,0 +1,188 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
+)
+
+func TestTierEligible(t *testing.T) {
+	tests := []struct {
+		name          string
+		requiredLevel int
+		trustLevel    int
+		want          bool
+	}{
+		{"无等级要求", 0, 0, true},
+		{"等级刚好满足", 2, 2, true},
+		{"等级高于要求", 2, 4, true},
+		{"等级不足", 3, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tier := &model.Tier{RequiredLevel: tt.requiredLevel}
+			user := &model.User{TrustLevel: tt.trustLevel}
+			if got := TierEligible(tier, user); got != tt.want {
+				t.Errorf("TierEligible() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckTierOnSale(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		startsAt time.Time
+		endsAt   time.Time
+		want     bool
+	}{
+		{"不限时间", time.Time{}, time.Time{}, true},
+		{"已开放", now.Add(-time.Hour), time.Time{}, true},
+		{"刚好开放", now, time.Time{}, true},
+		{"尚未开放", now.Add(time.Minute), time.Time{}, false},
+		{"结束前", time.Time{}, now.Add(time.Second), true},
+		{"刚好结束", time.Time{}, now, false},
+		{"已结束", now.Add(-2 * time.Hour), now.Add(-time.Hour), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkTierOnSale(&model.Tier{StartsAt: tt.startsAt, EndsAt: tt.endsAt}, now)
+			if (err == nil) != tt.want {
+				t.Errorf("checkTierOnSale() error = %v, want 开放 = %v", err, tt.want)
+			}
+			if err != nil && !errors.Is(err, ErrTierNotOnSale) {
+				t.Errorf("checkTierOnSale() error = %v, want ErrTierNotOnSale", err)
+			}
+		})
+	}
+}
+
+func TestTierRequiredLevel(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store repository.Store) {
+		cdks := NewCDKService(store, testCipher, time.Now)
+		orders := NewOrderService(store, time.Now)
+		user := createTestUser(t, store, 1)
+		tier := createTestTier(t, store, 10, true, 2)
+		tier.RequiredLevel = 2
+		if err := store.Tiers().Update(tier); err != nil {
+			t.Fatalf("更新档位失败: %v", err)
+		}
+
+		// 服务层自行校验信任等级，不依赖处理器提前检查
+		if _, err := cdks.ClaimNextCDK(tier.ID, user.ID); !errors.Is(err, ErrTrustLevelTooLow) {
+			t.Errorf("等级不足 ClaimNextCDK() error = %v, want ErrTrustLevelTooLow", err)
+		}
+		if _, err := orders.CreateOrder(user.ID, tier.ID, 1); !errors.Is(err, ErrTrustLevelTooLow) {
+			t.Errorf("等级不足 CreateOrder() error = %v, want ErrTrustLevelTooLow", err)
+		}
+
+		user.TrustLevel = 2
+		if err := store.Users().Upsert(user); err != nil {
+			t.Fatalf("更新用户失败: %v", err)
+		}
+		if _, err := cdks.ClaimNextCDK(tier.ID, user.ID); err != nil {
+			t.Errorf("等级满足 ClaimNextCDK() error = %v", err)
+		}
+		if _, err := orders.CreateOrder(user.ID, tier.ID, 1); err != nil {
+			t.Errorf("等级满足 CreateOrder() error = %v", err)
+		}
+	})
+}
+
+func TestTierSaleWindow(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store repository.Store) {
+		clock := newFakeClock()
+		tiers := NewTierService(store.Tiers(), store.CDKs(), clock.Now)
+		cdks := NewCDKService(store, testCipher, clock.Now)
+		user := createTestUser(t, store, 1)
+
+		if _, err := tiers.CreateTier("无效", 10, 0, 0, 0, 0, true, clock.Now().Add(time.Hour), clock.Now()); err == nil {
+			t.Fatal("CreateTier() 结束时间早于开放时间时应返回错误")
+		}
+		upcoming := createTestTier(t, store, 10, true, 1)
+		if _, err := tiers.UpdateTier(upcoming.ID, "即将开放", 10, 0, 0, 0, 0, true, clock.Now().Add(3*time.Minute), time.Time{}); err != nil {
+			t.Fatalf("UpdateTier() error = %v", err)
+		}
+		ended := createTestTier(t, store, 10, true, 1)
+		if _, err := tiers.UpdateTier(ended.ID, "已结束", 10, 0, 0, 0, 0, true, time.Time{}, clock.Now()); err != nil {
+			t.Fatalf("UpdateTier() error = %v", err)
+		}
+
+		// 已结束的档位不返回，尚未开放的档位返回以显示倒计时
+		active, err := tiers.GetActiveTiers()
+		if err != nil {
+			t.Fatalf("GetActiveTiers() error = %v", err)
+		}
+		if len(active) != 1 || active[0].ID != upcoming.ID {
+			t.Fatalf("GetActiveTiers() = %+v, want 只有档位%d", active, upcoming.ID)
+		}
+
+		if _, err := cdks.ClaimNextCDK(upcoming.ID, user.ID); !errors.Is(err, ErrTierNotOnSale) {
+			t.Errorf("开放前 ClaimNextCDK() error = %v, want ErrTierNotOnSale", err)
+		}
+		if _, err := cdks.ClaimNextCDK(ended.ID, user.ID); !errors.Is(err, ErrTierNotOnSale) {
+			t.Errorf("结束后 ClaimNextCDK() error = %v, want ErrTierNotOnSale", err)
+		}
+
+		// 停用的档位即使在开放时间内也不能兑换
+		inactive := createTestTier(t, store, 10, false, 1)
+		if _, err := cdks.ClaimNextCDK(inactive.ID, user.ID); err == nil || !strings.Contains(err.Error(), "该档位未启用") {
+			t.Errorf("停用档位 ClaimNextCDK() error = %v, want 该档位未启用", err)
+		}
+
+		clock.Advance(3 * time.Minute)
+		if _, err := cdks.ClaimNextCDK(upcoming.ID, user.ID); err != nil {
+			t.Errorf("开放后 ClaimNextCDK() error = %v", err)
+		}
+	})
+}
+
+func TestScheduleRelease(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store repository.Store) {
+		clock := newFakeClock()
+		tiers := NewTierService(store.Tiers(), store.CDKs(), clock.Now)
+		cdks := NewCDKService(store, testCipher, clock.Now)
+		user := createTestUser(t, store, 1)
+		tier := createTestTier(t, store, 10, true, 3)
+
+		if err := cdks.ScheduleRelease(tier.ID, 2, clock.Now()); err == nil {
+			t.Error("ScheduleRelease() 放出时间不晚于当前时间时应返回错误")
+		}
+		if err := cdks.ScheduleRelease(tier.ID, 4, clock.Now().Add(time.Hour)); err == nil {
+			t.Error("ScheduleRelease() 可用CDK不足时应返回错误")
+		}
+		releaseAt := clock.Now().Add(time.Hour)
+		if err := cdks.ScheduleRelease(tier.ID, 2, releaseAt); err != nil {
+			t.Fatalf("ScheduleRelease() error = %v", err)
+		}
+
+		got, err := tiers.GetTierByID(tier.ID)
+		if err != nil {
+			t.Fatalf("GetTierByID() error = %v", err)
+		}
+		if got.Stock != 1 || got.Scheduled != 2 || got.NextReleaseCount != 2 || !got.NextReleaseAt.Equal(releaseAt) {
+			t.Errorf("GetTierByID() 库存 = %d, 待放出 = %d, 下一批 = %d @ %v", got.Stock, got.Scheduled, got.NextReleaseCount, got.NextReleaseAt)
+		}
+
+		if _, err := cdks.ClaimNextCDK(tier.ID, user.ID); err != nil {
+			t.Fatalf("ClaimNextCDK() error = %v", err)
+		}
+		if _, err := cdks.ClaimNextCDK(tier.ID, user.ID); err == nil {
+			t.Error("放出前 ClaimNextCDK() 应返回错误")
+		}
+
+		clock.Advance(time.Hour)
+		got, _ = tiers.GetTierByID(tier.ID)
+		if got.Stock != 2 || got.Scheduled != 0 || !got.NextReleaseAt.IsZero() {
+			t.Errorf("放出后 库存 = %d, 待放出 = %d, 下一批 = %v", got.Stock, got.Scheduled, got.NextReleaseAt)
+		}
+		if _, err := cdks.ClaimNextCDK(tier.ID, user.ID); err != nil {
+			t.Errorf("放出后 ClaimNextCDK() error = %v", err)
+		}
+	})
+}
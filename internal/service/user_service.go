@@ -1,244 +1,114 @@
 package service
 
 import (
-	"encoding/csv"
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
-	"strconv"
+	"log"
+	"sync"
 	"time"
 
 	"github.com/zhongruan0522/DuiDuiMao/internal/config"
 	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
 )
 
 // UserService 用户服务
 type UserService struct {
-	mode string // dev 或 server
+	repo   repository.UserRepository
+	mu     sync.RWMutex // 保护admins（重新加载配置时更新）
+	admins config.AdminConfig
 }
 
-// NewUserService 创建用户服务
-func NewUserService(mode string) *UserService {
-	return &UserService{mode: mode}
+// NewUserService 创建用户服务，admins为按LinuxDo用户指定的管理员
+func NewUserService(repo repository.UserRepository, admins config.AdminConfig) *UserService {
+	return &UserService{repo: repo, admins: admins}
 }
 
-// CreateOrUpdateUser 创建或更新用户（OAuth登录后调用）
-func (s *UserService) CreateOrUpdateUser(linuxDoID int, username, name string, trustLevel int, isAdmin bool) (*model.User, error) {
-	if s.mode == config.ModeDev {
-		return s.createOrUpdateUserCSV(linuxDoID, username, name, trustLevel, isAdmin)
-	}
-	// TODO: 实现数据库版本
-	return nil, fmt.Errorf("数据库模式暂未实现")
+// SetAdmins 更新按LinuxDo用户指定的管理员（重新加载配置后调用）
+func (s *UserService) SetAdmins(admins config.AdminConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.admins = admins
 }
 
-// GetUserByLinuxDoID 根据LinuxDo ID获取用户
-func (s *UserService) GetUserByLinuxDoID(linuxDoID int) (*model.User, error) {
-	if s.mode == config.ModeDev {
-		return s.getUserByLinuxDoIDCSV(linuxDoID)
-	}
-	// TODO: 实现数据库版本
-	return nil, fmt.Errorf("数据库模式暂未实现")
-}
-
-// GetUserByID 根据ID获取用户
-func (s *UserService) GetUserByID(id int) (*model.User, error) {
-	if s.mode == config.ModeDev {
-		return s.getUserByIDCSV(id)
-	}
-	// TODO: 实现数据库版本
-	return nil, fmt.Errorf("数据库模式暂未实现")
+// RoleFor 按配置确定LinuxDo用户的管理员角色，同时出现在多个列表中时取权限最大的角色
+func (s *UserService) RoleFor(linuxDoID int, username string) string {
+	s.mu.RLock()
+	admins := s.admins
+	s.mu.RUnlock()
+
+	switch {
+	case admins.SuperAdmins.Contains(linuxDoID, username):
+		return model.RoleSuperAdmin
+	case admins.CDKOperators.Contains(linuxDoID, username):
+		return model.RoleCDKOperator
+	case admins.Auditors.Contains(linuxDoID, username):
+		return model.RoleAuditor
+	}
+	return ""
 }
 
-// ========== CSV模式实现 ==========
-
-const userCSVPath = "Temp/user.csv"
-
-// ensureUserCSV 确保CSV文件存在
-func (s *UserService) ensureUserCSV() error {
-	dir := filepath.Dir(userCSVPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return err
-	}
-
-	if _, err := os.Stat(userCSVPath); os.IsNotExist(err) {
-		file, err := os.Create(userCSVPath)
-		if err != nil {
-			return err
-		}
-		defer file.Close()
-
-		writer := csv.NewWriter(file)
-		// 写入CSV头部
-		header := []string{"id", "linux_do_id", "username", "name", "trust_level", "is_admin", "created_at", "updated_at"}
-		if err := writer.Write(header); err != nil {
-			return err
-		}
-		writer.Flush()
-	}
-	return nil
-}
-
-// readUsersCSV 读取所有用户
-func (s *UserService) readUsersCSV() ([]model.User, error) {
-	if err := s.ensureUserCSV(); err != nil {
-		return nil, err
-	}
-
-	file, err := os.Open(userCSVPath)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
-	if err != nil {
+// CreateOrUpdateUser 创建或更新用户（登录后调用），role为管理员角色（普通用户为空）
+func (s *UserService) CreateOrUpdateUser(linuxDoID int, username, name string, trustLevel int, role string) (*model.User, error) {
+	now := time.Now()
+	user := &model.User{
+		LinuxDoID:  linuxDoID,
+		Username:   username,
+		Name:       name,
+		TrustLevel: trustLevel,
+		IsAdmin:    role != "",
+		Role:       role,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if err := s.repo.Upsert(user); err != nil {
 		return nil, err
 	}
-
-	users := []model.User{}
-	for i, record := range records {
-		if i == 0 || len(record) < 8 {
-			continue // 跳过头部或不完整的行
-		}
-
-		id, _ := strconv.Atoi(record[0])
-		linuxDoID, _ := strconv.Atoi(record[1])
-		trustLevel, _ := strconv.Atoi(record[4])
-		isAdmin := record[5] == "true"
-		createdAt, _ := time.Parse(time.RFC3339, record[6])
-		updatedAt, _ := time.Parse(time.RFC3339, record[7])
-
-		users = append(users, model.User{
-			ID:         id,
-			LinuxDoID:  linuxDoID,
-			Username:   record[2],
-			Name:       record[3],
-			TrustLevel: trustLevel,
-			IsAdmin:    isAdmin,
-			CreatedAt:  createdAt,
-			UpdatedAt:  updatedAt,
-		})
-	}
-	return users, nil
+	return user, nil
 }
 
-// writeUsersCSV 写入所有用户
-func (s *UserService) writeUsersCSV(users []model.User) error {
-	file, err := os.Create(userCSVPath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
-
-	// 写入头部
-	header := []string{"id", "linux_do_id", "username", "name", "trust_level", "is_admin", "created_at", "updated_at"}
-	if err := writer.Write(header); err != nil {
-		return err
+// GetUserByLinuxDoID 根据LinuxDo ID获取用户
+func (s *UserService) GetUserByLinuxDoID(linuxDoID int) (*model.User, error) {
+	user, err := s.repo.GetByLinuxDoID(linuxDoID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, fmt.Errorf("用户不存在")
 	}
+	return user, err
+}
 
-	// 写入数据
-	for _, user := range users {
-		record := []string{
-			strconv.Itoa(user.ID),
-			strconv.Itoa(user.LinuxDoID),
-			user.Username,
-			user.Name,
-			strconv.Itoa(user.TrustLevel),
-			strconv.FormatBool(user.IsAdmin),
-			user.CreatedAt.Format(time.RFC3339),
-			user.UpdatedAt.Format(time.RFC3339),
-		}
-		if err := writer.Write(record); err != nil {
-			return err
-		}
+// GetUserByID 根据ID获取用户
+func (s *UserService) GetUserByID(id int) (*model.User, error) {
+	user, err := s.repo.GetByID(id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, fmt.Errorf("用户不存在")
 	}
-	return nil
+	return user, err
 }
 
-// createOrUpdateUserCSV CSV模式创建或更新用户
-func (s *UserService) createOrUpdateUserCSV(linuxDoID int, username, name string, trustLevel int, isAdmin bool) (*model.User, error) {
-	users, err := s.readUsersCSV()
+// SyncAdminRoles 按当前配置调整已有管理员的角色（启动时调用）
+// 角色只在登录时授予，从配置中移除的管理员若不重新登录会一直保留原角色，因此启动时收回或调整
+// 账密登录的管理员（LinuxDoID为0）不受影响；新加入配置的用户仍在下次登录时授予角色
+func (s *UserService) SyncAdminRoles() (int, error) {
+	admins, err := s.repo.ListAdmins()
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
+	changed := 0
 	now := time.Now()
-	var targetUser *model.User
-
-	// 查找是否已存在
-	found := false
-	for i := range users {
-		if users[i].LinuxDoID == linuxDoID {
-			// 更新现有用户
-			users[i].Username = username
-			users[i].Name = name
-			users[i].TrustLevel = trustLevel
-			users[i].IsAdmin = isAdmin
-			users[i].UpdatedAt = now
-			targetUser = &users[i]
-			found = true
-			break
-		}
-	}
-
-	if !found {
-		// 创建新用户
-		newID := 1
-		if len(users) > 0 {
-			newID = users[len(users)-1].ID + 1
-		}
-
-		newUser := model.User{
-			ID:         newID,
-			LinuxDoID:  linuxDoID,
-			Username:   username,
-			Name:       name,
-			TrustLevel: trustLevel,
-			IsAdmin:    isAdmin,
-			CreatedAt:  now,
-			UpdatedAt:  now,
+	for _, user := range admins {
+		if user.LinuxDoID == 0 {
+			continue
 		}
-		users = append(users, newUser)
-		targetUser = &newUser
-	}
-
-	if err := s.writeUsersCSV(users); err != nil {
-		return nil, err
-	}
-
-	return targetUser, nil
-}
-
-// getUserByLinuxDoIDCSV CSV模式根据LinuxDo ID获取用户
-func (s *UserService) getUserByLinuxDoIDCSV(linuxDoID int) (*model.User, error) {
-	users, err := s.readUsersCSV()
-	if err != nil {
-		return nil, err
-	}
-
-	for _, user := range users {
-		if user.LinuxDoID == linuxDoID {
-			return &user, nil
+		role := s.RoleFor(user.LinuxDoID, user.Username)
+		if role == user.Role {
+			continue
 		}
-	}
-	return nil, fmt.Errorf("用户不存在")
-}
-
-// getUserByIDCSV CSV模式根据ID获取用户
-func (s *UserService) getUserByIDCSV(id int) (*model.User, error) {
-	users, err := s.readUsersCSV()
-	if err != nil {
-		return nil, err
-	}
-
-	for _, user := range users {
-		if user.ID == id {
-			return &user, nil
+		if err := s.repo.UpdateRole(user.ID, role, now); err != nil {
+			return changed, err
 		}
+		log.Printf("用户 %d（%s）的管理员角色 %q -> %q", user.ID, user.Username, user.Role, role)
+		changed++
 	}
-	return nil, fmt.Errorf("用户不存在")
+	return changed, nil
 }
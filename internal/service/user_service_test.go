@@ -0,0 +1,115 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/config"
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
+)
+
+// testAdmins 测试用管理员配置
+var testAdmins = config.AdminConfig{
+	SuperAdmins:  config.AdminList{UserIDs: []int{1}},
+	CDKOperators: config.AdminList{UserIDs: []int{2}, Usernames: []string{"Volunteer"}},
+	Auditors:     config.AdminList{UserIDs: []int{1, 3}},
+}
+
+func TestRoleFor(t *testing.T) {
+	users := NewUserService(nil, testAdmins)
+	tests := []struct {
+		name      string
+		linuxDoID int
+		username  string
+		want      string
+	}{
+		{"按ID指定的超级管理员", 1, "root", model.RoleSuperAdmin},
+		{"按ID指定的CDK运营", 2, "ops", model.RoleCDKOperator},
+		{"按用户名指定（不区分大小写）", 9, "volunteer", model.RoleCDKOperator},
+		{"审计员", 3, "audit", model.RoleAuditor},
+		{"普通用户", 4, "user", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := users.RoleFor(tt.linuxDoID, tt.username); got != tt.want {
+				t.Errorf("RoleFor(%d, %q) = %q, want %q", tt.linuxDoID, tt.username, got, tt.want)
+			}
+		})
+	}
+
+	// 重新加载配置后立即按新的管理员列表判断
+	users.SetAdmins(config.AdminConfig{Auditors: config.AdminList{UserIDs: []int{4}}})
+	if got := users.RoleFor(1, "root"); got != "" {
+		t.Errorf("SetAdmins() 后 RoleFor(1) = %q, want 空", got)
+	}
+	if got := users.RoleFor(4, "user"); got != model.RoleAuditor {
+		t.Errorf("SetAdmins() 后 RoleFor(4) = %q, want %q", got, model.RoleAuditor)
+	}
+}
+
+func TestRoleAllows(t *testing.T) {
+	tests := []struct {
+		name    string
+		role    string
+		allowed []string
+		want    bool
+	}{
+		{"超级管理员总是允许", model.RoleSuperAdmin, nil, true},
+		{"CDK运营导入CDK", model.RoleCDKOperator, []string{model.RoleCDKOperator}, true},
+		{"CDK运营修改设置", model.RoleCDKOperator, nil, false},
+		{"审计员导入CDK", model.RoleAuditor, []string{model.RoleCDKOperator}, false},
+		{"审计员查看订单", model.RoleAuditor, []string{model.RoleAuditor}, true},
+		{"普通用户", "", []string{model.RoleCDKOperator, model.RoleAuditor}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := model.RoleAllows(tt.role, tt.allowed...); got != tt.want {
+				t.Errorf("RoleAllows(%q, %v) = %v, want %v", tt.role, tt.allowed, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyncAdminRoles(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store repository.Store) {
+		users := NewUserService(store.Users(), testAdmins)
+		now := time.Now()
+		create := func(linuxDoID int, username, role string) *model.User {
+			t.Helper()
+			user := &model.User{LinuxDoID: linuxDoID, Username: username, IsAdmin: role != "", Role: role, CreatedAt: now, UpdatedAt: now}
+			if err := store.Users().Upsert(user); err != nil {
+				t.Fatalf("创建用户失败: %v", err)
+			}
+			return user
+		}
+
+		root := create(0, "root", model.RoleSuperAdmin)       // 账密登录的管理员
+		kept := create(1, "alice", model.RoleSuperAdmin)      // 仍在配置中
+		removed := create(5, "mallory", model.RoleSuperAdmin) // 已从配置中移除
+		demoted := create(3, "carol", model.RoleCDKOperator)  // 已改为审计员
+		plain := create(2, "ops", "")                         // 新加入配置，下次登录时授予
+
+		changed, err := users.SyncAdminRoles()
+		if err != nil {
+			t.Fatalf("SyncAdminRoles() error = %v", err)
+		}
+		if changed != 2 {
+			t.Errorf("SyncAdminRoles() = %d, want 2", changed)
+		}
+
+		want := map[*model.User]string{
+			root:    model.RoleSuperAdmin,
+			kept:    model.RoleSuperAdmin,
+			removed: "",
+			demoted: model.RoleAuditor,
+			plain:   "",
+		}
+		for user, role := range want {
+			got, _ := store.Users().GetByID(user.ID)
+			if got.Role != role || got.IsAdmin != (role != "") {
+				t.Errorf("用户 %s 角色 = %q（is_admin=%v），want %q", user.Username, got.Role, got.IsAdmin, role)
+			}
+		}
+	})
+}
@@ -0,0 +1,281 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/model"
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
+	"github.com/zhongruan0522/DuiDuiMao/internal/util"
+)
+
+// tokenRefreshMargin access token剩余有效期不足该时长时先刷新再使用
+const tokenRefreshMargin = time.Minute
+
+// UserSyncService LinuxDo账号同步服务：保存用户登录时获得的令牌，定期或按需刷新信任等级和账号状态
+type UserSyncService struct {
+	store    repository.Store
+	provider OAuthProvider
+	sessions *SessionService
+	cipher   *util.CodeCipher // 令牌与CDK使用同一套密钥加密存储
+	now      Clock
+}
+
+// NewUserSyncService 创建LinuxDo账号同步服务
+func NewUserSyncService(store repository.Store, provider OAuthProvider, sessions *SessionService, cipher *util.CodeCipher, clock Clock) *UserSyncService {
+	return &UserSyncService{store: store, provider: provider, sessions: sessions, cipher: cipher, now: clock}
+}
+
+// Reencrypt 用当前密钥重新加密所有用户保存的LinuxDo令牌
+func (s *UserSyncService) Reencrypt(result *RotateKeysResult) error {
+	tokens, err := s.store.OAuthTokens().List()
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if err := s.rotateOAuthToken(result, token); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rotateOAuthToken 重新加密用户的LinuxDo令牌，access token和refresh token（为空时跳过）一起替换
+// 任一令牌无法解密时整条跳过并计为失败
+func (s *UserSyncService) rotateOAuthToken(result *RotateKeysResult, token model.OAuthToken) error {
+	stored := []string{token.AccessToken, token.RefreshToken}
+	rotated := []string{token.AccessToken, token.RefreshToken}
+	changed := 0
+	for i, value := range stored {
+		if value == "" {
+			continue
+		}
+		result.Total++
+		if !s.cipher.NeedsRotation(value) {
+			continue
+		}
+		plain, err := s.cipher.Decrypt(value)
+		if err != nil {
+			log.Printf("用户 %d 的LinuxDo令牌解密失败，跳过: %v", token.UserID, err)
+			result.Failed++
+			return nil
+		}
+		if rotated[i], err = s.cipher.Encrypt(plain); err != nil {
+			return fmt.Errorf("加密LinuxDo令牌失败: %v", err)
+		}
+		changed++
+	}
+	if changed == 0 {
+		return nil
+	}
+
+	err := s.store.OAuthTokens().UpdateCiphertexts(token.UserID, stored[0], stored[1], rotated[0], rotated[1])
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil // 令牌已被刷新（使用当前密钥重新保存）
+	}
+	if err != nil {
+		return err
+	}
+	result.Rotated += changed
+	return nil
+}
+
+// UserSyncResult 批量同步结果
+type UserSyncResult struct {
+	Total   int `json:"total"`   // 保存了令牌的用户数
+	Updated int `json:"updated"` // 信任等级发生变化的用户数
+	Blocked int `json:"blocked"` // 账号未激活或被禁言、已被登出的用户数
+	Failed  int `json:"failed"`  // 同步失败的用户数（令牌失效等，需要用户重新登录）
+}
+
+// SaveToken 加密保存用户登录时获得的LinuxDo令牌
+func (s *UserSyncService) SaveToken(userID int, token *LinuxDoToken) error {
+	accessToken, err := s.cipher.Encrypt(token.AccessToken)
+	if err != nil {
+		return err
+	}
+	refreshToken := ""
+	if token.RefreshToken != "" {
+		if refreshToken, err = s.cipher.Encrypt(token.RefreshToken); err != nil {
+			return err
+		}
+	}
+
+	now := s.now()
+	stored := &model.OAuthToken{
+		UserID:       userID,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		UpdatedAt:    now,
+	}
+	if token.ExpiresIn > 0 {
+		stored.ExpiresAt = now.Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	if err := s.store.OAuthTokens().Save(stored); err != nil {
+		return fmt.Errorf("保存LinuxDo令牌失败: %v", err)
+	}
+	return nil
+}
+
+// accessToken 取出用户可用的access token，即将过期时先用refresh token刷新
+func (s *UserSyncService) accessToken(userID int) (string, error) {
+	stored, err := s.store.OAuthTokens().GetByUserID(userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return "", fmt.Errorf("没有保存的LinuxDo令牌，需要用户重新登录")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if stored.ExpiresAt.IsZero() || s.now().Add(tokenRefreshMargin).Before(stored.ExpiresAt) {
+		return s.cipher.Decrypt(stored.AccessToken)
+	}
+	if stored.RefreshToken == "" {
+		return "", fmt.Errorf("LinuxDo令牌已过期，需要用户重新登录")
+	}
+	refreshToken, err := s.cipher.Decrypt(stored.RefreshToken)
+	if err != nil {
+		return "", err
+	}
+	token, err := s.provider.RefreshToken(refreshToken)
+	if err != nil {
+		return "", fmt.Errorf("刷新LinuxDo令牌失败: %v", err)
+	}
+	if token.RefreshToken == "" {
+		token.RefreshToken = refreshToken // 未轮换时继续使用原refresh token
+	}
+	if err := s.SaveToken(userID, token); err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+// SyncUser 从LinuxDo同步用户的信任等级和账号状态
+// 账号未激活或已被禁言时登出该用户的所有会话，并返回包装了ErrAccountBlocked的错误
+func (s *UserSyncService) SyncUser(userID int) (*model.User, error) {
+	user, _, err := s.syncUser(userID)
+	return user, err
+}
+
+// syncUser 同步单个用户，返回同步后的用户以及信任等级是否发生变化
+func (s *UserSyncService) syncUser(userID int) (*model.User, bool, error) {
+	user, err := s.store.Users().GetByID(userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, false, fmt.Errorf("用户不存在")
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if user.LinuxDoID == 0 {
+		return nil, false, fmt.Errorf("该用户不是LinuxDo用户")
+	}
+
+	accessToken, err := s.accessToken(userID)
+	if err != nil {
+		return nil, false, err
+	}
+	linuxDoUser, err := s.provider.GetUser(accessToken)
+	if err != nil {
+		return nil, false, fmt.Errorf("获取LinuxDo用户信息失败: %v", err)
+	}
+	if linuxDoUser.ID != user.LinuxDoID {
+		return nil, false, fmt.Errorf("LinuxDo用户不匹配: 令牌属于 %d，本地用户为 %d", linuxDoUser.ID, user.LinuxDoID)
+	}
+
+	if err := linuxDoUser.CheckAccount(); err != nil {
+		if _, logoutErr := s.sessions.LogoutAll(user.ID); logoutErr != nil {
+			log.Printf("登出用户 %d 失败: %v", user.ID, logoutErr)
+		}
+		return user, false, err
+	}
+
+	if linuxDoUser.TrustLevel == user.TrustLevel {
+		return user, false, nil
+	}
+	now := s.now()
+	if err := s.store.Users().UpdateTrustLevel(user.ID, linuxDoUser.TrustLevel, now); err != nil {
+		return nil, false, err
+	}
+	log.Printf("用户 %d 信任等级 %d -> %d", user.ID, user.TrustLevel, linuxDoUser.TrustLevel)
+	user.TrustLevel = linuxDoUser.TrustLevel
+	user.UpdatedAt = now
+	return user, true, nil
+}
+
+// SyncAll 同步所有保存了令牌的用户
+func (s *UserSyncService) SyncAll() (*UserSyncResult, error) {
+	tokens, err := s.store.OAuthTokens().List()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &UserSyncResult{Total: len(tokens)}
+	for _, token := range tokens {
+		_, changed, err := s.syncUser(token.UserID)
+		switch {
+		case errors.Is(err, ErrAccountBlocked):
+			result.Blocked++
+		case err != nil:
+			log.Printf("同步用户 %d 失败: %v", token.UserID, err)
+			result.Failed++
+		case changed:
+			result.Updated++
+		}
+	}
+	return result, nil
+}
+
+// UserSyncer LinuxDo账号定期同步器
+type UserSyncer struct {
+	users    *UserSyncService
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+// NewUserSyncer 创建LinuxDo账号定期同步器，interval为同步间隔
+func NewUserSyncer(users *UserSyncService, interval time.Duration) *UserSyncer {
+	return &UserSyncer{
+		users:    users,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start 在后台协程中开始定期同步（启动后等待一个间隔再进行第一次同步）
+func (w *UserSyncer) Start() {
+	go w.run()
+}
+
+// Stop 停止同步并等待正在进行的一轮同步结束（须在Start之后调用）
+func (w *UserSyncer) Stop() {
+	w.once.Do(func() { close(w.stop) })
+	<-w.done
+}
+
+func (w *UserSyncer) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		}
+
+		result, err := w.users.SyncAll()
+		if err != nil {
+			log.Printf("同步LinuxDo用户失败: %v", err)
+			continue
+		}
+		log.Printf("同步LinuxDo用户：共 %d 个，等级变化 %d 个，已登出 %d 个，失败 %d 个", result.Total, result.Updated, result.Blocked, result.Failed)
+	}
+}
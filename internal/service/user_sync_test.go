@@ -0,0 +1,154 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zhongruan0522/DuiDuiMao/internal/repository"
+	"github.com/zhongruan0522/DuiDuiMao/internal/util"
+)
+
+func TestUserSync(t *testing.T) {
+	util.InitJWT("test-secret")
+	forEachStore(t, func(t *testing.T, store repository.Store) {
+		clock := newFakeClock()
+		linuxDo := newFakeLinuxDo(t)
+		sessions := NewSessionService(store, 15*time.Minute, time.Hour, clock.Now)
+		users := NewUserSyncService(store, linuxDo.client(), sessions, testCipher, clock.Now)
+
+		// 信任等级3的用户被降级为1
+		demoted := createTestUser(t, store, 101)
+		if err := store.Users().UpdateTrustLevel(demoted.ID, 3, clock.Now()); err != nil {
+			t.Fatalf("UpdateTrustLevel() error = %v", err)
+		}
+		if err := users.SaveToken(demoted.ID, &LinuxDoToken{AccessToken: "at-101", ExpiresIn: 86400}); err != nil {
+			t.Fatalf("SaveToken() error = %v", err)
+		}
+		linuxDo.setUser("at-101", LinuxDoUser{ID: 101, Active: true, TrustLevel: 1})
+
+		// 令牌以密文保存
+		if stored, _ := store.OAuthTokens().GetByUserID(demoted.ID); stored.AccessToken == "at-101" {
+			t.Error("access token 未加密保存")
+		}
+
+		user, err := users.SyncUser(demoted.ID)
+		if err != nil {
+			t.Fatalf("SyncUser() error = %v", err)
+		}
+		if user.TrustLevel != 1 {
+			t.Errorf("SyncUser() 信任等级 = %d, want 1", user.TrustLevel)
+		}
+		if got, _ := store.Users().GetByID(demoted.ID); got.TrustLevel != 1 {
+			t.Errorf("数据库中信任等级 = %d, want 1", got.TrustLevel)
+		}
+
+		// 被禁言的用户：同步后所有会话被撤销
+		silenced := createTestUser(t, store, 102)
+		tokens, err := sessions.Login(silenced)
+		if err != nil {
+			t.Fatalf("Login() error = %v", err)
+		}
+		if err := users.SaveToken(silenced.ID, &LinuxDoToken{AccessToken: "at-102", ExpiresIn: 86400}); err != nil {
+			t.Fatalf("SaveToken() error = %v", err)
+		}
+		linuxDo.setUser("at-102", LinuxDoUser{ID: 102, Active: true, Silenced: true})
+		if _, err := users.SyncUser(silenced.ID); !errors.Is(err, ErrAccountBlocked) {
+			t.Errorf("SyncUser(已被禁言) error = %v, want ErrAccountBlocked", err)
+		}
+		if err := authenticate(sessions, tokens.AccessToken); !errors.Is(err, ErrSessionInvalid) {
+			t.Errorf("被禁言用户的会话仍然有效: %v", err)
+		}
+
+		// access token过期后用refresh token刷新
+		expired := createTestUser(t, store, 103)
+		if err := users.SaveToken(expired.ID, &LinuxDoToken{AccessToken: "at-103-old", RefreshToken: "rt-103", ExpiresIn: 60}); err != nil {
+			t.Fatalf("SaveToken() error = %v", err)
+		}
+		linuxDo.setRefresh("rt-103", "at-103-new")
+		linuxDo.setUser("at-103-new", LinuxDoUser{ID: 103, Active: true, TrustLevel: 2})
+		clock.Advance(time.Hour)
+		if user, err := users.SyncUser(expired.ID); err != nil || user.TrustLevel != 2 {
+			t.Fatalf("SyncUser(令牌过期) = %+v, %v", user, err)
+		}
+		stored, _ := store.OAuthTokens().GetByUserID(expired.ID)
+		if accessToken, _ := testCipher.Decrypt(stored.AccessToken); accessToken != "at-103-new" {
+			t.Errorf("刷新后保存的access token = %q, want at-103-new", accessToken)
+		}
+		if refreshToken, _ := testCipher.Decrypt(stored.RefreshToken); refreshToken != "rt-103" {
+			t.Errorf("未轮换时应保留原refresh token, got %q", refreshToken)
+		}
+
+		// 没有保存令牌的用户无法同步
+		noToken := createTestUser(t, store, 104)
+		if _, err := users.SyncUser(noToken.ID); err == nil {
+			t.Error("SyncUser(没有令牌) 应返回错误")
+		}
+
+		// 令牌属于其他LinuxDo用户时不修改本地数据
+		mismatch := createTestUser(t, store, 105)
+		if err := users.SaveToken(mismatch.ID, &LinuxDoToken{AccessToken: "at-101", ExpiresIn: 86400}); err != nil {
+			t.Fatalf("SaveToken() error = %v", err)
+		}
+		if _, err := users.SyncUser(mismatch.ID); err == nil {
+			t.Error("SyncUser(用户不匹配) 应返回错误")
+		}
+
+		// 批量同步：再把101升级为2，另有一个令牌已失效的用户
+		linuxDo.setUser("at-101", LinuxDoUser{ID: 101, Active: true, TrustLevel: 2})
+		invalid := createTestUser(t, store, 106)
+		if err := users.SaveToken(invalid.ID, &LinuxDoToken{AccessToken: "at-106-revoked", ExpiresIn: 86400}); err != nil {
+			t.Fatalf("SaveToken() error = %v", err)
+		}
+		result, err := users.SyncAll()
+		if err != nil {
+			t.Fatalf("SyncAll() error = %v", err)
+		}
+		want := UserSyncResult{Total: 5, Updated: 1, Blocked: 1, Failed: 2}
+		if *result != want {
+			t.Errorf("SyncAll() = %+v, want %+v", *result, want)
+		}
+	})
+}
+
+func TestRotateKeysOAuthTokens(t *testing.T) {
+	util.InitJWT("test-secret")
+	forEachStore(t, func(t *testing.T, store repository.Store) {
+		clock := newFakeClock()
+		linuxDo := newFakeLinuxDo(t)
+		sessions := NewSessionService(store, 15*time.Minute, time.Hour, clock.Now)
+
+		// 用密钥a加密保存令牌：一个只有access token，一个同时有refresh token
+		users := NewUserSyncService(store, linuxDo.client(), sessions, testCipher, clock.Now)
+		plain := createTestUser(t, store, 201)
+		if err := users.SaveToken(plain.ID, &LinuxDoToken{AccessToken: "at-201", ExpiresIn: 86400}); err != nil {
+			t.Fatalf("SaveToken() error = %v", err)
+		}
+		refreshing := createTestUser(t, store, 202)
+		if err := users.SaveToken(refreshing.ID, &LinuxDoToken{AccessToken: "at-202-old", RefreshToken: "rt-202", ExpiresIn: 60}); err != nil {
+			t.Fatalf("SaveToken() error = %v", err)
+		}
+
+		// 新增密钥b并轮换，LinuxDo令牌也应计入
+		result, err := newTestKeyRotation(store, mustCipher("b", map[string]string{"a": testKeyA, "b": testKeyB})).RotateKeys()
+		if err != nil {
+			t.Fatalf("RotateKeys() error = %v", err)
+		}
+		if result.Total != 3 || result.Rotated != 3 || result.Failed != 0 {
+			t.Errorf("RotateKeys() = %+v, want 3个全部轮换", result)
+		}
+
+		// 移除密钥a后仍能读取access token，过期后也能用refresh token刷新
+		onlyB := NewUserSyncService(store, linuxDo.client(), sessions, mustCipher("b", map[string]string{"b": testKeyB}), clock.Now)
+		linuxDo.setUser("at-201", LinuxDoUser{ID: 201, Active: true, TrustLevel: 2})
+		if user, err := onlyB.SyncUser(plain.ID); err != nil || user.TrustLevel != 2 {
+			t.Errorf("轮换后 SyncUser() = %+v, %v", user, err)
+		}
+		linuxDo.setRefresh("rt-202", "at-202-new")
+		linuxDo.setUser("at-202-new", LinuxDoUser{ID: 202, Active: true, TrustLevel: 3})
+		clock.Advance(time.Hour)
+		if user, err := onlyB.SyncUser(refreshing.ID); err != nil || user.TrustLevel != 3 {
+			t.Errorf("轮换后 SyncUser(令牌过期) = %+v, %v", user, err)
+		}
+	})
+}
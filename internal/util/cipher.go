@@ -0,0 +1,158 @@
+package util
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// cipherPrefix 加密后的CDK前缀，完整格式为 enc:<密钥ID>:<Base64(nonce+密文)>
+// 旧数据是双重Base64编码，不会包含冒号，因此可以据此区分
+const cipherPrefix = "enc:"
+
+// devFingerprintKey 未配置指纹密钥时使用的固定密钥（仅适合开发环境）
+const devFingerprintKey = "duiduimao-dev-fingerprint-key"
+
+// CodeCipher CDK加解密器（AES-256-GCM）
+// 密文带有密钥ID，配置多个密钥即可解密旧密钥加密的数据，新数据总是使用当前密钥加密
+// 指纹使用独立的HMAC密钥，轮换加密密钥不会改变指纹
+type CodeCipher struct {
+	currentID      string
+	keys           map[string]cipher.AEAD
+	fingerprintKey []byte
+}
+
+// NewCodeCipher 创建CDK加解密器，keys为密钥ID到Base64编码的32字节密钥的映射
+// currentID为空且未配置任何密钥时退化为旧的双重Base64编码（仅适合开发环境）
+// fingerprintKey为Base64编码的HMAC密钥（至少16字节），为空时使用固定的开发密钥
+func NewCodeCipher(currentID string, keys map[string]string, fingerprintKey string) (*CodeCipher, error) {
+	c := &CodeCipher{currentID: currentID, keys: make(map[string]cipher.AEAD)}
+	if fingerprintKey != "" {
+		key, err := base64.StdEncoding.DecodeString(fingerprintKey)
+		if err != nil {
+			return nil, fmt.Errorf("指纹密钥不是有效的Base64: %v", err)
+		}
+		if len(key) < 16 {
+			return nil, fmt.Errorf("指纹密钥长度至少为16字节，实际为%d字节", len(key))
+		}
+		c.fingerprintKey = key
+	}
+	for id, encoded := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("无效的密钥ID: %q", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("密钥 %s 不是有效的Base64: %v", id, err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("密钥 %s 长度必须为32字节，实际为%d字节", id, len(key))
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		c.keys[id] = aead
+	}
+
+	if currentID == "" && len(c.keys) > 0 {
+		return nil, fmt.Errorf("配置了加密密钥但未指定当前使用的密钥ID")
+	}
+	if currentID != "" && c.keys[currentID] == nil {
+		return nil, fmt.Errorf("当前密钥 %s 未配置", currentID)
+	}
+	return c, nil
+}
+
+// Enabled 是否配置了加密密钥
+func (c *CodeCipher) Enabled() bool {
+	return c.currentID != ""
+}
+
+// FingerprintEnabled 是否配置了指纹密钥
+func (c *CodeCipher) FingerprintEnabled() bool {
+	return c.fingerprintKey != nil
+}
+
+// CurrentKeyID 当前用于加密的密钥ID
+func (c *CodeCipher) CurrentKeyID() string {
+	return c.currentID
+}
+
+// Encrypt 使用当前密钥加密，未配置密钥时使用双重Base64编码
+func (c *CodeCipher) Encrypt(plain string) (string, error) {
+	if !c.Enabled() {
+		return DoubleEncode(plain), nil
+	}
+
+	aead := c.keys[c.currentID]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("生成随机数失败: %v", err)
+	}
+	// 密钥ID作为附加数据参与认证，篡改前缀会导致解密失败
+	sealed := aead.Seal(nonce, nonce, []byte(plain), []byte(c.currentID))
+	return cipherPrefix + c.currentID + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt 解密存储的CDK，兼容旧的双重Base64数据
+func (c *CodeCipher) Decrypt(stored string) (string, error) {
+	if !strings.HasPrefix(stored, cipherPrefix) {
+		return DoubleDecode(stored)
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(stored, cipherPrefix), ":", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("密文格式错误")
+	}
+	aead, ok := c.keys[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("未配置密钥 %s", parts[0])
+	}
+	sealed, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("密文格式错误: %v", err)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("密文格式错误")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(parts[0]))
+	if err != nil {
+		return "", fmt.Errorf("解密失败: %v", err)
+	}
+	return string(plain), nil
+}
+
+// KeyID 返回存储的CDK使用的密钥ID，旧的双重Base64数据返回空字符串
+func KeyID(stored string) string {
+	if !strings.HasPrefix(stored, cipherPrefix) {
+		return ""
+	}
+	return strings.SplitN(strings.TrimPrefix(stored, cipherPrefix), ":", 2)[0]
+}
+
+// NeedsRotation 存储的CDK是否需要用当前密钥重新加密
+func (c *CodeCipher) NeedsRotation(stored string) bool {
+	return c.Enabled() && KeyID(stored) != c.currentID
+}
+
+// Fingerprint 计算CDK明文的指纹（HMAC-SHA256，十六进制），用于不解密即可检测重复
+func (c *CodeCipher) Fingerprint(plain string) string {
+	key := c.fingerprintKey
+	if key == nil {
+		key = []byte(devFingerprintKey)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(plain))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,131 @@
+package util
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+var (
+	testCipherKeyA = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	testCipherKeyB = base64.StdEncoding.EncodeToString([]byte("fedcba9876543210fedcba9876543210"))
+)
+
+func TestNewCodeCipher(t *testing.T) {
+	tests := []struct {
+		name           string
+		keyID          string
+		keys           map[string]string
+		fingerprintKey string
+		wantErr        bool
+	}{
+		{"未配置密钥", "", nil, "", false},
+		{"单个密钥", "a", map[string]string{"a": testCipherKeyA}, "", false},
+		{"当前密钥未配置", "b", map[string]string{"a": testCipherKeyA}, "", true},
+		{"未指定当前密钥", "", map[string]string{"a": testCipherKeyA}, "", true},
+		{"密钥长度错误", "a", map[string]string{"a": base64.StdEncoding.EncodeToString([]byte("short"))}, "", true},
+		{"密钥不是Base64", "a", map[string]string{"a": "!!!"}, "", true},
+		{"密钥ID包含冒号", "a:b", map[string]string{"a:b": testCipherKeyA}, "", true},
+		{"指纹密钥", "a", map[string]string{"a": testCipherKeyA}, testCipherKeyB, false},
+		{"指纹密钥过短", "a", map[string]string{"a": testCipherKeyA}, base64.StdEncoding.EncodeToString([]byte("short")), true},
+		{"指纹密钥不是Base64", "", nil, "!!!", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewCodeCipher(tt.keyID, tt.keys, tt.fingerprintKey)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewCodeCipher() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCodeCipher(t *testing.T) {
+	oldCipher, _ := NewCodeCipher("a", map[string]string{"a": testCipherKeyA}, "")
+	newCipher, _ := NewCodeCipher("b", map[string]string{"a": testCipherKeyA, "b": testCipherKeyB}, "")
+
+	encrypted, err := oldCipher.Encrypt("CDK-SECRET")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if !strings.HasPrefix(encrypted, "enc:a:") || strings.Contains(encrypted, DoubleEncode("CDK-SECRET")) {
+		t.Fatalf("Encrypt() = %s, want enc:a: 前缀的密文", encrypted)
+	}
+	if again, _ := oldCipher.Encrypt("CDK-SECRET"); again == encrypted {
+		t.Errorf("相同明文两次加密结果相同，nonce未随机")
+	}
+
+	tampered := encrypted[:len(encrypted)-4] + "AAA="
+	tests := []struct {
+		name    string
+		c       *CodeCipher
+		stored  string
+		want    string
+		wantErr bool
+	}{
+		{"当前密钥解密", oldCipher, encrypted, "CDK-SECRET", false},
+		{"轮换后用旧密钥解密", newCipher, encrypted, "CDK-SECRET", false},
+		{"旧的双重Base64数据", newCipher, DoubleEncode("LEGACY"), "LEGACY", false},
+		{"缺少密钥", mustNewCodeCipher(t, "b", map[string]string{"b": testCipherKeyB}), encrypted, "", true},
+		{"密文被篡改", oldCipher, tampered, "", true},
+		{"篡改密钥ID", newCipher, strings.Replace(encrypted, "enc:a:", "enc:b:", 1), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.c.Decrypt(tt.stored)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decrypt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Decrypt() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if !newCipher.NeedsRotation(encrypted) || !newCipher.NeedsRotation(DoubleEncode("LEGACY")) {
+		t.Errorf("旧密钥和旧格式数据应需要轮换")
+	}
+	if rotated, _ := newCipher.Encrypt("CDK-SECRET"); newCipher.NeedsRotation(rotated) || KeyID(rotated) != "b" {
+		t.Errorf("当前密钥加密的数据不应需要轮换: %s", rotated)
+	}
+}
+
+func TestCodeCipherLegacyMode(t *testing.T) {
+	c, _ := NewCodeCipher("", nil, "")
+	encoded, _ := c.Encrypt("CDK")
+	if encoded != DoubleEncode("CDK") {
+		t.Errorf("未配置密钥时 Encrypt() = %s, want 双重Base64", encoded)
+	}
+	if c.NeedsRotation(encoded) {
+		t.Errorf("未配置密钥时不应需要轮换")
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	a := mustNewCodeCipher(t, "a", map[string]string{"a": testCipherKeyA})
+	b := mustNewCodeCipher(t, "b", map[string]string{"b": testCipherKeyB})
+	keyed, _ := NewCodeCipher("a", map[string]string{"a": testCipherKeyA}, testCipherKeyB)
+
+	// 指纹只取决于指纹密钥，与加密密钥无关，轮换加密密钥后仍可匹配
+	if a.Fingerprint("CDK-1") != b.Fingerprint("CDK-1") {
+		t.Errorf("相同指纹密钥下指纹应一致")
+	}
+	if a.Fingerprint("CDK-1") == a.Fingerprint("CDK-2") {
+		t.Errorf("不同CDK的指纹不应相同")
+	}
+	if keyed.Fingerprint("CDK-1") == a.Fingerprint("CDK-1") {
+		t.Errorf("不同指纹密钥的指纹不应相同")
+	}
+	if a.FingerprintEnabled() || !keyed.FingerprintEnabled() {
+		t.Errorf("FingerprintEnabled() 结果错误")
+	}
+}
+
+func mustNewCodeCipher(t *testing.T, keyID string, keys map[string]string) *CodeCipher {
+	t.Helper()
+	c, err := NewCodeCipher(keyID, keys, "")
+	if err != nil {
+		t.Fatalf("NewCodeCipher() error = %v", err)
+	}
+	return c
+}
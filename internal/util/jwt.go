@@ -9,8 +9,8 @@ import (
 
 // Claims JWT载荷
 type Claims struct {
-	UserID  int  `json:"user_id"`
-	IsAdmin bool `json:"is_admin"`
+	UserID int    `json:"user_id"`
+	Role   string `json:"role"` // 管理员角色（普通用户为空）
 	jwt.RegisteredClaims
 }
 
@@ -21,14 +21,16 @@ func InitJWT(secret string) {
 	jwtSecret = []byte(secret)
 }
 
-// GenerateJWT 生成JWT Token
-func GenerateJWT(userID int, isAdmin bool, expireHours int) (string, error) {
+// GenerateJWT 生成JWT Token，sessionID写入jti，认证时据此校验会话是否已撤销
+func GenerateJWT(userID int, role, sessionID string, ttl time.Duration) (string, error) {
+	now := time.Now()
 	claims := &Claims{
-		UserID:  userID,
-		IsAdmin: isAdmin,
+		UserID: userID,
+		Role:   role,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * time.Duration(expireHours))),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ID:        sessionID,
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
 
@@ -0,0 +1,78 @@
+package util
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// IsPasswordHash 配置的密码是否为哈希（bcrypt 或 PHC格式的argon2id），否则视为明文
+func IsPasswordHash(stored string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$", "$argon2id$"} {
+		if strings.HasPrefix(stored, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// HashPassword 使用bcrypt计算密码哈希
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("计算密码哈希失败: %v", err)
+	}
+	return string(hash), nil
+}
+
+// CheckPassword 校验密码，stored可以是bcrypt哈希、argon2id哈希或明文（仅适合开发环境）
+func CheckPassword(stored, password string) bool {
+	switch {
+	case strings.HasPrefix(stored, "$argon2id$"):
+		ok, err := checkArgon2id(stored, password)
+		return err == nil && ok
+	case IsPasswordHash(stored):
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+	default:
+		return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+	}
+}
+
+// checkArgon2id 校验PHC格式的argon2id哈希：$argon2id$v=19$m=65536,t=3,p=4$<Base64盐>$<Base64哈希>
+func checkArgon2id(stored, password string) (bool, error) {
+	parts := strings.Split(stored, "$")
+	if len(parts) != 6 {
+		return false, fmt.Errorf("argon2id哈希格式错误")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, fmt.Errorf("不支持的argon2版本: %s", parts[2])
+	}
+	var memory, iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return false, fmt.Errorf("argon2id参数格式错误: %v", err)
+	}
+	// 参数为0时argon2.IDKey会panic
+	if memory == 0 || iterations == 0 || threads == 0 {
+		return false, fmt.Errorf("argon2id参数错误: m、t、p必须大于0")
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, fmt.Errorf("argon2id盐格式错误: %v", err)
+	}
+	hash, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, fmt.Errorf("argon2id哈希格式错误: %v", err)
+	}
+	if len(hash) == 0 {
+		return false, fmt.Errorf("argon2id哈希为空")
+	}
+
+	computed := argon2.IDKey([]byte(password), salt, iterations, memory, threads, uint32(len(hash)))
+	return subtle.ConstantTimeCompare(hash, computed) == 1, nil
+}
@@ -0,0 +1,56 @@
+package util
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// argon2idHash 按PHC格式生成argon2id哈希（参数取较小值以加快测试）
+func argon2idHash(password string) string {
+	salt := []byte("0123456789abcdef")
+	hash := argon2.IDKey([]byte(password), salt, 1, 8*1024, 1, 32)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, 8*1024, 1, 1,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash))
+}
+
+func TestCheckPassword(t *testing.T) {
+	bcryptHash, err := HashPassword("s3cret")
+	if err != nil {
+		t.Fatalf("HashPassword() error = %v", err)
+	}
+	argonHash := argon2idHash("s3cret")
+
+	tests := []struct {
+		name     string
+		stored   string
+		password string
+		isHash   bool
+		want     bool
+	}{
+		{"bcrypt", bcryptHash, "s3cret", true, true},
+		{"bcrypt密码错误", bcryptHash, "wrong", true, false},
+		{"argon2id", argonHash, "s3cret", true, true},
+		{"argon2id密码错误", argonHash, "wrong", true, false},
+		{"argon2id格式错误", "$argon2id$v=19$m=8192$abc", "s3cret", true, false},
+		{"argon2id内存参数为0", "$argon2id$v=19$m=0,t=1,p=1$c2FsdHNhbHQ$aGFzaA", "s3cret", true, false},
+		{"argon2id迭代次数为0", "$argon2id$v=19$m=8192,t=0,p=1$c2FsdHNhbHQ$aGFzaA", "s3cret", true, false},
+		{"argon2id并行度为0", "$argon2id$v=19$m=8192,t=1,p=0$c2FsdHNhbHQ$aGFzaA", "s3cret", true, false},
+		{"argon2id哈希为空", "$argon2id$v=19$m=8192,t=1,p=1$c2FsdHNhbHQ$", "s3cret", true, false},
+		{"明文", "s3cret", "s3cret", false, true},
+		{"明文密码错误", "s3cret", "s3cret ", false, false},
+		{"空密码", "", "", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsPasswordHash(tt.stored); got != tt.isHash {
+				t.Errorf("IsPasswordHash() = %v, want %v", got, tt.isHash)
+			}
+			if got := CheckPassword(tt.stored, tt.password); got != tt.want {
+				t.Errorf("CheckPassword(%q) = %v, want %v", tt.password, got, tt.want)
+			}
+		})
+	}
+}
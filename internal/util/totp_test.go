@@ -0,0 +1,85 @@
+package util
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret RFC 6238 附录B中SHA1测试向量的密钥 "12345678901234567890"
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 测试向量（8位验证码）的后6位
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := TOTPCode(rfc6238Secret, TOTPStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("TOTPCode() error = %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("TOTPCode(T=%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+
+	if _, err := TOTPCode("not base32!", 1); err == nil {
+		t.Error("TOTPCode(无效密钥) 应返回错误")
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0) // 时间步 37037037
+	tests := []struct {
+		name     string
+		code     string
+		skew     int
+		wantStep int64
+		wantOK   bool
+	}{
+		{"当前时间步", "050471", 1, 37037037, true},
+		{"上一个时间步", "081804", 1, 37037036, true},
+		{"超出允许的误差", "081804", 0, 0, false},
+		{"错误的验证码", "000000", 1, 0, false},
+		{"位数不对", "50471", 1, 0, false},
+		{"带空格", " 050471 ", 1, 37037037, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := VerifyTOTP(rfc6238Secret, tt.code, now, tt.skew)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("VerifyTOTP() = %d, %v, want %d, %v", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret() error = %v", err)
+	}
+	if len(secret) != 32 {
+		t.Errorf("密钥长度 = %d, want 32", len(secret))
+	}
+
+	u, err := url.Parse(TOTPURI("DuiDuiMao", "root", secret))
+	if err != nil {
+		t.Fatalf("TOTPURI() 不是有效的URL: %v", err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/DuiDuiMao:root" {
+		t.Errorf("TOTPURI() = %s", u)
+	}
+	if q := u.Query(); q.Get("secret") != secret || q.Get("issuer") != "DuiDuiMao" || q.Get("digits") != "6" {
+		t.Errorf("TOTPURI() 参数 = %v", q)
+	}
+}
@@ -1,14 +1,57 @@
-import { getToken, doubleEncode, doubleDecode } from './auth'
+import { getToken, setToken, getRefreshToken, setRefreshToken, clearAuth, doubleEncode, doubleDecode } from './auth'
 
 const API_BASE_URL = '/api'
 
+// 不需要携带token、也不触发自动刷新的接口
+const PUBLIC_AUTH_URLS = ['/auth/admin/login', '/auth/callback', '/auth/refresh']
+
+// 正在进行的刷新请求（多个请求同时过期时只刷新一次）
+let refreshing = null
+
+/**
+ * 用refresh token换取新的token，失败时清除登录信息
+ * @returns {Promise<boolean>} 是否刷新成功
+ */
+function refreshSession() {
+  if (!refreshing) {
+    refreshing = (async () => {
+      const refreshToken = getRefreshToken()
+      if (!refreshToken) {
+        return false
+      }
+      try {
+        const response = await fetch(`${API_BASE_URL}/auth/refresh`, {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify({ refresh_token: doubleEncode(refreshToken) })
+        })
+        const data = await response.json()
+        if (!response.ok || !data.success) {
+          clearAuth()
+          return false
+        }
+        setToken(doubleDecode(data.data.token))
+        setRefreshToken(doubleDecode(data.data.refresh_token))
+        return true
+      } catch (error) {
+        console.error('刷新登录状态失败:', error)
+        return false
+      }
+    })().finally(() => {
+      refreshing = null
+    })
+  }
+  return refreshing
+}
+
 /**
  * 通用请求函数
  * @param {string} url
  * @param {Object} options
+ * @param {boolean} retried - 是否已在刷新token后重试过
  * @returns {Promise}
  */
-async function request(url, options = {}) {
+async function request(url, options = {}, retried = false) {
   const token = getToken()
   const headers = {
     'Content-Type': 'application/json',
@@ -16,7 +59,7 @@ async function request(url, options = {}) {
   }
 
   // 如果有token，添加到请求头
-  if (token && url !== '/auth/admin/login' && url !== '/auth/callback') {
+  if (token && !PUBLIC_AUTH_URLS.includes(url)) {
     headers['Authorization'] = `Bearer ${token}`
   }
 
@@ -27,10 +70,20 @@ async function request(url, options = {}) {
 
   try {
     const response = await fetch(`${API_BASE_URL}${url}`, config)
+
+    // access token过期或失效时，刷新后重试一次
+    if (response.status === 401 && token && !retried && !PUBLIC_AUTH_URLS.includes(url)) {
+      if (await refreshSession()) {
+        return request(url, options, true)
+      }
+    }
+
     const data = await response.json()
 
     if (!response.ok) {
-      throw new Error(data.error || '请求失败')
+      const error = new Error(data.error || '请求失败')
+      error.status = response.status
+      throw error
     }
 
     return data
@@ -80,24 +133,36 @@ export function del(url) {
  * 管理员账密登录
  * @param {string} username - 原始用户名
  * @param {string} password - 原始密码
+ * @param {string} code - 两步验证码或恢复码（启用两步验证时必填）
+ * @returns {Promise<Object>} 登录结果；需要两步验证时返回 { totpRequired: true }
  */
-export async function adminLogin(username, password) {
+export async function adminLogin(username, password, code = '') {
   // 加密用户名和密码
   const encryptedUsername = doubleEncode(username)
   const encryptedPassword = doubleEncode(password)
 
-  const response = await post('/auth/admin/login', {
+  const body = {
     username: encryptedUsername,
     password: encryptedPassword
-  })
+  }
+  if (code) {
+    body.code = doubleEncode(code)
+  }
+  const response = await post('/auth/admin/login', body)
+
+  // 密码正确，但需要输入两步验证码
+  if (response.success && response.data?.totp_required) {
+    return { totpRequired: doubleDecode(response.data.totp_required) === 'true' }
+  }
 
   // 解密响应数据
   if (response.success && response.data) {
     const token = doubleDecode(response.data.token)
+    const refreshToken = doubleDecode(response.data.refresh_token)
     const userStr = doubleDecode(response.data.user)
     const user = JSON.parse(userStr)
 
-    return { token, user }
+    return { token, refreshToken, user }
   }
 
   throw new Error('登录失败')
@@ -119,14 +184,72 @@ export function logout() {
 }
 
 /**
- * 获取当前用户信息
+ * 登出所有设备
  */
-export function getCurrentUser() {
-  return get('/user/me')
+export function logoutAll() {
+  return post('/auth/logout-all')
+}
+
+/**
+ * 获取当前用户信息及兑换统计
+ */
+export async function getCurrentUser() {
+  const response = await get('/user/me')
+  if (response.success && response.data) {
+    const data = response.data
+    return {
+      user: JSON.parse(doubleDecode(data.user)),
+      total_redeemed: parseInt(doubleDecode(data.total_redeemed)),
+      tiers: data.tiers.map(stat => ({
+        tier_id: parseInt(doubleDecode(stat.tier_id)),
+        tier_name: doubleDecode(stat.tier_name),
+        daily_limit: parseInt(doubleDecode(stat.daily_limit)),
+        redeemed_today: parseInt(doubleDecode(stat.redeemed_today)),
+        // -1 表示不限购
+        remaining: parseInt(doubleDecode(stat.remaining)),
+        eligible: doubleDecode(stat.eligible) === 'true'
+      }))
+    }
+  }
+  throw new Error('获取用户信息失败')
+}
+
+// ========== 站点信息API ==========
+
+/**
+ * 获取首页公告和是否开放兑换（无需登录）
+ * @returns {Promise<{announcement: string, open: boolean}>}
+ */
+export async function getSite() {
+  const response = await get('/site')
+  if (response.success && response.data) {
+    return {
+      announcement: doubleDecode(response.data.announcement),
+      open: doubleDecode(response.data.open) === 'true'
+    }
+  }
+  return { announcement: '', open: true }
 }
 
 // ========== 档位管理API ==========
 
+/**
+ * 解密档位的开放时间和定时放出信息
+ * *_in 为按服务端时钟计算的倒计时秒数（0表示已开放/不限/没有待放出的CDK）
+ */
+function decodeSchedule(tier) {
+  return {
+    starts_at: doubleDecode(tier.starts_at),
+    ends_at: doubleDecode(tier.ends_at),
+    opens_in: parseInt(doubleDecode(tier.opens_in)),
+    closes_in: parseInt(doubleDecode(tier.closes_in)),
+    scheduled: parseInt(doubleDecode(tier.scheduled)),
+    next_release_at: doubleDecode(tier.next_release_at),
+    next_release_in: parseInt(doubleDecode(tier.next_release_in)),
+    next_release_count: parseInt(doubleDecode(tier.next_release_count))
+  }
+}
+
 /**
  * 获取用户端档位列表（仅显示启用的档位）
  */
@@ -138,13 +261,17 @@ export async function getUserTiers() {
       id: parseInt(doubleDecode(tier.id)),
       name: doubleDecode(tier.name),
       quota: parseInt(doubleDecode(tier.quota)),
+      price: parseInt(doubleDecode(tier.price)),
       required_level: parseInt(doubleDecode(tier.required_level)),
       daily_limit: parseInt(doubleDecode(tier.daily_limit)),
       stock: parseInt(doubleDecode(tier.stock)),
       is_active: doubleDecode(tier.is_active) === 'true',
       sort_order: parseInt(doubleDecode(tier.sort_order)),
+      // 未登录时后端不返回 eligible
+      eligible: tier.eligible ? doubleDecode(tier.eligible) === 'true' : null,
       created_at: doubleDecode(tier.created_at),
-      updated_at: doubleDecode(tier.updated_at)
+      updated_at: doubleDecode(tier.updated_at),
+      ...decodeSchedule(tier)
     }))
   }
   return []
@@ -167,7 +294,8 @@ export async function getTiers() {
       is_active: doubleDecode(tier.is_active) === 'true',
       sort_order: parseInt(doubleDecode(tier.sort_order)),
       created_at: doubleDecode(tier.created_at),
-      updated_at: doubleDecode(tier.updated_at)
+      updated_at: doubleDecode(tier.updated_at),
+      ...decodeSchedule(tier)
     }))
   }
   return []
@@ -197,6 +325,25 @@ export async function deleteTier(id) {
   return response
 }
 
+/**
+ * 定时放出：暂扣档位中的一批可用CDK，到时间后自动放出
+ * @param {number} id 档位ID
+ * @param {number} quantity 放出数量
+ * @param {string} releaseAt 放出时间（RFC3339）
+ */
+export async function scheduleRelease(id, quantity, releaseAt) {
+  const response = await post(`/admin/tiers/${id}/releases`, { quantity, release_at: releaseAt })
+  return response
+}
+
+/**
+ * 立即放出档位中所有尚未放出的CDK
+ */
+export async function releaseNow(id) {
+  const response = await del(`/admin/tiers/${id}/releases`)
+  return response
+}
+
 // ========== 系统设置API ==========
 
 /**
@@ -205,26 +352,37 @@ export async function deleteTier(id) {
 export async function getSettings() {
   const response = await get('/admin/settings')
   if (response.success && response.data) {
-    // 解密设置数据
-    return {
-      global_enabled: doubleDecode(response.data.global_enabled) === 'true',
-      announcement: doubleDecode(response.data.announcement),
-      order_expire_minutes: parseInt(doubleDecode(response.data.order_expire_minutes))
-    }
+    return decodeSettings(response.data)
   }
   return null
 }
 
+/**
+ * 解密系统设置数据
+ */
+function decodeSettings(data) {
+  return {
+    global_enabled: doubleDecode(data.global_enabled) === 'true',
+    announcement: doubleDecode(data.announcement),
+    order_expire_minutes: parseInt(doubleDecode(data.order_expire_minutes)),
+    version: parseInt(doubleDecode(data.version))
+  }
+}
+
 /**
  * 更新系统设置
  * @param {Object} settings - 原始设置数据
  * @param {boolean} settings.global_enabled - 全局开关
  * @param {string} settings.announcement - 公告内容
  * @param {number} settings.order_expire_minutes - 订单超时时间
+ * @param {number} settings.version - 获取设置时返回的版本号（设置已被其他管理员修改时返回409）
+ * @returns {Promise<Object>} 保存后的设置（包含新版本号）
  */
 export async function updateSettings(settings) {
   // 加密设置数据
-  const encryptedData = {}
+  const encryptedData = {
+    version: doubleEncode(String(settings.version))
+  }
 
   if (settings.global_enabled !== undefined) {
     encryptedData.global_enabled = doubleEncode(String(settings.global_enabled))
@@ -239,5 +397,51 @@ export async function updateSettings(settings) {
   }
 
   const response = await put('/admin/settings', encryptedData)
-  return response
+  return decodeSettings(response.data)
+}
+
+// ========== 两步验证API ==========
+
+/**
+ * 获取当前管理员的两步验证状态
+ */
+export async function getTOTPStatus() {
+  const response = await get('/admin/2fa')
+  if (response.success && response.data) {
+    return {
+      enabled: doubleDecode(response.data.enabled) === 'true',
+      recovery_codes: parseInt(doubleDecode(response.data.recovery_codes))
+    }
+  }
+  return null
+}
+
+/**
+ * 生成新的两步验证密钥
+ * @returns {Promise<Object>} { secret, uri }，uri 可生成二维码供验证器App扫描
+ */
+export async function setupTOTP() {
+  const response = await post('/admin/2fa/setup', {})
+  return {
+    secret: doubleDecode(response.data.secret),
+    uri: doubleDecode(response.data.uri)
+  }
+}
+
+/**
+ * 校验验证码并启用两步验证
+ * @param {string} code - 验证器App中的6位验证码
+ * @returns {Promise<string[]>} 一次性恢复码（只返回这一次）
+ */
+export async function enableTOTP(code) {
+  const response = await post('/admin/2fa/enable', { code: doubleEncode(code) })
+  return response.data.recovery_codes.map(doubleDecode)
+}
+
+/**
+ * 关闭两步验证
+ * @param {string} code - 验证码或恢复码
+ */
+export async function disableTOTP(code) {
+  return post('/admin/2fa/disable', { code: doubleEncode(code) })
 }
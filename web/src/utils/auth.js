@@ -47,6 +47,29 @@ export function removeToken() {
   localStorage.removeItem('token')
 }
 
+/**
+ * 获取Refresh Token
+ * @returns {string|null}
+ */
+export function getRefreshToken() {
+  return localStorage.getItem('refreshToken')
+}
+
+/**
+ * 保存Refresh Token
+ * @param {string} refreshToken
+ */
+export function setRefreshToken(refreshToken) {
+  localStorage.setItem('refreshToken', refreshToken)
+}
+
+/**
+ * 删除Refresh Token
+ */
+export function removeRefreshToken() {
+  localStorage.removeItem('refreshToken')
+}
+
 /**
  * 获取用户信息
  * @returns {Object|null}
@@ -76,5 +99,6 @@ export function removeUserInfo() {
  */
 export function clearAuth() {
   removeToken()
+  removeRefreshToken()
   removeUserInfo()
 }